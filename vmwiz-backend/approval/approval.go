@@ -0,0 +1,242 @@
+package approval
+
+// Optional four-eyes rule: the actions listed in FOUR_EYES_ACTIONS are not run when requested.
+// A pending operation is stored instead, and a different admin has to approve it before it expires,
+// at which point the original request is replayed with the approver's identity.
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/audit"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/auth"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
+)
+
+const (
+	STATUS_PENDING  = "pending"
+	STATUS_APPROVED = "approved"
+	STATUS_REJECTED = "rejected"
+	// Never stored, pending operations past their expiry are reported as expired
+	STATUS_EXPIRED = "expired"
+)
+
+const maxBodySize = 1 << 20
+
+// The handler an action runs once approved, and the role needed to approve it
+type registration struct {
+	role    auth.Role
+	handler http.Handler
+}
+
+var (
+	mu       sync.Mutex
+	handlers = map[string]registration{}
+)
+
+// Whether action needs the approval of a second admin
+func Required(action string) bool {
+	return slices.Contains(config.AppConfig.FOUR_EYES_ACTIONS, action)
+}
+
+// Status of op as shown to the admins
+func Status(op storage.PendingOperation) string {
+	if op.Status == STATUS_PENDING && !op.ExpiresAt.After(time.Now()) {
+		return STATUS_EXPIRED
+	}
+	return op.Status
+}
+
+// Request stores a pending operation for action, requested by auth.Actor(ctx), and returns it.
+// Once approved, body is sent again to the route at path.
+func Request(ctx context.Context, action string, path string, body []byte) (storage.PendingOperation, error) {
+	target, _ := audit.SummarizeBody(body)
+	id, err := storage.DB.CreatePendingOperation(ctx, storage.CreatePendingOperationParams{
+		Action:           action,
+		Target:           target,
+		Path:             path,
+		Payload:          string(body),
+		RequestedBy:      auth.Actor(ctx),
+		RequestedByOwner: auth.Owner(ctx),
+		ExpiresAt:        time.Now().Add(config.AppConfig.FOUR_EYES_EXPIRY),
+	})
+	if err != nil {
+		return storage.PendingOperation{}, fmt.Errorf("Failed to create pending operation for '%v': %v", action, err)
+	}
+
+	if err := audit.Record(ctx, audit.Entry{
+		Action:  "operations.request",
+		Target:  strconv.FormatInt(id, 10),
+		Payload: fmt.Sprintf(`{"action":%q,"target":%q}`, action, target),
+		Result:  audit.RESULT_SUCCESS,
+	}); err != nil {
+		log.Println(err)
+	}
+
+	return storage.DB.GetPendingOperation(ctx, id)
+}
+
+// Middleware runs next directly, unless action needs approval (see Required). In that case a pending
+// operation is created and its ID returned with 202 Accepted; next is only called once it is approved.
+// Admins with at least role can approve it. Place it after the auth and confirmation middlewares.
+// The request is recorded by the audit middleware in front of them, the approved operation when it is run.
+func Middleware(action string, role auth.Role, next http.Handler) http.Handler {
+	mu.Lock()
+	handlers[action] = registration{role: role, handler: audit.Middleware(action, next)}
+	mu.Unlock()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !Required(action) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+		if err != nil {
+			log.Printf("Error reading request body: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		op, err := Request(r.Context(), action, r.URL.Path, body)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Failed to create pending operation", http.StatusInternalServerError)
+			return
+		}
+
+		type response struct {
+			PendingOperationID int64     `json:"pendingOperationId"`
+			ExpiresAt          time.Time `json:"expiresAt"`
+			Message            string    `json:"message"`
+		}
+		respJSON, err := json.Marshal(response{
+			PendingOperationID: op.ID,
+			ExpiresAt:          op.ExpiresAt,
+			Message:            fmt.Sprintf("'%v' needs the approval of another admin before it is run", action),
+		})
+		if err != nil {
+			log.Printf("Error marshalling pending operation: %v", err)
+			http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(respJSON)
+	})
+}
+
+// Approve marks the pending operation id as approved by the user of r and runs it, replying to w.
+// It fails if the user is the one who requested it (also through an API token or the CLI, see auth.Owner), lacks the role of the action, or if the
+// operation was already decided or has expired.
+func Approve(w http.ResponseWriter, r *http.Request, id int64) {
+	op, err := storage.DB.GetPendingOperation(r.Context(), id)
+	if err == sql.ErrNoRows {
+		http.Error(w, fmt.Sprintf("Pending operation %d not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error getting pending operation %d: %v", id, err)
+		http.Error(w, "Failed to fetch pending operation", http.StatusInternalServerError)
+		return
+	}
+
+	mu.Lock()
+	reg, ok := handlers[op.Action]
+	mu.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("Action '%v' cannot be run through the API", op.Action), http.StatusConflict)
+		return
+	}
+
+	user, _ := auth.User(r.Context())
+	approver := auth.Actor(r.Context())
+	if strings.HasPrefix(approver, "token:") {
+		http.Error(w, "Operations cannot be approved with an API token", http.StatusForbidden)
+		return
+	}
+	if user.Role < reg.role {
+		http.Error(w, fmt.Sprintf("Approving '%v' requires the %v role", op.Action, reg.role), http.StatusForbidden)
+		return
+	}
+	// Also compares the people behind API tokens and CLI users, so nobody can approve what they requested under another name
+	requesters := []string{op.RequestedBy, op.RequestedByOwner}
+	if slices.ContainsFunc([]string{approver, auth.Owner(r.Context())}, func(a string) bool {
+		return slices.ContainsFunc(requesters, func(req string) bool { return strings.EqualFold(a, req) })
+	}) {
+		http.Error(w, "Operations must be approved by a different admin than the one who requested them", http.StatusForbidden)
+		return
+	}
+	if status := Status(op); status != STATUS_PENDING {
+		http.Error(w, fmt.Sprintf("Pending operation %d is %v", id, status), http.StatusConflict)
+		return
+	}
+
+	// Checked again atomically, in case another admin decided in the meantime
+	n, err := storage.DB.ApprovePendingOperation(r.Context(), storage.ApprovePendingOperationParams{
+		Approver: sql.NullString{String: approver, Valid: true},
+		ID:       id,
+	})
+	if err != nil {
+		log.Printf("Error approving pending operation %d: %v", id, err)
+		http.Error(w, "Failed to approve pending operation", http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		http.Error(w, fmt.Sprintf("Pending operation %d is no longer pending", id), http.StatusConflict)
+		return
+	}
+
+	// Replay the original request, as the approver
+	replay := r.Clone(r.Context())
+	replay.URL.Path = op.Path
+	replay.URL.RawQuery = ""
+	replay.Body = io.NopCloser(bytes.NewReader([]byte(op.Payload)))
+	replay.ContentLength = int64(len(op.Payload))
+	reg.handler.ServeHTTP(w, replay)
+}
+
+// Reject marks the pending operation id as rejected by auth.Actor(ctx), so that it can no longer be approved.
+// Either the requester (to withdraw it) or an admin who could approve it may reject it.
+func Reject(ctx context.Context, id int64) error {
+	op, err := storage.DB.GetPendingOperation(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Pending operation %d not found", id)
+	}
+
+	mu.Lock()
+	reg, ok := handlers[op.Action]
+	mu.Unlock()
+	user, _ := auth.User(ctx)
+	if auth.Actor(ctx) != op.RequestedBy {
+		if !ok {
+			return fmt.Errorf("Cannot reject '%v': unknown action", op.Action)
+		}
+		if user.Role < reg.role {
+			return fmt.Errorf("Rejecting '%v' requires the %v role", op.Action, reg.role)
+		}
+	}
+
+	n, err := storage.DB.RejectPendingOperation(ctx, storage.RejectPendingOperationParams{
+		ID:        id,
+		DecidedBy: sql.NullString{String: auth.Actor(ctx), Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to reject pending operation %d: %v", id, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("Pending operation %d is not pending", id)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package audit
+
+// Append-only record of every mutating action, done through the API or the CLI, and of who did it (see auth.Actor).
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/auth"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
+)
+
+const (
+	RESULT_SUCCESS = "success"
+	RESULT_FAILURE = "failure"
+)
+
+const (
+	maxPayloadLength = 1000
+	maxDetailLength  = 500
+	maxBodySize      = 1 << 20
+)
+
+// Fields of a request body that identify the target of an action, in order of preference
+var targetFields = []string{"id", "hostname", "vmName", "surveyId", "name"}
+
+// Fields never stored in the payload
+var redactedFields = []string{"confirmationToken", "token"}
+
+type Entry struct {
+	Action     string
+	Target     string
+	Payload    string
+	Result     string
+	Detail     string
+	LogScopeID string
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// Record stores an entry, done by auth.Actor(ctx).
+func Record(ctx context.Context, e Entry) error {
+	err := storage.DB.CreateAuditEntry(ctx, storage.CreateAuditEntryParams{
+		Actor:      auth.Actor(ctx),
+		Action:     e.Action,
+		Target:     e.Target,
+		Payload:    truncate(e.Payload, maxPayloadLength),
+		Result:     e.Result,
+		Detail:     truncate(e.Detail, maxDetailLength),
+		LogScopeID: sql.NullString{String: e.LogScopeID, Valid: e.LogScopeID != ""},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to record audit entry '%v': %v", e.Action, err)
+	}
+	return nil
+}
+
+// Keeps the status code and the start of error responses
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	if rr.status >= 400 && rr.body.Len() < maxDetailLength {
+		rr.body.Write(b)
+	}
+	return rr.ResponseWriter.Write(b)
+}
+
+// Returns the target and the payload summary of a JSON request body
+func SummarizeBody(body []byte) (string, string) {
+	var fields map[string]any
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return "", strings.TrimSpace(string(body))
+	}
+
+	target := ""
+	for _, f := range targetFields {
+		if v, ok := fields[f]; ok && v != nil && fmt.Sprint(v) != "" {
+			target = fmt.Sprint(v)
+			break
+		}
+	}
+
+	for _, f := range redactedFields {
+		delete(fields, f)
+	}
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return target, ""
+	}
+	return target, string(payload)
+}
+
+// Middleware records every request to next as action, with the outcome of the handler.
+// Place it before the auth, confirmation and approval middlewares, so that denied, unconfirmed and pending
+// attempts are recorded too, by whoever the auth middleware identified. Confirmation previews are not recorded.
+func Middleware(action string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("preview") == "true" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+		if err != nil {
+			log.Printf("Error reading request body: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := auth.TrackIdentity(r.Context())
+		rr := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rr, r.WithContext(ctx))
+		if rr.status == 0 {
+			rr.status = http.StatusOK
+		}
+
+		target, payload := SummarizeBody(body)
+		e := Entry{
+			Action:     action,
+			Target:     target,
+			Payload:    payload,
+			Result:     RESULT_SUCCESS,
+			Detail:     fmt.Sprintf("%d %s", rr.status, http.StatusText(rr.status)),
+			LogScopeID: rr.Header().Get("X-Log-Scope-Id"),
+		}
+		if rr.status >= 400 {
+			e.Result = RESULT_FAILURE
+			e.Detail += ": " + strings.TrimSpace(rr.body.String())
+		}
+		recordCtx := r.Context()
+		if identity := auth.Identity(ctx); identity != "" {
+			recordCtx = auth.WithActor(recordCtx, identity)
+		}
+		if err := Record(recordCtx, e); err != nil {
+			log.Println(err)
+		}
+	})
+}
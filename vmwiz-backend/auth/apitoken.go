@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Route groups an API token can be given access to, i.e. the first path segment after /api/
+var API_TOKEN_SCOPES = []string{"vmrequest", "vm", "dns", "usagesurvey", "logs", "mail", "audit", "quota", "operations", "reconcile"}
+
+const apiTokenPrefix = "vmwiz_"
+
+type APIToken struct {
+	ID     int64
+	Name   string
+	Owner  string
+	Role   Role
+	Scopes []string
+	// Zero if the token does not expire
+	ExpiresAt time.Time
+	Revoked   bool
+}
+
+var ErrAPITokenNotFound = errors.New("API token not found")
+
+// An APITokenStore looks up API tokens by the hash of their secret.
+type APITokenStore interface {
+	// Returns ErrAPITokenNotFound if there is no such token
+	GetAPIToken(ctx context.Context, hash string) (APIToken, error)
+	TouchAPIToken(ctx context.Context, id int64) error
+}
+
+// Bearer tokens are rejected until a store is set
+var apiTokens APITokenStore
+
+func SetAPITokenStore(s APITokenStore) { apiTokens = s }
+
+// NewAPITokenSecret generates a token to hand out once, and the hash to store.
+func NewAPITokenSecret() (secret string, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("Failed to generate API token: %v", err)
+	}
+	secret = apiTokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	return secret, HashAPIToken(secret), nil
+}
+
+func HashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateAPITokenScopes checks that all scopes are in API_TOKEN_SCOPES.
+func ValidateAPITokenScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required, available: %v", strings.Join(API_TOKEN_SCOPES, ", "))
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(API_TOKEN_SCOPES, scope) {
+			return fmt.Errorf("unknown scope '%v', available: %v", scope, strings.Join(API_TOKEN_SCOPES, ", "))
+		}
+	}
+	return nil
+}
+
+// Route group of a request path, e.g. "dns" for /api/dns/deleteByHostname
+func routeScope(path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, "/api/"), "/")
+	return parts[0]
+}
+
+// Returns the token from an "Authorization: Bearer" header, if any
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return strings.TrimSpace(token), ok
+}
+
+// Authenticates a request made with an API token. The user is named after the token, e.g. "token:deploy".
+func authenticateAPIToken(r *http.Request, secret string) (KeycloakUser, error) {
+	if apiTokens == nil {
+		return KeycloakUser{}, fmt.Errorf("API tokens are not enabled")
+	}
+
+	token, err := apiTokens.GetAPIToken(r.Context(), HashAPIToken(secret))
+	if err != nil {
+		return KeycloakUser{}, err
+	}
+	if token.Revoked {
+		return KeycloakUser{}, fmt.Errorf("API token '%v' was revoked", token.Name)
+	}
+	if !token.ExpiresAt.IsZero() && token.ExpiresAt.Before(time.Now()) {
+		return KeycloakUser{}, fmt.Errorf("API token '%v' expired", token.Name)
+	}
+	if scope := routeScope(r.URL.Path); !slices.Contains(token.Scopes, scope) {
+		return KeycloakUser{}, fmt.Errorf("API token '%v' does not have the '%v' scope", token.Name, scope)
+	}
+
+	if err := apiTokens.TouchAPIToken(r.Context(), token.ID); err != nil {
+		return KeycloakUser{}, fmt.Errorf("Failed to update API token '%v': %v", token.Name, err)
+	}
+
+	return KeycloakUser{Email: "token:" + token.Name, Role: token.Role, TokenOwner: token.Owner}, nil
+}
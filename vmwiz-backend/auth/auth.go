@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
 	"time"
 
@@ -19,13 +21,23 @@ var provider *oidc.Provider
 var oauth2Config oauth2.Config
 var verifier *oidc.IDTokenVerifier
 var ctx context.Context
-var tokenSourceMap = make(map[string]oauth2.TokenSource)
 
 type KeycloakUser struct {
-	Email  string   `json:"email"`
-	Groups []string `json:"groups"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Groups        []string `json:"groups"`
+	RealmAccess   struct {
+		Roles []string `json:"roles"`
+	} `json:"realm_access"`
+	// Not a claim, computed from the groups and realm roles (see RequireRole)
+	Role Role `json:"role"`
+	// Who created the API token the request was made with, empty for Keycloak users
+	TokenOwner string `json:"-"`
 }
 
+// Where the browser is sent after logging in, unless StartKeycloakAuthFlow is given another path
+const DEFAULT_LOGIN_REDIRECT = "/console"
+
 func Init() {
 	ctx = context.Background()
 
@@ -38,6 +50,18 @@ func Init() {
 
 	verifier = provider.Verifier(&oidc.Config{ClientID: config.AppConfig.KEYCLOAK_CLIENT_ID})
 
+	roleMapping, err = ParseRoleMapping(config.AppConfig.KEYCLOAK_ROLE_MAPPING)
+	if err != nil {
+		log.Fatalf("Failed to parse KEYCLOAK_ROLE_MAPPING: %v", err)
+	}
+	cliEmails, err = ParseCLIUserEmails(config.AppConfig.CLI_USER_EMAILS)
+	if err != nil {
+		log.Fatalf("Failed to parse CLI_USER_EMAILS: %v", err)
+	}
+	if len(roleMapping) == 0 && !slices.ContainsFunc(config.AppConfig.KEYCLOAK_RESTRICT_AUTH_TO_GROUPS, func(g string) bool { return g != "" }) {
+		log.Printf("Neither KEYCLOAK_ROLE_MAPPING nor KEYCLOAK_RESTRICT_AUTH_TO_GROUPS is set, nobody can use the admin console")
+	}
+
 	// Configure an OpenID Connect aware OAuth2 client.
 	oauth2Config = oauth2.Config{
 		ClientID:     config.AppConfig.KEYCLOAK_CLIENT_ID,
@@ -50,96 +74,184 @@ func Init() {
 	}
 	go func() {
 		for {
-			time.Sleep(24 * time.Hour)
-			// delete all expired tokens
-			for k, v := range tokenSourceMap {
-				token, err := v.Token()
-				if err != nil || token.Expiry.Before(time.Now()) {
-					delete(tokenSourceMap, k)
-				}
+			time.Sleep(time.Hour)
+			if err := sessions.DeleteExpiredSessions(ctx); err != nil {
+				log.Printf("Failed to delete expired sessions: %v", err)
 			}
 		}
 	}()
 }
 
 func setCookie(w http.ResponseWriter, r *http.Request, name string, value string) {
+	setCookieMaxAge(w, r, name, value, time.Hour)
+}
+
+// A maxAge of minus one second or less deletes the cookie
+func setCookieMaxAge(w http.ResponseWriter, r *http.Request, name string, value string, maxAge time.Duration) {
 	c := &http.Cookie{
 		Name:     name,
 		Value:    value,
-		MaxAge:   int(time.Hour.Seconds()),
+		MaxAge:   max(int(maxAge.Seconds()), -1),
 		Secure:   r.TLS != nil,
 		HttpOnly: true,
+		// Lax still sends the cookies on the redirect back from Keycloak, but not on cross-site POSTs
+		SameSite: http.SameSiteLaxMode,
 		Path:     "/",
 	}
 	http.SetCookie(w, c)
 }
 
-// Checks if the user is authenticated or redirects him to the login endpoint instead.
+type actorKey struct{}
+type userKey struct{}
+type identityKey struct{}
+
+// Actor used when nobody in particular performs an action
+const ACTOR_SYSTEM = "system"
+
+// WithActor records who performs the actions done with ctx, for contexts that are not derived
+// from an authenticated request (e.g. the CLI, or work that outlives the request).
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns who performs the actions done with ctx: the email of the authenticated user,
+// the actor set with WithActor, or ACTOR_SYSTEM.
+func Actor(ctx context.Context) string {
+	if user, ok := User(ctx); ok && user.Email != "" {
+		return user.Email
+	}
+	if actor, ok := ctx.Value(actorKey{}).(string); ok {
+		return actor
+	}
+	return ACTOR_SYSTEM
+}
+
+// Emails of the admins behind CLI users. Set by Init from CLI_USER_EMAILS.
+var cliEmails = map[string]string{}
+
+// ParseCLIUserEmails parses CLI_USER_EMAILS, a comma separated list of user:email pairs, e.g. "alice:alice@ethz.ch".
+func ParseCLIUserEmails(mapping string) (map[string]string, error) {
+	emails := map[string]string{}
+	for _, entry := range strings.Split(mapping, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		user, email, ok := strings.Cut(entry, ":")
+		if !ok || strings.TrimSpace(user) == "" || !strings.Contains(email, "@") {
+			return nil, fmt.Errorf("invalid entry '%v', expected user:email", entry)
+		}
+		emails[strings.TrimSpace(user)] = strings.TrimSpace(email)
+	}
+	return emails, nil
+}
+
+// Owner returns the person behind Actor(ctx): the owner of the API token the request was made with,
+// and for CLI users ("cli:alice") the email CLI_USER_EMAILS maps them to. Otherwise it is Actor(ctx) itself.
+func Owner(ctx context.Context) string {
+	owner := Actor(ctx)
+	if user, ok := User(ctx); ok && user.TokenOwner != "" {
+		owner = user.TokenOwner
+	}
+	if name, ok := strings.CutPrefix(owner, "cli:"); ok {
+		if email, ok := cliEmails[name]; ok {
+			return email
+		}
+	}
+	return owner
+}
+
+// TrackIdentity returns a context in which the auth middlewares note who sent the request as soon as they know it,
+// also if they reject the request afterwards (see Identity). For middlewares that run before them, e.g. audit.Middleware.
+func TrackIdentity(ctx context.Context) context.Context {
+	return context.WithValue(ctx, identityKey{}, new(string))
+}
+
+// Identity returns who sent the request of a context made by TrackIdentity, "" if they were not identified.
+func Identity(ctx context.Context) string {
+	if identity, ok := ctx.Value(identityKey{}).(*string); ok {
+		return *identity
+	}
+	return ""
+}
+
+func noteIdentity(r *http.Request, email string) {
+	if identity, ok := r.Context().Value(identityKey{}).(*string); ok && email != "" {
+		*identity = email
+	}
+}
+
+// Verifies the auth_token cookie (refreshing it if possible) and returns its claims.
+// Replies with 401 and returns false if the user is not logged in.
+func authenticate(w http.ResponseWriter, r *http.Request, redirect string) (KeycloakUser, bool) {
+	session, idToken, err := sessionToken(r.Context(), w, r)
+	if err != nil {
+		log.Println("Error authenticating:", err)
+		replyUnauthorized(w, redirect)
+		return KeycloakUser{}, false
+	}
+
+	var claims KeycloakUser
+	if err := idToken.Claims(&claims); err != nil {
+		log.Println("Error parsing token claims:", err)
+		replyUnauthorized(w, redirect)
+		return KeycloakUser{}, false
+	}
+	if !strings.EqualFold(claims.Email, session.Email) {
+		log.Printf("Token of %s used with a session of %s", claims.Email, session.Email)
+		replyUnauthorized(w, redirect)
+		return KeycloakUser{}, false
+	}
+	claims.Role = ROLE_NONE
+	return claims, true
+}
+
+// Checks if the user is authenticated and may use the admin console (at least ROLE_VIEWER).
 func CheckAuthenticated(next http.Handler) http.Handler {
+	return RequireRole(ROLE_VIEWER, next)
+}
+
+// Checks if the request comes from any logged in user with a verified email address, regardless of their groups.
+// Used for the self-service routes, which must only ever touch resources belonging to User(ctx).Email.
+// With AUTH_SKIP, the user is AUTH_SKIP_USER.
+func CheckUserAuthenticated(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if config.AppConfig.AUTH_SKIP {
-			next.ServeHTTP(w, r)
+			if config.AppConfig.AUTH_SKIP_USER == "" {
+				http.Error(w, "AUTH_SKIP is set but AUTH_SKIP_USER is not", http.StatusUnauthorized)
+				return
+			}
+			checkAuthenticatedSuccess(w, r, next, KeycloakUser{Email: config.AppConfig.AUTH_SKIP_USER, EmailVerified: true})
 			return
 		}
 
-		tokenCookie, err := r.Cookie("auth_token")
-
-		if err != nil {
-			log.Println("Error getting token cookie:", err)
-			replyUnauthorized(w)
+		claims, ok := authenticate(w, r, USER_LOGIN_REDIRECT)
+		if !ok {
 			return
 		}
-		if tokenCookie == nil {
-			log.Println("Token cookie is nil")
-			replyUnauthorized(w)
-			return
-		}
-		// get tokesource from map
-		tokenSource, ok := tokenSourceMap[tokenCookie.Value]
-		if ok {
-			token, err := tokenSource.Token()
-			if err == nil {
-				// delete the old token
-				delete(tokenSourceMap, tokenCookie.Value)
-				tokenCookie.Value = token.Extra("id_token").(string)
-				setCookie(w, r, "auth_token", tokenCookie.Value)
-				tokenSourceMap[tokenCookie.Value] = tokenSource
-			}
-		}
+		noteIdentity(r, claims.Email)
 
-		// Verify the token
-		idToken, err := verifier.Verify(ctx, tokenCookie.Value)
-		if err != nil {
-			log.Println("Error verifying token:", err)
-			replyUnauthorized(w)
+		if claims.Email == "" || !claims.EmailVerified {
+			http.Error(w, "Your account has no verified email address", http.StatusForbidden)
 			return
 		}
+		checkAuthenticatedSuccess(w, r, next, claims)
+	})
+}
 
-		var claims KeycloakUser
-		err = idToken.Claims(&claims)
-		for _, group := range claims.Groups {
-			if len(config.AppConfig.KEYCLOAK_RESTRICT_AUTH_TO_GROUPS) == 0 {
-				checkAuthenticatedSuccess(w, r, next, claims)
-				return
-			}
-			for _, allowed_group := range config.AppConfig.KEYCLOAK_RESTRICT_AUTH_TO_GROUPS {
-				if strings.TrimPrefix(group, "/") == allowed_group {
-					checkAuthenticatedSuccess(w, r, next, claims)
-					return
-				}
-			}
+// Where requesters are sent after logging in
+const USER_LOGIN_REDIRECT = "/me"
 
-		}
-		http.Error(w, "Your user is not allowed to use this route", http.StatusUnauthorized)
-		return
-	})
+// User returns the authenticated user of a request context, see CheckAuthenticated and CheckUserAuthenticated.
+func User(ctx context.Context) (KeycloakUser, bool) {
+	user, ok := ctx.Value(userKey{}).(KeycloakUser)
+	return user, ok
 }
 
 // Tells the client that he is not authenticated/authorized and instructs him to begin the auth flow
-func replyUnauthorized(w http.ResponseWriter) {
+func replyUnauthorized(w http.ResponseWriter, redirect string) {
 	w.Header().Set("Content-Type", "application/json")
 	body := RedirectUrlBody{
-		RedirectURL: "/api/auth/start",
+		RedirectURL: "/api/auth/start?redirect=" + url.QueryEscape(redirect),
 	}
 	respJSON, err := json.Marshal(body)
 	if err != nil {
@@ -152,8 +264,9 @@ func replyUnauthorized(w http.ResponseWriter) {
 }
 
 func checkAuthenticatedSuccess(w http.ResponseWriter, r *http.Request, next http.Handler, claims KeycloakUser) {
+	noteIdentity(r, claims.Email)
 	// Attach claims to the request context.
-	ctxWithClaims := context.WithValue(r.Context(), "user", claims)
+	ctxWithClaims := context.WithValue(r.Context(), userKey{}, claims)
 	next.ServeHTTP(w, r.WithContext(ctxWithClaims))
 }
 
@@ -165,6 +278,7 @@ func StartKeycloakAuthFlow(w http.ResponseWriter, r *http.Request) {
 	state := uuid.String()
 
 	setCookie(w, r, "session_state", state)
+	setCookie(w, r, "auth_redirect", loginRedirect(r.URL.Query().Get("redirect")))
 
 	http.Redirect(w, r, oauth2Config.AuthCodeURL(state), http.StatusFound)
 	return
@@ -208,11 +322,22 @@ func HandleKeycloakCallback(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to get claims: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	tokenString := oauth2Token.Extra("id_token").(string)
-	ts := oauth2Config.TokenSource(ctx, oauth2Token)
-	tokenSourceMap[tokenString] = ts
+	if err := createSession(r.Context(), w, r, claims.Email, oauth2Token); err != nil {
+		http.Error(w, "Failed to create session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	setCookie(w, r, "auth_token", tokenString)
+	redirect := DEFAULT_LOGIN_REDIRECT
+	if c, err := r.Cookie("auth_redirect"); err == nil {
+		redirect = loginRedirect(c.Value)
+	}
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
 
-	http.Redirect(w, r, "/console", http.StatusFound)
+// Only allows redirecting to local paths after logging in, anything else becomes DEFAULT_LOGIN_REDIRECT
+func loginRedirect(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return DEFAULT_LOGIN_REDIRECT
+	}
+	return path
 }
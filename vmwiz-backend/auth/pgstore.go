@@ -0,0 +1,176 @@
+package auth
+
+// SessionStore and APITokenStore backed by the vmwiz database. Refresh tokens are encrypted with a key derived from
+// SESSION_ENCRYPTION_KEY, so a database dump alone does not allow logging in as anybody.
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
+)
+
+// Keeps sessions and API tokens in storage.DB, which must be initialized before it is used.
+type PostgresStore struct{}
+
+func sessionCipher() (cipher.AEAD, error) {
+	if config.AppConfig.SESSION_ENCRYPTION_KEY == "" {
+		return nil, fmt.Errorf("SESSION_ENCRYPTION_KEY is not set")
+	}
+	key := sha256.Sum256([]byte(config.AppConfig.SESSION_ENCRYPTION_KEY))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptRefreshToken(token string) ([]byte, error) {
+	aead, err := sessionCipher()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encrypt refresh token: %v", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("Failed to encrypt refresh token: %v", err)
+	}
+	return aead.Seal(nonce, nonce, []byte(token), nil), nil
+}
+
+func decryptRefreshToken(data []byte) (string, error) {
+	aead, err := sessionCipher()
+	if err != nil {
+		return "", fmt.Errorf("Failed to decrypt refresh token: %v", err)
+	}
+	if len(data) < aead.NonceSize() {
+		return "", fmt.Errorf("Failed to decrypt refresh token: Too short")
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("Failed to decrypt refresh token: %v", err)
+	}
+	return string(plain), nil
+}
+
+func (PostgresStore) CreateSession(ctx context.Context, session Session) error {
+	token, err := encryptRefreshToken(session.RefreshToken)
+	if err != nil {
+		return err
+	}
+	return storage.DB.CreateAuthSession(ctx, storage.CreateAuthSessionParams{
+		ID:           session.ID,
+		Email:        session.Email,
+		ExpiresAt:    session.ExpiresAt,
+		RefreshToken: token,
+	})
+}
+
+func (PostgresStore) GetSession(ctx context.Context, id string) (Session, error) {
+	row, err := storage.DB.GetAuthSession(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return Session{}, err
+	}
+	token, err := decryptRefreshToken(row.RefreshToken)
+	if err != nil {
+		return Session{}, err
+	}
+	session := toSession(row)
+	session.RefreshToken = token
+	return session, nil
+}
+
+func (PostgresStore) UpdateSession(ctx context.Context, id string, refreshToken string, expiresAt time.Time) error {
+	token, err := encryptRefreshToken(refreshToken)
+	if err != nil {
+		return err
+	}
+	n, err := storage.DB.UpdateAuthSession(ctx, storage.UpdateAuthSessionParams{
+		ID:           id,
+		RefreshToken: token,
+		ExpiresAt:    expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (PostgresStore) DeleteSession(ctx context.Context, id string) error {
+	n, err := storage.DB.DeleteAuthSession(ctx, id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// Lists the sessions without their refresh tokens
+func (PostgresStore) ListSessions(ctx context.Context) ([]Session, error) {
+	rows, err := storage.DB.ListAuthSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []Session{}
+	for _, row := range rows {
+		sessions = append(sessions, toSession(row))
+	}
+	return sessions, nil
+}
+
+func (PostgresStore) DeleteExpiredSessions(ctx context.Context) error {
+	return storage.DB.DeleteExpiredAuthSessions(ctx)
+}
+
+func toSession(row storage.AuthSession) Session {
+	return Session{
+		ID:         row.ID,
+		Email:      row.Email,
+		CreatedAt:  row.CreatedAt,
+		LastSeenAt: row.LastSeenAt,
+		ExpiresAt:  row.ExpiresAt,
+	}
+}
+
+func (PostgresStore) GetAPIToken(ctx context.Context, hash string) (APIToken, error) {
+	row, err := storage.DB.GetAPITokenByHash(ctx, hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIToken{}, ErrAPITokenNotFound
+	}
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	role, err := ParseRole(row.Role)
+	if err != nil {
+		return APIToken{}, fmt.Errorf("API token %d: %v", row.ID, err)
+	}
+	return APIToken{
+		ID:        row.ID,
+		Name:      row.Name,
+		Owner:     row.Owner,
+		Role:      role,
+		Scopes:    row.Scopes,
+		ExpiresAt: row.ExpiresAt.Time,
+		Revoked:   row.RevokedAt.Valid,
+	}, nil
+}
+
+func (PostgresStore) TouchAPIToken(ctx context.Context, id int64) error {
+	return storage.DB.TouchAPIToken(ctx, id)
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
+)
+
+// Roles of admin console users. Every role includes the permissions of the roles below it.
+type Role int
+
+const (
+	// Logged in, but not allowed to use the admin console
+	ROLE_NONE Role = iota
+	// Reads requests, logs, surveys and emails
+	ROLE_VIEWER
+	// Accepts, rejects, holds and edits VM requests
+	ROLE_APPROVER
+	// Runs operational actions, e.g. surveys and the mail queue
+	ROLE_OPERATOR
+	// Deletes VMs and DNS entries
+	ROLE_SUPERADMIN
+)
+
+var roleNames = map[Role]string{
+	ROLE_NONE:       "none",
+	ROLE_VIEWER:     "viewer",
+	ROLE_APPROVER:   "approver",
+	ROLE_OPERATOR:   "operator",
+	ROLE_SUPERADMIN: "superadmin",
+}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// Roles are never taken from the token, unknown values become ROLE_NONE (see authenticate)
+func (r *Role) UnmarshalText(text []byte) error {
+	*r, _ = ParseRole(string(text))
+	return nil
+}
+
+func ParseRole(s string) (Role, error) {
+	for role, name := range roleNames {
+		if name == strings.ToLower(strings.TrimSpace(s)) {
+			return role, nil
+		}
+	}
+	return ROLE_NONE, fmt.Errorf("unknown role '%v'", s)
+}
+
+// ParseRoleMapping parses KEYCLOAK_ROLE_MAPPING, a comma separated list of role:name pairs,
+// e.g. "superadmin:vsos_team,viewer:vsos_helpers". The name is matched against the Keycloak groups and realm roles of the user.
+func ParseRoleMapping(mapping string) (map[string]Role, error) {
+	roles := map[string]Role{}
+	for _, entry := range strings.Split(mapping, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		roleName, name, ok := strings.Cut(entry, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid role mapping '%v', expected role:group", entry)
+		}
+		role, err := ParseRole(roleName)
+		if err != nil {
+			return nil, err
+		}
+		roles[strings.TrimPrefix(strings.TrimSpace(name), "/")] = role
+	}
+	return roles, nil
+}
+
+// Maps Keycloak groups and realm roles to VMWiz roles. Set by Init from KEYCLOAK_ROLE_MAPPING.
+var roleMapping = map[string]Role{}
+
+// Returns the highest role granted to the user.
+// Without KEYCLOAK_ROLE_MAPPING, members of KEYCLOAK_RESTRICT_AUTH_TO_GROUPS are superadmins. If neither is set, nobody gets a role.
+func userRole(user KeycloakUser) Role {
+	names := append([]string{}, user.Groups...)
+	names = append(names, user.RealmAccess.Roles...)
+
+	if len(roleMapping) == 0 {
+		allowed_groups := slices.DeleteFunc(slices.Clone(config.AppConfig.KEYCLOAK_RESTRICT_AUTH_TO_GROUPS), func(g string) bool { return g == "" })
+		for _, name := range names {
+			for _, allowed_group := range allowed_groups {
+				if strings.TrimPrefix(name, "/") == allowed_group {
+					return ROLE_SUPERADMIN
+				}
+			}
+		}
+		return ROLE_NONE
+	}
+
+	role := ROLE_NONE
+	for _, name := range names {
+		if r, ok := roleMapping[strings.TrimPrefix(name, "/")]; ok && r > role {
+			role = r
+		}
+	}
+	return role
+}
+
+// RequireRole checks that the user is authenticated and has at least the given role, or replies with 401/403 instead.
+// Besides the session cookies, it accepts API tokens in an "Authorization: Bearer" header.
+func RequireRole(role Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.AppConfig.AUTH_SKIP {
+			checkAuthenticatedSuccess(w, r, next, KeycloakUser{Role: ROLE_SUPERADMIN})
+			return
+		}
+
+		var claims KeycloakUser
+		if secret, ok := bearerToken(r); ok {
+			var err error
+			claims, err = authenticateAPIToken(r, secret)
+			if err != nil {
+				log.Printf("Rejected API token for %s %s: %v", r.Method, r.URL.Path, err)
+				http.Error(w, "Invalid API token", http.StatusUnauthorized)
+				return
+			}
+		} else {
+			claims, ok = authenticate(w, r, DEFAULT_LOGIN_REDIRECT)
+			if !ok {
+				return
+			}
+			claims.Role = userRole(claims)
+		}
+		noteIdentity(r, claims.Email)
+
+		if claims.Role == ROLE_NONE {
+			http.Error(w, "Your user is not allowed to use this route", http.StatusUnauthorized)
+			return
+		}
+		if claims.Role < role {
+			log.Printf("%s (%v) tried to use %s %s, which requires %v", claims.Email, claims.Role, r.Method, r.URL.Path, role)
+			http.Error(w, fmt.Sprintf("This action requires the %v role, you are %v", role, claims.Role), http.StatusForbidden)
+			return
+		}
+		checkAuthenticatedSuccess(w, r, next, claims)
+	})
+}
@@ -0,0 +1,282 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"golang.org/x/oauth2"
+)
+
+// A login of a user. The browser holds a random secret in the auth_session cookie, the store only knows its hash (the ID),
+// so the IDs can be listed without handing out working sessions.
+type Session struct {
+	ID           string
+	Email        string
+	CreatedAt    time.Time
+	LastSeenAt   time.Time
+	ExpiresAt    time.Time
+	RefreshToken string
+}
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// A SessionStore keeps the sessions and their refresh tokens. Implementations must be safe for concurrent use.
+type SessionStore interface {
+	CreateSession(ctx context.Context, s Session) error
+	// Returns ErrSessionNotFound if there is no such session
+	GetSession(ctx context.Context, id string) (Session, error)
+	// Stores a new refresh token (and its expiry) and marks the session as seen
+	UpdateSession(ctx context.Context, id string, refreshToken string, expiresAt time.Time) error
+	// Returns ErrSessionNotFound if there is no such session
+	DeleteSession(ctx context.Context, id string) error
+	ListSessions(ctx context.Context) ([]Session, error)
+	DeleteExpiredSessions(ctx context.Context) error
+}
+
+var sessions SessionStore = newMemorySessionStore()
+
+// SetSessionStore replaces the default in-memory store, e.g. with one that survives restarts.
+func SetSessionStore(s SessionStore) { sessions = s }
+
+// Keeps the sessions in memory, they are lost on restart.
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: map[string]Session{}}
+}
+
+func (m *memorySessionStore) CreateSession(ctx context.Context, s Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.ID] = s
+	return nil
+}
+
+func (m *memorySessionStore) GetSession(ctx context.Context, id string) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+	return s, nil
+}
+
+func (m *memorySessionStore) UpdateSession(ctx context.Context, id string, refreshToken string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	s.RefreshToken = refreshToken
+	s.ExpiresAt = expiresAt
+	s.LastSeenAt = time.Now()
+	m.sessions[id] = s
+	return nil
+}
+
+func (m *memorySessionStore) DeleteSession(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[id]; !ok {
+		return ErrSessionNotFound
+	}
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *memorySessionStore) ListSessions(ctx context.Context) ([]Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := []Session{}
+	for _, s := range m.sessions {
+		res = append(res, s)
+	}
+	slices.SortFunc(res, func(a, b Session) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return res, nil
+}
+
+func (m *memorySessionStore) DeleteExpiredSessions(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, s := range m.sessions {
+		if s.ExpiresAt.Before(time.Now()) {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+// Hashes the secret from the auth_session cookie into the session ID
+func sessionID(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CurrentSessionID returns the ID of the session the request was made with, or "" if there is none.
+func CurrentSessionID(r *http.Request) string {
+	c, err := r.Cookie("auth_session")
+	if err != nil || c.Value == "" {
+		return ""
+	}
+	return sessionID(c.Value)
+}
+
+// Until when the refresh token of a Keycloak token response can be used.
+// Falls back to a day if Keycloak does not say.
+func refreshExpiry(token *oauth2.Token) time.Time {
+	if secs, ok := token.Extra("refresh_expires_in").(float64); ok && secs > 0 {
+		return time.Now().Add(time.Duration(secs) * time.Second)
+	}
+	return time.Now().Add(24 * time.Hour)
+}
+
+// Starts a session for a freshly logged in user and sets its cookies
+func createSession(ctx context.Context, w http.ResponseWriter, r *http.Request, email string, token *oauth2.Token) error {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Errorf("Failed to generate session secret: %v", err)
+	}
+	secret := base64.RawURLEncoding.EncodeToString(buf)
+
+	s := Session{
+		ID:           sessionID(secret),
+		Email:        email,
+		CreatedAt:    time.Now(),
+		LastSeenAt:   time.Now(),
+		ExpiresAt:    refreshExpiry(token),
+		RefreshToken: token.RefreshToken,
+	}
+	if err := sessions.CreateSession(ctx, s); err != nil {
+		return fmt.Errorf("Failed to store session: %v", err)
+	}
+
+	setCookieMaxAge(w, r, "auth_session", secret, time.Until(s.ExpiresAt))
+	setCookie(w, r, "auth_token", token.Extra("id_token").(string))
+	return nil
+}
+
+// Gets a new ID token with the refresh token of the session, stores the new refresh token and sets the auth_token cookie.
+func refreshSession(ctx context.Context, w http.ResponseWriter, r *http.Request, s Session) (*oidc.IDToken, error) {
+	token, err := oauth2Config.TokenSource(ctx, &oauth2.Token{RefreshToken: s.RefreshToken}).Token()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to refresh token: %v", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("Failed to refresh token: No id_token in the token response")
+	}
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to verify refreshed token: %v", err)
+	}
+
+	refreshToken := token.RefreshToken
+	if refreshToken == "" {
+		refreshToken = s.RefreshToken
+	}
+	if err := sessions.UpdateSession(ctx, s.ID, refreshToken, refreshExpiry(token)); err != nil {
+		return nil, fmt.Errorf("Failed to update session: %v", err)
+	}
+
+	setCookie(w, r, "auth_token", rawIDToken)
+	return idToken, nil
+}
+
+// Looks up the session of the request, and the ID token it carries (refreshed through the session if needed)
+func sessionToken(ctx context.Context, w http.ResponseWriter, r *http.Request) (Session, *oidc.IDToken, error) {
+	id := CurrentSessionID(r)
+	if id == "" {
+		return Session{}, nil, fmt.Errorf("No session cookie")
+	}
+	s, err := sessions.GetSession(ctx, id)
+	if err != nil {
+		return Session{}, nil, fmt.Errorf("Failed to get session: %v", err)
+	}
+	if s.ExpiresAt.Before(time.Now()) {
+		sessions.DeleteSession(ctx, id)
+		return Session{}, nil, fmt.Errorf("Session expired")
+	}
+
+	if tokenCookie, err := r.Cookie("auth_token"); err == nil {
+		if idToken, err := verifier.Verify(ctx, tokenCookie.Value); err == nil {
+			return s, idToken, nil
+		}
+	}
+
+	idToken, err := refreshSession(ctx, w, r, s)
+	if err != nil {
+		return Session{}, nil, err
+	}
+	return s, idToken, nil
+}
+
+// Logout ends the session of the request and clears the auth cookies.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	if !sameOrigin(r) {
+		http.Error(w, "Cross-origin logout is not allowed", http.StatusForbidden)
+		return
+	}
+	if id := CurrentSessionID(r); id != "" {
+		if s, err := sessions.GetSession(r.Context(), id); err == nil {
+			noteIdentity(r, s.Email)
+		}
+		if err := sessions.DeleteSession(r.Context(), id); err != nil && !errors.Is(err, ErrSessionNotFound) {
+			log.Printf("Failed to delete session: %v", err)
+		}
+	}
+	setCookieMaxAge(w, r, "auth_session", "", -time.Second)
+	setCookieMaxAge(w, r, "auth_token", "", -time.Second)
+}
+
+// Browsers send an Origin header with every POST, requests without one (e.g. from the CLI or curl) are not cross-site.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// ListSessions returns the sessions of the given user, or of everybody if email is empty.
+func ListSessions(ctx context.Context, email string) ([]Session, error) {
+	all, err := sessions.ListSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if email == "" {
+		return all, nil
+	}
+	return slices.DeleteFunc(all, func(s Session) bool { return !strings.EqualFold(s.Email, email) }), nil
+}
+
+// RevokeSession ends a session, logging its user out on their next request.
+// Unless email is empty, only sessions of that user can be revoked.
+func RevokeSession(ctx context.Context, id string, email string) error {
+	s, err := sessions.GetSession(ctx, id)
+	if err != nil {
+		return err
+	}
+	if email != "" && !strings.EqualFold(s.Email, email) {
+		return ErrSessionNotFound
+	}
+	return sessions.DeleteSession(ctx, id)
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var AppConfig Config = Config{}
@@ -29,15 +30,49 @@ type Config struct {
 	SSH_COMP_USER            string
 	SSH_COMP_PKEY_PASSPHRASE string
 
-	NETCENTER_HOST string
-	NETCENTER_USER string
-	NETCENTER_PWD  string
+	NETCENTER_HOST    string
+	NETCENTER_USER    string
+	NETCENTER_PWD     string
+	NETCENTER_SUBNETS string
 
 	KEYCLOAK_ISSUER_URL              string
 	KEYCLOAK_CLIENT_ID               string
 	KEYCLOAK_CLIENT_SECRET           string
 	KEYCLOAK_RESTRICT_AUTH_TO_GROUPS []string
+	KEYCLOAK_ROLE_MAPPING            string
+	CLI_USER_EMAILS                  string
 	AUTH_SKIP                        bool
+	AUTH_SKIP_USER                   string
+	SESSION_STORE                    string
+	SESSION_ENCRYPTION_KEY           string
+	FOUR_EYES_ACTIONS                []string
+	FOUR_EYES_EXPIRY                 time.Duration
+
+	// Abuse protection of the public VM request form
+	VMREQUEST_LIMIT_IP_INTERVAL    time.Duration
+	VMREQUEST_LIMIT_IP_BURST       int
+	VMREQUEST_LIMIT_EMAIL_INTERVAL time.Duration
+	VMREQUEST_LIMIT_EMAIL_BURST    int
+	VMREQUEST_MAX_OPEN_PER_EMAIL   int
+	TRUST_PROXY_HEADERS            bool
+
+	VMREQUEST_VERIFY_PERSONAL_EMAIL bool
+	VMREQUEST_UNVERIFIED_TTL        time.Duration
+
+	// Size limit of the custom cloud-init user-data of a request, 0 disables it
+	VMREQUEST_USER_DATA_MAX_BYTES int
+	// Directory with the post-install hook scripts, see the hooks package
+	VM_HOOKS_DIR string
+
+	// Default quotas per university email and per organization, 0 for no limit
+	QUOTA_USER_VMS     int
+	QUOTA_USER_CORES   int
+	QUOTA_USER_RAM_GB  int
+	QUOTA_USER_DISK_GB int
+	QUOTA_ORG_VMS      int
+	QUOTA_ORG_CORES    int
+	QUOTA_ORG_RAM_GB   int
+	QUOTA_ORG_DISK_GB  int
 
 	POSTGRES_USER     string
 	POSTGRES_PASSWORD string
@@ -52,9 +87,13 @@ type Config struct {
 	SMTP_PASSWORD          string
 	SMTP_RECEIVER_OVERRIDE string
 
+	NOTIFY_CONFIG string
+
 	VM_PERSONAL_POOL     string
 	VM_ORGANIZATION_POOL string
 
+	PROVISIONING_PROFILES string
+
 	PATH_PREFIX string
 
 	LOG_RETENTION_DAYS  int
@@ -89,12 +128,85 @@ func (c *Config) Init() error {
 	c.NETCENTER_HOST = os.Getenv("NETCENTER_HOST")
 	c.NETCENTER_USER = os.Getenv("NETCENTER_USER")
 	c.NETCENTER_PWD = os.Getenv("NETCENTER_PWD")
+	c.NETCENTER_SUBNETS = os.Getenv("NETCENTER_SUBNETS")
 
 	c.KEYCLOAK_ISSUER_URL = os.Getenv("KEYCLOAK_ISSUER_URL")
 	c.KEYCLOAK_CLIENT_ID = os.Getenv("KEYCLOAK_CLIENT_ID")
 	c.KEYCLOAK_CLIENT_SECRET = os.Getenv("KEYCLOAK_CLIENT_SECRET")
 	c.KEYCLOAK_RESTRICT_AUTH_TO_GROUPS = strings.Split(os.Getenv("KEYCLOAK_RESTRICT_AUTH_TO_GROUPS"), ",")
+	c.KEYCLOAK_ROLE_MAPPING = os.Getenv("KEYCLOAK_ROLE_MAPPING")
+	c.CLI_USER_EMAILS = os.Getenv("CLI_USER_EMAILS")
 	c.AUTH_SKIP = os.Getenv("AUTH_SKIP") == "true"
+	c.AUTH_SKIP_USER = os.Getenv("AUTH_SKIP_USER")
+	c.SESSION_ENCRYPTION_KEY = os.Getenv("SESSION_ENCRYPTION_KEY")
+	c.SESSION_STORE = os.Getenv("SESSION_STORE")
+	if c.SESSION_STORE == "" {
+		c.SESSION_STORE = "memory"
+		if c.SESSION_ENCRYPTION_KEY != "" {
+			c.SESSION_STORE = "postgres"
+		}
+	}
+	if c.SESSION_STORE != "memory" && c.SESSION_STORE != "postgres" {
+		return fmt.Errorf("Failed to parse config: SESSION_STORE must be 'memory' or 'postgres', not '%v'", c.SESSION_STORE)
+	}
+	if c.SESSION_STORE == "postgres" && c.SESSION_ENCRYPTION_KEY == "" {
+		return fmt.Errorf("Failed to parse config: SESSION_STORE=postgres requires SESSION_ENCRYPTION_KEY")
+	}
+	c.FOUR_EYES_ACTIONS = []string{}
+	for _, a := range strings.Split(os.Getenv("FOUR_EYES_ACTIONS"), ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			c.FOUR_EYES_ACTIONS = append(c.FOUR_EYES_ACTIONS, a)
+		}
+	}
+	if c.FOUR_EYES_EXPIRY, err = durationEnv("FOUR_EYES_EXPIRY", 24*time.Hour); err != nil {
+		return err
+	} else if c.FOUR_EYES_EXPIRY == 0 {
+		return fmt.Errorf("Failed to parse config: FOUR_EYES_EXPIRY: Value must be greater than 0")
+	}
+
+	if c.VMREQUEST_LIMIT_IP_INTERVAL, err = durationEnv("VMREQUEST_LIMIT_IP_INTERVAL", 10*time.Minute); err != nil {
+		return err
+	}
+	if c.VMREQUEST_LIMIT_IP_BURST, err = intEnv("VMREQUEST_LIMIT_IP_BURST", 5); err != nil {
+		return err
+	}
+	if c.VMREQUEST_LIMIT_EMAIL_INTERVAL, err = durationEnv("VMREQUEST_LIMIT_EMAIL_INTERVAL", time.Hour); err != nil {
+		return err
+	}
+	if c.VMREQUEST_LIMIT_EMAIL_BURST, err = intEnv("VMREQUEST_LIMIT_EMAIL_BURST", 3); err != nil {
+		return err
+	}
+	if c.VMREQUEST_MAX_OPEN_PER_EMAIL, err = intEnv("VMREQUEST_MAX_OPEN_PER_EMAIL", 3); err != nil {
+		return err
+	}
+	c.TRUST_PROXY_HEADERS = os.Getenv("TRUST_PROXY_HEADERS") == "true"
+
+	c.VMREQUEST_VERIFY_PERSONAL_EMAIL = os.Getenv("VMREQUEST_VERIFY_PERSONAL_EMAIL") == "true"
+	if c.VMREQUEST_UNVERIFIED_TTL, err = durationEnv("VMREQUEST_UNVERIFIED_TTL", 48*time.Hour); err != nil {
+		return err
+	} else if c.VMREQUEST_UNVERIFIED_TTL == 0 {
+		return fmt.Errorf("Failed to parse config: VMREQUEST_UNVERIFIED_TTL: Value must be greater than 0")
+	}
+
+	if c.VMREQUEST_USER_DATA_MAX_BYTES, err = intEnv("VMREQUEST_USER_DATA_MAX_BYTES", 16384); err != nil {
+		return err
+	}
+	c.VM_HOOKS_DIR = os.Getenv("VM_HOOKS_DIR")
+
+	for name, dst := range map[string]*int{
+		"QUOTA_USER_VMS":     &c.QUOTA_USER_VMS,
+		"QUOTA_USER_CORES":   &c.QUOTA_USER_CORES,
+		"QUOTA_USER_RAM_GB":  &c.QUOTA_USER_RAM_GB,
+		"QUOTA_USER_DISK_GB": &c.QUOTA_USER_DISK_GB,
+		"QUOTA_ORG_VMS":      &c.QUOTA_ORG_VMS,
+		"QUOTA_ORG_CORES":    &c.QUOTA_ORG_CORES,
+		"QUOTA_ORG_RAM_GB":   &c.QUOTA_ORG_RAM_GB,
+		"QUOTA_ORG_DISK_GB":  &c.QUOTA_ORG_DISK_GB,
+	} {
+		if *dst, err = intEnv(name, 0); err != nil {
+			return err
+		}
+	}
 
 	c.POSTGRES_USER = os.Getenv("POSTGRES_USER")
 	c.POSTGRES_PASSWORD = os.Getenv("POSTGRES_PASSWORD")
@@ -109,9 +221,13 @@ func (c *Config) Init() error {
 	c.SMTP_PASSWORD = os.Getenv("SMTP_PASSWORD")
 	c.SMTP_RECEIVER_OVERRIDE = os.Getenv("SMTP_RECEIVER_OVERRIDE")
 
+	c.NOTIFY_CONFIG = os.Getenv("NOTIFY_CONFIG")
+
 	c.VM_PERSONAL_POOL = os.Getenv("VM_PERSONAL_POOL")
 	c.VM_ORGANIZATION_POOL = os.Getenv("VM_ORGANIZATION_POOL")
 
+	c.PROVISIONING_PROFILES = os.Getenv("PROVISIONING_PROFILES")
+
 	c.PATH_PREFIX = os.Getenv("PATH_PREFIX")
 
 	v, err := strconv.Atoi(os.Getenv("LOG_RETENTION_DAYS"))
@@ -132,3 +248,38 @@ func (c *Config) Init() error {
 
 	return nil
 }
+
+// URL returns the public URL of path (starting with /) on VMWiz, as linked to in emails
+func (c *Config) URL(path string) string {
+	return c.VMWIZ_SCHEME + "://" + c.VMWIZ_HOSTNAME + ":" + strconv.Itoa(c.VMWIZ_PORT) + path
+}
+
+// Parses the duration in env variable name (e.g. 10m), def if it is not set. Negative values are not allowed.
+func durationEnv(name string, def time.Duration) (time.Duration, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to parse config: %v: %v", name, err.Error())
+	} else if d < 0 {
+		return 0, fmt.Errorf("Failed to parse config: %v: Value must not be negative, value is %v", name, d)
+	}
+	return d, nil
+}
+
+// Parses the integer in env variable name, def if it is not set. Negative values are not allowed.
+func intEnv(name string, def int) (int, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to parse config: %v: %v", name, err.Error())
+	} else if v < 0 {
+		return 0, fmt.Errorf("Failed to parse config: %v: Value must not be negative, value is %v", name, v)
+	}
+	return v, nil
+}
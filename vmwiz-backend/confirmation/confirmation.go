@@ -14,24 +14,76 @@ import (
 func Init() {
 }
 
+// What a confirmable request is about to act on
+type Target struct {
+	// The string the user has to type, derived from the resource, e.g "accept request 123" or a hostname
+	Token string
+	// Human readable description of what will happen, shown next to the token
+	Summary string
+}
+
+// Derives the target from the (raw) request body.
+// Returning an error rejects the request with 400 and the error message.
+type TargetFunc func(r *http.Request, body []byte) (Target, error)
+
+// Target of routes that do not act on a specific resource
+func Fixed(token string, summary string) TargetFunc {
+	return func(r *http.Request, body []byte) (Target, error) {
+		return Target{Token: token, Summary: summary}, nil
+	}
+}
+
 // Middleware for handling confirmation tokens.
 // We are interested only in updating/destructive operations (i.e not GET).
-// - If ?preview=true, we respond directly with the confirmation token (the handler is never called).
+// The expected token is always derived from the body of the request itself, so a token
+// obtained for one resource can never confirm an action on another one.
+// - If ?preview=true, we respond directly with the confirmation token and a summary (the handler is never called).
 // - Otherwise, we retrieve the token from the body and verify it. We return an error if it's invalid.
 // - If the token is valid, we call next (the action is confirmed).
-func ConfirmMiddleware(token string, next http.Handler) http.Handler {
+func ConfirmMiddleware(target TargetFunc, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" {
 			next.ServeHTTP(w, r)
 			return
 		}
 
+		bodyBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Printf("Error reading request body: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		r.Body.Close()
+
+		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+
+		type bodyS struct {
+			ConfirmationToken string `json:"confirmationToken"`
+		}
+		var body bodyS
+		if len(bytes.TrimSpace(bodyBytes)) > 0 {
+			err = json.Unmarshal(bodyBytes, &body)
+			if err != nil {
+				log.Printf("Error unmarshalling request body: %v", err)
+				http.Error(w, "Invalid request payload", http.StatusBadRequest)
+				return
+			}
+		}
+
+		t, err := target(r, bodyBytes)
+		if err != nil {
+			log.Printf("Error resolving confirmation target: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// If ?preview=true, respond with the token directly. The handler is not called.
 		if r.URL.Query().Get("preview") == "true" {
 			type response struct {
 				ConfirmationToken string `json:"confirmationToken"`
+				Summary           string `json:"summary"`
 			}
-			respJSON, err := json.Marshal(response{ConfirmationToken: token})
+			respJSON, err := json.Marshal(response{ConfirmationToken: t.Token, Summary: t.Summary})
 			if err != nil {
 				log.Printf("Error marshalling confirmation token: %v", err)
 				http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
@@ -42,29 +94,7 @@ func ConfirmMiddleware(token string, next http.Handler) http.Handler {
 			return
 		}
 
-		// Otherwise we try to retrieve the confirmation token from the body and verify it
-		type bodyS struct {
-			ConfirmationToken string `json:"confirmationToken"`
-		}
-		var body bodyS
-		bodyBytes, err := io.ReadAll(r.Body)
-		if err != nil {
-			log.Printf("Error reading request body: %v", err)
-			http.Error(w, "Invalid request payload", http.StatusBadRequest)
-			return
-		}
-		r.Body.Close()
-
-		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-
-		err = json.Unmarshal(bodyBytes, &body)
-		if err != nil {
-			log.Printf("Error unmarshalling request body: %v", err)
-			http.Error(w, "Invalid request payload", http.StatusBadRequest)
-			return
-		}
-
-		if body.ConfirmationToken != token {
+		if t.Token == "" || body.ConfirmationToken != t.Token {
 			http.Error(w, "Confirmation token is invalid", 409)
 			return
 		}
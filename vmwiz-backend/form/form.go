@@ -1,12 +1,17 @@
 package form
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"regexp"
+	"strings"
 
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/images"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/netcenter"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/proxmox"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/quota"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/exp/slices"
@@ -27,6 +32,8 @@ type Form struct {
 	SecondaryDiskGB int    `json:"secondaryDiskGB"`
 
 	SshPubkeys []string `json:"sshPubkey"`
+	// Optional #cloud-config merged into the cloud-init user-data of the VM
+	UserData string `json:"userData"`
 
 	Comments     string `json:"comments"`
 	Accept_terms bool   `json:"accept_terms"`
@@ -44,8 +51,10 @@ type Form_validation struct {
 	DiskGB_err          string `json:"diskGB"`
 	SecondaryDiskGB_err string `json:"secondaryDiskGB"`
 	Explanation_err     string `json:"explanation"`
+	Quota_err           string `json:"quota"`
 
 	SshPubkeys_err []string `json:"sshPubkey"`
+	UserData_err   string   `json:"userData"`
 
 	Accept_terms_err string `json:"accept_terms"`
 }
@@ -56,11 +65,10 @@ type minmax struct {
 	Max int `json:"max"`
 }
 type form_allowed_values struct {
-	Images          []string `json:"image"`
-	Cores           minmax   `json:"cores"`
-	RamGB           minmax   `json:"ramGB"`
-	DiskGB          minmax   `json:"diskGB"`
-	SecondaryDiskGB minmax   `json:"secondaryDiskGB"`
+	Cores           minmax `json:"cores"`
+	RamGB           minmax `json:"ramGB"`
+	DiskGB          minmax `json:"diskGB"`
+	SecondaryDiskGB minmax `json:"secondaryDiskGB"`
 }
 
 type needs_explanation_values struct {
@@ -70,25 +78,10 @@ type needs_explanation_values struct {
 	SecondaryDiskGB int `json:"secondaryDiskGB"`
 }
 
-// If the requested resources exceed these values, the user must provide an explanation for their request.
-var NEEDS_EXPLANATION needs_explanation_values = needs_explanation_values{
-	Cores:           5, //cores aren't really a problem anyway in theory
-	RamGB:           4,
-	DiskGB:          30,
-	SecondaryDiskGB: 0,
-}
-
-var ALLOWED_VALUES form_allowed_values = form_allowed_values{
-	Images:          []string{proxmox.IMAGE_UBUNTU_24_04, proxmox.IMAGE_DEBIAN_13},
-	Cores:           minmax{Min: 1, Max: 8},
-	RamGB:           minmax{Min: 2, Max: 16},
-	DiskGB:          minmax{Min: 15, Max: 100},
-	SecondaryDiskGB: minmax{Min: 0, Max: 500},
-}
-
 func (f *Form) Validate() (Form_validation, bool) {
 	var validation Form_validation
 	var err bool = false
+	settings := Current()
 
 	university_email_regexp, _ := regexp.Compile("^[0-9A-Za-z+._~\\-!#$%&'.\\/=^{}|]+@(ethz|uzh)\\.ch$")
 	if !university_email_regexp.Match([]byte(f.Email)) {
@@ -118,14 +111,14 @@ func (f *Form) Validate() (Form_validation, bool) {
 		err = true
 	}
 
-	taken, e := proxmox.ExistsVMName(fmt.Sprintf("%v.vsos.ethz.ch", f.Hostname))
+	taken, e := proxmox.ExistsVMName(FQDN(f.Hostname))
 	if e != nil {
 		log.Printf("ERROR: %v\n", e)
 		validation.Hostname_err = "Hostname cannot be validated"
 		err = true
 	}
 
-	existing_ipv4s, existing_ipv6sm, e := netcenter.GetHostIPs(fmt.Sprintf("%v.vsos.ethz.ch", f.Hostname))
+	existing_ipv4s, existing_ipv6sm, e := netcenter.GetHostIPs(FQDN(f.Hostname))
 	if e != nil {
 		log.Printf("ERROR: %v\n", e)
 		validation.Hostname_err = "Hostname cannot be validated"
@@ -141,33 +134,56 @@ func (f *Form) Validate() (Form_validation, bool) {
 		validation.Image_err = "Please specify an image"
 	}
 
-	// If f image no in list of images
-	if !slices.Contains(ALLOWED_VALUES.Images, f.Image) {
+	// The image must be enabled in the catalog
+	enabled, e := images.Enabled(context.Background())
+	if e != nil {
+		log.Printf("ERROR: %v\n", e)
+		validation.Image_err = "Image cannot be validated"
+		err = true
+	}
+	idx := slices.IndexFunc(enabled, func(img images.Image) bool { return img.Name == f.Image })
+	if e == nil && idx < 0 {
 		validation.Image_err = "Please select a valid image"
 		err = true
 	}
 
-	if f.Cores < ALLOWED_VALUES.Cores.Min {
+	if f.Cores < settings.Cores.Min {
 		core := "core"
-		if ALLOWED_VALUES.Cores.Min > 1 {
+		if settings.Cores.Min > 1 {
 			core = "cores"
 		}
-		validation.Cores_err = fmt.Sprintf("Please select at least %d %s", ALLOWED_VALUES.Cores.Min, core)
+		validation.Cores_err = fmt.Sprintf("Please select at least %d %s", settings.Cores.Min, core)
+		err = true
+	}
+	if f.Cores > settings.Cores.Max {
+		validation.Cores_err = fmt.Sprintf("Please select at most %d cores", settings.Cores.Max)
 		err = true
 	}
 
-	if f.RamGB < ALLOWED_VALUES.RamGB.Min {
-		validation.RamGB_err = fmt.Sprintf("Please select at least %d GB of RAM", ALLOWED_VALUES.RamGB.Min)
+	if f.RamGB < settings.RamGB.Min {
+		validation.RamGB_err = fmt.Sprintf("Please select at least %d GB of RAM", settings.RamGB.Min)
+		err = true
+	}
+	if f.RamGB > settings.RamGB.Max {
+		validation.RamGB_err = fmt.Sprintf("Please select at most %d GB of RAM", settings.RamGB.Max)
 		err = true
 	}
 
-	if f.DiskGB < ALLOWED_VALUES.DiskGB.Min {
-		validation.DiskGB_err = fmt.Sprintf("Please select at least %d GB of disk space", ALLOWED_VALUES.DiskGB.Min)
+	if f.DiskGB < settings.DiskGB.Min {
+		validation.DiskGB_err = fmt.Sprintf("Please select at least %d GB of disk space", settings.DiskGB.Min)
+		err = true
+	}
+	if f.DiskGB > settings.DiskGB.Max {
+		validation.DiskGB_err = fmt.Sprintf("Please select at most %d GB of disk space", settings.DiskGB.Max)
+		err = true
+	}
+	if idx >= 0 && f.DiskGB < enabled[idx].MinDiskGB {
+		validation.DiskGB_err = fmt.Sprintf("%v needs at least %d GB of disk space", f.Image, enabled[idx].MinDiskGB)
 		err = true
 	}
 
-	if f.SecondaryDiskGB < ALLOWED_VALUES.SecondaryDiskGB.Min || f.SecondaryDiskGB > ALLOWED_VALUES.SecondaryDiskGB.Max {
-		validation.SecondaryDiskGB_err = fmt.Sprintf("Please select a value between %d and %d", ALLOWED_VALUES.SecondaryDiskGB.Min, ALLOWED_VALUES.SecondaryDiskGB.Max)
+	if f.SecondaryDiskGB < settings.SecondaryDiskGB.Min || f.SecondaryDiskGB > settings.SecondaryDiskGB.Max {
+		validation.SecondaryDiskGB_err = fmt.Sprintf("Please select a value between %d and %d", settings.SecondaryDiskGB.Min, settings.SecondaryDiskGB.Max)
 		err = true
 	}
 
@@ -186,15 +202,50 @@ func (f *Form) Validate() (Form_validation, bool) {
 		}
 	}
 
+	if strings.TrimSpace(f.UserData) == "" {
+		f.UserData = ""
+	} else if config.AppConfig.VMREQUEST_USER_DATA_MAX_BYTES == 0 {
+		validation.UserData_err = "Custom cloud-init user-data is disabled"
+		err = true
+	} else if len(f.UserData) > config.AppConfig.VMREQUEST_USER_DATA_MAX_BYTES {
+		validation.UserData_err = fmt.Sprintf("The user-data must be at most %d bytes", config.AppConfig.VMREQUEST_USER_DATA_MAX_BYTES)
+		err = true
+	} else if e := proxmox.ValidateUserData(f.UserData); e != nil {
+		validation.UserData_err = fmt.Sprintf("Invalid cloud-init user-data: %v", e)
+		err = true
+	}
+
 	if !f.Accept_terms {
 		validation.Accept_terms_err = "You must read and accept the terms"
 		err = true
 	}
 
-	if (f.Cores > NEEDS_EXPLANATION.Cores || f.RamGB > NEEDS_EXPLANATION.RamGB || f.DiskGB > NEEDS_EXPLANATION.DiskGB) && f.Comments == "" {
+	if (f.Cores > settings.NeedsExplanation.Cores || f.RamGB > settings.NeedsExplanation.RamGB || f.DiskGB > settings.NeedsExplanation.DiskGB) && f.Comments == "" {
 		validation.Explanation_err = "Please provide an explanation for your request, as you are requesting for a more significant amount of resources. We can always increase resources later if needed."
 		err = true
 	}
 
+	// Only checked for otherwise valid requests, it needs the usage of all VMs
+	if !err {
+		orgName := ""
+		if f.IsOrganization {
+			orgName = f.OrgName
+		}
+		over, e := quota.Check(context.Background(), f.Email, orgName, quota.Resources{
+			VMs:    1,
+			Cores:  f.Cores,
+			RamGB:  f.RamGB,
+			DiskGB: f.DiskGB + f.SecondaryDiskGB,
+		})
+		if e != nil {
+			log.Printf("ERROR: %v\n", e)
+			validation.Quota_err = "Quota cannot be validated"
+			err = true
+		} else if over != "" {
+			validation.Quota_err = over
+			err = true
+		}
+	}
+
 	return validation, err
 }
@@ -0,0 +1,200 @@
+package form
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/images"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
+)
+
+// Settings of the VM request form. They are stored in the database and can be changed by superadmins
+// at runtime, see Load and Save. Other processes (more backend instances, the CLI) see a change after SETTINGS_TTL. The images are in the image catalog, see the images package.
+type Settings struct {
+	// The VMs are created as <hostname>.<Domain>
+	Domain string `json:"domain"`
+	form_allowed_values
+	// If the requested resources exceed these values, the user must provide an explanation for their request.
+	NeedsExplanation needs_explanation_values `json:"needsExplanation"`
+}
+
+// Used as long as no superadmin changed the settings
+var DEFAULT_SETTINGS = Settings{
+	Domain: "vsos.ethz.ch",
+	form_allowed_values: form_allowed_values{
+		Cores:           minmax{Min: 1, Max: 8},
+		RamGB:           minmax{Min: 2, Max: 16},
+		DiskGB:          minmax{Min: 15, Max: 100},
+		SecondaryDiskGB: minmax{Min: 0, Max: 500},
+	},
+	NeedsExplanation: needs_explanation_values{
+		Cores:           5, //cores aren't really a problem anyway in theory
+		RamGB:           4,
+		DiskGB:          30,
+		SecondaryDiskGB: 0,
+	},
+}
+
+// How long the settings are used before they are read from the database again
+const SETTINGS_TTL = 30 * time.Second
+
+var (
+	settingsMu sync.RWMutex
+	settings   = DEFAULT_SETTINGS
+	// When the settings were last read from the database, zero as long as Load was never called
+	loadedAt time.Time
+)
+
+var domain_regexp = regexp.MustCompile(`^([a-z0-9-]+\.)+[a-z]{2,}$`)
+
+// Current returns the settings in effect, reading them again if they are older than SETTINGS_TTL
+func Current() Settings {
+	settingsMu.RLock()
+	s, stale := settings, !loadedAt.IsZero() && time.Since(loadedAt) > SETTINGS_TTL
+	settingsMu.RUnlock()
+	if !stale {
+		return s
+	}
+
+	// Only one caller reloads, the others keep using the current settings in the meantime
+	settingsMu.Lock()
+	stale = time.Since(loadedAt) > SETTINGS_TTL
+	if stale {
+		loadedAt = time.Now()
+	}
+	settingsMu.Unlock()
+	if !stale {
+		return s
+	}
+	if err := Load(context.Background()); err != nil {
+		log.Printf("Keeping the current form settings: %v", err)
+		return s
+	}
+
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return settings
+}
+
+// FQDN of a VM with the given hostname, in the configured domain
+func FQDN(hostname string) string {
+	return fmt.Sprintf("%v.%v", hostname, Current().Domain)
+}
+
+func (s Settings) Validate() error {
+	if !domain_regexp.MatchString(s.Domain) {
+		return fmt.Errorf("invalid domain '%v'", s.Domain)
+	}
+
+	for name, r := range map[string]struct {
+		limits minmax
+		least  int
+	}{
+		"cores":           {s.Cores, 1},
+		"ramGB":           {s.RamGB, 1},
+		"diskGB":          {s.DiskGB, 1},
+		"secondaryDiskGB": {s.SecondaryDiskGB, 0},
+	} {
+		if r.limits.Min < r.least {
+			return fmt.Errorf("%v: min must be at least %d", name, r.least)
+		}
+		if r.limits.Max < r.limits.Min {
+			return fmt.Errorf("%v: max must not be less than min", name)
+		}
+	}
+
+	n := s.NeedsExplanation
+	if n.Cores < 0 || n.RamGB < 0 || n.DiskGB < 0 || n.SecondaryDiskGB < 0 {
+		return fmt.Errorf("needsExplanation: values must not be negative")
+	}
+	return nil
+}
+
+// What the form offers, served by /api/vmrequest/options
+type Options struct {
+	Settings
+	// Names of the enabled images, in form order
+	Images []string `json:"image"`
+	// Minimum disk size of the images that need more than diskGB.min
+	ImageMinDiskGB map[string]int `json:"imageMinDiskGB"`
+	// Size limit of the custom cloud-init user-data, 0 if it is disabled
+	UserDataMaxBytes int `json:"userDataMaxBytes"`
+}
+
+func GetOptions(ctx context.Context) (Options, error) {
+	enabled, err := images.Enabled(ctx)
+	if err != nil {
+		return Options{}, err
+	}
+
+	opts := Options{
+		Settings:         Current(),
+		Images:           []string{},
+		ImageMinDiskGB:   map[string]int{},
+		UserDataMaxBytes: config.AppConfig.VMREQUEST_USER_DATA_MAX_BYTES,
+	}
+	for _, img := range enabled {
+		opts.Images = append(opts.Images, img.Name)
+		if img.MinDiskGB > opts.DiskGB.Min {
+			opts.ImageMinDiskGB[img.Name] = img.MinDiskGB
+		}
+	}
+	return opts, nil
+}
+
+// Load reads the settings from the database. If there are none stored yet, the defaults are used.
+func Load(ctx context.Context) error {
+	row, err := storage.DB.GetFormSettings(ctx)
+	if err == sql.ErrNoRows {
+		settingsMu.Lock()
+		loadedAt = time.Now()
+		settingsMu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load form settings: %v", err)
+	}
+
+	var s Settings
+	if err := json.Unmarshal(row.Settings, &s); err != nil {
+		return fmt.Errorf("Failed to load form settings: %v", err)
+	}
+	if err := s.Validate(); err != nil {
+		return fmt.Errorf("Failed to load form settings: Invalid settings stored by %v: %v", row.UpdatedBy, err)
+	}
+
+	settingsMu.Lock()
+	settings = s
+	loadedAt = time.Now()
+	settingsMu.Unlock()
+	return nil
+}
+
+// Save validates and stores new settings, they are in effect immediately in this process
+func Save(ctx context.Context, s Settings, actor string) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	err = storage.DB.SaveFormSettings(ctx, storage.SaveFormSettingsParams{Settings: data, UpdatedBy: actor})
+	if err != nil {
+		return fmt.Errorf("Failed to save form settings: %v", err)
+	}
+
+	settingsMu.Lock()
+	settings = s
+	loadedAt = time.Now()
+	settingsMu.Unlock()
+	return nil
+}
@@ -18,6 +18,7 @@ require (
 	golang.org/x/exp v0.0.0-20260529124908-c761662dc8c9
 	golang.org/x/oauth2 v0.36.0
 	golang.org/x/time v0.15.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
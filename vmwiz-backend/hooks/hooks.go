@@ -0,0 +1,89 @@
+package hooks
+
+// Post-install hooks: bash snippets in VM_HOOKS_DIR appended to the post-install script of the VMs.
+// <VM_HOOKS_DIR>/*.sh run for every VM, <VM_HOOKS_DIR>/org/<organization>/*.sh only for the VMs of that organization
+// (directory name in lower case). They are read when a VM is created, so they can be changed without a restart.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/proxmox"
+)
+
+// The *.sh files in dir, in name order (as returned by ReadDir). A missing dir has no hooks.
+func readDir(dir string, prefix string) ([]proxmox.Hook, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read hooks directory '%v': %v", dir, err)
+	}
+
+	hooks := []proxmox.Hook{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sh") {
+			continue
+		}
+		script, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read hook '%v': %v", entry.Name(), err)
+		}
+		hooks = append(hooks, proxmox.Hook{Name: prefix + entry.Name(), Script: string(script)})
+	}
+	return hooks, nil
+}
+
+// The directory of the hooks of an organization, "" if the name cannot be one
+func orgDir(orgName string) string {
+	name := strings.ToLower(strings.TrimSpace(orgName))
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return ""
+	}
+	return filepath.Join(config.AppConfig.VM_HOOKS_DIR, "org", name)
+}
+
+// For returns the hooks of a VM: the common ones first, then those of its organization (orgName empty for personal VMs)
+func For(orgName string) ([]proxmox.Hook, error) {
+	if config.AppConfig.VM_HOOKS_DIR == "" {
+		return nil, nil
+	}
+
+	hooks, err := readDir(config.AppConfig.VM_HOOKS_DIR, "")
+	if err != nil {
+		return nil, err
+	}
+	if dir := orgDir(orgName); dir != "" {
+		org, err := readDir(dir, "org/"+filepath.Base(dir)+"/")
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, org...)
+	}
+	return hooks, nil
+}
+
+// Organizations returns the organizations that have hooks
+func Organizations() ([]string, error) {
+	if config.AppConfig.VM_HOOKS_DIR == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(filepath.Join(config.AppConfig.VM_HOOKS_DIR, "org"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read hooks directory: %v", err)
+	}
+	orgs := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			orgs = append(orgs, entry.Name())
+		}
+	}
+	return orgs, nil
+}
@@ -0,0 +1,154 @@
+package images
+
+// The image catalog: the images offered in the VM request form and how CreateVM installs them.
+// Stored in the database (table vm_image) and editable by superadmins, images are never deleted but disabled.
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/proxmox"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
+)
+
+// An entry of the catalog as shown to and received from admins
+type Image struct {
+	Name string `json:"name"`
+	// qcow2 file, relative to the template storage
+	Path            string `json:"path"`
+	DefaultUser     string `json:"defaultUser"`
+	FirstBootMarker string `json:"firstBootMarker"`
+	SourcesPath     string `json:"sourcesPath"`
+	Sources         string `json:"sources"`
+	PackageManager  string `json:"packageManager"`
+	MinDiskGB       int    `json:"minDiskGB"`
+	Enabled         bool   `json:"enabled"`
+	Position        int    `json:"position"`
+}
+
+var user_regexp = regexp.MustCompile(`^[a-z_][a-z0-9_-]*$`)
+
+func fromRow(row storage.VmImage) Image {
+	return Image{
+		Name:            row.Name,
+		Path:            row.Path,
+		DefaultUser:     row.DefaultUser,
+		FirstBootMarker: row.FirstBootMarker,
+		SourcesPath:     row.SourcesPath,
+		Sources:         row.Sources,
+		PackageManager:  row.PackageManager,
+		MinDiskGB:       int(row.MinDiskGb),
+		Enabled:         row.Enabled,
+		Position:        int(row.Position),
+	}
+}
+
+// ToProxmox returns what CreateVM needs to install the image
+func (img Image) ToProxmox() proxmox.Image {
+	return proxmox.Image{
+		Name:            img.Name,
+		Path:            img.Path,
+		DefaultUser:     img.DefaultUser,
+		FirstBootMarker: img.FirstBootMarker,
+		SourcesPath:     img.SourcesPath,
+		Sources:         img.Sources,
+		PackageManager:  img.PackageManager,
+	}
+}
+
+func (img Image) Validate() error {
+	if strings.TrimSpace(img.Name) == "" {
+		return fmt.Errorf("the name is required")
+	}
+	if !strings.HasSuffix(img.Path, ".qcow2") || path.IsAbs(img.Path) || strings.Contains(img.Path, "..") {
+		return fmt.Errorf("path must be a .qcow2 file relative to the template storage, e.g. cloudinit/current-noble-amd64.qcow2")
+	}
+	if !user_regexp.MatchString(img.DefaultUser) {
+		return fmt.Errorf("invalid default user '%v'", img.DefaultUser)
+	}
+	if _, err := regexp.Compile(img.FirstBootMarker); err != nil || img.FirstBootMarker == "" {
+		return fmt.Errorf("the first boot marker must be a valid regex")
+	}
+	if img.Sources != "" && !path.IsAbs(img.SourcesPath) {
+		return fmt.Errorf("sourcesPath must be an absolute path if sources are given")
+	}
+	if !slices.Contains(proxmox.PACKAGE_MANAGERS, img.PackageManager) {
+		return fmt.Errorf("packageManager must be one of %v", strings.Join(proxmox.PACKAGE_MANAGERS, ", "))
+	}
+	if img.MinDiskGB < 0 {
+		return fmt.Errorf("minDiskGB must not be negative")
+	}
+	return nil
+}
+
+// List returns the whole catalog in form order, including the disabled images
+func List(ctx context.Context) ([]Image, error) {
+	rows, err := storage.DB.ListVMImages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list images: %v", err)
+	}
+	images := []Image{}
+	for _, row := range rows {
+		images = append(images, fromRow(row))
+	}
+	return images, nil
+}
+
+// Enabled returns the images offered in the form
+func Enabled(ctx context.Context) ([]Image, error) {
+	all, err := List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	images := []Image{}
+	for _, img := range all {
+		if img.Enabled {
+			images = append(images, img)
+		}
+	}
+	return images, nil
+}
+
+// Get returns the image with the given name, also if it is disabled: requests made before can still be accepted
+func Get(ctx context.Context, name string) (Image, error) {
+	row, err := storage.DB.GetVMImage(ctx, name)
+	if err == sql.ErrNoRows {
+		return Image{}, fmt.Errorf("Image '%v' is not in the catalog", name)
+	}
+	if err != nil {
+		return Image{}, fmt.Errorf("Failed to get image '%v': %v", name, err)
+	}
+	return fromRow(row), nil
+}
+
+// Save adds an image to the catalog or replaces the one with the same name
+func Save(ctx context.Context, img Image, actor string) error {
+	if img.PackageManager == "" {
+		img.PackageManager = proxmox.PACKAGE_MANAGER_APT
+	}
+	if err := img.Validate(); err != nil {
+		return err
+	}
+	err := storage.DB.SaveVMImage(ctx, storage.SaveVMImageParams{
+		Name:            img.Name,
+		Path:            img.Path,
+		DefaultUser:     img.DefaultUser,
+		FirstBootMarker: img.FirstBootMarker,
+		SourcesPath:     img.SourcesPath,
+		Sources:         img.Sources,
+		PackageManager:  img.PackageManager,
+		MinDiskGb:       int32(img.MinDiskGB),
+		Enabled:         img.Enabled,
+		Position:        int32(img.Position),
+		UpdatedBy:       actor,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to save image '%v': %v", img.Name, err)
+	}
+	return nil
+}
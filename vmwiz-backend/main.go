@@ -2,23 +2,34 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/user"
 	"strings"
+	"time"
 
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/approval"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/audit"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/auth"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/confirmation"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/form"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/logger"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/netcenter"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/notifier"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/proxmox"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/quota"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/reconcile"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/router"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/server"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/startupcheck"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/survey"
 	"github.com/urfave/cli/v3"
 )
 
@@ -36,14 +47,42 @@ func main() {
 	log.SetFlags(0)
 	log.SetOutput(io.MultiWriter(os.Stderr, logger.StdWriter()))
 
+	err = notifier.InitNotifiers()
+	if err != nil {
+		log.Printf("Failed to init notifiers: %v", err.Error())
+		return
+	}
+
 	notifier.InitSMTP()
 
+	err = netcenter.InitSubnets()
+	if err != nil {
+		log.Printf("Failed to init Netcenter subnets: %v", err.Error())
+		return
+	}
+
+	err = proxmox.InitProfiles()
+	if err != nil {
+		log.Printf("Failed to init provisioning profiles: %v", err.Error())
+		return
+	}
+
 	err = storage.DB.Init()
 	if err != nil {
 		log.Printf("Error on startup: %v", err.Error())
 		return
 	}
 	logger.SetStore(&storage.DB)
+	if config.AppConfig.SESSION_STORE == "postgres" {
+		auth.SetSessionStore(auth.PostgresStore{})
+	}
+	auth.SetAPITokenStore(auth.PostgresStore{})
+
+	err = form.Load(context.Background())
+	if err != nil {
+		log.Printf("Error on startup: %v", err.Error())
+		return
+	}
 
 	auth.Init()
 	confirmation.Init()
@@ -72,8 +111,107 @@ func main() {
 				Commands: []*cli.Command{
 					{
 						Name:        "list",
-						Description: "list all free IPv4 addresses",
-						Action:      handle_ip_list,
+						Description: "list all free IPv4 addresses, per subnet",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "subnet",
+								Usage: "only list the free IPs of this subnet",
+							},
+						},
+						Action: handle_ip_list,
+					},
+				},
+			},
+			{
+				Name:        "dns",
+				Description: "look at and fix the DNS records of the VM subnets",
+				Commands: []*cli.Command{
+					{
+						Name:        "list",
+						Description: "list the DNS records of all subnets",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "query",
+								Usage: "only list the records of this IP, or whose FQDN contains this",
+							},
+						},
+						Action: handle_dns_list,
+					},
+					{
+						Name:        "create",
+						Description: "register an FQDN for an IP of one of the subnets",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "ip",
+								Usage:    "IPv4 or IPv6 address",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "FQDN (e.g myvm.vsos.ethz.ch)",
+								Required: true,
+							},
+						},
+						Action: audited("dns.create", handle_dns_create),
+					},
+					{
+						Name:        "verify",
+						Description: "compare what the system resolver answers for an FQDN and its aliases with Netcenter",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "FQDN (e.g myvm.vsos.ethz.ch)",
+								Required: true,
+							},
+						},
+						Action: handle_dns_verify,
+					},
+					{
+						Name:        "alias",
+						Description: "manage the CNAME aliases of a host",
+						Commands: []*cli.Command{
+							{
+								Name:        "list",
+								Description: "list the aliases pointing to a host",
+								Flags: []cli.Flag{
+									&cli.StringFlag{
+										Name:     "name",
+										Usage:    "FQDN of the host",
+										Required: true,
+									},
+								},
+								Action: handle_dns_alias_list,
+							},
+							{
+								Name:        "create",
+								Description: "point an alias to a host",
+								Flags: []cli.Flag{
+									&cli.StringFlag{
+										Name:     "name",
+										Usage:    "FQDN of the alias (e.g www.myorg.vsos.ethz.ch)",
+										Required: true,
+									},
+									&cli.StringFlag{
+										Name:     "target",
+										Usage:    "FQDN of the host (e.g myorg.vsos.ethz.ch)",
+										Required: true,
+									},
+								},
+								Action: audited("dns.alias.create", handle_dns_alias_create),
+							},
+							{
+								Name:        "delete",
+								Description: "delete an alias",
+								Flags: []cli.Flag{
+									&cli.StringFlag{
+										Name:     "name",
+										Usage:    "FQDN of the alias",
+										Required: true,
+									},
+								},
+								Action: audited("dns.alias.delete", handle_dns_alias_delete),
+							},
+						},
 					},
 				},
 			},
@@ -87,7 +225,7 @@ func main() {
 						Flags: []cli.Flag{
 							&cli.BoolFlag{
 								Name:  "all",
-								Usage: "also display accepted and rejected requests",
+								Usage: "also display processed, rejected and withdrawn requests",
 								Value: false,
 							},
 						},
@@ -108,7 +246,24 @@ func main() {
 								Value: "",
 							},
 						},
-						Action: handle_request_accept,
+						Action: audited("vmrequest.accept", handle_request_accept),
+					},
+					{
+						Name:        "retry",
+						Description: "retry creating the VM of a failed request",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:  "id",
+								Usage: "ID of the VM request",
+								Value: -1,
+							},
+							&cli.StringFlag{
+								Name:  "name",
+								Usage: "Hostname of the VM request (e.g myvm.vsos.ethz.ch)",
+								Value: "",
+							},
+						},
+						Action: audited("vmrequest.retry", handle_request_retry),
 					},
 					{
 						Name:        "reject",
@@ -124,8 +279,23 @@ func main() {
 								Usage: "Hostname of the VM request (e.g myvm.vsos.ethz.ch)",
 								Value: "",
 							},
+							&cli.StringFlag{
+								Name:  "reason",
+								Usage: "Reason for the rejection, included in the email to the requester",
+								Value: "",
+							},
+							&cli.StringFlag{
+								Name:  "admin-note",
+								Usage: "Internal note, only visible to admins",
+								Value: "",
+							},
 						},
-						Action: handle_request_reject,
+						Action: audited("vmrequest.reject", handle_request_reject),
+					},
+					{
+						Name:        "link-vms",
+						Description: "store the VM on provisioned requests that have none, found by hostname in Proxmox",
+						Action:      audited("vmrequest.link_vms", handle_request_link_vms),
 					},
 				},
 			},
@@ -175,7 +345,7 @@ func main() {
 								Required: true,
 							},
 						},
-						Action: handle_survey_shutdownunanswered,
+						Action: audited("usagesurvey.shutdown_unanswered", handle_survey_shutdownunanswered),
 					},
 				},
 			},
@@ -184,12 +354,201 @@ func main() {
 				Description: "perform checks on the whole cluster and report potentially dangerous configurations",
 				Action:      handle_sanity,
 			},
+			{
+				Name:        "tokens",
+				Description: "manage API tokens for scripts (sent as 'Authorization: Bearer <token>')",
+				Commands: []*cli.Command{
+					{
+						Name:        "create",
+						Description: "create an API token and print it. It cannot be displayed again",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "Name of the token, e.g. what it is used for",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:  "role",
+								Usage: "Role of the token: viewer, approver, operator or superadmin",
+								Value: "viewer",
+							},
+							&cli.StringSliceFlag{
+								Name:     "scope",
+								Usage:    "Route group the token may use (repeatable): " + strings.Join(auth.API_TOKEN_SCOPES, ", "),
+								Required: true,
+							},
+							&cli.DurationFlag{
+								Name:  "expires-in",
+								Usage: "Lifetime of the token (e.g. 720h), 0 for no expiry",
+								Value: 90 * 24 * time.Hour,
+							},
+						},
+						Action: audited("tokens.create", handle_tokens_create),
+					},
+					{
+						Name:        "list",
+						Description: "list API tokens",
+						Action:      handle_tokens_list,
+					},
+					{
+						Name:        "revoke",
+						Description: "revoke an API token",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:     "id",
+								Usage:    "ID of the token",
+								Required: true,
+							},
+						},
+						Action: audited("tokens.revoke", handle_tokens_revoke),
+					},
+				},
+			},
+			{
+				Name:        "quota",
+				Description: "show the resource usage of users and organizations, manage their quotas",
+				Commands: []*cli.Command{
+					{
+						Name:        "list",
+						Description: "list the usage and quota of every user and organization with VMs or an override",
+						Action:      handle_quota_list,
+					},
+					{
+						Name:        "set",
+						Description: "override the quota of a user or organization. Limits that are not given keep the default, 0 for no limit",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "kind",
+								Usage: "user or org",
+								Value: quota.KIND_USER,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "University email address or organization name",
+								Required: true,
+							},
+							&cli.IntFlag{
+								Name:  "vms",
+								Usage: "Number of VMs",
+							},
+							&cli.IntFlag{
+								Name:  "cores",
+								Usage: "Total cores",
+							},
+							&cli.IntFlag{
+								Name:  "ram-gb",
+								Usage: "Total RAM in GB",
+							},
+							&cli.IntFlag{
+								Name:  "disk-gb",
+								Usage: "Total disk space in GB",
+							},
+							&cli.StringFlag{
+								Name:  "comment",
+								Usage: "Why the quota was changed",
+							},
+						},
+						Action: audited("quota.set", handle_quota_set),
+					},
+					{
+						Name:        "unset",
+						Description: "reset the quota of a user or organization to the defaults",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "kind",
+								Usage: "user or org",
+								Value: quota.KIND_USER,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "University email address or organization name",
+								Required: true,
+							},
+						},
+						Action: audited("quota.delete", handle_quota_unset),
+					},
+				},
+			},
 			{
 				Name:        "emails",
 				Description: "get a list of all e-mail addresses",
 				Action:      handle_emails,
+				Commands: []*cli.Command{
+					{
+						Name:        "queue",
+						Description: "look at or manage the outbound email queue",
+						Commands: []*cli.Command{
+							{
+								Name:        "list",
+								Description: "list queued and failed emails",
+								Flags: []cli.Flag{
+									&cli.BoolFlag{
+										Name:  "all",
+										Usage: "also display sent and cancelled emails",
+										Value: false,
+									},
+								},
+								Action: handle_emails_queue_list,
+							},
+							{
+								Name:        "retry",
+								Description: "schedule a queued or failed email for immediate delivery",
+								Flags: []cli.Flag{
+									&cli.IntFlag{
+										Name:     "id",
+										Usage:    "ID of the email",
+										Required: true,
+									},
+								},
+								Action: audited("mail.retry", handle_emails_queue_retry),
+							},
+							{
+								Name:        "cancel",
+								Description: "cancel a queued or failed email",
+								Flags: []cli.Flag{
+									&cli.IntFlag{
+										Name:     "id",
+										Usage:    "ID of the email",
+										Required: true,
+									},
+								},
+								Action: audited("mail.cancel", handle_emails_queue_cancel),
+							},
+						},
+					},
+				},
 			},
 
+			{
+				Name:        "notify",
+				Description: "manage notification backends",
+				Commands: []*cli.Command{
+					{
+						Name:        "test",
+						Description: "send a test notification for every event to each backend it is routed to",
+						Action:      handle_notify_test,
+					},
+				},
+			},
+			{
+				Name:        "reconcile",
+				Description: "report where Proxmox, Netcenter and the VM requests disagree",
+				Action:      handle_reconcile,
+				Commands: []*cli.Command{
+					{
+						Name:        "apply",
+						Description: "apply the suggested fix of a discrepancy",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "id",
+								Usage:    "ID of the discrepancy, as listed by reconcile",
+								Required: true,
+							},
+						},
+						Action: audited("reconcile.apply", handle_reconcile_apply),
+					},
+				},
+			},
 			{
 				Name:        "descriptions",
 				Description: "get all VM descriptions",
@@ -198,11 +557,65 @@ func main() {
 		},
 	}
 
-	if err := cmd.Run(context.Background(), os.Args); err != nil {
+	if err := cmd.Run(auth.WithActor(context.Background(), cliActor()), os.Args); err != nil {
+		if errors.Is(err, errAborted) {
+			fmt.Println("Aborted.")
+			return
+		}
+		if errors.Is(err, errPendingApproval) {
+			return
+		}
 		log.Fatal(err)
 	}
 }
 
+// Returned by commands the user did not confirm
+var errAborted = errors.New("aborted")
+
+// Returned by commands that were stored as a pending operation instead of being run (see approval.Request)
+var errPendingApproval = errors.New("pending approval")
+
+// Actor of CLI commands, e.g. "cli:alice"
+func cliActor() string {
+	if u, err := user.Current(); err == nil {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
+
+// Records a command in the audit log, with its flags as payload and the --id or --name flag as target.
+// Commands the user aborted are not recorded.
+func audited(action string, handler cli.ActionFunc) cli.ActionFunc {
+	return func(ctx context.Context, cmd *cli.Command) error {
+		err := handler(ctx, cmd)
+		if errors.Is(err, errAborted) || errors.Is(err, errPendingApproval) {
+			return err
+		}
+
+		flags := map[string]any{}
+		for _, name := range cmd.LocalFlagNames() {
+			flags[name] = cmd.Value(name)
+		}
+		payload, _ := json.Marshal(flags)
+
+		e := audit.Entry{Action: action, Payload: string(payload), Result: audit.RESULT_SUCCESS}
+		for _, f := range []string{"id", "name"} {
+			if cmd.IsSet(f) {
+				e.Target = fmt.Sprint(cmd.Value(f))
+				break
+			}
+		}
+		if err != nil {
+			e.Result = audit.RESULT_FAILURE
+			e.Detail = err.Error()
+		}
+		if errA := audit.Record(ctx, e); errA != nil {
+			log.Println(errA)
+		}
+		return err
+	}
+}
+
 // helper function that looks up a VM request by ID or hostname, and checks that it is pending if justpending is true. If multiple matching requests are found, an error is returned.
 func findVMRequest(ctx context.Context, id int, name string, justpending bool) (*storage.Request, error) {
 	vmrequests := []storage.Request{}
@@ -243,14 +656,6 @@ func findVMRequest(ctx context.Context, id int, name string, justpending bool) (
 	return &res[0], nil
 }
 
-func sliceToSet[T comparable](slice []T) map[T]struct{} {
-	set := make(map[T]struct{})
-	for _, val := range slice {
-		set[val] = struct{}{}
-	}
-	return set
-}
-
 func handle_server(ctx context.Context, cmd *cli.Command) error {
 	server.StartServer()
 	return nil
@@ -260,17 +665,146 @@ func handle_health(ctx context.Context, cmd *cli.Command) error {
 	return nil
 }
 func handle_ip_list(ctx context.Context, cmd *cli.Command) error {
-	ips, err := netcenter.GetFreeIPv4sInSubnet(netcenter.VM_SUBNET.V4net)
+	subnets := netcenter.Subnets()
+	if cmd.String("subnet") != "" {
+		subnet, err := netcenter.GetSubnet(cmd.String("subnet"))
+		if err != nil {
+			return err
+		}
+		subnets = []netcenter.NetcenterSubnet{*subnet}
+	}
+
+	for _, subnet := range subnets {
+		ipv4s, ipv6s, err := netcenter.FreeIPs(subnet)
+		if err != nil {
+			fmt.Printf("failed to fetch free IPs: %v\n", err)
+			return err
+		}
+
+		fmt.Printf("Subnet %v (net %v, %v, %v): %d free IPv4, %d usable IPv6\n", subnet.Name, subnet.Net, subnet.V4net, subnet.V6net, len(ipv4s), len(ipv6s))
+		for i, ip := range ipv4s {
+			fmt.Printf("%3d %v\n", i, ip.IP)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+func handle_dns_list(ctx context.Context, cmd *cli.Command) error {
+	records, err := netcenter.SearchRecords(cmd.String("query"))
 	if err != nil {
-		fmt.Printf("failed to fetch free IPs: %v\n", err)
 		return err
 	}
 
-	for i, ip := range *ips {
-		fmt.Printf("%3d %v\n", i, ip.IP)
+	for _, r := range records {
+		fmt.Printf("%-40s %-45s %v\n", r.IP, r.FQDN, r.Subnet)
+	}
+	if len(records) == 0 {
+		fmt.Println("no records to display.")
 	}
 	return nil
 }
+func handle_dns_create(ctx context.Context, cmd *cli.Command) error {
+	fmt.Printf("Register '%s' for %s. Confirm? (y/n): ", cmd.String("name"), cmd.String("ip"))
+	var response string
+	fmt.Scan(&response)
+	if strings.ToLower(response) != "y" {
+		return errAborted
+	}
+
+	if approval.Required("dns.create") {
+		body, _ := json.Marshal(map[string]string{"ip": cmd.String("ip"), "fqdn": cmd.String("name")})
+		op, err := approval.Request(ctx, "dns.create", "/api/dns/records", body)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created pending operation %d, it expires at %s\n", op.ID, op.ExpiresAt.Format(time.RFC3339))
+		return errPendingApproval
+	}
+
+	errB := router.CreateDNSRecord(ctx, cmd.String("ip"), cmd.String("name"))
+	if errB != nil {
+		return fmt.Errorf("%s: %v\n", errB.Err, errB.UserMsg)
+	}
+	fmt.Printf("Registered %s for %s.\n", cmd.String("name"), cmd.String("ip"))
+	return nil
+}
+func handle_dns_verify(ctx context.Context, cmd *cli.Command) error {
+	v, err := netcenter.Verify(ctx, cmd.String("name"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Netcenter: %s\n", strings.Join(v.Netcenter, ", "))
+	fmt.Printf("Resolved:  %s\n", strings.Join(v.Resolved, ", "))
+	if v.ResolveError != "" {
+		fmt.Printf("Resolver error: %s\n", v.ResolveError)
+	}
+	if len(v.Missing) > 0 {
+		fmt.Printf("Registered but not resolved: %s\n", strings.Join(v.Missing, ", "))
+	}
+	if len(v.Unexpected) > 0 {
+		fmt.Printf("Resolved but not registered: %s\n", strings.Join(v.Unexpected, ", "))
+	}
+	for _, a := range v.Aliases {
+		status := "ok"
+		if !a.OK {
+			status = fmt.Sprintf("MISMATCH (resolves to '%s' %s)", a.Resolved, a.Error)
+		}
+		fmt.Printf("Alias %s: %s\n", a.Alias, status)
+	}
+	if !v.OK {
+		return fmt.Errorf("DNS of %s does not match Netcenter", v.FQDN)
+	}
+	fmt.Println("DNS matches Netcenter.")
+	return nil
+}
+func handle_dns_alias_list(ctx context.Context, cmd *cli.Command) error {
+	aliases, err := netcenter.GetAliases(cmd.String("name"))
+	if err != nil {
+		return err
+	}
+
+	for _, a := range *aliases {
+		fmt.Printf("%s -> %s\n", a.FqName, a.HostName)
+	}
+	if len(*aliases) == 0 {
+		fmt.Println("no aliases to display.")
+	}
+	return nil
+}
+func handle_dns_alias_create(ctx context.Context, cmd *cli.Command) error {
+	errB := router.CreateDNSAlias(ctx, cmd.String("name"), cmd.String("target"))
+	if errB != nil {
+		return fmt.Errorf("%s: %v\n", errB.Err, errB.UserMsg)
+	}
+	fmt.Printf("%s now points to %s.\n", cmd.String("name"), cmd.String("target"))
+	return nil
+}
+func handle_dns_alias_delete(ctx context.Context, cmd *cli.Command) error {
+	fmt.Printf("Delete the alias '%s'. Confirm? (y/n): ", cmd.String("name"))
+	var response string
+	fmt.Scan(&response)
+	if strings.ToLower(response) != "y" {
+		return errAborted
+	}
+
+	if approval.Required("dns.alias.delete") {
+		body, _ := json.Marshal(map[string]string{"alias": cmd.String("name")})
+		op, err := approval.Request(ctx, "dns.alias.delete", "/api/dns/aliases/delete", body)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created pending operation %d, it expires at %s\n", op.ID, op.ExpiresAt.Format(time.RFC3339))
+		return errPendingApproval
+	}
+
+	errB := router.DeleteDNSAlias(ctx, cmd.String("name"))
+	if errB != nil {
+		return fmt.Errorf("%s: %v\n", errB.Err, errB.UserMsg)
+	}
+	fmt.Printf("Alias %s deleted.\n", cmd.String("name"))
+	return nil
+}
 func handle_request_list(ctx context.Context, cmd *cli.Command) error {
 	requests, err := storage.DB.ListVMRequests(ctx)
 	if err != nil {
@@ -283,7 +817,17 @@ func handle_request_list(ctx context.Context, cmd *cli.Command) error {
 		if !cmd.Bool("all") && req.Requeststatus != storage.REQUEST_STATUS_PENDING {
 			continue
 		}
-		fmt.Printf("%s\n", req.ToString())
+		fmt.Printf("%s", req.ToString())
+		if cmd.Bool("all") {
+			events, err := storage.DB.ListRequestEvents(ctx, req.Requestid)
+			if err != nil {
+				return err
+			}
+			for _, ev := range events {
+				fmt.Printf("Event: %s\n", ev.ToString())
+			}
+		}
+		fmt.Println()
 		numPrintedReqs += 1
 	}
 
@@ -292,6 +836,22 @@ func handle_request_list(ctx context.Context, cmd *cli.Command) error {
 	}
 	return nil
 }
+func handle_request_link_vms(ctx context.Context, cmd *cli.Command) error {
+	linked, problems, errB := router.LinkVMRequestVMs(ctx, 0)
+	for _, l := range linked {
+		fmt.Printf("Linked %s\n", l)
+	}
+	for _, p := range problems {
+		fmt.Printf("Not linked: %s\n", p)
+	}
+	if errB != nil {
+		return fmt.Errorf("%s: %v\n", errB.Err, errB.UserMsg)
+	}
+	if len(linked)+len(problems) == 0 {
+		fmt.Println("All provisioned requests have a VM.")
+	}
+	return nil
+}
 func handle_request_accept(ctx context.Context, cmd *cli.Command) error {
 	if cmd.Int("id") == -1 && cmd.String("name") == "" {
 		return fmt.Errorf("Either --id or --name must be provided")
@@ -306,8 +866,34 @@ func handle_request_accept(ctx context.Context, cmd *cli.Command) error {
 	var response string
 	fmt.Scan(&response)
 	if strings.ToLower(response) != "y" {
-		fmt.Println("Aborted.")
-		return nil
+		return errAborted
+	}
+
+	errB := router.AcceptVMRequest(ctx, vmrequest.Requestid)
+	if errB != nil {
+		return fmt.Errorf("%s: %v\n", errB.Err, errB.UserMsg)
+	}
+
+	return nil
+}
+func handle_request_retry(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Int("id") == -1 && cmd.String("name") == "" {
+		return fmt.Errorf("Either --id or --name must be provided")
+	}
+	vmrequest, err := findVMRequest(ctx, cmd.Int("id"), cmd.String("name"), false)
+	if err != nil {
+		return err
+	}
+	if vmrequest.Requeststatus != storage.REQUEST_STATUS_FAILED {
+		return fmt.Errorf("VM request %d is '%v', only failed requests can be retried", vmrequest.Requestid, vmrequest.Requeststatus)
+	}
+	fmt.Printf("Retrying VM request:\n%s\n", vmrequest.ToString())
+
+	fmt.Println("Confirm? (y/n): ")
+	var response string
+	fmt.Scan(&response)
+	if strings.ToLower(response) != "y" {
+		return errAborted
 	}
 
 	errB := router.AcceptVMRequest(ctx, vmrequest.Requestid)
@@ -331,11 +917,10 @@ func handle_request_reject(ctx context.Context, cmd *cli.Command) error {
 	var response string
 	fmt.Scan(&response)
 	if strings.ToLower(response) != "y" {
-		fmt.Println("Aborted.")
-		return nil
+		return errAborted
 	}
 
-	errB := router.RejectVMRequest(ctx, vmrequest.Requestid)
+	errB := router.RejectVMRequest(ctx, vmrequest.Requestid, cmd.String("reason"), cmd.String("admin-note"))
 	if errB != nil {
 		return fmt.Errorf("%s: %v\n", errB.Err, errB.UserMsg)
 	}
@@ -418,54 +1003,109 @@ func handle_survey_shutdownunanswered(ctx context.Context, cmd *cli.Command) err
 		return err
 	}
 
-	shutdownSet := sliceToSet(shutdownList)
+	if approval.Required("usagesurvey.shutdown_unanswered") {
+		fmt.Printf("The following VMs will be shut down once another admin approves:\n%s\n\nConfirm? (y/n): ", strings.Join(shutdownList, "\n"))
+		var response string
+		fmt.Scan(&response)
+		if strings.ToLower(response) != "y" {
+			return errAborted
+		}
 
-	vms, err := proxmox.GetAllClusterVMs()
-	if err != nil {
-		return err
+		body, _ := json.Marshal(map[string]int64{"id": int64(surveyId)})
+		op, err := approval.Request(ctx, "usagesurvey.shutdown_unanswered", "/api/usagesurvey/shutdown/unanswered", body)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created pending operation %d, it expires at %s\n", op.ID, op.ExpiresAt.Format(time.RFC3339))
+		return errPendingApproval
 	}
 
 	fmt.Printf("Shutting down the following VMs:\n%s\n\nConfirm? (y/n): ", strings.Join(shutdownList, "\n"))
 	var response string
 	fmt.Scan(&response)
 	if strings.ToLower(response) != "y" {
-		fmt.Println("Aborted.")
-		return nil
+		return errAborted
 	}
 
-	errors := []string{}
+	return survey.ShutdownUnansweredVMs(ctx, int64(surveyId))
+}
 
-	for _, vm := range *vms {
-		_, doShutdown := shutdownSet[vm.Name]
-		if !doShutdown {
-			continue
+func handle_sanity(ctx context.Context, cmd *cli.Command) error {
+	warns := proxmox.CheckAllVMs()
+
+	if len(warns) == 0 {
+		fmt.Println("Everything seems healthy.")
+	} else {
+		for i, w := range warns {
+			fmt.Printf("%4d  %-30s  %-15s  %-40s\n", i, w.VM.Name, w.Category, w.Detail)
 		}
+	}
+	return nil
+}
 
-		fmt.Printf("Shutting down %s...\n", vm.Name)
+func handle_reconcile(ctx context.Context, cmd *cli.Command) error {
+	report, err := reconcile.Build(ctx)
+	if err != nil {
+		return err
+	}
 
-		err = proxmox.ShutdownVMWithReason(vm.Node, vm.Vmid, "the owner did not respond to the survey.")
-		if err != nil {
-			fmt.Printf("Failed to shut down VM %s: %v", vm.Name, err)
-			errors = append(errors, fmt.Sprintf("Failed to shut down VM %s: %v", vm.Name, err))
+	for _, d := range report.Discrepancies {
+		fmt.Printf("%-20s %s\n\t%s\n", d.Category, d.Subject, d.Detail)
+		if d.Fix != nil {
+			fmt.Printf("\tFix: %s (reconcile apply --id %s)\n", d.Fix.Description, d.ID)
 		}
 	}
-
-	if len(errors) > 0 {
-		return fmt.Errorf("Errors occurred during shutdown:\n%s", strings.Join(errors, "\n"))
+	for _, e := range report.Errors {
+		fmt.Printf("Not checked: %s\n", e)
+	}
+	if len(report.Discrepancies) == 0 {
+		fmt.Println("Proxmox, Netcenter and the VM requests agree.")
 	}
 	return nil
 }
+func handle_reconcile_apply(ctx context.Context, cmd *cli.Command) error {
+	report, err := reconcile.Build(ctx)
+	if err != nil {
+		return err
+	}
+	d := report.Find(cmd.String("id"))
+	if d == nil {
+		return fmt.Errorf("No discrepancy %s, it may have been fixed in the meantime", cmd.String("id"))
+	}
+	if d.Fix == nil {
+		return fmt.Errorf("%s has to be fixed by hand: %s", d.Subject, d.Detail)
+	}
+	fmt.Printf("%s: %s\n%s\n", d.Subject, d.Detail, d.Fix.Description)
 
-func handle_sanity(ctx context.Context, cmd *cli.Command) error {
-	warns := proxmox.CheckAllVMs()
+	if approval.Required("reconcile.apply") {
+		fmt.Println("The fix will be applied once another admin approves. Confirm? (y/n): ")
+		var response string
+		fmt.Scan(&response)
+		if strings.ToLower(response) != "y" {
+			return errAborted
+		}
 
-	if len(warns) == 0 {
-		fmt.Println("Everything seems healthy.")
-	} else {
-		for i, w := range warns {
-			fmt.Printf("%4d  %-30s  %-15s  %-40s\n", i, w.VM.Name, w.Category, w.Detail)
+		body, _ := json.Marshal(map[string]string{"id": d.ID})
+		op, err := approval.Request(ctx, "reconcile.apply", "/api/reconcile/apply", body)
+		if err != nil {
+			return err
 		}
+		fmt.Printf("Created pending operation %d, it expires at %s\n", op.ID, op.ExpiresAt.Format(time.RFC3339))
+		return errPendingApproval
+	}
+
+	fmt.Println("Confirm? (y/n): ")
+	var response string
+	fmt.Scan(&response)
+	if strings.ToLower(response) != "y" {
+		return errAborted
 	}
+
+	errB := router.ApplyReconcileFix(ctx, d.ID)
+	if errB != nil {
+		return fmt.Errorf("%s: %v\n", errB.Err, errB.UserMsg)
+	}
+	fmt.Println("Fix applied.")
 	return nil
 }
 
@@ -514,3 +1154,169 @@ func handle_descriptions(ctx context.Context, cmd *cli.Command) error {
 
 	return nil
 }
+func handle_tokens_create(ctx context.Context, cmd *cli.Command) error {
+	role, err := auth.ParseRole(cmd.String("role"))
+	if err != nil || role == auth.ROLE_NONE {
+		return fmt.Errorf("Invalid role '%v'", cmd.String("role"))
+	}
+	if err := auth.ValidateAPITokenScopes(cmd.StringSlice("scope")); err != nil {
+		return err
+	}
+
+	expiresAt := sql.NullTime{}
+	if cmd.Duration("expires-in") > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().Add(cmd.Duration("expires-in")), Valid: true}
+	}
+
+	secret, hash, err := auth.NewAPITokenSecret()
+	if err != nil {
+		return err
+	}
+	id, err := storage.DB.CreateAPIToken(ctx, storage.CreateAPITokenParams{
+		Name:      cmd.String("name"),
+		TokenHash: hash,
+		Owner:     auth.Actor(ctx),
+		Role:      role.String(),
+		Scopes:    cmd.StringSlice("scope"),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to create API token: %v", err)
+	}
+
+	fmt.Printf("Created API token %d. Store it now, it cannot be displayed again:\n%s\n", id, secret)
+	return nil
+}
+
+func handle_tokens_list(ctx context.Context, cmd *cli.Command) error {
+	tokens, err := storage.DB.ListAPITokens(ctx)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		fmt.Printf("%s\n\n", token.ToString())
+	}
+	if len(tokens) == 0 {
+		fmt.Println("no API tokens to display.")
+	}
+	return nil
+}
+
+func handle_tokens_revoke(ctx context.Context, cmd *cli.Command) error {
+	n, err := storage.DB.RevokeAPIToken(ctx, int64(cmd.Int("id")))
+	if err != nil {
+		return fmt.Errorf("Failed to revoke API token: %v", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("No API token with ID %d, or it is already revoked", cmd.Int("id"))
+	}
+	fmt.Printf("Revoked API token %d.\n", cmd.Int("id"))
+	return nil
+}
+
+func handle_quota_list(ctx context.Context, cmd *cli.Command) error {
+	report, err := quota.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, kind := range []string{quota.KIND_USER, quota.KIND_ORG} {
+		fmt.Printf("Default %s quota: %v\n", kind, quota.Status{Limit: quota.Defaults(kind)})
+		for _, subject := range report.Subjects(kind) {
+			fmt.Printf("  %-40s %v\n", subject, report.Status(kind, subject))
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func handle_quota_set(ctx context.Context, cmd *cli.Command) error {
+	optional := func(name string) *int {
+		if !cmd.IsSet(name) {
+			return nil
+		}
+		v := int(cmd.Int(name))
+		return &v
+	}
+
+	errB := router.SetQuotaOverride(ctx, router.QuotaOverride{
+		Kind:    cmd.String("kind"),
+		Subject: cmd.String("name"),
+		VMs:     optional("vms"),
+		Cores:   optional("cores"),
+		RamGB:   optional("ram-gb"),
+		DiskGB:  optional("disk-gb"),
+		Comment: cmd.String("comment"),
+	})
+	if errB != nil {
+		return fmt.Errorf("%s: %v\n", errB.Err, errB.UserMsg)
+	}
+	fmt.Printf("Quota of %s %s updated.\n", cmd.String("kind"), cmd.String("name"))
+	return nil
+}
+
+func handle_quota_unset(ctx context.Context, cmd *cli.Command) error {
+	errB := router.DeleteQuotaOverride(ctx, cmd.String("kind"), cmd.String("name"))
+	if errB != nil {
+		return fmt.Errorf("%s: %v\n", errB.Err, errB.UserMsg)
+	}
+	fmt.Printf("Quota of %s %s reset to the defaults.\n", cmd.String("kind"), cmd.String("name"))
+	return nil
+}
+
+func handle_emails_queue_list(ctx context.Context, cmd *cli.Command) error {
+	// Filtered in SQL, so that old stuck mails are not hidden behind the most recent ones
+	statuses := []string{storage.MAIL_STATUS_QUEUED, storage.MAIL_STATUS_FAILED}
+	if cmd.Bool("all") {
+		statuses = []string{""}
+	}
+
+	numPrintedMails := 0
+	for _, status := range statuses {
+		mails, err := storage.DB.ListMails(ctx, storage.ListMailsParams{Status: status, MaxResults: 500})
+		if err != nil {
+			return err
+		}
+		for _, mail := range mails {
+			fmt.Printf("%s\n", mail.ToString())
+			numPrintedMails += 1
+		}
+	}
+
+	if numPrintedMails == 0 {
+		fmt.Println("no emails to display.")
+	}
+	return nil
+}
+func handle_emails_queue_retry(ctx context.Context, cmd *cli.Command) error {
+	err := notifier.RetryEmail(ctx, int64(cmd.Int("id")))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Email %d scheduled for delivery.\n", cmd.Int("id"))
+	return nil
+}
+func handle_emails_queue_cancel(ctx context.Context, cmd *cli.Command) error {
+	err := notifier.CancelEmail(ctx, int64(cmd.Int("id")))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Email %d cancelled.\n", cmd.Int("id"))
+	return nil
+}
+func handle_notify_test(ctx context.Context, cmd *cli.Command) error {
+	failed := 0
+	for _, res := range notifier.TestRoutes(ctx, "This is a test notification sent with 'vmwiz-backend notify test'") {
+		if res.Err != nil {
+			failed++
+			fmt.Printf("[X] %-20s -> %s: %v\n", res.Event, res.Backend, res.Err)
+		} else {
+			fmt.Printf("[+] %-20s -> %s\n", res.Event, res.Backend)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d notification(s) could not be delivered", failed)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package netcenter
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/logger"
+)
+
+// A CNAME record, e.g. www.org.vsos.ethz.ch pointing to org.vsos.ethz.ch
+type NetcenterAlias struct {
+	FqName   string   `xml:"fqName" json:"alias"`
+	HostName string   `xml:"hostName" json:"hostname"`
+	TTL      int      `xml:"ttl" json:"ttl"`
+	IsgGroup string   `xml:"isgGroup" json:"isgGroup"`
+	Views    []string `xml:"views>view" json:"views"`
+}
+type netcenterAliasList struct {
+	XMLName xml.Name         `xml:"aliases"`
+	Aliases []NetcenterAlias `xml:"alias"`
+}
+
+func (c *HTTPClient) GetAliases(hostname string) (*[]NetcenterAlias, error) {
+	/*
+		<aliases>
+			<alias>
+				<fqName>www.org.vsos.ethz.ch</fqName>
+				<hostName>org.vsos.ethz.ch</hostName>
+				<ttl>3600</ttl>
+				<isgGroup>adm-soseth</isgGroup>
+				<views>
+					<view>extern</view>
+					<view>intern</view>
+				</views>
+			</alias>
+			...
+		</aliases>
+	*/
+	req, client, err := c.makeRequest("GET", fmt.Sprintf("/netcenter/rest/alias/%v", url.PathEscape(hostname)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Get aliases of '%v': %v", hostname, err.Error())
+	}
+
+	body, err := netcenterDoRequest(req, client)
+	if err != nil {
+		return nil, fmt.Errorf("Get aliases of '%v': %v", hostname, err.Error())
+	}
+
+	var aliases netcenterAliasList
+	err = xml.Unmarshal(body, &aliases)
+	if err != nil {
+		return nil, fmt.Errorf("Get aliases of '%v': Unmarshal: %v", hostname, err.Error())
+	}
+
+	return &(aliases.Aliases), nil
+}
+
+type netcenterCreateAliasRequest struct {
+	XMLName  xml.Name `xml:"insert"`
+	FqName   string   `xml:"alias>fqName"`
+	HostName string   `xml:"alias>hostName"`
+	ISGGroup string   `xml:"alias>isgGroup,omitempty"`
+}
+
+func (c *HTTPClient) CreateAlias(ctx context.Context, alias string, hostname string) error {
+	body, err := xml.Marshal(netcenterCreateAliasRequest{
+		FqName:   alias,
+		HostName: hostname,
+		ISGGroup: ISG_GROUP,
+	})
+	if err != nil {
+		return fmt.Errorf("Creating alias: Marshalling: %v", err.Error())
+	}
+
+	req, client, err := c.makeRequest("POST", "/netcenter/rest/alias", body)
+	if err != nil {
+		return fmt.Errorf("Creating alias: Creating Request: %v", err.Error())
+	}
+	_, err = netcenterDoRequest(req, client)
+	if err != nil {
+		return fmt.Errorf("Creating alias: %v", err.Error())
+	}
+
+	logger.From(ctx).Infof("[+] Created alias '%v' for '%v'", alias, hostname)
+	return nil
+}
+
+func (c *HTTPClient) DeleteAlias(ctx context.Context, alias string) error {
+	req, client, err := c.makeRequest("DELETE", fmt.Sprintf("/netcenter/rest/alias/%v", url.PathEscape(alias)), nil)
+	if err != nil {
+		return fmt.Errorf("Deleting alias: %v", err.Error())
+	}
+
+	_, err = netcenterDoRequest(req, client)
+	if err != nil {
+		return fmt.Errorf("Deleting alias: %v", err.Error())
+	}
+
+	logger.From(ctx).Infof("[+] Deleted alias '%v'", alias)
+	return nil
+}
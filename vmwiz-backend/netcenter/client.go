@@ -0,0 +1,83 @@
+package netcenter
+
+import (
+	"context"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+)
+
+// The Netcenter REST endpoints VMWiz uses. The package-level functions of the same name go through the client set
+// with SetClient, by default an HTTPClient for NETCENTER_HOST. Tests point it at a netcentertest.Server.
+type Client interface {
+	GetFreeIPv4sInSubnet(ip *ipaddr.IPv4Address) (*[]NetcenterFreeIPv4, error)
+	GetFreeIPv6sInSubnet(ip *ipaddr.IPv6Address) (*[]NetcenterFreeIPv6, error)
+	GetUsedIPv4sInSubnet(ip *ipaddr.IPv4Address) (*[]NetcenterUsedIPv4, error)
+	GetUsedIPv6sInSubnet(ip *ipaddr.IPv6Address) (*[]NetcenterUsedIPv6, error)
+	CreateDNSEntry(ctx context.Context, ip *ipaddr.IPAddress, fqdn string) error
+	DeleteDNSEntryByIP(ctx context.Context, ip *ipaddr.IPAddress) error
+	GetAliases(hostname string) (*[]NetcenterAlias, error)
+	CreateAlias(ctx context.Context, alias string, hostname string) error
+	DeleteAlias(ctx context.Context, alias string) error
+}
+
+// Talks XML to the Netcenter REST API at Host with basic auth
+type HTTPClient struct {
+	Host     string
+	User     string
+	Password string
+}
+
+func NewHTTPClient(host string, user string, password string) *HTTPClient {
+	return &HTTPClient{Host: host, User: user, Password: password}
+}
+
+var client Client = nil
+
+// SetClient replaces the client of the package-level functions, nil goes back to the one configured by the environment
+func SetClient(c Client) {
+	client = c
+}
+
+func getClient() Client {
+	if client != nil {
+		return client
+	}
+	return NewHTTPClient(config.AppConfig.NETCENTER_HOST, config.AppConfig.NETCENTER_USER, config.AppConfig.NETCENTER_PWD)
+}
+
+func GetFreeIPv4sInSubnet(ip *ipaddr.IPv4Address) (*[]NetcenterFreeIPv4, error) {
+	return getClient().GetFreeIPv4sInSubnet(ip)
+}
+
+func GetFreeIPv6sInSubnet(ip *ipaddr.IPv6Address) (*[]NetcenterFreeIPv6, error) {
+	return getClient().GetFreeIPv6sInSubnet(ip)
+}
+
+func GetUsedIPv4sInSubnet(ip *ipaddr.IPv4Address) (*[]NetcenterUsedIPv4, error) {
+	return getClient().GetUsedIPv4sInSubnet(ip)
+}
+
+func GetUsedIPv6sInSubnet(ip *ipaddr.IPv6Address) (*[]NetcenterUsedIPv6, error) {
+	return getClient().GetUsedIPv6sInSubnet(ip)
+}
+
+func CreateDNSEntry(ctx context.Context, ip *ipaddr.IPAddress, fqdn string) error {
+	return getClient().CreateDNSEntry(ctx, ip, fqdn)
+}
+
+func DeleteDNSEntryByIP(ctx context.Context, ip *ipaddr.IPAddress) error {
+	return getClient().DeleteDNSEntryByIP(ctx, ip)
+}
+
+func GetAliases(hostname string) (*[]NetcenterAlias, error) {
+	return getClient().GetAliases(hostname)
+}
+
+func CreateAlias(ctx context.Context, alias string, hostname string) error {
+	return getClient().CreateAlias(ctx, alias, hostname)
+}
+
+func DeleteAlias(ctx context.Context, alias string) error {
+	return getClient().DeleteAlias(ctx, alias)
+}
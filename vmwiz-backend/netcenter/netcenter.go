@@ -7,13 +7,11 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
-	"math/big"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
-	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/logger"
 	"github.com/seancfoley/ipaddress-go/ipaddr"
 )
@@ -138,37 +136,10 @@ type netcenterRequestErrors struct {
 	Errors  []string `xml:"error>msg"`
 }
 
-type NetcenterSubnet struct {
-	Name        string
-	V4net       *ipaddr.IPv4Address
-	V6net       *ipaddr.IPv6Address
-	ipv6_offset int64
-	Comment     string
-}
-
-func NewNetcenterSubnet(name string, v4net string, v6net string, offset int64, comment string) NetcenterSubnet {
-	n := NetcenterSubnet{}
-	n.Name = name
-	v4, _ := ipaddr.NewIPAddressString(v4net).GetAddress().ToZeroHost()
-	n.V4net = v4.ToIPv4()
-	v6, _ := ipaddr.NewIPAddressString(v6net).GetAddress().ToZeroHost()
-	n.V6net = v6.ToIPv6()
-	n.ipv6_offset = offset
-	n.Comment = comment
-
-	return n
-}
-
-var VM_SUBNET NetcenterSubnet = NewNetcenterSubnet("vm",
-	"192.33.91.255/24",
-	"2001:67c:10ec:49c3::/118",
-	0x020,
-	"VM (sos-dc-server-1)")
-
 const ISG_GROUP string = "adm-soseth"
 
-func netcenterMakeRequest(method string, path string, body []byte) (*http.Request, *http.Client, error) {
-	url, err := url.Parse(fmt.Sprintf("%v%v", config.AppConfig.NETCENTER_HOST, path))
+func (c *HTTPClient) makeRequest(method string, path string, body []byte) (*http.Request, *http.Client, error) {
+	url, err := url.Parse(fmt.Sprintf("%v%v", c.Host, path))
 	if err != nil {
 		return nil, nil, fmt.Errorf("Creating request: Parsing URL: %v", err.Error())
 	}
@@ -180,9 +151,9 @@ func netcenterMakeRequest(method string, path string, body []byte) (*http.Reques
 
 	req.Header.Set("Content-Type", "text/xml")
 
-	addAuthHeaders(req, nil)
+	c.addAuthHeaders(req, nil)
 	req.Host = url.Host
-	return req, &http.Client{CheckRedirect: addAuthHeaders, Timeout: time.Second * 10}, nil
+	return req, &http.Client{CheckRedirect: c.addAuthHeaders, Timeout: time.Second * 10}, nil
 }
 
 func netcenterDoRequest(req *http.Request, client *http.Client) ([]byte, error) {
@@ -190,6 +161,7 @@ func netcenterDoRequest(req *http.Request, client *http.Client) ([]byte, error)
 	if err != nil {
 		return nil, fmt.Errorf("Making request %v %v: %v", req.Method, req.URL, err.Error())
 	}
+	defer res.Body.Close()
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
 		return nil, fmt.Errorf("Making request: Cannot read body: %v", err.Error())
@@ -208,12 +180,12 @@ func netcenterDoRequest(req *http.Request, client *http.Client) ([]byte, error)
 	return body, nil
 }
 
-func addAuthHeaders(req *http.Request, via []*http.Request) error {
-	req.Header.Set("authorization", fmt.Sprintf("Basic %v", base64.StdEncoding.EncodeToString([]byte(config.AppConfig.NETCENTER_USER+":"+config.AppConfig.NETCENTER_PWD))))
+func (c *HTTPClient) addAuthHeaders(req *http.Request, via []*http.Request) error {
+	req.Header.Set("authorization", fmt.Sprintf("Basic %v", base64.StdEncoding.EncodeToString([]byte(c.User+":"+c.Password))))
 	return nil
 }
 
-func GetFreeIPv4sInSubnet(ip *ipaddr.IPv4Address) (*[]NetcenterFreeIPv4, error) {
+func (c *HTTPClient) GetFreeIPv4sInSubnet(ip *ipaddr.IPv4Address) (*[]NetcenterFreeIPv4, error) {
 	/*
 		<freeIps>
 			<freeIp>
@@ -226,7 +198,7 @@ func GetFreeIPv4sInSubnet(ip *ipaddr.IPv4Address) (*[]NetcenterFreeIPv4, error)
 			...
 		</freeIps>
 	*/
-	req, client, err := netcenterMakeRequest("GET", fmt.Sprintf("/netcenter/rest/nameToIP/freeIps/v4/%v", ip.WithoutPrefixLen().String()), nil)
+	req, client, err := c.makeRequest("GET", fmt.Sprintf("/netcenter/rest/nameToIP/freeIps/v4/%v", ip.WithoutPrefixLen().String()), nil)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get free IPv4 addresses in subnet '%v': %v", ip.String(), err.Error())
 	}
@@ -246,7 +218,7 @@ func GetFreeIPv4sInSubnet(ip *ipaddr.IPv4Address) (*[]NetcenterFreeIPv4, error)
 	return &(freeIps.FreeIps), nil
 }
 
-func GetFreeIPv6sInSubnet(ip *ipaddr.IPv6Address) (*[]NetcenterFreeIPv6, error) {
+func (c *HTTPClient) GetFreeIPv6sInSubnet(ip *ipaddr.IPv6Address) (*[]NetcenterFreeIPv6, error) {
 	/*
 		<freeIpV6>
 			<freeIpV6>
@@ -260,7 +232,7 @@ func GetFreeIPv6sInSubnet(ip *ipaddr.IPv6Address) (*[]NetcenterFreeIPv6, error)
 			...
 		</freeIpV6>
 	*/
-	req, client, err := netcenterMakeRequest("GET", fmt.Sprintf("/netcenter/rest/nameToIP/freeIps/v6/%v", ip.WithoutPrefixLen().String()), nil)
+	req, client, err := c.makeRequest("GET", fmt.Sprintf("/netcenter/rest/nameToIP/freeIps/v6/%v", ip.WithoutPrefixLen().String()), nil)
 	if err != nil {
 		return nil, fmt.Errorf("Get free IPv6 addresses in subnet '%v': %v", ip.String(), err.Error())
 	}
@@ -279,8 +251,8 @@ func GetFreeIPv6sInSubnet(ip *ipaddr.IPv6Address) (*[]NetcenterFreeIPv6, error)
 	return &(freeIps.FreeIps), nil
 }
 
-func DeleteDNSEntryByIP(ctx context.Context, ip *ipaddr.IPAddress) error {
-	req, client, err := netcenterMakeRequest("DELETE", fmt.Sprintf("/netcenter/rest/nameToIP/%s", ip.WithoutPrefixLen().String()), nil)
+func (c *HTTPClient) DeleteDNSEntryByIP(ctx context.Context, ip *ipaddr.IPAddress) error {
+	req, client, err := c.makeRequest("DELETE", fmt.Sprintf("/netcenter/rest/nameToIP/%s", ip.WithoutPrefixLen().String()), nil)
 	if err != nil {
 		return fmt.Errorf("Deleting DNS entry: %v", err.Error())
 	}
@@ -322,30 +294,41 @@ func DeleteDNSEntryByHostname(ctx context.Context, fqdn string) error {
 	return nil
 }
 
+// GetHostIPs returns the IPs registered for fqdn in any of the subnets
 func GetHostIPs(fqdn string) ([]NetcenterUsedIPv4, []NetcenterUsedIPv6, error) {
 
 	var res_ipv4 []NetcenterUsedIPv4
 	var res_ipv6 []NetcenterUsedIPv6
 
-	ipv4s, err := GetUsedIPv4sInSubnet(VM_SUBNET.V4net)
-	if err != nil {
-		return nil, nil, fmt.Errorf("Get host IPs: %v", err.Error())
-	}
-
-	for _, ip := range *ipv4s {
-		if ip.Fqname == fqdn {
-			res_ipv4 = append(res_ipv4, ip)
+	// Subnets of different nets may share a prefix, every prefix is only queried once
+	seen := map[string]bool{}
+	for _, subnet := range Subnets() {
+		if !seen[subnet.V4net.String()] {
+			seen[subnet.V4net.String()] = true
+			ipv4s, err := GetUsedIPv4sInSubnet(subnet.V4net)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Get host IPs: %v", err.Error())
+			}
+
+			for _, ip := range *ipv4s {
+				if ip.Fqname == fqdn {
+					res_ipv4 = append(res_ipv4, ip)
+				}
+			}
 		}
-	}
-
-	ipv6s, err := GetUsedIPv6sInSubnet(VM_SUBNET.V6net)
-	if err != nil {
-		return nil, nil, fmt.Errorf("Get host IPs: %v", err.Error())
-	}
 
-	for _, ip := range *ipv6s {
-		if ip.Fqname == fqdn {
-			res_ipv6 = append(res_ipv6, ip)
+		if !seen[subnet.V6net.String()] {
+			seen[subnet.V6net.String()] = true
+			ipv6s, err := GetUsedIPv6sInSubnet(subnet.V6net)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Get host IPs: %v", err.Error())
+			}
+
+			for _, ip := range *ipv6s {
+				if ip.Fqname == fqdn {
+					res_ipv6 = append(res_ipv6, ip)
+				}
+			}
 		}
 	}
 
@@ -418,7 +401,7 @@ func (out *NetcenterUsedIPv4) UnmarshalXML(d *xml.Decoder, start xml.StartElemen
 	return nil
 }
 
-func GetUsedIPv4sInSubnet(ip *ipaddr.IPv4Address) (*[]NetcenterUsedIPv4, error) {
+func (c *HTTPClient) GetUsedIPv4sInSubnet(ip *ipaddr.IPv4Address) (*[]NetcenterUsedIPv4, error) {
 	/*
 		<usedIps>
 			<usedIp>
@@ -438,7 +421,7 @@ func GetUsedIPv4sInSubnet(ip *ipaddr.IPv4Address) (*[]NetcenterUsedIPv4, error)
 			...
 		</usedIps>
 	*/
-	req, client, err := netcenterMakeRequest("GET", fmt.Sprintf("/netcenter/rest/nameToIP/usedIps/v4/%v", ip.WithoutPrefixLen().String()), nil)
+	req, client, err := c.makeRequest("GET", fmt.Sprintf("/netcenter/rest/nameToIP/usedIps/v4/%v", ip.WithoutPrefixLen().String()), nil)
 	if err != nil {
 		return nil, fmt.Errorf("Get used IPv4 addresses in subnet '%v': %v", ip.String(), err.Error())
 	}
@@ -534,7 +517,7 @@ func (out *NetcenterUsedIPv6) UnmarshalXML(d *xml.Decoder, start xml.StartElemen
 	return nil
 }
 
-func GetUsedIPv6sInSubnet(ip *ipaddr.IPv6Address) (*[]NetcenterUsedIPv6, error) {
+func (c *HTTPClient) GetUsedIPv6sInSubnet(ip *ipaddr.IPv6Address) (*[]NetcenterUsedIPv6, error) {
 	/*
 	  <usedIps>
 	    <usedIp>
@@ -557,7 +540,7 @@ func GetUsedIPv6sInSubnet(ip *ipaddr.IPv6Address) (*[]NetcenterUsedIPv6, error)
 	    ...
 	  </usedIps>
 	*/
-	req, client, err := netcenterMakeRequest("GET", fmt.Sprintf("/netcenter/rest/nameToIP/usedIps/v6/%v", ip.WithoutPrefixLen().String()), nil)
+	req, client, err := c.makeRequest("GET", fmt.Sprintf("/netcenter/rest/nameToIP/usedIps/v6/%v", ip.WithoutPrefixLen().String()), nil)
 	if err != nil {
 		return nil, fmt.Errorf("Get used IPv6 addresses in subnet '%v': %v", ip.String(), err.Error())
 	}
@@ -591,7 +574,7 @@ type netcenterCreateIPv6DNSEntryRequest struct {
 	FqName   string   `xml:"nameToIP>fqName,omitempty"`
 }
 
-func CreateDNSEntry(ctx context.Context, ip *ipaddr.IPAddress, fqdn string) error {
+func (c *HTTPClient) CreateDNSEntry(ctx context.Context, ip *ipaddr.IPAddress, fqdn string) error {
 	var reqBody any
 	if ip.IsIPv4() {
 		reqBody = netcenterCreateIPv4DNSEntryRequest{
@@ -616,7 +599,7 @@ func CreateDNSEntry(ctx context.Context, ip *ipaddr.IPAddress, fqdn string) erro
 		return fmt.Errorf("Creating DNS entry: Marshalling: %v", err.Error())
 	}
 
-	req, client, err := netcenterMakeRequest("POST", "/netcenter/rest/nameToIP", body)
+	req, client, err := c.makeRequest("POST", "/netcenter/rest/nameToIP", body)
 	if err != nil {
 		return fmt.Errorf("Creating DNS entry: Creating Request: %v", err.Error())
 	}
@@ -629,48 +612,66 @@ func CreateDNSEntry(ctx context.Context, ip *ipaddr.IPAddress, fqdn string) erro
 	return nil
 }
 
-func Registerhost(ctx context.Context, net string, fqdn string) (*ipaddr.IPv4Address, *ipaddr.IPv6Address, error) {
-	var v4_subnet *ipaddr.IPv4Address = VM_SUBNET.V4net
-	var v6_subnet *ipaddr.IPv6Address = VM_SUBNET.V6net
-
-	freeIPv4s, err := GetFreeIPv4sInSubnet(v4_subnet.WithoutPrefixLen())
+// FreeIPs returns the free IPv4 and usable IPv6 addresses of a subnet
+func FreeIPs(subnet NetcenterSubnet) ([]NetcenterFreeIPv4, []NetcenterFreeIPv6, error) {
+	freeIPv4s, err := GetFreeIPv4sInSubnet(subnet.V4net.WithoutPrefixLen())
 	if err != nil {
-		return nil, nil, fmt.Errorf("Registering host with FQDN '%v': %v", fqdn, err.Error())
+		return nil, nil, err
 	}
-	if len(*freeIPv4s) == 0 {
-		return nil, nil, fmt.Errorf("Registering host with FQDN '%v': No free IPv4 in subnet %v", fqdn, v4_subnet)
-	}
-
-	freeIPv6s, err := GetFreeIPv6sInSubnet(v6_subnet.WithoutPrefixLen())
+	freeIPv6s, err := GetFreeIPv6sInSubnet(subnet.V6net.WithoutPrefixLen())
 	if err != nil {
-		return nil, nil, fmt.Errorf("Registering host with FQDN '%v': %v", fqdn, err.Error())
+		return nil, nil, err
 	}
 
-	var chosenIPv4 *ipaddr.IPv4Address = (*freeIPv4s)[0].IP
-	var chosenIPv6 *ipaddr.IPv6Address = nil
 	// Discard IPv6 addresses that are not in the usable range (0 address + ipv6_offset)
+	usableIPv6s := []NetcenterFreeIPv6{}
 	for _, ip := range *freeIPv6s {
-		ipv6 := ip.IP
-		if v6_subnet.Enumerate(ipv6).Cmp(big.NewInt(VM_SUBNET.ipv6_offset)) > 0 {
-			chosenIPv6 = ipv6
-			break
+		if subnet.usableIPv6(ip.IP) {
+			usableIPv6s = append(usableIPv6s, ip)
+		}
+	}
+	return *freeIPv4s, usableIPv6s, nil
+}
+
+// Registerhost registers fqdn with a free IPv4 and IPv6 address of the first subnet of net that has both left
+func Registerhost(ctx context.Context, net string, fqdn string) (*ipaddr.IPv4Address, *ipaddr.IPv6Address, *NetcenterSubnet, error) {
+	candidates := SubnetsOf(net)
+	if len(candidates) == 0 {
+		return nil, nil, nil, fmt.Errorf("Registering host with FQDN '%v': No subnet in net '%v'", fqdn, net)
+	}
+
+	var subnet *NetcenterSubnet = nil
+	var chosenIPv4 *ipaddr.IPv4Address = nil
+	var chosenIPv6 *ipaddr.IPv6Address = nil
+	for _, candidate := range candidates {
+		freeIPv4s, freeIPv6s, err := FreeIPs(candidate)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("Registering host with FQDN '%v': %v", fqdn, err.Error())
+		}
+		if len(freeIPv4s) == 0 || len(freeIPv6s) == 0 {
+			logger.From(ctx).Infof("[!] Subnet '%v' is full (%d free IPv4, %d usable IPv6), trying the next one", candidate.Name, len(freeIPv4s), len(freeIPv6s))
+			continue
 		}
+		subnet = &candidate
+		chosenIPv4 = freeIPv4s[0].IP
+		chosenIPv6 = freeIPv6s[0].IP
+		break
 	}
-	if chosenIPv6 == nil {
-		return nil, nil, fmt.Errorf("Registering host with FQDN '%v': No usable IPv6 in subnet %v", fqdn, v6_subnet)
+	if subnet == nil {
+		return nil, nil, nil, fmt.Errorf("Registering host with FQDN '%v': No free IPv4 and usable IPv6 left in any subnet of net '%v'", fqdn, net)
 	}
 
 	// ? Adding DNS entry for chosen IP and FQDN through Netcenter
-	err = CreateDNSEntry(ctx, chosenIPv4.ToIP(), fqdn)
+	err := CreateDNSEntry(ctx, chosenIPv4.ToIP(), fqdn)
 	if err != nil {
-		return nil, nil, fmt.Errorf("Registering host %v with FQDN '%v': %v", chosenIPv4, fqdn, err.Error())
+		return nil, nil, nil, fmt.Errorf("Registering host %v with FQDN '%v': %v", chosenIPv4, fqdn, err.Error())
 	}
 
 	err = CreateDNSEntry(ctx, chosenIPv6.ToIP(), fqdn)
 	if err != nil {
-		return nil, nil, fmt.Errorf("Registering host %v with FQDN '%v': %v", chosenIPv6, fqdn, err.Error())
+		return nil, nil, nil, fmt.Errorf("Registering host %v with FQDN '%v': %v", chosenIPv6, fqdn, err.Error())
 	}
 
-	logger.From(ctx).Infof("[+] Registered host '%v' with FQDN '%v'\n\tIPv4: %v\n\tIPv6: %v", net, fqdn, chosenIPv4, chosenIPv6)
-	return chosenIPv4, chosenIPv6, nil
+	logger.From(ctx).Infof("[+] Registered host '%v' with FQDN '%v' in subnet '%v'\n\tIPv4: %v\n\tIPv6: %v", net, fqdn, subnet.Name, chosenIPv4, chosenIPv6)
+	return chosenIPv4, chosenIPv6, subnet, nil
 }
@@ -0,0 +1,352 @@
+package netcenter_test
+
+import (
+	"context"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/netcenter"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/netcenter/netcentertest"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+)
+
+// Starts a fake Netcenter with the subnets of the default configuration and points the package at it
+func newFakeNetcenter(t *testing.T) *netcentertest.Server {
+	t.Helper()
+	srv := netcentertest.NewServer("vmwiz", "secret")
+	t.Cleanup(srv.Close)
+	netcenter.SetClient(netcenter.NewHTTPClient(srv.URL, "vmwiz", "secret"))
+	t.Cleanup(func() { netcenter.SetClient(nil) })
+
+	for _, subnet := range netcenter.Subnets() {
+		mustDo(t, srv.AddSubnet(subnet.Name, subnet.V4net.String()))
+		mustDo(t, srv.AddSubnet(subnet.Name, subnet.V6net.String()))
+		mustDo(t, srv.AddRecord(subnet.V4gateway.String(), "rou-"+subnet.Name+".ethz.ch"))
+		mustDo(t, srv.AddRecord(subnet.V6gateway.String(), "rou-"+subnet.Name+".ethz.ch"))
+	}
+	return srv
+}
+
+// Loads the subnets from a NETCENTER_SUBNETS file with the given content
+func useSubnets(t *testing.T, content string) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "subnets.json")
+	mustDo(t, os.WriteFile(file, []byte(content), 0o644))
+	config.AppConfig.NETCENTER_SUBNETS = file
+	t.Cleanup(func() {
+		config.AppConfig.NETCENTER_SUBNETS = ""
+		netcenter.InitSubnets()
+	})
+	mustDo(t, netcenter.InitSubnets())
+}
+
+func mustDo(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func recordsOf(srv *netcentertest.Server, fqdn string) []string {
+	ips := []string{}
+	for _, rec := range srv.Records() {
+		if rec.FQDN == fqdn {
+			ips = append(ips, rec.IP)
+		}
+	}
+	return ips
+}
+
+func TestUnmarshalFreeIPv4(t *testing.T) {
+	var res struct {
+		FreeIps []netcenter.NetcenterFreeIPv4 `xml:"freeIp"`
+	}
+	mustDo(t, xml.Unmarshal([]byte(`<freeIps>
+		<freeIp>
+			<ip>192.33.91.173</ip>
+			<ipSubnet>192.33.91.0</ipSubnet>
+			<ipMask>24</ipMask>
+			<subnetAndMask>192.33.91.0/24</subnetAndMask>
+			<subnetName>sos-dcz2-server-1-a</subnetName>
+		</freeIp>
+	</freeIps>`), &res))
+
+	if len(res.FreeIps) != 1 {
+		t.Fatalf("got %d free IPs, want 1", len(res.FreeIps))
+	}
+	ip := res.FreeIps[0]
+	if ip.IP.String() != "192.33.91.173" || ip.IpSubnet.String() != "192.33.91.0" || ip.SubnetAndMask.String() != "192.33.91.0/24" {
+		t.Errorf("wrong addresses: %v %v %v", ip.IP, ip.IpSubnet, ip.SubnetAndMask)
+	}
+	if ip.IpMask != 24 || ip.SubnetName != "sos-dcz2-server-1-a" {
+		t.Errorf("wrong fields: %v %v", ip.IpMask, ip.SubnetName)
+	}
+}
+
+func TestUnmarshalFreeIPv6(t *testing.T) {
+	var res struct {
+		FreeIps []netcenter.NetcenterFreeIPv6 `xml:"freeIpV6"`
+	}
+	mustDo(t, xml.Unmarshal([]byte(`<freeIpV6s>
+		<freeIpV6>
+			<ipv6>2001:67c:10ec:49c3::3cc</ipv6>
+			<ipv6Subnet>2001:67c:10ec:49c3::</ipv6Subnet>
+			<prefix>118</prefix>
+			<subnetAndPrefix>2001:67c:10ec:49c3::/118</subnetAndPrefix>
+			<subnetName>sos-dcz2-server-1-static</subnetName>
+			<subnetType>Subnet_Static</subnetType>
+		</freeIpV6>
+	</freeIpV6s>`), &res))
+
+	if len(res.FreeIps) != 1 {
+		t.Fatalf("got %d free IPs, want 1", len(res.FreeIps))
+	}
+	ip := res.FreeIps[0]
+	if ip.IP.String() != "2001:67c:10ec:49c3::3cc" || ip.SubnetAndPrefix.String() != "2001:67c:10ec:49c3::/118" {
+		t.Errorf("wrong addresses: %v %v", ip.IP, ip.SubnetAndPrefix)
+	}
+	if ip.Prefix != 118 || ip.SubnetType != "Subnet_Static" {
+		t.Errorf("wrong fields: %v %v", ip.Prefix, ip.SubnetType)
+	}
+}
+
+func TestUnmarshalUsedIPs(t *testing.T) {
+	var v4 struct {
+		UsedIps []netcenter.NetcenterUsedIPv4 `xml:"usedIp"`
+	}
+	mustDo(t, xml.Unmarshal([]byte(`<usedIps>
+		<usedIp>
+			<ip>192.33.91.1</ip>
+			<ipSubnet>192.33.91.0</ipSubnet>
+			<fqname>rou-dcz2-dg-sos-dcz2-server-1-a.ethz.ch</fqname>
+			<forward>Y</forward>
+			<reverse>Y</reverse>
+			<ttl>7200</ttl>
+			<dhcp>N</dhcp>
+			<ddns>N</ddns>
+			<isgGroup>id-kom-net</isgGroup>
+			<views><view>intern</view></views>
+		</usedIp>
+	</usedIps>`), &v4))
+	if len(v4.UsedIps) != 1 || v4.UsedIps[0].IP.String() != "192.33.91.1" || v4.UsedIps[0].TTL != 7200 || v4.UsedIps[0].Views[0] != "intern" {
+		t.Errorf("wrong used IPv4s: %+v", v4.UsedIps)
+	}
+
+	var v6 struct {
+		UsedIps []netcenter.NetcenterUsedIPv6 `xml:"usedIp"`
+	}
+	mustDo(t, xml.Unmarshal([]byte(`<usedIps>
+		<usedIp>
+			<ip>2001:67c:10ec:49c3::23a</ip>
+			<ipSubnet>2001:67c:10ec:49c3::</ipSubnet>
+			<subnetAndPrefix>2001:67c:10ec:49c3::/118</subnetAndPrefix>
+			<fqname>example.vsos.ethz.ch</fqname>
+			<forward>Y</forward>
+			<reverse>Y</reverse>
+			<ttl>3600</ttl>
+			<dhcp>N</dhcp>
+			<ddns>N</ddns>
+			<isgGroup>adm-soseth</isgGroup>
+			<lastDetection>2025-01-08 23:03</lastDetection>
+			<views><view>extern</view><view>intern</view></views>
+		</usedIp>
+	</usedIps>`), &v6))
+	if len(v6.UsedIps) != 1 || v6.UsedIps[0].Fqname != "example.vsos.ethz.ch" || v6.UsedIps[0].LastDetection != "2025-01-08 23:03" || len(v6.UsedIps[0].Views) != 2 {
+		t.Errorf("wrong used IPv6s: %+v", v6.UsedIps)
+	}
+}
+
+func TestUnmarshalInvalidIP(t *testing.T) {
+	var res struct {
+		FreeIps []netcenter.NetcenterFreeIPv4 `xml:"freeIp"`
+	}
+	err := xml.Unmarshal([]byte(`<freeIps><freeIp><ip>not-an-ip</ip></freeIp></freeIps>`), &res)
+	if err == nil {
+		t.Fatal("expected an error for an invalid IP")
+	}
+}
+
+func TestRegisterhost(t *testing.T) {
+	srv := newFakeNetcenter(t)
+
+	ipv4, ipv6, subnet, err := netcenter.Registerhost(context.Background(), "vm", "test.vsos.ethz.ch")
+	mustDo(t, err)
+
+	if subnet.Name != "vm" {
+		t.Errorf("registered in subnet %v, want vm", subnet.Name)
+	}
+	// The first free IPv4 after the gateway, the first IPv6 past the reserved offset
+	if ipv4.String() != "192.33.91.2" {
+		t.Errorf("got IPv4 %v, want 192.33.91.2", ipv4)
+	}
+	if ipv6.String() != "2001:67c:10ec:49c3::21" {
+		t.Errorf("got IPv6 %v, want 2001:67c:10ec:49c3::21", ipv6)
+	}
+	if got := recordsOf(srv, "test.vsos.ethz.ch"); len(got) != 2 {
+		t.Errorf("got records %v, want one IPv4 and one IPv6", got)
+	}
+	for _, rec := range srv.Records() {
+		if rec.FQDN == "test.vsos.ethz.ch" && (rec.ISGGroup != netcenter.ISG_GROUP || rec.Reverse != "Y") {
+			t.Errorf("record %+v should have a reverse entry and belong to %v", rec, netcenter.ISG_GROUP)
+		}
+	}
+
+	ipv4s, ipv6s, err := netcenter.GetHostIPs("test.vsos.ethz.ch")
+	mustDo(t, err)
+	if len(ipv4s) != 1 || !ipv4s[0].IP.Equal(ipv4) || len(ipv6s) != 1 || !ipv6s[0].IP.Equal(ipv6) {
+		t.Errorf("GetHostIPs returned %v %v", ipv4s, ipv6s)
+	}
+}
+
+func TestRegisterhostFallsThroughFullSubnets(t *testing.T) {
+	useSubnets(t, `{"subnets": [
+		{"name": "small", "net": "vm", "ipv4": "192.0.2.0/30", "ipv4Gateway": "192.0.2.1", "ipv6": "2001:db8::/120", "ipv6Gateway": "2001:db8::1", "ipv6Offset": 16},
+		{"name": "next", "net": "vm", "ipv4": "198.51.100.0/24", "ipv4Gateway": "198.51.100.1", "ipv6": "2001:db8:1::/120", "ipv6Gateway": "2001:db8:1::1", "ipv6Offset": 16},
+		{"name": "other", "net": "staging", "ipv4": "203.0.113.0/24", "ipv4Gateway": "203.0.113.1", "ipv6": "2001:db8:2::/120", "ipv6Gateway": "2001:db8:2::1", "ipv6Offset": 16}
+	]}`)
+	srv := newFakeNetcenter(t)
+	// Takes the last IPv4 of the /30
+	mustDo(t, srv.AddRecord("192.0.2.2", "full.vsos.ethz.ch"))
+
+	ipv4, ipv6, subnet, err := netcenter.Registerhost(context.Background(), "vm", "test.vsos.ethz.ch")
+	mustDo(t, err)
+	if subnet.Name != "next" {
+		t.Errorf("registered in subnet %v, want next", subnet.Name)
+	}
+	if ipv4.String() != "198.51.100.2" || ipv6.String() != "2001:db8:1::11" {
+		t.Errorf("got %v and %v", ipv4, ipv6)
+	}
+	if subnet.V4netmask() != 24 || subnet.V4gateway.String() != "198.51.100.1" {
+		t.Errorf("wrong network settings %v/%d", subnet.V4gateway, subnet.V4netmask())
+	}
+}
+
+func TestRegisterhostAllSubnetsFull(t *testing.T) {
+	useSubnets(t, `{"subnets": [
+		{"name": "small", "net": "vm", "ipv4": "192.0.2.0/30", "ipv4Gateway": "192.0.2.1", "ipv6": "2001:db8::/120", "ipv6Gateway": "2001:db8::1", "ipv6Offset": 16}
+	]}`)
+	srv := newFakeNetcenter(t)
+	mustDo(t, srv.AddRecord("192.0.2.2", "full.vsos.ethz.ch"))
+
+	_, _, _, err := netcenter.Registerhost(context.Background(), "vm", "test.vsos.ethz.ch")
+	if err == nil {
+		t.Fatal("expected an error when all subnets are full")
+	}
+	if got := recordsOf(srv, "test.vsos.ethz.ch"); len(got) != 0 {
+		t.Errorf("no record should be created, got %v", got)
+	}
+
+	_, _, _, err = netcenter.Registerhost(context.Background(), "unknown", "test.vsos.ethz.ch")
+	if err == nil {
+		t.Fatal("expected an error for a net without subnets")
+	}
+}
+
+func TestDeleteDNSEntryByHostname(t *testing.T) {
+	srv := newFakeNetcenter(t)
+	mustDo(t, srv.AddRecord("192.33.91.10", "test.vsos.ethz.ch"))
+	mustDo(t, srv.AddRecord("2001:67c:10ec:49c3::30", "test.vsos.ethz.ch"))
+	mustDo(t, srv.AddRecord("192.33.91.11", "other.vsos.ethz.ch"))
+
+	mustDo(t, netcenter.DeleteDNSEntryByHostname(context.Background(), "test.vsos.ethz.ch"))
+
+	if got := recordsOf(srv, "test.vsos.ethz.ch"); len(got) != 0 {
+		t.Errorf("records left: %v", got)
+	}
+	if got := recordsOf(srv, "other.vsos.ethz.ch"); len(got) != 1 {
+		t.Errorf("the records of other hosts must be kept, got %v", got)
+	}
+
+	// Nothing left to delete
+	mustDo(t, netcenter.DeleteDNSEntryByHostname(context.Background(), "test.vsos.ethz.ch"))
+}
+
+func TestNetcenterErrors(t *testing.T) {
+	newFakeNetcenter(t)
+
+	// Outside of all subnets, reported in an <errors> body
+	ip, _ := ipaddr.NewIPAddressString("203.0.113.5").ToAddress()
+	err := netcenter.CreateDNSEntry(context.Background(), ip, "test.vsos.ethz.ch")
+	if err == nil || !strings.Contains(err.Error(), "not in a subnet you manage") {
+		t.Errorf("got %v, want the error of Netcenter", err)
+	}
+
+	ip, _ = ipaddr.NewIPAddressString("192.33.91.1").ToAddress()
+	err = netcenter.CreateDNSEntry(context.Background(), ip, "test.vsos.ethz.ch")
+	if err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Errorf("got %v, want the error of Netcenter", err)
+	}
+
+	err = netcenter.DeleteDNSEntryByIP(context.Background(), ip.Increment(100))
+	if err == nil || !strings.Contains(err.Error(), "No nameToIP entry") {
+		t.Errorf("got %v, want the error of Netcenter", err)
+	}
+}
+
+func TestNetcenterWrongCredentials(t *testing.T) {
+	srv := newFakeNetcenter(t)
+	netcenter.SetClient(netcenter.NewHTTPClient(srv.URL, "vmwiz", "wrong"))
+
+	_, err := netcenter.GetFreeIPv4sInSubnet(netcenter.DEFAULT_SUBNET.V4net)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("got %v, want an authentication error", err)
+	}
+}
+
+func TestSearchRecords(t *testing.T) {
+	srv := newFakeNetcenter(t)
+	mustDo(t, srv.AddRecord("192.33.91.10", "test.vsos.ethz.ch"))
+	mustDo(t, srv.AddRecord("2001:67c:10ec:49c3::30", "test.vsos.ethz.ch"))
+	mustDo(t, srv.AddRecord("192.33.91.11", "other.vsos.ethz.ch"))
+
+	all, err := netcenter.SearchRecords("")
+	mustDo(t, err)
+	// Including the gateways
+	if len(all) != 5 {
+		t.Errorf("got %d records, want 5", len(all))
+	}
+
+	byName, err := netcenter.SearchRecords("TEST.vsos")
+	mustDo(t, err)
+	if len(byName) != 2 || byName[0].IP != "192.33.91.10" || byName[1].Subnet != "vm" {
+		t.Errorf("search by name returned %+v", byName)
+	}
+
+	byIP, err := netcenter.SearchRecords("2001:67c:10ec:49c3:0::30")
+	mustDo(t, err)
+	if len(byIP) != 1 || byIP[0].FQDN != "test.vsos.ethz.ch" {
+		t.Errorf("search by IP returned %+v", byIP)
+	}
+}
+
+func TestAliases(t *testing.T) {
+	srv := newFakeNetcenter(t)
+	mustDo(t, srv.AddRecord("192.33.91.10", "org.vsos.ethz.ch"))
+	ctx := context.Background()
+
+	mustDo(t, netcenter.CreateAlias(ctx, "www.org.vsos.ethz.ch", "org.vsos.ethz.ch"))
+	if err := netcenter.CreateAlias(ctx, "www.org.vsos.ethz.ch", "org.vsos.ethz.ch"); err == nil {
+		t.Error("expected an error for an existing alias")
+	}
+	if err := netcenter.CreateAlias(ctx, "www.none.vsos.ethz.ch", "none.vsos.ethz.ch"); err == nil {
+		t.Error("expected an error for an alias of an unknown host")
+	}
+
+	aliases, err := netcenter.GetAliases("org.vsos.ethz.ch")
+	mustDo(t, err)
+	if len(*aliases) != 1 || (*aliases)[0].FqName != "www.org.vsos.ethz.ch" || (*aliases)[0].IsgGroup != netcenter.ISG_GROUP {
+		t.Errorf("got aliases %+v", *aliases)
+	}
+
+	mustDo(t, netcenter.DeleteAlias(ctx, "www.org.vsos.ethz.ch"))
+	if len(srv.Aliases()) != 0 {
+		t.Errorf("aliases left: %+v", srv.Aliases())
+	}
+	if err := netcenter.DeleteAlias(ctx, "www.org.vsos.ethz.ch"); err == nil {
+		t.Error("expected an error for a deleted alias")
+	}
+}
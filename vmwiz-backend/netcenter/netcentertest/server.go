@@ -0,0 +1,474 @@
+package netcentertest
+
+// A fake Netcenter for tests: an httptest server answering the nameToIP endpoints VMWiz uses from an in-memory zone.
+// Invalid requests are answered like Netcenter does, with an <errors> body.
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+)
+
+// Free IPs listed per request at most, the real Netcenter also truncates the lists of large (IPv6) subnets
+const MAX_FREE_IPS = 1024
+
+// A nameToIP entry of the zone
+type Record struct {
+	IP       string
+	FQDN     string
+	Reverse  string
+	ISGGroup string
+}
+
+type subnet struct {
+	name  string
+	block *ipaddr.IPAddress
+}
+
+type Server struct {
+	*httptest.Server
+	User     string
+	Password string
+
+	mu      sync.Mutex
+	subnets []subnet
+	// By normalized IP
+	records map[string]Record
+	// CNAME records, by alias
+	aliases map[string]Alias
+}
+
+// A CNAME record of the zone
+type Alias struct {
+	FQDN     string
+	HostName string
+	ISGGroup string
+}
+
+// NewServer starts a fake Netcenter without subnets, accepting the given basic auth credentials
+func NewServer(user string, password string) *Server {
+	s := &Server{User: user, Password: password, records: map[string]Record{}, aliases: map[string]Alias{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /netcenter/rest/nameToIP/freeIps/v4/{ip}", s.handleFreeIPv4s)
+	mux.HandleFunc("GET /netcenter/rest/nameToIP/freeIps/v6/{ip}", s.handleFreeIPv6s)
+	mux.HandleFunc("GET /netcenter/rest/nameToIP/usedIps/v4/{ip}", s.handleUsedIPv4s)
+	mux.HandleFunc("GET /netcenter/rest/nameToIP/usedIps/v6/{ip}", s.handleUsedIPv6s)
+	mux.HandleFunc("POST /netcenter/rest/nameToIP", s.handleInsert)
+	mux.HandleFunc("DELETE /netcenter/rest/nameToIP/{ip}", s.handleDelete)
+	mux.HandleFunc("GET /netcenter/rest/alias/{hostname}", s.handleAliases)
+	mux.HandleFunc("POST /netcenter/rest/alias", s.handleInsertAlias)
+	mux.HandleFunc("DELETE /netcenter/rest/alias/{alias}", s.handleDeleteAlias)
+	s.Server = httptest.NewServer(s.checkAuth(mux))
+	return s
+}
+
+// AddSubnet adds an IPv4 or IPv6 subnet (e.g. 192.0.2.0/24) to the zone
+func (s *Server) AddSubnet(name string, prefix string) error {
+	addr, err := ipaddr.NewIPAddressString(prefix).ToAddress()
+	if err != nil || !addr.IsPrefixed() {
+		return fmt.Errorf("invalid subnet '%v'", prefix)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subnets = append(s.subnets, subnet{name: name, block: addr.ToPrefixBlock()})
+	return nil
+}
+
+// AddRecord registers ip for fqdn, e.g. to fill a subnet up before a test
+func (s *Server) AddRecord(ip string, fqdn string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.insert(Record{IP: ip, FQDN: fqdn, Reverse: "Y"})
+	return err
+}
+
+// Records returns the entries of the zone, sorted by IP
+func (s *Server) Records() []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := []Record{}
+	for _, r := range s.records {
+		res = append(res, r)
+	}
+	slices.SortFunc(res, func(a, b Record) int { return strings.Compare(a.IP, b.IP) })
+	return res
+}
+
+// Aliases returns the CNAME records of the zone, sorted by alias
+func (s *Server) Aliases() []Alias {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := []Alias{}
+	for _, a := range s.aliases {
+		res = append(res, a)
+	}
+	slices.SortFunc(res, func(a, b Alias) int { return strings.Compare(a.FQDN, b.FQDN) })
+	return res
+}
+
+func (s *Server) checkAuth(next http.Handler) http.Handler {
+	expected := "Basic " + base64.StdEncoding.EncodeToString([]byte(s.User+":"+s.Password))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != expected {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The subnet containing ip, must hold the lock
+func (s *Server) subnetOf(ip *ipaddr.IPAddress) *subnet {
+	for i := range s.subnets {
+		if s.subnets[i].block.GetIPVersion() == ip.GetIPVersion() && s.subnets[i].block.Contains(ip) {
+			return &s.subnets[i]
+		}
+	}
+	return nil
+}
+
+// Validates and adds a record, must hold the lock
+func (s *Server) insert(r Record) (Record, error) {
+	addr, err := ipaddr.NewIPAddressString(r.IP).ToAddress()
+	if err != nil || addr.IsPrefixed() {
+		return r, fmt.Errorf("IP '%v' is invalid", r.IP)
+	}
+	if s.subnetOf(addr) == nil {
+		return r, fmt.Errorf("IP '%v' is not in a subnet you manage", r.IP)
+	}
+	r.IP = addr.String()
+	if _, ok := s.records[r.IP]; ok {
+		return r, fmt.Errorf("IP '%v' is already in use", r.IP)
+	}
+	if r.FQDN == "" || !strings.Contains(r.FQDN, ".") {
+		return r, fmt.Errorf("fqName '%v' is invalid", r.FQDN)
+	}
+	s.records[r.IP] = r
+	return r, nil
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write(body)
+}
+
+type xmlErrors struct {
+	XMLName xml.Name `xml:"errors"`
+	Errors  []string `xml:"error>msg"`
+}
+
+// Netcenter reports invalid requests in the body
+func writeErrors(w http.ResponseWriter, errs ...string) {
+	writeXML(w, xmlErrors{Errors: errs})
+}
+
+// Parses the {ip} of the request and looks up its subnet, writes the errors if there is none
+func (s *Server) requestSubnet(w http.ResponseWriter, r *http.Request, ipv6 bool) *subnet {
+	addr, err := ipaddr.NewIPAddressString(r.PathValue("ip")).ToAddress()
+	if err != nil || addr.IsIPv6() != ipv6 {
+		writeErrors(w, fmt.Sprintf("'%v' is not a valid IP", r.PathValue("ip")))
+		return nil
+	}
+	sub := s.subnetOf(addr)
+	if sub == nil {
+		writeErrors(w, fmt.Sprintf("No subnet found for '%v'", addr))
+		return nil
+	}
+	return sub
+}
+
+// The unused addresses of a subnet, without the network and broadcast addresses. Must hold the lock
+func (s *Server) freeIPs(sub *subnet) []*ipaddr.IPAddress {
+	res := []*ipaddr.IPAddress{}
+	lower, upper := sub.block.GetLower().WithoutPrefixLen(), sub.block.GetUpper().WithoutPrefixLen()
+	for it := sub.block.WithoutPrefixLen().Iterator(); it.HasNext() && len(res) < MAX_FREE_IPS; {
+		ip := it.Next()
+		if ip.Equal(lower) || (sub.block.IsIPv4() && ip.Equal(upper)) {
+			continue
+		}
+		if _, ok := s.records[ip.String()]; ok {
+			continue
+		}
+		res = append(res, ip)
+	}
+	return res
+}
+
+// The records of a subnet, sorted by IP. Must hold the lock
+func (s *Server) usedIPs(sub *subnet) []Record {
+	res := []Record{}
+	for _, rec := range s.records {
+		addr, _ := ipaddr.NewIPAddressString(rec.IP).ToAddress()
+		if s.subnetOf(addr) == sub {
+			res = append(res, rec)
+		}
+	}
+	slices.SortFunc(res, func(a, b Record) int { return strings.Compare(a.IP, b.IP) })
+	return res
+}
+
+type freeIPv4 struct {
+	IP            string `xml:"ip"`
+	IpSubnet      string `xml:"ipSubnet"`
+	IpMask        int    `xml:"ipMask"`
+	SubnetAndMask string `xml:"subnetAndMask"`
+	SubnetName    string `xml:"subnetName"`
+}
+
+func (s *Server) handleFreeIPv4s(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub := s.requestSubnet(w, r, false)
+	if sub == nil {
+		return
+	}
+
+	res := struct {
+		XMLName xml.Name   `xml:"freeIps"`
+		FreeIps []freeIPv4 `xml:"freeIp"`
+	}{}
+	for _, ip := range s.freeIPs(sub) {
+		res.FreeIps = append(res.FreeIps, freeIPv4{
+			IP:            ip.String(),
+			IpSubnet:      sub.block.GetLower().WithoutPrefixLen().String(),
+			IpMask:        sub.block.GetPrefixLen().Len(),
+			SubnetAndMask: sub.block.String(),
+			SubnetName:    sub.name,
+		})
+	}
+	writeXML(w, res)
+}
+
+type freeIPv6 struct {
+	IP              string `xml:"ipv6"`
+	IpSubnet        string `xml:"ipv6Subnet"`
+	Prefix          int    `xml:"prefix"`
+	SubnetAndPrefix string `xml:"subnetAndPrefix"`
+	SubnetName      string `xml:"subnetName"`
+	SubnetType      string `xml:"subnetType"`
+}
+
+func (s *Server) handleFreeIPv6s(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub := s.requestSubnet(w, r, true)
+	if sub == nil {
+		return
+	}
+
+	res := struct {
+		XMLName xml.Name   `xml:"freeIpV6s"`
+		FreeIps []freeIPv6 `xml:"freeIpV6"`
+	}{}
+	for _, ip := range s.freeIPs(sub) {
+		res.FreeIps = append(res.FreeIps, freeIPv6{
+			IP:              ip.String(),
+			IpSubnet:        sub.block.GetLower().WithoutPrefixLen().String(),
+			Prefix:          sub.block.GetPrefixLen().Len(),
+			SubnetAndPrefix: sub.block.String(),
+			SubnetName:      sub.name,
+			SubnetType:      "Subnet_Static",
+		})
+	}
+	writeXML(w, res)
+}
+
+type usedIP struct {
+	IP              string   `xml:"ip"`
+	IPSubnet        string   `xml:"ipSubnet"`
+	SubnetAndPrefix string   `xml:"subnetAndPrefix,omitempty"`
+	Fqname          string   `xml:"fqname"`
+	Forward         string   `xml:"forward"`
+	Reverse         string   `xml:"reverse"`
+	TTL             int      `xml:"ttl"`
+	Dhcp            string   `xml:"dhcp"`
+	Ddns            string   `xml:"ddns"`
+	IsgGroup        string   `xml:"isgGroup"`
+	Views           []string `xml:"views>view"`
+}
+
+func (s *Server) handleUsedIPs(w http.ResponseWriter, r *http.Request, ipv6 bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub := s.requestSubnet(w, r, ipv6)
+	if sub == nil {
+		return
+	}
+
+	res := struct {
+		XMLName xml.Name `xml:"usedIps"`
+		UsedIps []usedIP `xml:"usedIp"`
+	}{}
+	for _, rec := range s.usedIPs(sub) {
+		used := usedIP{
+			IP:       rec.IP,
+			IPSubnet: sub.block.GetLower().WithoutPrefixLen().String(),
+			Fqname:   rec.FQDN,
+			Forward:  "Y",
+			Reverse:  rec.Reverse,
+			TTL:      3600,
+			Dhcp:     "N",
+			Ddns:     "N",
+			IsgGroup: rec.ISGGroup,
+			Views:    []string{"extern", "intern"},
+		}
+		if ipv6 {
+			used.SubnetAndPrefix = sub.block.String()
+		}
+		res.UsedIps = append(res.UsedIps, used)
+	}
+	writeXML(w, res)
+}
+
+func (s *Server) handleUsedIPv4s(w http.ResponseWriter, r *http.Request) {
+	s.handleUsedIPs(w, r, false)
+}
+
+func (s *Server) handleUsedIPv6s(w http.ResponseWriter, r *http.Request) {
+	s.handleUsedIPs(w, r, true)
+}
+
+func (s *Server) handleInsert(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		XMLName  xml.Name `xml:"insert"`
+		IPv4     string   `xml:"nameToIP>ip"`
+		IPv6     string   `xml:"nameToIP>ipv6"`
+		Reverse  string   `xml:"nameToIP>reverse"`
+		ISGGroup string   `xml:"nameToIP>isgGroup"`
+		FqName   string   `xml:"nameToIP>fqName"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrors(w, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+	if (req.IPv4 == "") == (req.IPv6 == "") {
+		writeErrors(w, "Exactly one of ip and ipv6 is required")
+		return
+	}
+	if addr, err := ipaddr.NewIPAddressString(req.IPv4 + req.IPv6).ToAddress(); err == nil && addr.IsIPv6() != (req.IPv6 != "") {
+		writeErrors(w, fmt.Sprintf("'%v' is given as the wrong IP version", addr))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, err := s.insert(Record{IP: req.IPv4 + req.IPv6, FQDN: req.FqName, Reverse: req.Reverse, ISGGroup: req.ISGGroup})
+	if err != nil {
+		writeErrors(w, err.Error())
+		return
+	}
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"success"`
+		Msg     string   `xml:"msg"`
+	}{Msg: fmt.Sprintf("Inserted %v", rec.IP)})
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	addr, err := ipaddr.NewIPAddressString(r.PathValue("ip")).ToAddress()
+	if err != nil {
+		writeErrors(w, fmt.Sprintf("'%v' is not a valid IP", r.PathValue("ip")))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[addr.String()]; !ok {
+		writeErrors(w, fmt.Sprintf("No nameToIP entry for '%v'", addr))
+		return
+	}
+	delete(s.records, addr.String())
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"success"`
+		Msg     string   `xml:"msg"`
+	}{Msg: fmt.Sprintf("Deleted %v", addr)})
+}
+
+type xmlAlias struct {
+	FqName   string   `xml:"fqName"`
+	HostName string   `xml:"hostName"`
+	TTL      int      `xml:"ttl"`
+	IsgGroup string   `xml:"isgGroup"`
+	Views    []string `xml:"views>view"`
+}
+
+func (s *Server) handleAliases(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := struct {
+		XMLName xml.Name   `xml:"aliases"`
+		Aliases []xmlAlias `xml:"alias"`
+	}{}
+	for _, a := range s.aliases {
+		if a.HostName == r.PathValue("hostname") {
+			res.Aliases = append(res.Aliases, xmlAlias{FqName: a.FQDN, HostName: a.HostName, TTL: 3600, IsgGroup: a.ISGGroup, Views: []string{"extern", "intern"}})
+		}
+	}
+	slices.SortFunc(res.Aliases, func(a, b xmlAlias) int { return strings.Compare(a.FqName, b.FqName) })
+	writeXML(w, res)
+}
+
+func (s *Server) handleInsertAlias(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		XMLName  xml.Name `xml:"insert"`
+		FqName   string   `xml:"alias>fqName"`
+		HostName string   `xml:"alias>hostName"`
+		ISGGroup string   `xml:"alias>isgGroup"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrors(w, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.aliases[req.FqName]; ok {
+		writeErrors(w, fmt.Sprintf("Alias '%v' already exists", req.FqName))
+		return
+	}
+	target, alias := false, false
+	for _, rec := range s.records {
+		target = target || rec.FQDN == req.HostName
+		alias = alias || rec.FQDN == req.FqName
+	}
+	if alias {
+		writeErrors(w, fmt.Sprintf("'%v' is a host name and cannot be an alias", req.FqName))
+		return
+	}
+	if !target {
+		writeErrors(w, fmt.Sprintf("Host '%v' does not exist", req.HostName))
+		return
+	}
+	s.aliases[req.FqName] = Alias{FQDN: req.FqName, HostName: req.HostName, ISGGroup: req.ISGGroup}
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"success"`
+		Msg     string   `xml:"msg"`
+	}{Msg: fmt.Sprintf("Inserted %v", req.FqName)})
+}
+
+func (s *Server) handleDeleteAlias(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.aliases[r.PathValue("alias")]; !ok {
+		writeErrors(w, fmt.Sprintf("No alias '%v'", r.PathValue("alias")))
+		return
+	}
+	delete(s.aliases, r.PathValue("alias"))
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"success"`
+		Msg     string   `xml:"msg"`
+	}{Msg: fmt.Sprintf("Deleted %v", r.PathValue("alias"))})
+}
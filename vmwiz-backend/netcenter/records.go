@@ -0,0 +1,169 @@
+package netcenter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+)
+
+// A nameToIP entry in one of the subnets
+type DNSRecord struct {
+	FQDN     string `json:"fqdn"`
+	IP       string `json:"ip"`
+	Subnet   string `json:"subnet"`
+	Reverse  string `json:"reverse"`
+	IsgGroup string `json:"isgGroup"`
+}
+
+// ListRecords returns the entries of all subnets, IPv4 first
+func ListRecords() ([]DNSRecord, error) {
+	res := []DNSRecord{}
+
+	seen := map[string]bool{}
+	for _, subnet := range Subnets() {
+		if !seen[subnet.V4net.String()] {
+			seen[subnet.V4net.String()] = true
+			ipv4s, err := GetUsedIPv4sInSubnet(subnet.V4net)
+			if err != nil {
+				return nil, fmt.Errorf("List DNS records: %v", err.Error())
+			}
+			for _, ip := range *ipv4s {
+				res = append(res, DNSRecord{FQDN: ip.Fqname, IP: ip.IP.String(), Subnet: subnet.Name, Reverse: ip.Reverse, IsgGroup: ip.IsgGroup})
+			}
+		}
+	}
+	for _, subnet := range Subnets() {
+		if !seen[subnet.V6net.String()] {
+			seen[subnet.V6net.String()] = true
+			ipv6s, err := GetUsedIPv6sInSubnet(subnet.V6net)
+			if err != nil {
+				return nil, fmt.Errorf("List DNS records: %v", err.Error())
+			}
+			for _, ip := range *ipv6s {
+				res = append(res, DNSRecord{FQDN: ip.Fqname, IP: ip.IP.String(), Subnet: subnet.Name, Reverse: ip.Reverse, IsgGroup: ip.IsgGroup})
+			}
+		}
+	}
+	return res, nil
+}
+
+// SearchRecords returns the entries whose FQDN contains query, or whose IP is query
+func SearchRecords(query string) ([]DNSRecord, error) {
+	records, err := ListRecords()
+	if err != nil {
+		return nil, err
+	}
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return records, nil
+	}
+
+	queryIP, _ := ipaddr.NewIPAddressString(query).ToAddress()
+	res := []DNSRecord{}
+	for _, r := range records {
+		if queryIP != nil && !queryIP.IsPrefixed() {
+			ip, _ := ipaddr.NewIPAddressString(r.IP).ToAddress()
+			if ip != nil && ip.Equal(queryIP) {
+				res = append(res, r)
+			}
+			continue
+		}
+		if strings.Contains(strings.ToLower(r.FQDN), query) {
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
+
+// Result of comparing what the system resolver answers for a name with Netcenter
+type Verification struct {
+	FQDN string `json:"fqdn"`
+	// IPs registered in Netcenter and returned by the resolver
+	Netcenter []string `json:"netcenter"`
+	Resolved  []string `json:"resolved"`
+	// Registered but not resolved, and resolved but not registered
+	Missing    []string `json:"missing"`
+	Unexpected []string `json:"unexpected"`
+	// Set if the name could not be resolved at all
+	ResolveError string              `json:"resolveError,omitempty"`
+	Aliases      []AliasVerification `json:"aliases"`
+	OK           bool                `json:"ok"`
+}
+
+type AliasVerification struct {
+	Alias string `json:"alias"`
+	// Canonical name the resolver returns for the alias
+	Resolved string `json:"resolved"`
+	Error    string `json:"error,omitempty"`
+	OK       bool   `json:"ok"`
+}
+
+// The resolver Verify asks, the one of the system
+var Resolver = net.DefaultResolver
+
+// Normalizes an IP for comparison, e.g. 2001:db8:0::1 and 2001:db8::1
+func normalizeIP(ip string) string {
+	addr, err := ipaddr.NewIPAddressString(ip).ToAddress()
+	if err != nil {
+		return ip
+	}
+	return addr.WithoutPrefixLen().String()
+}
+
+// Verify resolves fqdn and its Netcenter aliases with the system resolver and compares the answers to Netcenter
+func Verify(ctx context.Context, fqdn string) (*Verification, error) {
+	v := Verification{FQDN: fqdn, Netcenter: []string{}, Resolved: []string{}, Missing: []string{}, Unexpected: []string{}, Aliases: []AliasVerification{}}
+
+	ipv4s, ipv6s, err := GetHostIPs(fqdn)
+	if err != nil {
+		return nil, fmt.Errorf("Verify '%v': %v", fqdn, err.Error())
+	}
+	for _, ip := range ipv4s {
+		v.Netcenter = append(v.Netcenter, ip.IP.String())
+	}
+	for _, ip := range ipv6s {
+		v.Netcenter = append(v.Netcenter, ip.IP.String())
+	}
+
+	addrs, err := Resolver.LookupIPAddr(ctx, fqdn)
+	if err != nil {
+		v.ResolveError = err.Error()
+	}
+	for _, addr := range addrs {
+		v.Resolved = append(v.Resolved, normalizeIP(addr.IP.String()))
+	}
+
+	for _, ip := range v.Netcenter {
+		if !slices.Contains(v.Resolved, normalizeIP(ip)) {
+			v.Missing = append(v.Missing, ip)
+		}
+	}
+	for _, ip := range v.Resolved {
+		if !slices.ContainsFunc(v.Netcenter, func(n string) bool { return normalizeIP(n) == ip }) {
+			v.Unexpected = append(v.Unexpected, ip)
+		}
+	}
+
+	aliases, err := GetAliases(fqdn)
+	if err != nil {
+		return nil, fmt.Errorf("Verify '%v': %v", fqdn, err.Error())
+	}
+	for _, alias := range *aliases {
+		a := AliasVerification{Alias: alias.FqName}
+		cname, err := Resolver.LookupCNAME(ctx, alias.FqName)
+		if err != nil {
+			a.Error = err.Error()
+		} else {
+			a.Resolved = strings.TrimSuffix(cname, ".")
+			a.OK = strings.EqualFold(a.Resolved, fqdn)
+		}
+		v.Aliases = append(v.Aliases, a)
+	}
+
+	v.OK = len(v.Missing) == 0 && len(v.Unexpected) == 0 && v.ResolveError == "" && !slices.ContainsFunc(v.Aliases, func(a AliasVerification) bool { return !a.OK })
+	return &v, nil
+}
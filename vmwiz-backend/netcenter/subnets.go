@@ -0,0 +1,185 @@
+package netcenter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+)
+
+// A subnet VM IPs are allocated in. Subnets with the same Net form a pool: Registerhost tries them in the
+// configured order and falls through to the next one when a subnet has no free IPv4 or usable IPv6 left.
+type NetcenterSubnet struct {
+	Name string
+	// Pool the subnet belongs to, referenced by the provisioning profiles
+	Net       string
+	V4net     *ipaddr.IPv4Address
+	V6net     *ipaddr.IPv6Address
+	V4gateway *ipaddr.IPv4Address
+	V6gateway *ipaddr.IPv6Address
+	// IPv6 addresses up to this host number are reserved (routers, infrastructure) and never allocated
+	ipv6_offset int64
+	Comment     string
+}
+
+func NewNetcenterSubnet(name string, net string, v4net string, v4gateway string, v6net string, v6gateway string, offset int64, comment string) (NetcenterSubnet, error) {
+	n := NetcenterSubnet{}
+	n.Name = name
+	n.Net = net
+
+	v4, err := ipaddr.NewIPAddressString(v4net).ToAddress()
+	if err != nil || !v4.IsIPv4() || !v4.IsPrefixed() {
+		return n, fmt.Errorf("Subnet '%v': invalid IPv4 subnet '%v'", name, v4net)
+	}
+	v4, _ = v4.ToZeroHost()
+	n.V4net = v4.ToIPv4()
+	v6, err := ipaddr.NewIPAddressString(v6net).ToAddress()
+	if err != nil || !v6.IsIPv6() || !v6.IsPrefixed() {
+		return n, fmt.Errorf("Subnet '%v': invalid IPv6 subnet '%v'", name, v6net)
+	}
+	v6, _ = v6.ToZeroHost()
+	n.V6net = v6.ToIPv6()
+
+	gw4, err := ipaddr.NewIPAddressString(v4gateway).ToAddress()
+	if err != nil || !gw4.IsIPv4() || !n.V4net.ToPrefixBlock().Contains(gw4.ToIPv4().WithoutPrefixLen()) {
+		return n, fmt.Errorf("Subnet '%v': IPv4 gateway '%v' is not in %v", name, v4gateway, n.V4net)
+	}
+	n.V4gateway = gw4.ToIPv4().WithoutPrefixLen()
+	gw6, err := ipaddr.NewIPAddressString(v6gateway).ToAddress()
+	if err != nil || !gw6.IsIPv6() || !n.V6net.ToPrefixBlock().Contains(gw6.ToIPv6().WithoutPrefixLen()) {
+		return n, fmt.Errorf("Subnet '%v': IPv6 gateway '%v' is not in %v", name, v6gateway, n.V6net)
+	}
+	n.V6gateway = gw6.ToIPv6().WithoutPrefixLen()
+
+	if offset < 0 {
+		return n, fmt.Errorf("Subnet '%v': ipv6Offset must not be negative", name)
+	}
+	n.ipv6_offset = offset
+	n.Comment = comment
+
+	return n, nil
+}
+
+// MustNetcenterSubnet is NewNetcenterSubnet for subnets defined in the code, it panics if the subnet is invalid
+func MustNetcenterSubnet(name string, net string, v4net string, v4gateway string, v6net string, v6gateway string, offset int64, comment string) NetcenterSubnet {
+	n, err := NewNetcenterSubnet(name, net, v4net, v4gateway, v6net, v6gateway, offset, comment)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func (n NetcenterSubnet) V4netmask() int {
+	return n.V4net.GetPrefixLen().Len()
+}
+
+func (n NetcenterSubnet) V6netmask() int {
+	return n.V6net.GetPrefixLen().Len()
+}
+
+// usableIPv6 reports if ip is past the reserved part of the IPv6 subnet
+func (n NetcenterSubnet) usableIPv6(ip *ipaddr.IPv6Address) bool {
+	return n.V6net.Enumerate(ip).Cmp(big.NewInt(n.ipv6_offset)) > 0
+}
+
+// The subnet used before subnets were configurable, used if NETCENTER_SUBNETS is not set
+var DEFAULT_SUBNET = MustNetcenterSubnet("vm", "vm",
+	"192.33.91.255/24", "192.33.91.1",
+	"2001:67c:10ec:49c3::/118", "2001:67c:10ec:49c3::1",
+	0x020,
+	"VM (sos-dc-server-1)")
+
+var subnets = []NetcenterSubnet{DEFAULT_SUBNET}
+
+// Format of the file pointed to by NETCENTER_SUBNETS
+type subnetsConfig struct {
+	Subnets []struct {
+		Name        string `json:"name"`
+		Net         string `json:"net"`
+		IPv4        string `json:"ipv4"`
+		IPv4Gateway string `json:"ipv4Gateway"`
+		IPv6        string `json:"ipv6"`
+		IPv6Gateway string `json:"ipv6Gateway"`
+		IPv6Offset  int64  `json:"ipv6Offset"`
+		Comment     string `json:"comment"`
+	} `json:"subnets"`
+}
+
+// InitSubnets loads the subnets from NETCENTER_SUBNETS
+func InitSubnets() error {
+	if config.AppConfig.NETCENTER_SUBNETS == "" {
+		subnets = []NetcenterSubnet{DEFAULT_SUBNET}
+		return nil
+	}
+
+	raw, err := os.ReadFile(config.AppConfig.NETCENTER_SUBNETS)
+	if err != nil {
+		return fmt.Errorf("Failed to read Netcenter subnets: %v", err)
+	}
+	var c subnetsConfig
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return fmt.Errorf("Failed to parse Netcenter subnets: %v", err)
+	}
+	if len(c.Subnets) == 0 {
+		return fmt.Errorf("No subnet is defined in %v", config.AppConfig.NETCENTER_SUBNETS)
+	}
+
+	loaded := []NetcenterSubnet{}
+	for _, s := range c.Subnets {
+		if s.Name == "" || s.Net == "" {
+			return fmt.Errorf("Every subnet needs a name and a net")
+		}
+		for _, l := range loaded {
+			if l.Name == s.Name {
+				return fmt.Errorf("Subnet '%v' is defined twice", s.Name)
+			}
+		}
+		subnet, err := NewNetcenterSubnet(s.Name, s.Net, s.IPv4, s.IPv4Gateway, s.IPv6, s.IPv6Gateway, s.IPv6Offset, s.Comment)
+		if err != nil {
+			return err
+		}
+		loaded = append(loaded, subnet)
+	}
+	subnets = loaded
+	return nil
+}
+
+// Subnets returns all subnets, in allocation order
+func Subnets() []NetcenterSubnet {
+	return subnets
+}
+
+// SubnetsOf returns the subnets of a net, in allocation order
+func SubnetsOf(net string) []NetcenterSubnet {
+	res := []NetcenterSubnet{}
+	for _, s := range subnets {
+		if s.Net == net {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// GetSubnet returns the subnet with the given name
+func GetSubnet(name string) (*NetcenterSubnet, error) {
+	for _, s := range subnets {
+		if s.Name == name {
+			return &s, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown subnet '%v'", name)
+}
+
+// SubnetOf returns the subnet ip is in, nil if it is in none of them
+func SubnetOf(ip *ipaddr.IPAddress) *NetcenterSubnet {
+	ip = ip.WithoutPrefixLen()
+	for _, s := range subnets {
+		if (ip.IsIPv4() && s.V4net.ToPrefixBlock().Contains(ip)) || (ip.IsIPv6() && s.V6net.ToPrefixBlock().Contains(ip)) {
+			return &s
+		}
+	}
+	return nil
+}
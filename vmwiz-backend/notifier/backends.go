@@ -0,0 +1,147 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A Notification is a message about a backend event, e.g. a new VM request.
+type Notification struct {
+	Event string
+	Title string
+	Body  string
+}
+
+// A Notifier delivers notifications to a chat or an external service.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+// Sends the request and turns any non-2xx response into an error
+func doRequest(req *http.Request) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s returned %s: %s", req.Method, req.URL.Redacted(), resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+func postJSON(ctx context.Context, method string, url string, payload any, header http.Header) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal notification: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doRequest(req)
+}
+
+// Posts to the stateful API of an Apprise server, using the event as tag.
+// Which services get notified for which tag is configured in Apprise itself (see docker/notifier_config.yml).
+type AppriseNotifier struct {
+	URL string
+}
+
+func (a AppriseNotifier) Notify(ctx context.Context, n Notification) error {
+	v := url.Values{}
+	v.Add("title", n.Title)
+	v.Add("tags", n.Event)
+	v.Add("body", n.Body)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", a.URL, bytes.NewBufferString(v.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doRequest(req)
+}
+
+// Posts the notification as JSON to an arbitrary URL.
+// If Secret is set, the request carries an X-VMWiz-Signature header: the hex encoded HMAC-SHA256
+// of "<X-VMWiz-Timestamp>.<body>", keyed with Secret.
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+}
+
+func (wh WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	timestamp := time.Now()
+	body, err := json.Marshal(struct {
+		Event     string    `json:"event"`
+		Title     string    `json:"title"`
+		Body      string    `json:"body"`
+		Timestamp time.Time `json:"timestamp"`
+	}{n.Event, n.Title, n.Body, timestamp})
+	if err != nil {
+		return fmt.Errorf("Failed to marshal notification: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-VMWiz-Event", n.Event)
+	if wh.Secret != "" {
+		ts := strconv.FormatInt(timestamp.Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(wh.Secret))
+		mac.Write([]byte(ts + "."))
+		mac.Write(body)
+		req.Header.Set("X-VMWiz-Timestamp", ts)
+		req.Header.Set("X-VMWiz-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return doRequest(req)
+}
+
+// Posts to a Slack compatible incoming webhook (Slack, Mattermost, Rocket.Chat, Zulip's Slack integration, ...)
+type SlackNotifier struct {
+	URL string
+}
+
+func (s SlackNotifier) Notify(ctx context.Context, n Notification) error {
+	return postJSON(ctx, "POST", s.URL, struct {
+		Text string `json:"text"`
+	}{fmt.Sprintf("*%s*\n%s", n.Title, n.Body)}, nil)
+}
+
+// Sends an m.text message to a Matrix room using the client-server API.
+type MatrixNotifier struct {
+	// Homeserver base URL, e.g. https://matrix.org
+	URL         string
+	Room        string
+	AccessToken string
+}
+
+func (m MatrixNotifier) Notify(ctx context.Context, n Notification) error {
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s", m.URL, url.PathEscape(m.Room), uuid.New().String())
+	return postJSON(ctx, "PUT", endpoint, struct {
+		MsgType string `json:"msgtype"`
+		Body    string `json:"body"`
+	}{"m.text", n.Title + "\n" + n.Body}, http.Header{"Authorization": {"Bearer " + m.AccessToken}})
+}
@@ -0,0 +1,244 @@
+package notifier
+
+// Outbound emails are never sent directly. They are stored in the mail_queue table (the "outbox")
+// together with the name of their template and the data to render it with. A single worker
+// delivers due messages and retries failed attempts with exponential backoff.
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"log"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/logger"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
+)
+
+//go:embed *.tmpl
+var templatesFS embed.FS
+
+const (
+	// After this many failed attempts a message is marked as failed and no longer retried automatically.
+	MAIL_MAX_ATTEMPTS = 8
+
+	mailRetryBaseDelay = time.Minute
+	mailPollInterval   = 10 * time.Second
+	mailBatchSize      = 20
+	// How long a worker has to deliver the messages it claimed before another worker may try them
+	mailLease = 10 * time.Minute
+)
+
+// EnqueueEmail stores an email in the outbox and returns its ID.
+// The template is rendered with data when the message is delivered, so data must survive a round trip through JSON.
+func EnqueueEmail(ctx context.Context, subject string, tmpl string, data any, to []string) (int64, error) {
+	return enqueueEmail(ctx, NewMessageID(), "", subject, tmpl, data, to)
+}
+
+// EnqueueThreadedEmail is like EnqueueEmail, but marks the email as a reply to thread (a Message-ID, see RequestThreadID).
+func EnqueueThreadedEmail(ctx context.Context, thread string, subject string, tmpl string, data any, to []string) (int64, error) {
+	return enqueueEmail(ctx, NewMessageID(), thread, subject, tmpl, data, to)
+}
+
+// StartEmailThread is like EnqueueEmail, but uses thread as the Message-ID, so that the emails enqueued
+// with EnqueueThreadedEmail afterwards reply to it.
+func StartEmailThread(ctx context.Context, thread string, subject string, tmpl string, data any, to []string) (int64, error) {
+	return enqueueEmail(ctx, thread, "", subject, tmpl, data, to)
+}
+
+func enqueueEmail(ctx context.Context, messageID string, inReplyTo string, subject string, tmpl string, data any, to []string) (int64, error) {
+	if _, err := fs.Stat(templatesFS, tmpl); err != nil {
+		return 0, fmt.Errorf("Failed to enqueue email: Unknown template '%v': %v", tmpl, err)
+	}
+
+	templateData, err := json.Marshal(data)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to enqueue email: Marshalling template data: %v", err)
+	}
+
+	id, err := storage.DB.EnqueueMail(ctx, storage.EnqueueMailParams{
+		Recipients:   to,
+		Subject:      subject,
+		Template:     tmpl,
+		TemplateData: templateData,
+		MessageID:    messageID,
+		InReplyTo:    sql.NullString{String: inReplyTo, Valid: inReplyTo != ""},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Failed to enqueue email: %v", err)
+	}
+
+	logger.From(ctx).Infof("[-] Queued email %d '%s' to %s", id, subject, strings.Join(to, ", "))
+	return id, nil
+}
+
+// RetryEmail schedules a message that was not sent yet for immediate delivery.
+func RetryEmail(ctx context.Context, id int64) error {
+	n, err := storage.DB.RetryMail(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Failed to retry email %d: %v", id, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("Failed to retry email %d: No such email, or it was already sent", id)
+	}
+	return nil
+}
+
+// CancelEmail stops any further delivery attempt of a queued or failed message.
+func CancelEmail(ctx context.Context, id int64) error {
+	n, err := storage.DB.CancelMail(ctx, storage.CancelMailParams{
+		ID:        id,
+		LastError: sql.NullString{String: "Cancelled by an admin", Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to cancel email %d: %v", id, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("Failed to cancel email %d: No such email, or it is neither queued nor failed", id)
+	}
+	return nil
+}
+
+// The HTML alternative of a message: its rendered plain text, escaped, with whitespace preserved as is
+var htmlLayout = htmltemplate.Must(htmltemplate.New("layout").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"></head>
+<body>
+<div style="font-family: sans-serif; white-space: pre-wrap;">{{.}}</div>
+</body>
+</html>
+`))
+
+// Renders the text and HTML bodies of a queued message
+func renderEmail(msg storage.MailQueue) (email, error) {
+	var data map[string]any
+	if err := json.Unmarshal(msg.TemplateData, &data); err != nil {
+		return email{}, fmt.Errorf("Failed to unmarshal template data: %v", err)
+	}
+
+	src, err := templatesFS.ReadFile(msg.Template)
+	if err != nil {
+		return email{}, fmt.Errorf("Failed to read email template '%v': %v", msg.Template, err)
+	}
+
+	textTmpl, err := texttemplate.New(msg.Template).Parse(string(src))
+	if err != nil {
+		return email{}, fmt.Errorf("Failed to parse email template '%v': %v", msg.Template, err)
+	}
+	text := new(bytes.Buffer)
+	if err := textTmpl.Execute(text, data); err != nil {
+		return email{}, fmt.Errorf("Failed to execute email template '%v': %v", msg.Template, err)
+	}
+
+	// The templates are plain text, only the rendered text is escaped for HTML
+	html := new(bytes.Buffer)
+	if err := htmlLayout.Execute(html, text.String()); err != nil {
+		return email{}, fmt.Errorf("Failed to execute HTML email template '%v': %v", msg.Template, err)
+	}
+
+	messageID := msg.MessageID
+	if messageID == "" {
+		// Queued before Message-IDs were stored
+		messageID = NewMessageID()
+	}
+
+	return email{
+		Subject:   msg.Subject,
+		To:        msg.Recipients,
+		MessageID: messageID,
+		InReplyTo: msg.InReplyTo.String,
+		Text:      text.Bytes(),
+		HTML:      html.Bytes(),
+	}, nil
+}
+
+// Backoff before the next attempt, given how many attempts were already made (1, 2, 4, ... minutes).
+func mailRetryDelay(attempts int32) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return mailRetryBaseDelay << (attempts - 1)
+}
+
+// deliverMail makes a single delivery attempt and records its outcome in the outbox.
+func deliverMail(ctx context.Context, msg storage.MailQueue) error {
+	if !config.AppConfig.SMTP_ENABLE {
+		// Dry-run: drop the message rather than piling it up until SMTP gets enabled.
+		_, err := storage.DB.CancelMail(ctx, storage.CancelMailParams{
+			ID:        msg.ID,
+			LastError: sql.NullString{String: "SMTP disabled (dry-run)", Valid: true},
+		})
+		return err
+	}
+
+	e, err := renderEmail(msg)
+	if err == nil {
+		err = sendEmail(e)
+	}
+	if err != nil {
+		attempts := msg.Attempts + 1
+		status := storage.MailStatus(storage.MAIL_STATUS_QUEUED)
+		if attempts >= MAIL_MAX_ATTEMPTS {
+			status = storage.MAIL_STATUS_FAILED
+		}
+		errU := storage.DB.MarkMailAttemptFailed(ctx, storage.MarkMailAttemptFailedParams{
+			ID:            msg.ID,
+			Status:        status,
+			LastError:     sql.NullString{String: err.Error(), Valid: true},
+			NextAttemptAt: time.Now().Add(mailRetryDelay(attempts)),
+		})
+		if errU != nil {
+			return fmt.Errorf("Delivering email %d: %v (and failed to record the attempt: %v)", msg.ID, err, errU)
+		}
+		return fmt.Errorf("Delivering email %d (attempt %d/%d): %v", msg.ID, attempts, MAIL_MAX_ATTEMPTS, err)
+	}
+
+	if err := storage.DB.MarkMailSent(ctx, msg.ID); err != nil {
+		return fmt.Errorf("Delivered email %d but failed to mark it as sent: %v", msg.ID, err)
+	}
+	if err := storage.DB.MarkSurveyEmailSentByMailID(ctx, sql.NullInt64{Int64: msg.ID, Valid: true}); err != nil {
+		return fmt.Errorf("Delivered email %d but failed to update the related survey email: %v", msg.ID, err)
+	}
+	return nil
+}
+
+// ProcessMailQueue makes one delivery attempt for every message that is due and not claimed by another worker.
+func ProcessMailQueue(ctx context.Context) error {
+	msgs, err := storage.DB.ClaimDueMails(ctx, storage.ClaimDueMailsParams{
+		LeaseUntil: time.Now().Add(mailLease),
+		MaxResults: mailBatchSize,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to list due emails: %v", err)
+	}
+
+	var errors []string
+	for _, msg := range msgs {
+		if err := deliverMail(ctx, msg); err != nil {
+			errors = append(errors, err.Error())
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("Some emails could not be delivered:\n- %v", strings.Join(errors, "\n- "))
+	}
+	return nil
+}
+
+// RunMailWorker polls the outbox forever. It is meant to run in its own goroutine.
+func RunMailWorker() {
+	for {
+		if err := ProcessMailQueue(context.Background()); err != nil {
+			log.Printf("Mail queue: %v", err)
+		}
+		time.Sleep(mailPollInterval)
+	}
+}
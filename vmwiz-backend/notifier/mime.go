@@ -0,0 +1,126 @@
+package notifier
+
+// Emails are sent as RFC 5322 messages with a multipart/alternative body (RFC 2045/2046):
+// a plain text rendering of the template, followed by an HTML rendering of the same template.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
+)
+
+type email struct {
+	Subject   string
+	To        []string
+	MessageID string
+	// Message-ID of the thread root, empty if the email is not part of a thread
+	InReplyTo string
+	Text      []byte
+	HTML      []byte
+}
+
+// Domain used on the right-hand side of generated Message-IDs
+func messageIDDomain() string {
+	if addr, err := mail.ParseAddress(config.AppConfig.SMTP_SENDER); err == nil {
+		if at := strings.LastIndex(addr.Address, "@"); at >= 0 {
+			return addr.Address[at+1:]
+		}
+	}
+	return config.AppConfig.VMWIZ_HOSTNAME
+}
+
+// NewMessageID returns a globally unique Message-ID, including the angle brackets.
+func NewMessageID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(b), messageIDDomain())
+}
+
+// RequestThreadID returns the Message-ID of the first email about a VM request (see StartEmailThread).
+// All later emails reply to it, so that mail clients group them into a single conversation.
+func RequestThreadID(requestId int64) string {
+	return fmt.Sprintf("<vmrequest-%d@%s>", requestId, messageIDDomain())
+}
+
+// Formats a list of addresses for an address header, encoding display names if needed
+func encodeAddressList(addrs []string) string {
+	if len(addrs) == 0 {
+		return "undisclosed-recipients:;"
+	}
+	encoded := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		addr, err := mail.ParseAddress(a)
+		if err != nil {
+			// Keep the address as is, the SMTP server will reject it if it is really invalid
+			encoded = append(encoded, a)
+			continue
+		}
+		encoded = append(encoded, addr.String())
+	}
+	return strings.Join(encoded, ", ")
+}
+
+func writeQuotedPrintablePart(w *multipart.Writer, contentType string, body []byte) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write(body); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// Bytes returns the message with CRLF line endings, ready to be handed to the SMTP server.
+func (e email) Bytes() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+
+	header := func(key, value string) {
+		fmt.Fprintf(buf, "%s: %s\r\n", key, value)
+	}
+	header("From", encodeAddressList([]string{config.AppConfig.SMTP_SENDER}))
+	header("To", encodeAddressList(e.To))
+	if config.AppConfig.SMTP_REPLYTO != "" {
+		header("Reply-To", encodeAddressList([]string{config.AppConfig.SMTP_REPLYTO}))
+	}
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Subject", mime.QEncoding.Encode("utf-8", e.Subject))
+	header("Message-ID", e.MessageID)
+	if e.InReplyTo != "" {
+		header("In-Reply-To", e.InReplyTo)
+		header("References", e.InReplyTo)
+	}
+	header("MIME-Version", "1.0")
+	header("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": mw.Boundary()}))
+	buf.WriteString("\r\n")
+
+	// Parts are ordered by increasing preference: clients that can render HTML pick the last one
+	if err := writeQuotedPrintablePart(mw, "text/plain; charset=utf-8", e.Text); err != nil {
+		return nil, fmt.Errorf("Failed to write text part: %v", err)
+	}
+	if err := writeQuotedPrintablePart(mw, "text/html; charset=utf-8", e.HTML); err != nil {
+		return nil, fmt.Errorf("Failed to write HTML part: %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("Failed to close multipart body: %v", err)
+	}
+
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
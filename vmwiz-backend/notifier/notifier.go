@@ -2,10 +2,11 @@ package notifier
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"net/http"
 	"net/smtp"
-	"net/url"
+	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -17,7 +18,7 @@ import (
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
 )
 
-var APPRISE_THREAD_TITLE = "VM Request Notifications"
+var NOTIFICATION_TITLE = "VM Request Notifications"
 
 type SMTP struct {
 	rate_limit *rate.Limiter
@@ -27,46 +28,179 @@ type SMTP struct {
 
 var SMTP_CLIENT SMTP
 
-func useNotifier(ctx context.Context, tags string, body string) error {
+// Events that can be routed to notifiers. The names double as Apprise tags.
+const (
+	EVENT_TEST                  = "test"
+	EVENT_NEW_VMREQUEST         = "new_vmrequest"
+	EVENT_VMREQUEST_ACCEPTED    = "vmrequest_accepted"
+	EVENT_VMREQUEST_REJECTED    = "vmrequest_rejected"
+	EVENT_VMREQUEST_HELD        = "vmrequest_held"
+	EVENT_VMREQUEST_UNHELD      = "vmrequest_unheld"
+	EVENT_VMREQUEST_WITHDRAWN   = "vmrequest_withdrawn"
+	EVENT_VM_DELETION_REQUESTED = "vm_deletion_requested"
+	EVENT_VMCREATION_UPDATE     = "vmcreation_update"
+	EVENT_VMUSAGESURVEY         = "vmusagesurvey"
+)
+
+var EVENTS = []string{EVENT_TEST, EVENT_NEW_VMREQUEST, EVENT_VMREQUEST_ACCEPTED, EVENT_VMREQUEST_REJECTED, EVENT_VMREQUEST_HELD, EVENT_VMREQUEST_UNHELD, EVENT_VMREQUEST_WITHDRAWN, EVENT_VM_DELETION_REQUESTED, EVENT_VMCREATION_UPDATE, EVENT_VMUSAGESURVEY}
+
+// Route used for events without a route of their own
+const ROUTE_DEFAULT = "*"
+
+// Format of the file pointed to by NOTIFY_CONFIG
+type notifyConfig struct {
+	Backends map[string]struct {
+		// One of apprise, webhook, slack, matrix
+		Type        string `json:"type"`
+		URL         string `json:"url"`
+		Secret      string `json:"secret"`
+		Room        string `json:"room"`
+		AccessToken string `json:"access_token"`
+	} `json:"backends"`
+	// Maps events (or ROUTE_DEFAULT) to backend names
+	Routes map[string][]string `json:"routes"`
+}
 
-	logger.From(ctx).Info("[-] Sending notification to vmwiz-notifier")
-	v := url.Values{}
-	v.Add("title", APPRISE_THREAD_TITLE)
-	v.Add("tags", tags)
-	v.Add("body", body)
+var notifiers = map[string]Notifier{
+	"apprise": AppriseNotifier{URL: "http://vmwiz-notifier:8000/notify/default"},
+}
+var routes = map[string][]string{
+	ROUTE_DEFAULT: {"apprise"},
+}
 
-	_, err := http.PostForm("http://vmwiz-notifier:8000/notify/default", v)
+// InitNotifiers loads the notification backends and routes from NOTIFY_CONFIG.
+// Without a config file, every event is sent to the bundled Apprise container.
+func InitNotifiers() error {
+	if config.AppConfig.NOTIFY_CONFIG == "" {
+		return nil
+	}
+
+	raw, err := os.ReadFile(config.AppConfig.NOTIFY_CONFIG)
 	if err != nil {
-		return err
+		return fmt.Errorf("Failed to read notify config: %v", err)
+	}
+	var c notifyConfig
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return fmt.Errorf("Failed to parse notify config: %v", err)
 	}
+
+	newNotifiers := map[string]Notifier{}
+	for name, b := range c.Backends {
+		if b.URL == "" {
+			return fmt.Errorf("Failed to parse notify config: Backend '%s' has no url", name)
+		}
+		switch b.Type {
+		case "apprise":
+			newNotifiers[name] = AppriseNotifier{URL: b.URL}
+		case "webhook":
+			newNotifiers[name] = WebhookNotifier{URL: b.URL, Secret: b.Secret}
+		case "slack":
+			newNotifiers[name] = SlackNotifier{URL: b.URL}
+		case "matrix":
+			if b.Room == "" || b.AccessToken == "" {
+				return fmt.Errorf("Failed to parse notify config: Matrix backend '%s' needs a room and an access_token", name)
+			}
+			newNotifiers[name] = MatrixNotifier{URL: strings.TrimSuffix(b.URL, "/"), Room: b.Room, AccessToken: b.AccessToken}
+		default:
+			return fmt.Errorf("Failed to parse notify config: Backend '%s' has unknown type '%s'", name, b.Type)
+		}
+	}
+
+	for event, names := range c.Routes {
+		if event != ROUTE_DEFAULT && !slices.Contains(EVENTS, event) {
+			return fmt.Errorf("Failed to parse notify config: Unknown event '%s'", event)
+		}
+		for _, name := range names {
+			if _, ok := newNotifiers[name]; !ok {
+				return fmt.Errorf("Failed to parse notify config: Event '%s' is routed to unknown backend '%s'", event, name)
+			}
+		}
+	}
+
+	notifiers = newNotifiers
+	routes = c.Routes
 	return nil
 }
 
+// Backends that get notified about an event
+func routeFor(event string) []string {
+	if names, ok := routes[event]; ok {
+		return names
+	}
+	return routes[ROUTE_DEFAULT]
+}
+
+func useNotifier(ctx context.Context, event string, body string) error {
+	n := Notification{Event: event, Title: NOTIFICATION_TITLE, Body: body}
+
+	var errors []string
+	for _, name := range routeFor(event) {
+		logger.From(ctx).Infof("[-] Sending '%s' notification to %s", event, name)
+		if err := notifiers[name].Notify(ctx, n); err != nil {
+			logger.From(ctx).Errorf("Failed to send '%s' notification to %s: %v", event, name, err)
+			errors = append(errors, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("Failed to send '%s' notification: %s", event, strings.Join(errors, "; "))
+	}
+	return nil
+}
+
+type RouteTestResult struct {
+	Event   string
+	Backend string
+	Err     error
+}
+
+// TestRoutes sends a test notification for each event to every backend it is routed to.
+func TestRoutes(ctx context.Context, body string) []RouteTestResult {
+	results := []RouteTestResult{}
+	for _, event := range EVENTS {
+		for _, name := range routeFor(event) {
+			err := notifiers[name].Notify(ctx, Notification{Event: event, Title: NOTIFICATION_TITLE, Body: body})
+			results = append(results, RouteTestResult{Event: event, Backend: name, Err: err})
+		}
+	}
+	return results
+}
+
 func NotifyTest(ctx context.Context, body string) error {
-	return useNotifier(ctx, "test", body)
+	return useNotifier(ctx, EVENT_TEST, body)
 }
 
 func NotifyVMRequest(ctx context.Context, req storage.Request) error {
-	return useNotifier(ctx, "new_vmrequest", fmt.Sprintf("New VM Request %v:\n```\n%v\n```", req.Requestid, req.ToString()))
+	return useNotifier(ctx, EVENT_NEW_VMREQUEST, fmt.Sprintf("New VM Request %v:\n```\n%v\n```", req.Requestid, req.ToString()))
 }
 
 func NotifyVMRequestStatusChanged(ctx context.Context, req storage.Request, additional_text string) error {
 	switch req.Requeststatus {
-	case storage.REQUEST_STATUS_ACCEPTED:
-		return useNotifier(ctx, "vmrequest_accepted", fmt.Sprintf("Request %v approved ! %v", req.Requestid, additional_text))
+	case storage.REQUEST_STATUS_ACCEPTED, storage.REQUEST_STATUS_PROVISIONING:
+		return useNotifier(ctx, EVENT_VMREQUEST_ACCEPTED, fmt.Sprintf("Request %v approved ! %v", req.Requestid, additional_text))
 	case storage.REQUEST_STATUS_REJECTED:
-		return useNotifier(ctx, "vmrequest_rejected", fmt.Sprintf("Request %v denied ! %v", req.Requestid, additional_text))
+		return useNotifier(ctx, EVENT_VMREQUEST_REJECTED, fmt.Sprintf("Request %v denied ! %v", req.Requestid, additional_text))
+	case storage.REQUEST_STATUS_HELD:
+		return useNotifier(ctx, EVENT_VMREQUEST_HELD, fmt.Sprintf("Request %v put on hold. %v", req.Requestid, additional_text))
+	case storage.REQUEST_STATUS_PENDING:
+		return useNotifier(ctx, EVENT_VMREQUEST_UNHELD, fmt.Sprintf("Request %v released from hold. %v", req.Requestid, additional_text))
+	case storage.REQUEST_STATUS_WITHDRAWN:
+		return useNotifier(ctx, EVENT_VMREQUEST_WITHDRAWN, fmt.Sprintf("Request %v withdrawn by the requester. %v", req.Requestid, additional_text))
 	}
 
 	return nil
 }
 
+func NotifyVMDeletionRequested(ctx context.Context, req storage.Request, reason string) error {
+	return useNotifier(ctx, EVENT_VM_DELETION_REQUESTED, fmt.Sprintf("The requester of request %v asked for VM %v (%v) to be deleted. %v", req.Requestid, req.Vmid.Int32, req.Hostname, reason))
+}
+
 func NotifyVMCreationUpdate(ctx context.Context, msg string) error {
-	return useNotifier(ctx, "vmcreation_update", msg)
+	return useNotifier(ctx, EVENT_VMCREATION_UPDATE, msg)
 }
 
 func NotifyVMUsageSurvey(ctx context.Context, surveyId int64, msg string) error {
-	return useNotifier(ctx, "vmusagesurvey", fmt.Sprintf("VM Usage survey %v: %v", surveyId, msg))
+	return useNotifier(ctx, EVENT_VMUSAGESURVEY, fmt.Sprintf("VM Usage survey %v: %v", surveyId, msg))
 }
 
 func InitSMTP() error {
@@ -75,14 +209,15 @@ func InitSMTP() error {
 	SMTP_CLIENT.smtp_auth = smtp.PlainAuth("", config.AppConfig.SMTP_USER, config.AppConfig.SMTP_PASSWORD, config.AppConfig.SMTP_HOST)
 
 	// Sends an email to no one to test the SMTP connection
-	err := SendEmail("Test", []byte{}, []string{})
+	err := sendEmail(email{Subject: "Test", MessageID: NewMessageID()})
 	if err != nil {
 		return fmt.Errorf("Failed to send test email: %v", err)
 	}
 	return nil
 }
 
-func SendEmail(subject string, body []byte, to []string) error {
+// sendEmail delivers a message right away. Everything else goes through the outbox, see EnqueueEmail.
+func sendEmail(e email) error {
 	if config.AppConfig.SMTP_ENABLE == false {
 		return nil
 	}
@@ -99,10 +234,11 @@ func SendEmail(subject string, body []byte, to []string) error {
 		return fmt.Errorf("SMTP not initialized")
 	}
 
-	// Body is formatted according to RFC 822
-	date := time.Now().Format(time.RFC1123Z)
-	mailbody := fmt.Sprintf("Subject: %s\r\nFrom: %s\r\nTo: %s\r\nReply-To: %s\r\nDate: %s\r\n%s\r\n", subject, config.AppConfig.SMTP_SENDER, strings.Join(to, ","), config.AppConfig.SMTP_REPLYTO, date, body)
-	err = smtp.SendMail(config.AppConfig.SMTP_HOST+":"+config.AppConfig.SMTP_PORT, SMTP_CLIENT.smtp_auth, config.AppConfig.SMTP_SENDER, to, []byte(mailbody))
+	mailbody, err := e.Bytes()
+	if err != nil {
+		return fmt.Errorf("Failed to build email: %v", err)
+	}
+	err = smtp.SendMail(config.AppConfig.SMTP_HOST+":"+config.AppConfig.SMTP_PORT, SMTP_CLIENT.smtp_auth, config.AppConfig.SMTP_SENDER, e.To, mailbody)
 	if err != nil {
 		return fmt.Errorf("Failed to send email: %v", err)
 	}
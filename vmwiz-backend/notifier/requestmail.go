@@ -0,0 +1,107 @@
+package notifier
+
+// Emails to the requester of a VM. The verification email starts a thread (see RequestThreadID), all others reply to it.
+
+import (
+	"context"
+	"slices"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
+)
+
+// Addresses of the requester, honoring SMTP_RECEIVER_OVERRIDE
+func requesterEmails(req storage.Request) []string {
+	if config.AppConfig.SMTP_RECEIVER_OVERRIDE != "" {
+		return []string{config.AppConfig.SMTP_RECEIVER_OVERRIDE}
+	}
+	to := []string{req.Email}
+	if req.Personalemail != "" && !slices.Contains(to, req.Personalemail) {
+		to = append(to, req.Personalemail)
+	}
+	return to
+}
+
+func emailRequester(ctx context.Context, req storage.Request, subject string, tmpl string, data any) error {
+	_, err := EnqueueThreadedEmail(ctx, RequestThreadID(req.Requestid), subject, tmpl, data, requesterEmails(req))
+	return err
+}
+
+// EmailVMRequestVerification sends one address of the requester the link that verifies they own it.
+// It is the first email about the request, so it starts the thread the later ones reply to.
+func EmailVMRequestVerification(ctx context.Context, req storage.Request, address string, link string) error {
+	to := []string{address}
+	if config.AppConfig.SMTP_RECEIVER_OVERRIDE != "" {
+		to = []string{config.AppConfig.SMTP_RECEIVER_OVERRIDE}
+	}
+	_, err := StartEmailThread(ctx, RequestThreadID(req.Requestid), "VSOS VM Request: please verify your email address", "vmrequest_verify.tmpl", struct {
+		REQUESTID int64
+		HOSTNAME  string
+		EMAIL     string
+		LINK      string
+		EXPIRES   string
+		REPLYTO   string
+	}{req.Requestid, req.Hostname, address, link, req.Requestcreatedat.Add(config.AppConfig.VMREQUEST_UNVERIFIED_TTL).Format("2006-01-02 15:04"), config.AppConfig.SMTP_REPLYTO}, to)
+	return err
+}
+
+// EmailVMRequestReceived sends the requester a receipt with the request ID and the submitted details.
+func EmailVMRequestReceived(ctx context.Context, req storage.Request) error {
+	return emailRequester(ctx, req, "VSOS VM Request received", "vmrequest_received.tmpl", struct {
+		REQUESTID int64
+		SUMMARY   string
+		REPLYTO   string
+	}{req.Requestid, req.ToString(), config.AppConfig.SMTP_REPLYTO})
+}
+
+// EmailVMRequestHeld tells the requester that their request was put on hold, with an optional message from the admin.
+func EmailVMRequestHeld(ctx context.Context, req storage.Request, message string) error {
+	return emailRequester(ctx, req, "VSOS VM Request on hold", "vmrequest_held.tmpl", struct {
+		REQUESTID int64
+		HOSTNAME  string
+		MESSAGE   string
+		REPLYTO   string
+	}{req.Requestid, req.Hostname, message, config.AppConfig.SMTP_REPLYTO})
+}
+
+// EmailVMRequestEdited lists the changes an admin made to the request.
+func EmailVMRequestEdited(ctx context.Context, req storage.Request, changes []string) error {
+	return emailRequester(ctx, req, "VSOS VM Request changed", "vmrequest_edited.tmpl", struct {
+		REQUESTID int64
+		HOSTNAME  string
+		CHANGES   []string
+		REPLYTO   string
+	}{req.Requestid, req.Hostname, changes, config.AppConfig.SMTP_REPLYTO})
+}
+
+// EmailVMRequestRejected tells the requester that their request was rejected, with an optional reason.
+func EmailVMRequestRejected(ctx context.Context, req storage.Request, reason string) error {
+	return emailRequester(ctx, req, "VSOS VM Request rejected", "vmrequest_rejected.tmpl", struct {
+		REQUESTID int64
+		HOSTNAME  string
+		REASON    string
+		REPLYTO   string
+	}{req.Requestid, req.Hostname, reason, config.AppConfig.SMTP_REPLYTO})
+}
+
+// EmailVMCreationFailed tells the requester that their request was accepted but the VM could not be created.
+// The error itself is only reported to the admins.
+func EmailVMCreationFailed(ctx context.Context, req storage.Request) error {
+	return emailRequester(ctx, req, "VSOS VM Creation delayed", "vm_creation_failed.tmpl", struct {
+		REQUESTID int64
+		HOSTNAME  string
+		REPLYTO   string
+	}{req.Requestid, req.Hostname, config.AppConfig.SMTP_REPLYTO})
+}
+
+// EmailVMCreated sends the requester the summary of their new VM, with a copy to SMTP_REPLYTO.
+func EmailVMCreated(ctx context.Context, req storage.Request, summary string) error {
+	to := requesterEmails(req)
+	if config.AppConfig.SMTP_REPLYTO != "" {
+		to = append(to, config.AppConfig.SMTP_REPLYTO)
+	}
+	_, err := EnqueueThreadedEmail(ctx, RequestThreadID(req.Requestid), "VSOS VM Creation", "vm_created.tmpl", struct {
+		SUMMARY string
+	}{summary}, to)
+	return err
+}
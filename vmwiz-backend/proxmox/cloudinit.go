@@ -0,0 +1,110 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/logger"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Custom cloud-init user-data. Proxmox only uses one user-data file per VM, so when a request brings its own
+// #cloud-config, CreateVM renders it together with what Proxmox would generate (hostname, default user, SSH keys)
+// into a snippet and points the cicustom option of the VM to it.
+
+const USER_DATA_HEADER = "#cloud-config"
+
+// Keys VMWiz sets itself, provisioning relies on them
+var USER_DATA_RESERVED_KEYS = []string{"hostname", "fqdn", "prefer_fqdn_over_hostname", "manage_etc_hosts", "user", "ssh_authorized_keys"}
+
+func parseUserData(userData string) (map[string]any, error) {
+	if !strings.HasPrefix(strings.TrimSpace(userData), USER_DATA_HEADER) {
+		return nil, fmt.Errorf("must start with '%v'", USER_DATA_HEADER)
+	}
+	data := map[string]any{}
+	if err := yaml.Unmarshal([]byte(userData), &data); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %v", err)
+	}
+	return data, nil
+}
+
+// ValidateUserData checks that userData is a #cloud-config document that leaves the keys VMWiz needs alone
+func ValidateUserData(userData string) error {
+	data, err := parseUserData(userData)
+	if err != nil {
+		return err
+	}
+	for _, key := range USER_DATA_RESERVED_KEYS {
+		if _, ok := data[key]; ok {
+			return fmt.Errorf("'%v' is set by VMWiz and cannot be overridden", key)
+		}
+	}
+	if users, ok := data["users"]; ok {
+		if _, ok := users.([]any); !ok {
+			return fmt.Errorf("'users' must be a list")
+		}
+	}
+	return nil
+}
+
+// renderUserData merges userData with the settings Proxmox would generate for the VM
+func renderUserData(userData string, fqdn string, sshPubkeys []string) ([]byte, error) {
+	if err := ValidateUserData(userData); err != nil {
+		return nil, err
+	}
+	data, _ := parseUserData(userData)
+
+	data["hostname"] = strings.Split(fqdn, ".")[0]
+	data["fqdn"] = fqdn
+	data["manage_etc_hosts"] = true
+	data["ssh_authorized_keys"] = sshPubkeys
+	// The SSH keys are installed for the default user, which the post-install script logs in as
+	users, _ := data["users"].([]any)
+	if !slices.Contains(users, any("default")) {
+		data["users"] = append([]any{"default"}, users...)
+	}
+	if _, ok := data["package_upgrade"]; !ok {
+		data["package_upgrade"] = true
+	}
+
+	out, err := yaml.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(USER_DATA_HEADER+"\n"), out...), nil
+}
+
+// Name of the user-data snippet of a VM in the snippets directory of the template storage
+func userDataSnippet(vmID int) string {
+	return fmt.Sprintf("vmwiz-%v-user.yaml", vmID)
+}
+
+// deleteUserData removes the user-data snippet of a deleted VM, so that a VM reusing its ID does not get it.
+// The profile the VM was created with is not known anymore, the snippet is removed from the storages of all profiles.
+func deleteUserData(ctx context.Context, vmID int) error {
+	cm_sftp, err := createCMSFTPClient()
+	if err != nil {
+		return fmt.Errorf("CM SFTP: %v", err)
+	}
+	defer cm_sftp.Close()
+
+	seen := []string{}
+	for _, p := range Profiles() {
+		file := path.Join(p.TemplateStorage, "snippets", userDataSnippet(vmID))
+		if slices.Contains(seen, file) {
+			continue
+		}
+		if err := cm_sftp.Remove(file); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to remove '%v': %v", file, err)
+		} else if err == nil {
+			logger.From(ctx).Infof("[+] Removed the Cloudinit user-data %v\n", file)
+		}
+		seen = append(seen, file)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package proxmox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/netcenter"
+)
+
+// Where and how CreateVM creates a VM: storages, network and the template storage of the images.
+// Profiles are loaded from PROVISIONING_PROFILES, every request records the one its VM is (or will be) created with.
+type ProvisioningProfile struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+
+	// Storage of the boot, swap, EFI and cloud-init disks. An RBD storage, its Ceph pool has the same name
+	DiskStorage string `json:"diskStorage"`
+	// Storage of the secondary (HDD) disks
+	SecondaryDiskStorage string `json:"secondaryDiskStorage"`
+	// e.g. 512M
+	SwapSize string `json:"swapSize"`
+
+	// Where the images are stored on the CM node, and where the same storage is mounted on the compute nodes
+	TemplateStorage       string `json:"templateStorage"`
+	TemplateStorageOnComp string `json:"templateStorageOnComp"`
+	// Proxmox storage with the snippets content type, backed by TemplateStorage
+	SnippetsStorage string `json:"snippetsStorage"`
+
+	Bridge string `json:"bridge"`
+	// Bandwidth limit of the network interface in MB/s, 0 for none
+	RateMBps int `json:"rateMBps"`
+	// Netcenter net (group of subnets, see NETCENTER_SUBNETS) the IPs are allocated in. The gateways and netmasks
+	// are those of the subnet the IPs end up in
+	NetcenterNet string   `json:"netcenterNet"`
+	Nameservers  []string `json:"nameservers"`
+	SearchDomain string   `json:"searchDomain"`
+}
+
+// The values used before profiles existed, used if PROVISIONING_PROFILES is not set
+var DEFAULT_PROFILE = ProvisioningProfile{
+	Name:                  "default",
+	Description:           "SSD storage in the VM subnet",
+	DiskStorage:           "ssd",
+	SecondaryDiskStorage:  "vmnorm",
+	SwapSize:              "512M",
+	TemplateStorage:       "/srv/cnfs",
+	TemplateStorageOnComp: "/mnt/pve/cnfs",
+	SnippetsStorage:       "cnfs",
+	Bridge:                "vmbr1",
+	RateMBps:              125,
+	NetcenterNet:          "vm",
+	Nameservers:           []string{"129.132.98.12", "129.132.250.2"},
+	SearchDomain:          "ethz.ch",
+}
+
+// Format of the file pointed to by PROVISIONING_PROFILES
+type profilesConfig struct {
+	Profiles []ProvisioningProfile `json:"profiles"`
+	// Names of the profiles new personal and organization requests get
+	Personal     string `json:"personal"`
+	Organization string `json:"organization"`
+}
+
+var profiles = profilesConfig{
+	Profiles:     []ProvisioningProfile{DEFAULT_PROFILE},
+	Personal:     DEFAULT_PROFILE.Name,
+	Organization: DEFAULT_PROFILE.Name,
+}
+
+var profile_name_regexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+var swap_size_regexp = regexp.MustCompile(`^[1-9][0-9]*[MG]$`)
+var storage_regexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]*$`)
+
+// InitProfiles loads the provisioning profiles from PROVISIONING_PROFILES. They are validated by the startup checks.
+func InitProfiles() error {
+	if config.AppConfig.PROVISIONING_PROFILES == "" {
+		return nil
+	}
+
+	raw, err := os.ReadFile(config.AppConfig.PROVISIONING_PROFILES)
+	if err != nil {
+		return fmt.Errorf("Failed to read provisioning profiles: %v", err)
+	}
+	var c profilesConfig
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return fmt.Errorf("Failed to parse provisioning profiles: %v", err)
+	}
+	profiles = c
+	return nil
+}
+
+// Validate checks a single profile
+func (p ProvisioningProfile) Validate() error {
+	if !profile_name_regexp.MatchString(p.Name) {
+		return fmt.Errorf("invalid name '%v'", p.Name)
+	}
+	for what, storage := range map[string]string{"diskStorage": p.DiskStorage, "secondaryDiskStorage": p.SecondaryDiskStorage, "snippetsStorage": p.SnippetsStorage, "bridge": p.Bridge} {
+		if !storage_regexp.MatchString(storage) {
+			return fmt.Errorf("%v: invalid %v '%v'", p.Name, what, storage)
+		}
+	}
+	if !swap_size_regexp.MatchString(p.SwapSize) {
+		return fmt.Errorf("%v: invalid swapSize '%v', e.g. 512M", p.Name, p.SwapSize)
+	}
+	if !path.IsAbs(p.TemplateStorage) || !path.IsAbs(p.TemplateStorageOnComp) {
+		return fmt.Errorf("%v: templateStorage and templateStorageOnComp must be absolute paths", p.Name)
+	}
+	if p.RateMBps < 0 {
+		return fmt.Errorf("%v: rateMBps must not be negative", p.Name)
+	}
+	if len(netcenter.SubnetsOf(p.NetcenterNet)) == 0 {
+		return fmt.Errorf("%v: netcenterNet '%v' has no subnet", p.Name, p.NetcenterNet)
+	}
+	if len(p.Nameservers) == 0 {
+		return fmt.Errorf("%v: at least one nameserver is required", p.Name)
+	}
+	for _, ns := range p.Nameservers {
+		if net.ParseIP(ns) == nil {
+			return fmt.Errorf("%v: invalid nameserver '%v'", p.Name, ns)
+		}
+	}
+	return nil
+}
+
+// ValidateProfiles checks all profiles and that the defaults for new requests exist
+func ValidateProfiles() []error {
+	errs := []error{}
+	if len(profiles.Profiles) == 0 {
+		errs = append(errs, fmt.Errorf("no provisioning profile is defined"))
+	}
+	names := []string{}
+	for _, p := range profiles.Profiles {
+		if err := p.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+		if slices.Contains(names, p.Name) {
+			errs = append(errs, fmt.Errorf("profile '%v' is defined twice", p.Name))
+		}
+		names = append(names, p.Name)
+	}
+	for what, name := range map[string]string{"personal": profiles.Personal, "organization": profiles.Organization} {
+		if !slices.Contains(names, name) {
+			errs = append(errs, fmt.Errorf("the %v profile '%v' is not defined", what, name))
+		}
+	}
+	return errs
+}
+
+// Profiles returns all provisioning profiles
+func Profiles() []ProvisioningProfile {
+	return profiles.Profiles
+}
+
+// GetProfile returns the profile with the given name
+func GetProfile(name string) (ProvisioningProfile, error) {
+	for _, p := range profiles.Profiles {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return ProvisioningProfile{}, fmt.Errorf("Unknown provisioning profile '%v'", name)
+}
+
+// DefaultProfile returns the name of the profile new personal or organization requests get
+func DefaultProfile(organization bool) string {
+	if organization {
+		return profiles.Organization
+	}
+	return profiles.Personal
+}
@@ -18,6 +18,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"regexp"
 	"slices"
 	"strconv"
@@ -319,11 +320,17 @@ func DeleteNodeVM(ctx context.Context, node string, vm_id int, destroy_unreferen
 	}
 
 	logger.From(ctx).Infof("[+] Deleted VM %v on node %v [destroy-unreferenced-disks: %v, purge: %v, skiplock: %v]\n", vm_id, node, destroy_unreferenced_disks, purge_vm_from_configs, skip_lock)
+
+	// The VM is gone either way, a leftover snippet is only logged
+	if err := deleteUserData(ctx, vm_id); err != nil {
+		logger.From(ctx).Errorf("Failed to remove the Cloudinit user-data of VM %v: %v", vm_id, err)
+	}
 	return nil
 }
 
 type VMCreationOptions struct {
-	Template           string
+	Image              Image
+	Profile            ProvisioningProfile
 	FQDN               string
 	Reinstall          bool
 	Cores_CPU          int
@@ -336,13 +343,58 @@ type VMCreationOptions struct {
 	SSHPubkeys         []string
 	ResourcePool       string
 	DescriptionKVPairs map[string]string
+	// Optional #cloud-config provided by the requester, see ValidateUserData
+	UserData string
+	// Run at the end of the post-install script, in order
+	Hooks []Hook
+}
+
+// A post-install hook, a bash snippet provided by the admins
+type Hook struct {
+	Name   string
+	Script string
 }
 
+// Package managers the post-install script knows how to use
 const (
-	IMAGE_UBUNTU_24_04 = "Ubuntu 24.04 - Noble Numbat"
-	IMAGE_DEBIAN_13    = "Debian 13 - Trixie"
+	PACKAGE_MANAGER_APT = "apt"
+	PACKAGE_MANAGER_DNF = "dnf"
 )
 
+var PACKAGE_MANAGERS = []string{PACKAGE_MANAGER_APT, PACKAGE_MANAGER_DNF}
+
+// An image CreateVM can install, an entry of the image catalog (see the images package)
+type Image struct {
+	Name string
+	// qcow2 file, relative to the template storage of the profile
+	Path string
+	// User the SSH keys are installed for
+	DefaultUser string
+	// Regex matching the console line printed once the first boot finished
+	FirstBootMarker string
+	// Package sources written to SourcesPath by the post-install script, nothing is written if empty
+	SourcesPath string
+	Sources     string
+	// One of PACKAGE_MANAGERS
+	PackageManager string
+}
+
+// ImageExists checks that the image file is in the template storage of the profile on the CM node
+func ImageExists(image Image, profile ProvisioningProfile) error {
+	cm_sftp, err := createCMSFTPClient()
+	if err != nil {
+		return fmt.Errorf("CM SFTP: %v", err.Error())
+	}
+	defer cm_sftp.Close()
+
+	file := path.Join(profile.TemplateStorage, image.Path)
+	_, err = cm_sftp.Stat(file)
+	if err != nil {
+		return fmt.Errorf("Cannot ensure existence of '%v' on CM node: %v", file, err)
+	}
+	return nil
+}
+
 type VMCreationSummary struct {
 	vm_id             int
 	comp_node_name    string
@@ -358,6 +410,11 @@ type VMCreationSummary struct {
 	fingerprint       []string
 }
 
+func (s *VMCreationSummary) VMID() int    { return s.vm_id }
+func (s *VMCreationSummary) Node() string { return s.comp_node_name }
+func (s *VMCreationSummary) IPv4() string { return s.ipv4 }
+func (s *VMCreationSummary) IPv6() string { return s.ipv6 }
+
 func (s *VMCreationSummary) String() string {
 	return `[+] Created VM ` + strconv.Itoa(s.vm_id) + ` on node ` + s.comp_node_name + `
 Summary
@@ -399,42 +456,27 @@ func CreateVM(ctx context.Context, options VMCreationOptions) (_ *PVENodeVM, _ *
 		return nil, nil, fmt.Errorf("Failed to create VM: Configured CM SSH host is not a cluster management node")
 	}
 
-	//! Prepare default/hardcoded parameters
+	//! Prepare the parameters of the provisioning profile
+	if err := options.Profile.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("Failed to create VM: Invalid provisioning profile: %v", err)
+	}
+	lg.Infof("[-] Using provisioning profile '%v'", options.Profile.Name)
 
 	comp_node := config.AppConfig.SSH_COMP_HOST
 	comp_node_name := config.AppConfig.COMP_NAME
 	example_fqdn := "example.vsos.ethz.ch"
-	net := "vm"
-	CEPH_POOL := "ssd"
-	VM_NETMASK_4 := 24
-	VM_GATEWAY_4 := "192.33.91.1"
-	VM_NETMASK_6 := 118
-	VM_GATEWAY_6 := "2001:67c:10ec:49c3::1"
-	TEMPLATE_STORAGE := "/srv/cnfs"
-	TEMPLATE_STORAGE_ON_COMP := "/mnt/pve/cnfs"
-	VM_SWAP_SIZE := "512M"
+	net := options.Profile.NetcenterNet
+	CEPH_POOL := options.Profile.DiskStorage
+	VM_SWAP_SIZE := options.Profile.SwapSize
 	VM_DEFAULT_ROOT_SIZE := "15G"
 	VM_DEFAULT_RAM_SIZE := "2048"
 
-	// options.Template := options.Template
-	ssh_user := "root"
-	first_boot_line := "no"
-
 	VMPUBKEY_PATH := "/root/.ssh/vm_univ_pubkey.key"
 
-	//! Choosing appropriate user and first boot line
-	lg.Info("[-] Choosing appropriate user and first boot line based on template")
-	switch options.Template {
-	case IMAGE_DEBIAN_13:
-		options.Template = "trixie"
-		ssh_user = "debian"
-		first_boot_line = "Cloud-init .* finished"
-	case IMAGE_UBUNTU_24_04:
-		options.Template = "noble"
-		ssh_user = "ubuntu"
-		first_boot_line = "Cloud-init .* finished"
-	default:
-		return nil, nil, fmt.Errorf("\tFailed to create VM: Unknown template %v", options.Template)
+	ssh_user := options.Image.DefaultUser
+	first_boot_line := options.Image.FirstBootMarker
+	if options.Image.Path == "" || ssh_user == "" || first_boot_line == "" {
+		return nil, nil, fmt.Errorf("Failed to create VM: Incomplete image '%v'", options.Image.Name)
 	}
 
 	//! Checking existence of DNS entries for chosen FQDN
@@ -474,8 +516,8 @@ func CreateVM(ctx context.Context, options VMCreationOptions) (_ *PVENodeVM, _ *
 	}
 
 	//! Check if the image exists on the management node
-	IMAGE := fmt.Sprintf("%v/cloudinit/current-%v-amd64.qcow2", TEMPLATE_STORAGE, options.Template)
-	IMAGE_REMOTE := fmt.Sprintf("%v/cloudinit/current-%v-amd64.qcow2", TEMPLATE_STORAGE_ON_COMP, options.Template)
+	IMAGE := path.Join(options.Profile.TemplateStorage, options.Image.Path)
+	IMAGE_REMOTE := path.Join(options.Profile.TemplateStorageOnComp, options.Image.Path)
 
 	lg.Infof("[-] Checking if image '%v' exists on management node", IMAGE)
 
@@ -490,34 +532,6 @@ func CreateVM(ctx context.Context, options VMCreationOptions) (_ *PVENodeVM, _ *
 		return nil, nil, fmt.Errorf("Failed to create VM: Cannot ensure existence of '%v' on CM node: %v", IMAGE, err)
 	}
 
-	//! Preparing sources.list for VM
-	lg.Info("[-] Preparing apt sources for VM")
-	var SOURCES_LIST string
-	switch options.Template {
-	case "trixie", "bookworm":
-		SOURCES_LIST = fmt.Sprintf(`
-		deb http://ftp.ch.debian.org/debian %v main
-		#deb-src http://ftp.ch.debian.org/debian %v main
-
-		deb http://ftp.ch.debian.org/debian %v-updates main
-		#deb-src http://ftp.ch.debian.org/debian %v-updates main
-
-		deb http://security.debian.org/ %v-security main
-		#deb-src http://security.debian.org/ %v-security main`, options.Template, options.Template, options.Template, options.Template, options.Template, options.Template)
-	case "jammy", "noble":
-		SOURCES_LIST = fmt.Sprintf(`
-		deb http://ch.archive.ubuntu.com/ubuntu %v main universe multiverse
-		#deb-src http://ch.archive.ubuntu.com/ubuntu %v main universe multiverse
-
-		deb http://ch.archive.ubuntu.com/ubuntu %v-updates main universe multiverse
-		#deb-src http://ch.archive.ubuntu.com/ubuntu %v-updates main universe multiverse
-
-		deb http://security.ubuntu.com/ubuntu %v-security main universe multiverse
-		#deb-src http://security.ubuntu.com/ubuntu %v-security main universe multiverse`, options.Template, options.Template, options.Template, options.Template, options.Template, options.Template)
-	default:
-		return nil, nil, fmt.Errorf("Failed to create VM: Unknown template %v", options.Template)
-	}
-
 	//! Generate random VM ID
 	// TODO: Do not generate randomly, rather take the smallest available one
 	lg.Info("[-] Generating random VM ID")
@@ -538,23 +552,37 @@ Disk size: %v
 Secondary Disk: %v
 Swap size: %v
 Ceph pool: %v
+Profile: %v
 
 QEMU agent: %v
 
 Reinstall: %v
 -------
-`, VM_ID, options.FQDN, options.Notes, options.Template, options.Cores_CPU, options.RAM_MB, options.Disk_GB, options.SecondaryDisk_GB, VM_SWAP_SIZE, CEPH_POOL, options.UseQemuAgent, options.Reinstall)
+`, VM_ID, options.FQDN, options.Notes, options.Image.Name, options.Cores_CPU, options.RAM_MB, options.Disk_GB, options.SecondaryDisk_GB, VM_SWAP_SIZE, CEPH_POOL, options.Profile.Name, options.UseQemuAgent, options.Reinstall)
 
 	//! Register DNS entries for FQDN and an available IPv4 and IPv6 address.
+	var subnet *netcenter.NetcenterSubnet
 	if !options.Reinstall {
 		lg.Infof("[-] Registering FQDN \"%v\" in net \"%v\"\n", options.FQDN, net)
-		ipv4, ipv6, err := netcenter.Registerhost(ctx, net, options.FQDN)
+		ipv4, ipv6, registered, err := netcenter.Registerhost(ctx, net, options.FQDN)
 		if err != nil {
 			return nil, nil, fmt.Errorf("Failed to create VM: %v", err)
 		}
 		ipv4s_str = append(ipv4s_str, (*ipv4).String())
 		ipv6s_str = append(ipv6s_str, (*ipv6).String())
+		subnet = registered
+	} else {
+		// A reinstalled VM keeps its IPs, the network settings are those of the subnet they are in
+		subnet = netcenter.SubnetOf(ipv4s[0].IP.ToIP())
+		if subnet == nil || !subnet.V6net.ToPrefixBlock().Contains(ipv6s[0].IP) {
+			return nil, nil, fmt.Errorf("Failed to create VM: The IPs of %v (%v, %v) are not in a configured subnet", options.FQDN, ipv4s_str[0], ipv6s_str[0])
+		}
 	}
+	lg.Infof("[-] Using subnet '%v' (%v, %v)", subnet.Name, subnet.V4net, subnet.V6net)
+	VM_NETMASK_4 := subnet.V4netmask()
+	VM_GATEWAY_4 := subnet.V4gateway.String()
+	VM_NETMASK_6 := subnet.V6netmask()
+	VM_GATEWAY_6 := subnet.V6gateway.String()
 
 	//! Read universal VM public key
 	// TODO: Startup check
@@ -568,7 +596,8 @@ Reinstall: %v
 	//! Prepare authorized_keys file
 	lg.Info("[-] Preparing authorized_keys file")
 	lg.Info("\tConcatenating VM universal public key with provided pubkeys")
-	authorized_keys_content := strings.Join(slices.Concat(options.SSHPubkeys, strings.Split(string(vmpubkey_content), "\n")), "\n\n")
+	authorized_keys := slices.Concat(options.SSHPubkeys, strings.Split(string(vmpubkey_content), "\n"))
+	authorized_keys_content := strings.Join(authorized_keys, "\n\n")
 
 	//! Upload authorized_keys file to comp node
 	VM_AUTHORIZED_KEYS_PATH := fmt.Sprintf("/tmp/vmwiz-%v.ssh.pub", VM_ID)
@@ -590,6 +619,35 @@ Reinstall: %v
 		return nil, nil, fmt.Errorf("Failed to create VM: Comp node SFTP: Failed to write to file '%v': %v", VM_AUTHORIZED_KEYS_PATH, err)
 	}
 
+	//! Upload custom Cloudinit user-data to the snippets storage
+	USER_DATA_SNIPPET := userDataSnippet(VM_ID)
+	USER_DATA_PATH_CM := path.Join(options.Profile.TemplateStorage, "snippets", USER_DATA_SNIPPET)
+	if options.UserData != "" {
+		lg.Infof("[-] Uploading custom Cloudinit user-data to CM at %v\n", USER_DATA_PATH_CM)
+		user_data, err := renderUserData(options.UserData, options.FQDN, slices.DeleteFunc(slices.Clone(authorized_keys), func(key string) bool { return strings.TrimSpace(key) == "" }))
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to create VM: Invalid Cloudinit user-data: %v", err)
+		}
+		lg.Infof("\tUser-data:\n%s", user_data)
+
+		cm_sftp_user_data, err := cm_sftp.Create(USER_DATA_PATH_CM)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to create VM: CM SFTP: Failed to create file '%v': %v", USER_DATA_PATH_CM, err)
+		}
+		// Proxmox reads it whenever the VM starts, it is removed if the creation fails or when the VM is deleted (see DeleteNodeVM)
+		defer func() {
+			if retErr != nil {
+				cm_sftp.Remove(USER_DATA_PATH_CM)
+			}
+		}()
+		defer cm_sftp_user_data.Close()
+
+		_, err = cm_sftp_user_data.Write(user_data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to create VM: CM SFTP: Failed to write to file '%v': %v", USER_DATA_PATH_CM, err)
+		}
+	}
+
 	//! Prepare Cloudinit configuration
 	lg.Info("[-] Preparing Cloudinit configuration")
 	cloudinit_fragments := fmt.Sprintf("ipconfig0: gw=%s,ip=%s/%d,ip6=%s/%d", VM_GATEWAY_4, ipv4s_str[0], VM_NETMASK_4, ipv6s_str[0], VM_NETMASK_6)
@@ -718,6 +776,8 @@ Reinstall: %v
 		VM_NETMASK_4 int
 		IPV6S_STR0   string
 		VM_NETMASK_6 int
+		NAMESERVERS  string
+		SEARCHDOMAIN string
 	}{
 		AGENT:        AGENT,
 		VM_DESC:      VM_DESC,
@@ -735,6 +795,8 @@ Reinstall: %v
 		VM_NETMASK_4: VM_NETMASK_4,
 		IPV6S_STR0:   ipv6s_str[0],
 		VM_NETMASK_6: VM_NETMASK_6,
+		NAMESERVERS:  strings.Join(options.Profile.Nameservers, " "),
+		SEARCHDOMAIN: options.Profile.SearchDomain,
 	})
 	if err != nil {
 		return nil, nil, fmt.Errorf("Failed to create VM: Failed to execute template: %v", err)
@@ -790,14 +852,14 @@ Reinstall: %v
 		lg.Infof("\t[-] Creating secondary disk\n")
 		diskName := fmt.Sprintf("vm-%v-disk-3", VM_ID)
 
-		allocCmd := fmt.Sprintf("pvesm alloc vmnorm %v %s %vG", VM_ID, diskName, options.SecondaryDisk_GB)
+		allocCmd := fmt.Sprintf("pvesm alloc %v %v %s %vG", options.Profile.SecondaryDiskStorage, VM_ID, diskName, options.SecondaryDisk_GB)
 		lg.Infof("\t\t> %v \n", allocCmd)
 		stdout, err = comp_ssh.Run(allocCmd)
 		if err != nil {
 			return nil, nil, fmt.Errorf("Failed to create VM: Comp node SSH: Cannot create secondary disk: %v\nOutput:\n%s", err, stdout)
 		}
 
-		attachCmd := fmt.Sprintf("qm set %v --scsi3 vmnorm:%s", VM_ID, diskName)
+		attachCmd := fmt.Sprintf("qm set %v --scsi3 %v:%s", VM_ID, options.Profile.SecondaryDiskStorage, diskName)
 		lg.Infof("\t\t> %v \n", attachCmd)
 		stdout, err = comp_ssh.Run(attachCmd)
 		if err != nil {
@@ -835,10 +897,25 @@ Reinstall: %v
 		return nil, nil, fmt.Errorf("Failed to create VM: Comp node SSH: Cannot add SSH keys to machine: %v\nOutput:\n%s", err, stdout)
 	}
 
+	//! Using the custom Cloudinit user-data
+	if options.UserData != "" {
+		lg.Infof("\t[-] Using custom Cloudinit user-data\n")
+		command = fmt.Sprintf("qm set \"%v\" --cicustom \"user=%v:snippets/%v\"", VM_ID, options.Profile.SnippetsStorage, USER_DATA_SNIPPET)
+		lg.Infof("\t\t> %v \n", command)
+
+		stdout, err = comp_ssh.Run(command)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to create VM: Comp node SSH: Cannot set custom Cloudinit user-data: %v\nOutput:\n%s", err, stdout)
+		}
+	}
+
 	//! Append network configuration to VM configuration
 	// ? For some reason, running the previous commands erases the network config entry, so we append it here, after running the aforementioned commands
 	lg.Infof("\t[-] Appending network configuration to VM configuration\n")
-	net0config := fmt.Sprintf("net0: %v=%v,bridge=vmbr1,rate=125", VM_NETMODEL, VM_MACADDR)
+	net0config := fmt.Sprintf("net0: %v=%v,bridge=%v", VM_NETMODEL, VM_MACADDR, options.Profile.Bridge)
+	if options.Profile.RateMBps > 0 {
+		net0config += fmt.Sprintf(",rate=%v", options.Profile.RateMBps)
+	}
 	command = fmt.Sprintf("echo \"%v\" >> \"%v\"", net0config, VM_CONFIG_PATH)
 	stdout, err = comp_ssh.Run(command)
 	if err != nil {
@@ -1019,17 +1096,25 @@ Reinstall: %v
 		return nil, nil, fmt.Errorf("Failed to create VM: Failed to parse template: %v", err)
 	}
 	err = post_install_template.Execute(vm_finish_script_content, struct {
+		SOURCES_PATH     string
 		SOURCES_LIST     string
+		PACKAGE_MANAGER  string
 		VM_GATEWAY_6     string
 		UseQemuAgent     bool
 		HasSecondaryDisk bool
 		SSH_USER         string
+		HasUserData      bool
+		HOOKS            []Hook
 	}{
-		SOURCES_LIST:     SOURCES_LIST,
+		SOURCES_PATH:     options.Image.SourcesPath,
+		SOURCES_LIST:     options.Image.Sources,
+		PACKAGE_MANAGER:  options.Image.PackageManager,
 		VM_GATEWAY_6:     VM_GATEWAY_6,
 		UseQemuAgent:     options.UseQemuAgent,
 		HasSecondaryDisk: options.SecondaryDisk_GB > 0,
 		SSH_USER:         ssh_user,
+		HasUserData:      options.UserData != "",
+		HOOKS:            options.Hooks,
 	})
 	if err != nil {
 		return nil, nil, fmt.Errorf("Failed to create VM: Failed to execute template: %v", err)
@@ -1072,6 +1157,7 @@ Reinstall: %v
 	if err != nil {
 		return nil, nil, fmt.Errorf("Failed to create VM: Failed to run post-install script on VM: %v\nStdout: %v", err, string(stdout))
 	}
+	lg.Infof("\t\t[-] Post-install script output:\n%s", stdout)
 
 	_ = comp_node
 	_ = example_fqdn
@@ -1081,8 +1167,6 @@ Reinstall: %v
 	_ = VM_GATEWAY_4
 	_ = VM_NETMASK_6
 	_ = VM_GATEWAY_6
-	_ = TEMPLATE_STORAGE
-	_ = TEMPLATE_STORAGE_ON_COMP
 	_ = VM_SWAP_SIZE
 	_ = VM_DEFAULT_ROOT_SIZE
 	_ = VM_DEFAULT_RAM_SIZE
@@ -1090,7 +1174,6 @@ Reinstall: %v
 	_ = first_boot_line
 	_ = IMAGE
 	_ = IMAGE_REMOTE
-	_ = SOURCES_LIST
 	_ = VM_ID
 
 	_ = VM_NETMODEL
@@ -1130,7 +1213,7 @@ Reinstall: %v
 		ipv6:              ipv6s_str[0],
 		comp_node_name:    comp_node_name,
 		ssh_user:          ssh_user,
-		image:             options.Template,
+		image:             options.Image.Name,
 		cpu:               vm.Cpus,
 		ram_mb:            int(options.RAM_MB),
 		disk_gb:           options.Disk_GB,
@@ -1278,6 +1361,31 @@ func AddVMToResourcePool(vm_id int, pool string) error {
 type PVENodeVMConfig struct {
 	Description string `json:"description"`
 	Net0        string `json:"net0"`
+	Ipconfig0   string `json:"ipconfig0"`
+}
+
+// IPConfig returns the IPv4 and IPv6 address of the cloud-init network configuration (ipconfig0) without
+// prefix length, "" if not set
+func (cfg *PVENodeVMConfig) IPConfig() (string, string) {
+	ipv4, ipv6 := "", ""
+	for _, pair := range strings.Split(cfg.Ipconfig0, ",") {
+		key, val, _ := strings.Cut(pair, "=")
+		val, _, _ = strings.Cut(val, "/")
+		switch key {
+		case "ip":
+			ipv4 = val
+		case "ip6":
+			ipv6 = val
+		}
+	}
+	// dhcp, auto and manual are no addresses
+	if !strings.Contains(ipv4, ".") {
+		ipv4 = ""
+	}
+	if !strings.Contains(ipv6, ":") {
+		ipv6 = ""
+	}
+	return ipv4, ipv6
 }
 
 func (cfg *PVENodeVMConfig) NetworkDeviceConfig() map[string]string {
@@ -0,0 +1,275 @@
+package quota
+
+// Quotas on the total resources of the VMs of one university email address and of one organization.
+// The usage counts the verified requests that are not answered yet, accepted or provisioned. Provisioned VMs are
+// counted with their live size in Proxmox, as admins may have resized them since.
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/proxmox"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
+)
+
+const (
+	KIND_USER = "user"
+	KIND_ORG  = "org"
+)
+
+const gib = 1 << 30
+
+// Resources of one or more VMs. As a limit, 0 means unlimited.
+type Resources struct {
+	VMs    int `json:"vms"`
+	Cores  int `json:"cores"`
+	RamGB  int `json:"ramGB"`
+	DiskGB int `json:"diskGB"`
+}
+
+func (r Resources) Add(o Resources) Resources {
+	return Resources{
+		VMs:    r.VMs + o.VMs,
+		Cores:  r.Cores + o.Cores,
+		RamGB:  r.RamGB + o.RamGB,
+		DiskGB: r.DiskGB + o.DiskGB,
+	}
+}
+
+// Exceeded describes what in r is over limit, "" if nothing is
+func (r Resources) Exceeded(limit Resources) string {
+	over := []string{}
+	check := func(used int, max int, what string) {
+		if max > 0 && used > max {
+			over = append(over, fmt.Sprintf("%d %s (quota %d)", used, what, max))
+		}
+	}
+	check(r.VMs, limit.VMs, "VMs")
+	check(r.Cores, limit.Cores, "cores")
+	check(r.RamGB, limit.RamGB, "GB of RAM")
+	check(r.DiskGB, limit.DiskGB, "GB of disk space")
+	return strings.Join(over, ", ")
+}
+
+// Usage and quota of a user or organization
+type Status struct {
+	Used  Resources `json:"used"`
+	Limit Resources `json:"limit"`
+	// Whether the limit was changed by an admin
+	Overridden bool `json:"overridden"`
+}
+
+func (s Status) String() string {
+	format := func(used int, limit int, what string) string {
+		if limit == 0 {
+			return fmt.Sprintf("%d %s", used, what)
+		}
+		return fmt.Sprintf("%d/%d %s", used, limit, what)
+	}
+	str := strings.Join([]string{
+		format(s.Used.VMs, s.Limit.VMs, "VMs"),
+		format(s.Used.Cores, s.Limit.Cores, "cores"),
+		format(s.Used.RamGB, s.Limit.RamGB, "GB RAM"),
+		format(s.Used.DiskGB, s.Limit.DiskGB, "GB disk"),
+	}, ", ")
+	if s.Overridden {
+		str += " (overridden)"
+	}
+	return str
+}
+
+type key struct {
+	kind    string
+	subject string
+}
+
+// Report holds the usage of all users and organizations, see Load
+type Report struct {
+	used      map[key]Resources
+	overrides map[key]storage.QuotaOverride
+}
+
+// Emails and organization names are compared case insensitively
+func Normalize(subject string) string {
+	return strings.ToLower(strings.TrimSpace(subject))
+}
+
+func ValidKind(kind string) bool {
+	return kind == KIND_USER || kind == KIND_ORG
+}
+
+// Default quota of kind, from the QUOTA_* settings
+func Defaults(kind string) Resources {
+	if kind == KIND_ORG {
+		return Resources{
+			VMs:    config.AppConfig.QUOTA_ORG_VMS,
+			Cores:  config.AppConfig.QUOTA_ORG_CORES,
+			RamGB:  config.AppConfig.QUOTA_ORG_RAM_GB,
+			DiskGB: config.AppConfig.QUOTA_ORG_DISK_GB,
+		}
+	}
+	return Resources{
+		VMs:    config.AppConfig.QUOTA_USER_VMS,
+		Cores:  config.AppConfig.QUOTA_USER_CORES,
+		RamGB:  config.AppConfig.QUOTA_USER_RAM_GB,
+		DiskGB: config.AppConfig.QUOTA_USER_DISK_GB,
+	}
+}
+
+// Load computes the usage of every user and organization
+func Load(ctx context.Context) (Report, error) {
+	return load(ctx, 0)
+}
+
+// load computes the usage without the request exclude (0 for none)
+func load(ctx context.Context, exclude int64) (Report, error) {
+	report := Report{used: map[key]Resources{}, overrides: map[key]storage.QuotaOverride{}}
+
+	overrides, err := storage.DB.ListQuotaOverrides(ctx)
+	if err != nil {
+		return Report{}, fmt.Errorf("Failed to list quota overrides: %v", err)
+	}
+	for _, o := range overrides {
+		report.overrides[key{o.Kind, o.Subject}] = o
+	}
+
+	requests, err := storage.DB.ListQuotaVMRequests(ctx)
+	if err != nil {
+		return Report{}, fmt.Errorf("Failed to list VM requests: %v", err)
+	}
+
+	var live map[int]proxmox.PVEClusterVM
+	for _, req := range requests {
+		if req.Requestid == exclude {
+			continue
+		}
+		if req.Vmid.Valid && live == nil {
+			live = liveVMs()
+		}
+		res := usageOf(req, live)
+
+		k := key{KIND_USER, Normalize(req.Email)}
+		report.used[k] = report.used[k].Add(res)
+		if req.Isorganization && Normalize(req.Orgname.String) != "" {
+			k := key{KIND_ORG, Normalize(req.Orgname.String)}
+			report.used[k] = report.used[k].Add(res)
+		}
+	}
+	return report, nil
+}
+
+// Status returns the usage and quota of a user (email) or organization (name)
+func (r Report) Status(kind string, subject string) Status {
+	k := key{kind, Normalize(subject)}
+	status := Status{Used: r.used[k], Limit: Defaults(kind)}
+
+	if o, ok := r.overrides[k]; ok {
+		status.Overridden = true
+		if o.MaxVms.Valid {
+			status.Limit.VMs = int(o.MaxVms.Int32)
+		}
+		if o.MaxCores.Valid {
+			status.Limit.Cores = int(o.MaxCores.Int32)
+		}
+		if o.MaxRamgb.Valid {
+			status.Limit.RamGB = int(o.MaxRamgb.Int32)
+		}
+		if o.MaxDiskgb.Valid {
+			status.Limit.DiskGB = int(o.MaxDiskgb.Int32)
+		}
+	}
+	return status
+}
+
+// Subjects returns the users or organizations that use resources or have an override, sorted
+func (r Report) Subjects(kind string) []string {
+	subjects := []string{}
+	for k := range r.used {
+		if k.kind == kind {
+			subjects = append(subjects, k.subject)
+		}
+	}
+	for k := range r.overrides {
+		if k.kind == kind && !slices.Contains(subjects, k.subject) {
+			subjects = append(subjects, k.subject)
+		}
+	}
+	slices.Sort(subjects)
+	return subjects
+}
+
+// Check describes what a new request for want would exceed of the quotas of its requester and organization (orgName
+// empty if it is a personal request), "" if it fits.
+func Check(ctx context.Context, email string, orgName string, want Resources) (string, error) {
+	report, err := Load(ctx)
+	if err != nil {
+		return "", err
+	}
+	return report.check(email, orgName, want), nil
+}
+
+// CheckRequest is Check for a request that was already submitted, with its current sizes. The request itself
+// is not counted in the usage, so accepting or editing it is checked against the quotas as they are now.
+func CheckRequest(ctx context.Context, req storage.Request) (string, error) {
+	report, err := load(ctx, req.Requestid)
+	if err != nil {
+		return "", err
+	}
+	orgName := ""
+	if req.Isorganization {
+		orgName = req.Orgname.String
+	}
+	return report.check(req.Email, orgName, usageOf(req, nil)), nil
+}
+
+func (r Report) check(email string, orgName string, want Resources) string {
+	status := r.Status(KIND_USER, email)
+	if over := status.Used.Add(want).Exceeded(status.Limit); over != "" {
+		return fmt.Sprintf("This request exceeds your quota: you would have %s", over)
+	}
+	if Normalize(orgName) != "" {
+		status := r.Status(KIND_ORG, orgName)
+		if over := status.Used.Add(want).Exceeded(status.Limit); over != "" {
+			return fmt.Sprintf("This request exceeds the quota of %s: it would have %s", orgName, over)
+		}
+	}
+	return ""
+}
+
+// The VMs in the VMWiz resource pools by VMID. If Proxmox cannot be reached it returns an empty map,
+// the requested sizes are used instead.
+func liveVMs() map[int]proxmox.PVEClusterVM {
+	live := map[int]proxmox.PVEClusterVM{}
+	vms, err := proxmox.GetAllClusterVMs()
+	if err != nil {
+		log.Printf("Failed to get VMs for quota usage, using the requested sizes: %v", err)
+		return live
+	}
+	for _, vm := range *vms {
+		if vm.Pool == config.AppConfig.VM_PERSONAL_POOL || vm.Pool == config.AppConfig.VM_ORGANIZATION_POOL {
+			live[vm.Vmid] = vm
+		}
+	}
+	return live
+}
+
+// Resources used by one request
+func usageOf(req storage.Request, live map[int]proxmox.PVEClusterVM) Resources {
+	res := Resources{
+		VMs:    1,
+		Cores:  int(req.Cores),
+		RamGB:  int(req.Ramgb),
+		DiskGB: int(req.Diskgb + req.Secondarydiskgb),
+	}
+	if vm, ok := live[int(req.Vmid.Int32)]; ok && req.Vmid.Valid {
+		res.Cores = int(vm.Maxcpu)
+		res.RamGB = (vm.Maxmem + gib - 1) / gib
+		// maxdisk is the size of the boot disk only
+		res.DiskGB = (vm.Maxdisk+gib-1)/gib + int(req.Secondarydiskgb)
+	}
+	return res
+}
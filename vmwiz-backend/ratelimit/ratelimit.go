@@ -0,0 +1,132 @@
+package ratelimit
+
+// Token buckets per key (e.g. client IP or email address), for the routes anyone can call.
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Buckets that have been full for this long are dropped
+const sweepInterval = 10 * time.Minute
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter allows burst requests per key, then one every interval.
+// A zero interval disables it.
+type Limiter struct {
+	interval  time.Duration
+	burst     int
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func New(interval time.Duration, burst int) *Limiter {
+	return &Limiter{
+		interval:  interval,
+		burst:     max(burst, 1),
+		buckets:   map[string]*bucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *Limiter) Enabled() bool {
+	return l.interval > 0
+}
+
+func (l *Limiter) String() string {
+	if !l.Enabled() {
+		return "disabled"
+	}
+	return fmt.Sprintf("%d at once, then 1 every %v", l.burst, l.interval)
+}
+
+// Allow takes a token from the bucket of key. If there is none, it returns false
+// and how long to wait until the next one.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if !l.Enabled() {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Every(l.interval), l.burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	res := b.limiter.ReserveN(now, 1)
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// Drops the buckets that refilled completely, they behave like new ones. Called with l.mu held.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	full := l.interval * time.Duration(l.burst)
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// ClientIP returns the IP the request comes from. If trustProxy is set, the last address
+// in X-Forwarded-For is used, i.e. the one added by our reverse proxy.
+func ClientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			parts := strings.Split(fwd, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Replies 429 with a Retry-After header of wait
+func ReplyTooManyRequests(w http.ResponseWriter, wait time.Duration, msg string) {
+	secs := int(wait.Round(time.Second).Seconds())
+	w.Header().Set("Retry-After", strconv.Itoa(max(secs, 1)))
+	http.Error(w, fmt.Sprintf("%v, please try again in %v", msg, time.Duration(max(secs, 1))*time.Second), http.StatusTooManyRequests)
+}
+
+// Middleware limits the requests to next per client IP (see ClientIP)
+func Middleware(l *Limiter, trustProxy bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := ClientIP(r, trustProxy)
+		if ok, wait := l.Allow(ip); !ok {
+			log.Printf("Rate limited %s %s from %s", r.Method, r.URL.Path, ip)
+			ReplyTooManyRequests(w, wait, "Too many requests from your address")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
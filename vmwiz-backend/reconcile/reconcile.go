@@ -0,0 +1,265 @@
+package reconcile
+
+// Reconciliation of the three places a VM is recorded in: its nameToIP entries in Netcenter, the VM in Proxmox
+// (name, cloud-init ipconfig0 and IP filter) and the VM request in VMWiz. Build cross-references them and reports
+// every discrepancy, with a fix where one can be applied automatically. Fixes are applied one at a time by ID.
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/netcenter"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/proxmox"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+)
+
+const (
+	// A VMWiz DNS entry in a VM subnet whose name is not a VM
+	CATEGORY_DNS_WITHOUT_VM = "DNS_WITHOUT_VM"
+	// An IP of a VM without DNS entry
+	CATEGORY_VM_WITHOUT_DNS = "VM_WITHOUT_DNS"
+	// The IPs of a VM differ between its ipconfig0, IP filter, DNS entries or request
+	CATEGORY_IP_MISMATCH = "IP_MISMATCH"
+	// A provisioned request whose VM does not exist anymore
+	CATEGORY_REQUEST_WITHOUT_VM = "REQUEST_WITHOUT_VM"
+	// A provisioned request that does not record its VM, provisioned before VMs were recorded on requests
+	CATEGORY_REQUEST_UNLINKED = "REQUEST_UNLINKED"
+)
+
+const (
+	FIX_DELETE_DNS           = "delete_dns"
+	FIX_CREATE_DNS           = "create_dns"
+	FIX_DECOMMISSION_REQUEST = "decommission_request"
+	FIX_LINK_VM              = "link_vm"
+)
+
+// What applying a fix does, the fields used depend on the action
+type Fix struct {
+	Action      string `json:"action"`
+	Description string `json:"description"`
+	IP          string `json:"ip,omitempty"`
+	FQDN        string `json:"fqdn,omitempty"`
+	RequestID   int64  `json:"requestId,omitempty"`
+}
+
+type Discrepancy struct {
+	// Stable across reports as long as the discrepancy exists, used to apply its fix
+	ID       string `json:"id"`
+	Category string `json:"category"`
+	// The VM, DNS entry or request concerned
+	Subject string `json:"subject"`
+	Detail  string `json:"detail"`
+	// nil if it has to be fixed by hand
+	Fix *Fix `json:"fix"`
+}
+
+type Report struct {
+	GeneratedAt   time.Time     `json:"generatedAt"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+	// VMs that could not be checked, the report is incomplete for them
+	Errors []string `json:"errors"`
+}
+
+// Find returns the discrepancy with the given ID, nil if there is none
+func (r *Report) Find(id string) *Discrepancy {
+	for i := range r.Discrepancies {
+		if r.Discrepancies[i].ID == id {
+			return &r.Discrepancies[i]
+		}
+	}
+	return nil
+}
+
+func (r *Report) add(category string, idSuffix string, subject string, detail string, fix *Fix) {
+	r.Discrepancies = append(r.Discrepancies, Discrepancy{
+		ID:       strings.ToLower(category) + ":" + idSuffix,
+		Category: category,
+		Subject:  subject,
+		Detail:   detail,
+		Fix:      fix,
+	})
+}
+
+// Normalizes an IP for comparison, "" if it is none
+func normalizeIP(ip string) string {
+	addr, err := ipaddr.NewIPAddressString(strings.TrimSpace(ip)).ToAddress()
+	if err != nil || addr == nil {
+		return ""
+	}
+	return addr.WithoutPrefixLen().String()
+}
+
+// A VM and the IPs of its ipconfig0 that are in the VM subnets
+type vmIPs struct {
+	vm       proxmox.PVEClusterVM
+	ipconfig []string
+}
+
+var (
+	mu     sync.Mutex
+	latest *Report
+)
+
+// Latest returns the last report built if it is at most maxAge old, otherwise it builds a new one.
+// Building a report queries every VM of the cluster, this is for looking up a discrepancy shown to an admin.
+func Latest(ctx context.Context, maxAge time.Duration) (*Report, error) {
+	mu.Lock()
+	report := latest
+	mu.Unlock()
+	if report != nil && time.Since(report.GeneratedAt) <= maxAge {
+		return report, nil
+	}
+	return Build(ctx)
+}
+
+// Build collects the current state of Netcenter, Proxmox and the requests and reports where they disagree
+func Build(ctx context.Context) (*Report, error) {
+	report := Report{GeneratedAt: time.Now(), Discrepancies: []Discrepancy{}, Errors: []string{}}
+
+	records, err := netcenter.ListRecords()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list DNS entries: %v", err)
+	}
+	vms, err := proxmox.GetAllClusterVMs()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list Proxmox VMs: %v", err)
+	}
+	requests, err := storage.DB.ListVMRequests(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list VM requests: %v", err)
+	}
+
+	recordsByIP := map[string]netcenter.DNSRecord{}
+	recordsByFQDN := map[string][]netcenter.DNSRecord{}
+	for _, rec := range records {
+		recordsByIP[normalizeIP(rec.IP)] = rec
+		recordsByFQDN[strings.ToLower(rec.FQDN)] = append(recordsByFQDN[strings.ToLower(rec.FQDN)], rec)
+	}
+
+	//! VMs: ipconfig0 against DNS and the IP filter
+	vmsByName := map[string]bool{}
+	vmsByID := map[int]vmIPs{}
+	// Every VM of the cluster, and those that could not be checked (listed in report.Errors)
+	exists := map[int]bool{}
+	unchecked := map[int]bool{}
+	for _, vm := range *vms {
+		if vm.Template != 0 {
+			continue
+		}
+		name := strings.ToLower(vm.Name)
+		vmsByName[name] = true
+		exists[vm.Vmid] = true
+		subject := fmt.Sprintf("VM %d (%v)", vm.Vmid, vm.Name)
+
+		cfg, err := proxmox.GetNodeVMConfig(vm.Node, vm.Vmid)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%v: %v", subject, err))
+			unchecked[vm.Vmid] = true
+			continue
+		}
+		ipv4, ipv6 := cfg.IPConfig()
+		ips := []string{}
+		for _, ip := range []string{normalizeIP(ipv4), normalizeIP(ipv6)} {
+			if addr, _ := ipaddr.NewIPAddressString(ip).ToAddress(); ip != "" && netcenter.SubnetOf(addr) != nil {
+				ips = append(ips, ip)
+			}
+		}
+		vmsByID[vm.Vmid] = vmIPs{vm: vm, ipconfig: ips}
+		// Not a VM of the VM subnets
+		if len(ips) == 0 {
+			continue
+		}
+
+		for _, ip := range ips {
+			rec, ok := recordsByIP[ip]
+			if !ok {
+				report.add(CATEGORY_VM_WITHOUT_DNS, fmt.Sprintf("%d:%v:%v", vm.Vmid, ip, name), subject,
+					fmt.Sprintf("%v is configured in ipconfig0 but has no DNS entry", ip),
+					&Fix{Action: FIX_CREATE_DNS, Description: fmt.Sprintf("Register %v for %v", vm.Name, ip), IP: ip, FQDN: name})
+			} else if !strings.EqualFold(rec.FQDN, vm.Name) {
+				report.add(CATEGORY_IP_MISMATCH, fmt.Sprintf("%d:dns:%v", vm.Vmid, ip), subject,
+					fmt.Sprintf("%v is configured in ipconfig0 but registered for %v", ip, rec.FQDN), nil)
+			}
+		}
+		for _, rec := range recordsByFQDN[name] {
+			if !slices.Contains(ips, normalizeIP(rec.IP)) {
+				report.add(CATEGORY_IP_MISMATCH, fmt.Sprintf("%d:dns:%v", vm.Vmid, normalizeIP(rec.IP)), subject,
+					fmt.Sprintf("%v is registered for the VM but not configured in ipconfig0 (%v)", rec.IP, strings.Join(ips, ", ")), nil)
+			}
+		}
+
+		ipset, err := proxmox.GetIPFilter(vm.Node, vm.Vmid)
+		if err != nil || ipset == nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%v: Failed to retrieve IP filter: %v", subject, err))
+			unchecked[vm.Vmid] = true
+			continue
+		}
+		filtered := []string{}
+		for _, entry := range *ipset {
+			filtered = append(filtered, normalizeIP(entry.Cidr))
+		}
+		slices.Sort(filtered)
+		configured := slices.Sorted(slices.Values(ips))
+		if !slices.Equal(filtered, configured) {
+			report.add(CATEGORY_IP_MISMATCH, fmt.Sprintf("%d:ipfilter", vm.Vmid), subject,
+				fmt.Sprintf("The IP filter allows %v, ipconfig0 has %v", strings.Join(filtered, ", "), strings.Join(configured, ", ")), nil)
+		}
+	}
+
+	//! Requests: provisioned requests against their VM
+	provisioning := map[string]bool{}
+	for _, req := range requests {
+		if req.Requeststatus == storage.REQUEST_STATUS_PROVISIONING {
+			provisioning[strings.ToLower(req.Hostname)] = true
+		}
+		if req.Requeststatus != storage.REQUEST_STATUS_PROVISIONED {
+			continue
+		}
+		subject := fmt.Sprintf("Request %d (%v)", req.Requestid, req.Hostname)
+		if !req.Vmid.Valid {
+			report.add(CATEGORY_REQUEST_UNLINKED, fmt.Sprint(req.Requestid), subject,
+				"The request does not record its VM, its IPs are not checked",
+				&Fix{Action: FIX_LINK_VM, Description: fmt.Sprintf("Store the VM named %v on request %d", req.Hostname, req.Requestid), RequestID: req.Requestid})
+			continue
+		}
+
+		if !exists[int(req.Vmid.Int32)] {
+			report.add(CATEGORY_REQUEST_WITHOUT_VM, fmt.Sprint(req.Requestid), subject,
+				fmt.Sprintf("VM %d does not exist anymore", req.Vmid.Int32),
+				&Fix{Action: FIX_DECOMMISSION_REQUEST, Description: fmt.Sprintf("Mark request %d as decommissioned", req.Requestid), RequestID: req.Requestid})
+			continue
+		}
+		if unchecked[int(req.Vmid.Int32)] {
+			continue
+		}
+		vm := vmsByID[int(req.Vmid.Int32)]
+		for _, ip := range []string{req.Ipv4.String, req.Ipv6.String} {
+			if ip != "" && !slices.Contains(vm.ipconfig, normalizeIP(ip)) {
+				report.add(CATEGORY_IP_MISMATCH, fmt.Sprintf("request:%d:%v", req.Requestid, normalizeIP(ip)), subject,
+					fmt.Sprintf("The request was provisioned with %v, VM %d has %v", ip, vm.vm.Vmid, strings.Join(vm.ipconfig, ", ")), nil)
+			}
+		}
+	}
+
+	//! DNS: entries of VMWiz whose name is not a VM. VMs being created are registered before they exist.
+	for _, rec := range records {
+		name := strings.ToLower(rec.FQDN)
+		if rec.IsgGroup != netcenter.ISG_GROUP || vmsByName[name] || provisioning[name] {
+			continue
+		}
+		// The name is part of the ID, so that a confirmed or approved fix can't delete a record the IP was reassigned to
+		report.add(CATEGORY_DNS_WITHOUT_VM, normalizeIP(rec.IP)+":"+name, fmt.Sprintf("%v (%v)", rec.FQDN, rec.IP),
+			fmt.Sprintf("No VM is named %v", rec.FQDN),
+			&Fix{Action: FIX_DELETE_DNS, Description: fmt.Sprintf("Delete the DNS entry of %v", rec.IP), IP: normalizeIP(rec.IP), FQDN: name})
+	}
+
+	mu.Lock()
+	latest = &report
+	mu.Unlock()
+	return &report, nil
+}
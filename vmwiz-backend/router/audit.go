@@ -0,0 +1,84 @@
+package router
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/auth"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
+	"github.com/gorilla/mux"
+)
+
+// Routes under /api/audit/*
+
+func addAuditRoutes(r *mux.Router) {
+	// Lists audit entries, newest first.
+	// Filters: ?actor=&action=&target= (exact match), ?since=&until= (RFC 3339), ?limit= (default 100, max 1000)
+	r.Methods("GET").Path("/api/audit").Subrouter().NewRoute().Handler(auth.RequireRole(auth.ROLE_OPERATOR, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		optional := func(key string) sql.NullString {
+			return sql.NullString{String: q.Get(key), Valid: q.Get(key) != ""}
+		}
+
+		params := storage.ListAuditEntriesParams{
+			Actor:      optional("actor"),
+			Action:     optional("action"),
+			Target:     optional("target"),
+			MaxEntries: 100,
+		}
+		if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 && n <= 1000 {
+			params.MaxEntries = int32(n)
+		}
+		for key, dst := range map[string]*sql.NullTime{"since": &params.Since, "until": &params.Until} {
+			if q.Get(key) == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, q.Get(key))
+			if err != nil {
+				http.Error(w, "Invalid '"+key+"', expected an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			*dst = sql.NullTime{Time: t, Valid: true}
+		}
+
+		entries, err := storage.DB.ListAuditEntries(r.Context(), params)
+		if err != nil {
+			log.Printf("Failed to list audit entries: %v", err)
+			http.Error(w, "Failed to list audit entries", http.StatusInternalServerError)
+			return
+		}
+
+		type auditResp struct {
+			ID         int64     `json:"id"`
+			CreatedAt  time.Time `json:"createdAt"`
+			Actor      string    `json:"actor"`
+			Action     string    `json:"action"`
+			Target     string    `json:"target"`
+			Payload    string    `json:"payload"`
+			Result     string    `json:"result"`
+			Detail     string    `json:"detail"`
+			LogScopeID string    `json:"logScopeId"`
+		}
+		resp := []auditResp{}
+		for _, e := range entries {
+			resp = append(resp, auditResp{
+				ID:         e.ID,
+				CreatedAt:  e.CreatedAt,
+				Actor:      e.Actor,
+				Action:     e.Action,
+				Target:     e.Target,
+				Payload:    e.Payload,
+				Result:     e.Result,
+				Detail:     e.Detail,
+				LogScopeID: e.LogScopeID.String,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})))
+}
@@ -2,9 +2,12 @@ package router
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"time"
 
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/audit"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/auth"
 	"github.com/gorilla/mux"
 )
@@ -20,7 +23,7 @@ func addAllAuthRoutes(r *mux.Router) {
 
 	// Returns the authenticated user's details
 	r.Methods("GET").Path("/api/auth/whoami").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user, ok := r.Context().Value("user").(auth.KeycloakUser)
+		user, ok := auth.User(r.Context())
 		if !ok {
 			log.Println("Failed to get user from context in /api/auth/whoami")
 			http.Error(w, "Failed to get user", http.StatusInternalServerError)
@@ -30,4 +33,83 @@ func addAllAuthRoutes(r *mux.Router) {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(user)
 	})))
+
+	// Ends the current session
+	r.Methods("POST").Path("/api/auth/logout").Handler(audit.Middleware("auth.logout", http.HandlerFunc(auth.Logout)))
+
+	// Lists the sessions of the authenticated user, or of everybody for superadmins (?all=true)
+	r.Methods("GET").Path("/api/auth/sessions").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := auth.User(r.Context())
+		email := user.Email
+		if r.URL.Query().Get("all") == "true" {
+			if user.Role < auth.ROLE_SUPERADMIN {
+				http.Error(w, "Only superadmins can list the sessions of other users", http.StatusForbidden)
+				return
+			}
+			email = ""
+		}
+
+		sessions, err := auth.ListSessions(r.Context(), email)
+		if err != nil {
+			log.Printf("Failed to list sessions: %v", err)
+			http.Error(w, "Failed to list sessions", http.StatusInternalServerError)
+			return
+		}
+
+		type sessionResp struct {
+			ID         string    `json:"ID"`
+			Email      string    `json:"Email"`
+			CreatedAt  time.Time `json:"CreatedAt"`
+			LastSeenAt time.Time `json:"LastSeenAt"`
+			ExpiresAt  time.Time `json:"ExpiresAt"`
+			Current    bool      `json:"Current"`
+		}
+		current := auth.CurrentSessionID(r)
+		resp := []sessionResp{}
+		for _, s := range sessions {
+			resp = append(resp, sessionResp{
+				ID:         s.ID,
+				Email:      s.Email,
+				CreatedAt:  s.CreatedAt,
+				LastSeenAt: s.LastSeenAt,
+				ExpiresAt:  s.ExpiresAt,
+				Current:    s.ID == current,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})))
+
+	// Revokes a session of the authenticated user. Superadmins can revoke any session.
+	r.Methods("POST").Path("/api/auth/sessions/revoke").Subrouter().NewRoute().Handler(audit.Middleware("auth.revoke_session", auth.CheckAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type bodyS struct {
+			ID string `json:"id"`
+		}
+
+		var body bodyS
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			log.Printf("Error decoding JSON: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		user, _ := auth.User(r.Context())
+		email := user.Email
+		if user.Role >= auth.ROLE_SUPERADMIN {
+			email = ""
+		}
+
+		err = auth.RevokeSession(r.Context(), body.ID, email)
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to revoke session: %v", err)
+			http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+			return
+		}
+	}))))
 }
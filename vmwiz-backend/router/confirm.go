@@ -0,0 +1,179 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/confirmation"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/proxmox"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/reconcile"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
+)
+
+// Confirmation targets of the confirmable routes.
+// Tokens are derived from the resource in the body, so they cannot be reused on another one.
+
+// Target of the /api/vmrequest/* routes, e.g "accept request 123"
+func vmRequestTarget(verb string, summary func(req storage.Request) string) confirmation.TargetFunc {
+	return func(r *http.Request, body []byte) (confirmation.Target, error) {
+		var b struct {
+			ID int64 `json:"id"`
+		}
+		if err := json.Unmarshal(body, &b); err != nil {
+			return confirmation.Target{}, fmt.Errorf("Invalid request payload")
+		}
+		req, err := storage.DB.GetVMRequestByID(r.Context(), b.ID)
+		if err != nil {
+			return confirmation.Target{}, fmt.Errorf("VM request %d not found", b.ID)
+		}
+		return confirmation.Target{
+			Token:   fmt.Sprintf("%s request %d", verb, req.Requestid),
+			Summary: summary(req),
+		}, nil
+	}
+}
+
+func describeVMRequest(req storage.Request) string {
+	s := fmt.Sprintf("%s (%s): %d cores, %d GB RAM, %d GB disk", req.Hostname, req.Image, req.Cores, req.Ramgb, req.Diskgb)
+	if req.Secondarydiskgb > 0 {
+		s += fmt.Sprintf(", %d GB secondary disk", req.Secondarydiskgb)
+	}
+	return s
+}
+
+// Target of /api/vm/deleteByName, the token is the name of the VM(s)
+func deleteVMTarget(r *http.Request, body []byte) (confirmation.Target, error) {
+	var b struct {
+		Name      string `json:"vmName"`
+		DeleteDNS bool   `json:"deleteDNS"`
+	}
+	if err := json.Unmarshal(body, &b); err != nil || b.Name == "" {
+		return confirmation.Target{}, fmt.Errorf("Invalid request payload")
+	}
+	vms, err := proxmox.GetAllClusterVMsByName(b.Name)
+	if err != nil {
+		return confirmation.Target{}, fmt.Errorf("Failed to get VM by name")
+	}
+	ids := []string{}
+	for _, vm := range *vms {
+		ids = append(ids, fmt.Sprintf("%d on %s", vm.Vmid, vm.Node))
+	}
+	summary := fmt.Sprintf("Force stop and delete %d VM(s) named '%s'", len(ids), b.Name)
+	if len(ids) > 0 {
+		summary += fmt.Sprintf(" (%s)", strings.Join(ids, ", "))
+	}
+	if b.DeleteDNS {
+		summary += " and delete their DNS entries"
+	}
+	return confirmation.Target{Token: b.Name, Summary: summary}, nil
+}
+
+// Target of /api/dns/deleteByHostname, the token is the hostname
+func deleteDNSTarget(r *http.Request, body []byte) (confirmation.Target, error) {
+	var b struct {
+		Hostname string `json:"hostname"`
+	}
+	if err := json.Unmarshal(body, &b); err != nil || b.Hostname == "" {
+		return confirmation.Target{}, fmt.Errorf("Invalid request payload")
+	}
+	return confirmation.Target{
+		Token:   b.Hostname,
+		Summary: fmt.Sprintf("Delete all DNS entries of '%s'", b.Hostname),
+	}, nil
+}
+
+// Target of POST /api/dns/records, the token is the FQDN
+func createDNSRecordTarget(r *http.Request, body []byte) (confirmation.Target, error) {
+	var b struct {
+		IP   string `json:"ip"`
+		FQDN string `json:"fqdn"`
+	}
+	if err := json.Unmarshal(body, &b); err != nil || b.FQDN == "" || b.IP == "" {
+		return confirmation.Target{}, fmt.Errorf("Invalid request payload")
+	}
+	fqdn := strings.ToLower(strings.TrimSpace(b.FQDN))
+	return confirmation.Target{
+		Token:   fqdn,
+		Summary: fmt.Sprintf("Register '%s' for %s", fqdn, strings.TrimSpace(b.IP)),
+	}, nil
+}
+
+// Target of /api/dns/aliases/delete, the token is the alias
+func deleteDNSAliasTarget(r *http.Request, body []byte) (confirmation.Target, error) {
+	var b struct {
+		Alias string `json:"alias"`
+	}
+	if err := json.Unmarshal(body, &b); err != nil || b.Alias == "" {
+		return confirmation.Target{}, fmt.Errorf("Invalid request payload")
+	}
+	alias := strings.ToLower(strings.TrimSpace(b.Alias))
+	return confirmation.Target{
+		Token:   alias,
+		Summary: fmt.Sprintf("Delete the alias '%s'", alias),
+	}, nil
+}
+
+// Target of /api/reconcile/apply, the token is the ID of the discrepancy
+func reconcileFixTarget(r *http.Request, body []byte) (confirmation.Target, error) {
+	var b struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &b); err != nil || b.ID == "" {
+		return confirmation.Target{}, fmt.Errorf("Invalid request payload")
+	}
+	// The admin just looked at the report, it is only built again when the fix is applied
+	report, err := reconcile.Latest(r.Context(), time.Minute)
+	if err != nil {
+		return confirmation.Target{}, fmt.Errorf("Failed to build reconciliation report")
+	}
+	d := report.Find(b.ID)
+	if d == nil || d.Fix == nil {
+		return confirmation.Target{}, fmt.Errorf("Discrepancy '%s' not found or it has no fix", b.ID)
+	}
+	return confirmation.Target{
+		Token:   d.ID,
+		Summary: fmt.Sprintf("%s: %s", d.Subject, d.Fix.Description),
+	}, nil
+}
+
+// Target of the /api/usagesurvey/resend/* routes, e.g "send reminders survey 4"
+func surveyTarget(verb string, summary func(r *http.Request, survey storage.Survey) (string, error)) confirmation.TargetFunc {
+	return func(r *http.Request, body []byte) (confirmation.Target, error) {
+		var b struct {
+			ID int64 `json:"id"`
+		}
+		if err := json.Unmarshal(body, &b); err != nil {
+			return confirmation.Target{}, fmt.Errorf("Invalid request payload")
+		}
+		survey, err := storage.DB.GetSurveyByID(r.Context(), b.ID)
+		if err != nil {
+			return confirmation.Target{}, fmt.Errorf("Survey %d not found", b.ID)
+		}
+		s, err := summary(r, survey)
+		if err != nil {
+			return confirmation.Target{}, err
+		}
+		return confirmation.Target{Token: fmt.Sprintf("%s survey %d", verb, survey.ID), Summary: s}, nil
+	}
+}
+
+// Target of /api/operations/approve, e.g "approve operation 7"
+func approveOperationTarget(r *http.Request, body []byte) (confirmation.Target, error) {
+	var b struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(body, &b); err != nil {
+		return confirmation.Target{}, fmt.Errorf("Invalid request payload")
+	}
+	op, err := storage.DB.GetPendingOperation(r.Context(), b.ID)
+	if err != nil {
+		return confirmation.Target{}, fmt.Errorf("Pending operation %d not found", b.ID)
+	}
+	return confirmation.Target{
+		Token:   fmt.Sprintf("approve operation %d", op.ID),
+		Summary: fmt.Sprintf("Run %s on '%s' (%s), requested by %s at %s", op.Action, op.Target, op.Payload, op.RequestedBy, op.RequestedAt.Format(time.DateTime)),
+	}, nil
+}
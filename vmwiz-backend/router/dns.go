@@ -6,19 +6,93 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"regexp"
+	"strings"
 
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/approval"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/audit"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/auth"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/confirmation"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/form"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/logger"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/netcenter"
 	"github.com/gorilla/mux"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
 )
 
 // Routes under /api/dns/*
 
+var fqdn_regexp = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,}$`)
+
+// Records and aliases can only be managed in the domain of the VMs
+func checkVMDomain(fqdn string) *ErrorBundle {
+	if !fqdn_regexp.MatchString(fqdn) || !strings.HasSuffix(fqdn, "."+form.Current().Domain) {
+		return &ErrorBundle{Err: fmt.Errorf("invalid FQDN '%v'", fqdn), UserMsg: fmt.Sprintf("'%v' is not a valid name in %v", fqdn, form.Current().Domain), HttpCode: http.StatusBadRequest}
+	}
+	return nil
+}
+
+// Netcenter rejects invalid changes with a message the admin needs to see
+func netcenterError(err error) *ErrorBundle {
+	log.Printf("Netcenter: %v\n", err)
+	return &ErrorBundle{Err: err, UserMsg: err.Error(), HttpCode: http.StatusBadGateway}
+}
+
+// CreateDNSRecord registers fqdn for an IP in one of the subnets, e.g. to restore a record deleted by mistake
+func CreateDNSRecord(ctx context.Context, ip string, fqdn string) *ErrorBundle {
+	fqdn = strings.ToLower(strings.TrimSpace(fqdn))
+	if eb := checkVMDomain(fqdn); eb != nil {
+		return eb
+	}
+	addr, err := ipaddr.NewIPAddressString(strings.TrimSpace(ip)).ToAddress()
+	if err != nil || addr.IsPrefixed() {
+		return &ErrorBundle{Err: fmt.Errorf("invalid IP '%v'", ip), UserMsg: fmt.Sprintf("'%v' is not an IP address", ip), HttpCode: http.StatusBadRequest}
+	}
+	if netcenter.SubnetOf(addr) == nil {
+		return &ErrorBundle{Err: fmt.Errorf("IP %v is in no subnet", addr), UserMsg: fmt.Sprintf("%v is not in one of the VM subnets", addr), HttpCode: http.StatusBadRequest}
+	}
+
+	if err := netcenter.CreateDNSEntry(ctx, addr, fqdn); err != nil {
+		return netcenterError(err)
+	}
+	return nil
+}
+
+// CreateDNSAlias points alias (e.g. www.org.vsos.ethz.ch) to hostname with a CNAME record
+func CreateDNSAlias(ctx context.Context, alias string, hostname string) *ErrorBundle {
+	alias = strings.ToLower(strings.TrimSpace(alias))
+	hostname = strings.ToLower(strings.TrimSpace(hostname))
+	for _, name := range []string{alias, hostname} {
+		if eb := checkVMDomain(name); eb != nil {
+			return eb
+		}
+	}
+	if alias == hostname {
+		return &ErrorBundle{Err: fmt.Errorf("alias '%v' points to itself", alias), UserMsg: "An alias cannot point to itself", HttpCode: http.StatusBadRequest}
+	}
+
+	if err := netcenter.CreateAlias(ctx, alias, hostname); err != nil {
+		return netcenterError(err)
+	}
+	return nil
+}
+
+// DeleteDNSAlias removes the CNAME record alias
+func DeleteDNSAlias(ctx context.Context, alias string) *ErrorBundle {
+	alias = strings.ToLower(strings.TrimSpace(alias))
+	if eb := checkVMDomain(alias); eb != nil {
+		return eb
+	}
+
+	if err := netcenter.DeleteAlias(ctx, alias); err != nil {
+		return netcenterError(err)
+	}
+	return nil
+}
+
 func addAllDNSRoutes(r *mux.Router) {
 
-	r.Methods("POST").Path("/api/dns/deleteByHostname").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(confirmation.ConfirmMiddleware("delete dns", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.Methods("POST").Path("/api/dns/deleteByHostname").Subrouter().NewRoute().Handler(audit.Middleware("dns.delete", auth.RequireRole(auth.ROLE_SUPERADMIN, confirmation.ConfirmMiddleware(deleteDNSTarget, approval.Middleware("dns.delete", auth.ROLE_SUPERADMIN, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		type bodyS struct {
 			Hostname string `json:"hostname"`
 		}
@@ -39,6 +113,119 @@ func addAllDNSRoutes(r *mux.Router) {
 			lg.Infof("Deleting DNS entries for %s", body.Hostname)
 			finish(netcenter.DeleteDNSEntryByHostname(ctx, body.Hostname))
 		}()
+	}))))))
+
+	// Records of the subnets, ?q= filters by IP or part of the FQDN
+	r.Methods("GET").Path("/api/dns/records").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		records, err := netcenter.SearchRecords(r.URL.Query().Get("q"))
+		if err != nil {
+			log.Printf("Failed to list DNS records: %v", err)
+			http.Error(w, "Failed to list DNS records", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+	})))
+
+	// Registering a name for an arbitrary IP can take over the name of another VM
+	r.Methods("POST").Path("/api/dns/records").Subrouter().NewRoute().Handler(audit.Middleware("dns.create", auth.RequireRole(auth.ROLE_SUPERADMIN, confirmation.ConfirmMiddleware(createDNSRecordTarget, approval.Middleware("dns.create", auth.ROLE_SUPERADMIN, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type bodyS struct {
+			IP   string `json:"ip"`
+			FQDN string `json:"fqdn"`
+		}
+
+		var body bodyS
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			log.Printf("Error decoding JSON: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		eb := CreateDNSRecord(r.Context(), body.IP, body.FQDN)
+		if eb != nil {
+			http.Error(w, eb.UserMsg, eb.HttpCode)
+			return
+		}
+	}))))))
+
+	// Aliases pointing to ?hostname=
+	r.Methods("GET").Path("/api/dns/aliases").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hostname := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("hostname")))
+		if hostname == "" {
+			http.Error(w, "Please specify the hostname", http.StatusBadRequest)
+			return
+		}
+
+		aliases, err := netcenter.GetAliases(hostname)
+		if err != nil {
+			log.Printf("Failed to list aliases of %v: %v", hostname, err)
+			http.Error(w, "Failed to list aliases", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(aliases)
+	})))
+
+	r.Methods("POST").Path("/api/dns/aliases").Subrouter().NewRoute().Handler(audit.Middleware("dns.alias.create", auth.RequireRole(auth.ROLE_OPERATOR, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type bodyS struct {
+			Alias    string `json:"alias"`
+			Hostname string `json:"hostname"`
+		}
+
+		var body bodyS
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			log.Printf("Error decoding JSON: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		eb := CreateDNSAlias(r.Context(), body.Alias, body.Hostname)
+		if eb != nil {
+			http.Error(w, eb.UserMsg, eb.HttpCode)
+			return
+		}
 	}))))
 
+	r.Methods("POST").Path("/api/dns/aliases/delete").Subrouter().NewRoute().Handler(audit.Middleware("dns.alias.delete", auth.RequireRole(auth.ROLE_SUPERADMIN, confirmation.ConfirmMiddleware(deleteDNSAliasTarget, approval.Middleware("dns.alias.delete", auth.ROLE_SUPERADMIN, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type bodyS struct {
+			Alias string `json:"alias"`
+		}
+
+		var body bodyS
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			log.Printf("Error decoding JSON: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		eb := DeleteDNSAlias(r.Context(), body.Alias)
+		if eb != nil {
+			http.Error(w, eb.UserMsg, eb.HttpCode)
+			return
+		}
+	}))))))
+
+	// Compares what the system resolver answers for ?hostname= and its aliases with Netcenter
+	r.Methods("GET").Path("/api/dns/verify").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hostname := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("hostname")))
+		if hostname == "" {
+			http.Error(w, "Please specify the hostname", http.StatusBadRequest)
+			return
+		}
+
+		v, err := netcenter.Verify(r.Context(), hostname)
+		if err != nil {
+			log.Printf("Failed to verify DNS of %v: %v", hostname, err)
+			http.Error(w, "Failed to verify DNS records", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	})))
 }
@@ -0,0 +1,122 @@
+package router
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/audit"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/auth"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/notifier"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
+	"github.com/gorilla/mux"
+)
+
+// Routes under /api/mail/*
+
+func addMailRoutes(r *mux.Router) {
+	// Lists the most recent messages of the outbox, optionally filtered by status
+	r.Methods("GET").Path("/api/mail").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := r.URL.Query().Get("status")
+		limit := 50
+		if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 500 {
+			limit = n
+		}
+
+		mails, err := storage.DB.ListMails(r.Context(), storage.ListMailsParams{Status: status, MaxResults: int32(limit)})
+		if err != nil {
+			log.Printf("Failed to list emails: %v", err)
+			http.Error(w, "Failed to list emails", http.StatusInternalServerError)
+			return
+		}
+
+		type mailResp struct {
+			ID            int64      `json:"id"`
+			CreatedAt     time.Time  `json:"createdAt"`
+			Status        string     `json:"status"`
+			Recipients    []string   `json:"recipients"`
+			Subject       string     `json:"subject"`
+			Template      string     `json:"template"`
+			Attempts      int32      `json:"attempts"`
+			NextAttemptAt time.Time  `json:"nextAttemptAt"`
+			LastAttemptAt *time.Time `json:"lastAttemptAt"`
+			LastError     string     `json:"lastError"`
+			SentAt        *time.Time `json:"sentAt"`
+		}
+		out := []mailResp{}
+		for _, m := range mails {
+			resp := mailResp{
+				ID:            m.ID,
+				CreatedAt:     m.CreatedAt,
+				Status:        string(m.Status),
+				Recipients:    m.Recipients,
+				Subject:       m.Subject,
+				Template:      m.Template,
+				Attempts:      m.Attempts,
+				NextAttemptAt: m.NextAttemptAt,
+				LastError:     m.LastError.String,
+			}
+			if m.LastAttemptAt.Valid {
+				resp.LastAttemptAt = &m.LastAttemptAt.Time
+			}
+			if m.SentAt.Valid {
+				resp.SentAt = &m.SentAt.Time
+			}
+			out = append(out, resp)
+		}
+
+		resp, _ := json.Marshal(out)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+	})))
+
+	// Schedules a queued or failed message for immediate delivery
+	r.Methods("POST").Path("/api/mail/retry").Subrouter().NewRoute().Handler(audit.Middleware("mail.retry", auth.RequireRole(auth.ROLE_OPERATOR, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type bodyS struct {
+			ID int64 `json:"id"`
+		}
+
+		var body bodyS
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			log.Printf("Error decoding JSON: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		err = notifier.RetryEmail(r.Context(), body.ID)
+		if err != nil {
+			log.Printf("%v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))))
+
+	// Stops any further delivery attempt of a queued or failed message
+	r.Methods("POST").Path("/api/mail/cancel").Subrouter().NewRoute().Handler(audit.Middleware("mail.cancel", auth.RequireRole(auth.ROLE_OPERATOR, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type bodyS struct {
+			ID int64 `json:"id"`
+		}
+
+		var body bodyS
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			log.Printf("Error decoding JSON: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		err = notifier.CancelEmail(r.Context(), body.ID)
+		if err != nil {
+			log.Printf("%v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))))
+}
@@ -0,0 +1,281 @@
+package router
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/audit"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/auth"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/notifier"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/proxmox"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
+	"github.com/gorilla/mux"
+)
+
+// Routes under /api/me/*
+// Self-service routes for requesters. They only ever show or touch the requests submitted with the verified email of the logged in user.
+
+// Fetches a VM request of the given user. Requests of other users are reported as not found.
+func ownVMRequest(ctx context.Context, id int64, email string) (storage.Request, *ErrorBundle) {
+	notFound := &ErrorBundle{
+		Err:      fmt.Errorf("request %d not found for %s", id, email),
+		UserMsg:  "VM request not found",
+		HttpCode: http.StatusNotFound,
+	}
+
+	request, err := storage.DB.GetVMRequestByID(ctx, id)
+	if err == sql.ErrNoRows {
+		return storage.Request{}, notFound
+	}
+	if err != nil {
+		return storage.Request{}, SimpleError(err, "Failed to fetch VM request")
+	}
+	if !strings.EqualFold(request.Email, email) {
+		return storage.Request{}, notFound
+	}
+	return request, nil
+}
+
+// WithdrawVMRequest lets the requester take back a request that was not processed yet (unverified, pending or on hold).
+func WithdrawVMRequest(ctx context.Context, id int64, email string, reason string) *ErrorBundle {
+	request, eb := ownVMRequest(ctx, id, email)
+	if eb != nil {
+		return eb
+	}
+
+	if eb := transitionVMRequest(ctx, request, storage.REQUEST_STATUS_WITHDRAWN, reason, ""); eb != nil {
+		return eb
+	}
+	// The admins were never notified of unverified requests
+	if request.Requeststatus == storage.REQUEST_STATUS_UNVERIFIED {
+		return nil
+	}
+	request.Requeststatus = storage.REQUEST_STATUS_WITHDRAWN
+
+	err := notifier.NotifyVMRequestStatusChanged(ctx, request, reason)
+	if err != nil {
+		return SimpleError(err, "Failed to notify VM request status change")
+	}
+	return nil
+}
+
+// RequestVMDeletion records that the requester wants the VM of a provisioned request deleted and notifies the admins.
+// The VM itself is deleted by an admin.
+func RequestVMDeletion(ctx context.Context, id int64, email string, reason string) *ErrorBundle {
+	request, eb := ownVMRequest(ctx, id, email)
+	if eb != nil {
+		return eb
+	}
+
+	n, err := storage.DB.RequestVMDeletion(ctx, id)
+	if err != nil {
+		return SimpleError(err, "Failed to request VM deletion")
+	}
+	if n == 0 {
+		return ConflictError(fmt.Errorf("request %d has no VM, or its deletion was already requested", id))
+	}
+
+	// Same status on both sides: not a transition, but part of the request history
+	err = storage.DB.RecordRequestEvent(ctx, id, auth.Actor(ctx), request.Requeststatus, request.Requeststatus, strings.TrimSpace("Deletion requested. "+reason), "")
+	if err != nil {
+		return SimpleError(err, "Failed to record VM request event")
+	}
+
+	err = notifier.NotifyVMDeletionRequested(ctx, request, reason)
+	if err != nil {
+		return SimpleError(err, "Failed to notify VM deletion request")
+	}
+	return nil
+}
+
+func addMeRoutes(r *mux.Router) {
+
+	// Returns the logged in user
+	r.Methods("GET").Path("/api/me").Subrouter().NewRoute().Handler(auth.CheckUserAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := auth.User(r.Context())
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(user)
+	})))
+
+	// Lists the requests of the logged in user, without internal admin notes
+	r.Methods("GET").Path("/api/me/requests").Subrouter().NewRoute().Handler(auth.CheckUserAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := auth.User(r.Context())
+
+		requests, err := storage.DB.ListVMRequestsByEmail(r.Context(), user.Email)
+		if err != nil {
+			log.Printf("Failed to get VM requests of %s: %v", user.Email, err)
+			http.Error(w, "Failed to get VM requests", http.StatusInternalServerError)
+			return
+		}
+
+		type eventResp struct {
+			CreatedAt  time.Time `json:"CreatedAt"`
+			FromStatus string    `json:"FromStatus"`
+			ToStatus   string    `json:"ToStatus"`
+			Reason     string    `json:"Reason"`
+		}
+		type requestResp struct {
+			ID               int64       `json:"ID"`
+			RequestCreatedAt time.Time   `json:"RequestCreatedAt"`
+			RequestStatus    string      `json:"RequestStatus"`
+			Hostname         string      `json:"Hostname"`
+			Image            string      `json:"Image"`
+			Cores            int32       `json:"Cores"`
+			RamGB            int32       `json:"RamGB"`
+			DiskGB           int32       `json:"DiskGB"`
+			SecondaryDiskGB  int32       `json:"SecondaryDiskGB"`
+			Events           []eventResp `json:"Events"`
+		}
+
+		resp := []requestResp{}
+		for _, req := range requests {
+			events, err := storage.DB.ListRequestEvents(r.Context(), req.Requestid)
+			if err != nil {
+				log.Printf("Failed to get events of VM request %d: %v", req.Requestid, err)
+				http.Error(w, "Failed to get VM request events", http.StatusInternalServerError)
+				return
+			}
+			evs := []eventResp{}
+			for _, ev := range events {
+				evs = append(evs, eventResp{
+					CreatedAt:  ev.CreatedAt,
+					FromStatus: string(ev.FromStatus),
+					ToStatus:   string(ev.ToStatus),
+					Reason:     ev.Reason.String,
+				})
+			}
+
+			resp = append(resp, requestResp{
+				ID:               req.Requestid,
+				RequestCreatedAt: req.Requestcreatedat,
+				RequestStatus:    string(req.Requeststatus),
+				Hostname:         req.Hostname,
+				Image:            req.Image,
+				Cores:            req.Cores,
+				RamGB:            req.Ramgb,
+				DiskGB:           req.Diskgb,
+				SecondaryDiskGB:  req.Secondarydiskgb,
+				Events:           evs,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})))
+
+	// Lists the VMs created for the requests of the logged in user, with their current state in Proxmox
+	r.Methods("GET").Path("/api/me/vms").Subrouter().NewRoute().Handler(auth.CheckUserAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := auth.User(r.Context())
+
+		requests, err := storage.DB.ListVMRequestsByEmail(r.Context(), user.Email)
+		if err != nil {
+			log.Printf("Failed to get VM requests of %s: %v", user.Email, err)
+			http.Error(w, "Failed to get VMs", http.StatusInternalServerError)
+			return
+		}
+
+		type vmResp struct {
+			RequestID       int64  `json:"RequestID"`
+			Hostname        string `json:"Hostname"`
+			VMID            int32  `json:"VMID"`
+			IPv4            string `json:"IPv4"`
+			IPv6            string `json:"IPv6"`
+			Cores           int32  `json:"Cores"`
+			RamGB           int32  `json:"RamGB"`
+			DiskGB          int32  `json:"DiskGB"`
+			SecondaryDiskGB int32  `json:"SecondaryDiskGB"`
+			// Proxmox status (e.g. running, stopped), "unknown" if it could not be fetched
+			Status              string     `json:"Status"`
+			DeletionRequestedAt *time.Time `json:"DeletionRequestedAt"`
+		}
+
+		resp := []vmResp{}
+		for _, req := range requests {
+			if req.Requeststatus != storage.REQUEST_STATUS_PROVISIONED || !req.Vmid.Valid {
+				continue
+			}
+
+			status := "unknown"
+			vm, err := proxmox.GetNodeVM(req.Node.String, int(req.Vmid.Int32))
+			if err != nil {
+				log.Printf("Failed to get status of VM %d: %v", req.Vmid.Int32, err)
+			} else {
+				status = vm.Status
+			}
+
+			var deletionRequestedAt *time.Time
+			if req.Deletionrequestedat.Valid {
+				deletionRequestedAt = &req.Deletionrequestedat.Time
+			}
+
+			resp = append(resp, vmResp{
+				RequestID:           req.Requestid,
+				Hostname:            req.Hostname,
+				VMID:                req.Vmid.Int32,
+				IPv4:                req.Ipv4.String,
+				IPv6:                req.Ipv6.String,
+				Cores:               req.Cores,
+				RamGB:               req.Ramgb,
+				DiskGB:              req.Diskgb,
+				SecondaryDiskGB:     req.Secondarydiskgb,
+				Status:              status,
+				DeletionRequestedAt: deletionRequestedAt,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})))
+
+	r.Methods("POST").Path("/api/me/requests/withdraw").Subrouter().NewRoute().Handler(audit.Middleware("me.withdraw_request", auth.CheckUserAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type bodyS struct {
+			ID     int    `json:"id"`
+			Reason string `json:"reason"`
+		}
+
+		var body bodyS
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			log.Printf("Error decoding JSON: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		user, _ := auth.User(r.Context())
+		eb := WithdrawVMRequest(r.Context(), int64(body.ID), user.Email, body.Reason)
+		if eb != nil {
+			http.Error(w, eb.UserMsg, eb.HttpCode)
+			return
+		}
+	}))))
+
+	r.Methods("POST").Path("/api/me/vms/delete").Subrouter().NewRoute().Handler(audit.Middleware("me.request_vm_deletion", auth.CheckUserAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type bodyS struct {
+			// ID of the request the VM was created for
+			ID     int    `json:"id"`
+			Reason string `json:"reason"`
+		}
+
+		var body bodyS
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			log.Printf("Error decoding JSON: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		user, _ := auth.User(r.Context())
+		eb := RequestVMDeletion(r.Context(), int64(body.ID), user.Email, body.Reason)
+		if eb != nil {
+			http.Error(w, eb.UserMsg, eb.HttpCode)
+			return
+		}
+	}))))
+}
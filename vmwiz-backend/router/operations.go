@@ -0,0 +1,109 @@
+package router
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/approval"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/audit"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/auth"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/confirmation"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
+	"github.com/gorilla/mux"
+)
+
+// Routes under /api/operations/*
+// Operations waiting for the approval of a second admin, see the approval package
+
+func addOperationRoutes(r *mux.Router) {
+	// Lists the operations waiting for approval, newest first.
+	// ?all=true also includes the approved, rejected and expired ones. ?limit= (default 100, max 1000)
+	r.Methods("GET").Path("/api/operations").Subrouter().NewRoute().Handler(auth.RequireRole(auth.ROLE_APPROVER, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := storage.ListPendingOperationsParams{
+			IncludeDecided: r.URL.Query().Get("all") == "true",
+			MaxEntries:     100,
+		}
+		if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 1000 {
+			params.MaxEntries = int32(n)
+		}
+
+		ops, err := storage.DB.ListPendingOperations(r.Context(), params)
+		if err != nil {
+			log.Printf("Failed to list pending operations: %v", err)
+			http.Error(w, "Failed to list pending operations", http.StatusInternalServerError)
+			return
+		}
+
+		type operationResp struct {
+			ID          int64      `json:"id"`
+			Action      string     `json:"action"`
+			Target      string     `json:"target"`
+			Payload     string     `json:"payload"`
+			RequestedBy string     `json:"requestedBy"`
+			RequestedAt time.Time  `json:"requestedAt"`
+			ExpiresAt   time.Time  `json:"expiresAt"`
+			Status      string     `json:"status"`
+			DecidedBy   string     `json:"decidedBy"`
+			DecidedAt   *time.Time `json:"decidedAt"`
+		}
+		resp := []operationResp{}
+		for _, op := range ops {
+			o := operationResp{
+				ID:          op.ID,
+				Action:      op.Action,
+				Target:      op.Target,
+				Payload:     op.Payload,
+				RequestedBy: op.RequestedBy,
+				RequestedAt: op.RequestedAt,
+				ExpiresAt:   op.ExpiresAt,
+				Status:      approval.Status(op),
+				DecidedBy:   op.DecidedBy.String,
+			}
+			if op.DecidedAt.Valid {
+				o.DecidedAt = &op.DecidedAt.Time
+			}
+			resp = append(resp, o)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})))
+
+	// Approves an operation and runs it. The response is the one of the original action.
+	r.Methods("POST").Path("/api/operations/approve").Subrouter().NewRoute().Handler(audit.Middleware("operations.approve", auth.RequireRole(auth.ROLE_APPROVER, confirmation.ConfirmMiddleware(approveOperationTarget, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type bodyS struct {
+			ID int64 `json:"id"`
+		}
+		var body bodyS
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			log.Printf("Error decoding JSON: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		approval.Approve(w, r, body.ID)
+	})))))
+
+	r.Methods("POST").Path("/api/operations/reject").Subrouter().NewRoute().Handler(audit.Middleware("operations.reject", auth.RequireRole(auth.ROLE_APPROVER, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type bodyS struct {
+			ID int64 `json:"id"`
+		}
+		var body bodyS
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			log.Printf("Error decoding JSON: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		if err := approval.Reject(r.Context(), body.ID); err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+	}))))
+}
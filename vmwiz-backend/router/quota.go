@@ -0,0 +1,176 @@
+package router
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/audit"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/auth"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/quota"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
+	"github.com/gorilla/mux"
+)
+
+// Routes under /api/quota/*
+
+// A quota override as received from and sent to the admin console. Unset (null) limits keep the default.
+type QuotaOverride struct {
+	Kind    string `json:"kind"`
+	Subject string `json:"subject"`
+	VMs     *int   `json:"vms"`
+	Cores   *int   `json:"cores"`
+	RamGB   *int   `json:"ramGB"`
+	DiskGB  *int   `json:"diskGB"`
+	Comment string `json:"comment"`
+
+	UpdatedBy string    `json:"updatedBy,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitzero"`
+}
+
+func nullInt32(v *int) sql.NullInt32 {
+	if v == nil {
+		return sql.NullInt32{}
+	}
+	return sql.NullInt32{Int32: int32(*v), Valid: true}
+}
+
+func intPtr(v sql.NullInt32) *int {
+	if !v.Valid {
+		return nil
+	}
+	i := int(v.Int32)
+	return &i
+}
+
+// SetQuotaOverride replaces the quota of a user or organization
+func SetQuotaOverride(ctx context.Context, o QuotaOverride) *ErrorBundle {
+	if !quota.ValidKind(o.Kind) {
+		return &ErrorBundle{Err: fmt.Errorf("invalid quota kind '%v'", o.Kind), UserMsg: "Kind must be 'user' or 'org'", HttpCode: http.StatusBadRequest}
+	}
+	if quota.Normalize(o.Subject) == "" {
+		return &ErrorBundle{Err: fmt.Errorf("empty quota subject"), UserMsg: "Please specify the email address or organization", HttpCode: http.StatusBadRequest}
+	}
+	for _, v := range []*int{o.VMs, o.Cores, o.RamGB, o.DiskGB} {
+		if v != nil && *v < 0 {
+			return &ErrorBundle{Err: fmt.Errorf("negative quota %d", *v), UserMsg: "Quotas cannot be negative, use 0 for no limit", HttpCode: http.StatusBadRequest}
+		}
+	}
+
+	err := storage.DB.SetQuotaOverride(ctx, storage.SetQuotaOverrideParams{
+		Kind:      o.Kind,
+		Subject:   quota.Normalize(o.Subject),
+		MaxVms:    nullInt32(o.VMs),
+		MaxCores:  nullInt32(o.Cores),
+		MaxRamgb:  nullInt32(o.RamGB),
+		MaxDiskgb: nullInt32(o.DiskGB),
+		Comment:   o.Comment,
+		UpdatedBy: auth.Actor(ctx),
+	})
+	if err != nil {
+		return SimpleError(err, "Failed to set quota override")
+	}
+	return nil
+}
+
+// DeleteQuotaOverride resets the quota of a user or organization to the defaults
+func DeleteQuotaOverride(ctx context.Context, kind string, subject string) *ErrorBundle {
+	n, err := storage.DB.DeleteQuotaOverride(ctx, storage.DeleteQuotaOverrideParams{Kind: kind, Subject: quota.Normalize(subject)})
+	if err != nil {
+		return SimpleError(err, "Failed to delete quota override")
+	}
+	if n == 0 {
+		return &ErrorBundle{Err: fmt.Errorf("no quota override for %s %s", kind, subject), UserMsg: "No quota override found", HttpCode: http.StatusNotFound}
+	}
+	return nil
+}
+
+func addQuotaRoutes(r *mux.Router) {
+
+	// Usage and quota of a user or organization: ?kind=user|org&subject=<email or organization name>
+	r.Methods("GET").Path("/api/quota").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kind := r.URL.Query().Get("kind")
+		if !quota.ValidKind(kind) {
+			http.Error(w, "Kind must be 'user' or 'org'", http.StatusBadRequest)
+			return
+		}
+
+		report, err := quota.Load(r.Context())
+		if err != nil {
+			log.Printf("Failed to compute quota usage: %v", err)
+			http.Error(w, "Failed to compute quota usage", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report.Status(kind, r.URL.Query().Get("subject")))
+	})))
+
+	r.Methods("GET").Path("/api/quota/overrides").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		overrides, err := storage.DB.ListQuotaOverrides(r.Context())
+		if err != nil {
+			log.Printf("Failed to list quota overrides: %v", err)
+			http.Error(w, "Failed to list quota overrides", http.StatusInternalServerError)
+			return
+		}
+
+		resp := []QuotaOverride{}
+		for _, o := range overrides {
+			resp = append(resp, QuotaOverride{
+				Kind:      o.Kind,
+				Subject:   o.Subject,
+				VMs:       intPtr(o.MaxVms),
+				Cores:     intPtr(o.MaxCores),
+				RamGB:     intPtr(o.MaxRamgb),
+				DiskGB:    intPtr(o.MaxDiskgb),
+				Comment:   o.Comment,
+				UpdatedBy: o.UpdatedBy,
+				UpdatedAt: o.UpdatedAt,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})))
+
+	r.Methods("POST").Path("/api/quota/overrides").Subrouter().NewRoute().Handler(audit.Middleware("quota.set", auth.RequireRole(auth.ROLE_SUPERADMIN, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body QuotaOverride
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			log.Printf("Error decoding JSON: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		eb := SetQuotaOverride(r.Context(), body)
+		if eb != nil {
+			http.Error(w, eb.UserMsg, eb.HttpCode)
+			return
+		}
+	}))))
+
+	r.Methods("POST").Path("/api/quota/overrides/delete").Subrouter().NewRoute().Handler(audit.Middleware("quota.delete", auth.RequireRole(auth.ROLE_SUPERADMIN, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type bodyS struct {
+			Kind    string `json:"kind"`
+			Subject string `json:"subject"`
+		}
+
+		var body bodyS
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			log.Printf("Error decoding JSON: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		eb := DeleteQuotaOverride(r.Context(), body.Kind, body.Subject)
+		if eb != nil {
+			http.Error(w, eb.UserMsg, eb.HttpCode)
+			return
+		}
+	}))))
+}
@@ -0,0 +1,113 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/approval"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/audit"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/auth"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/confirmation"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/netcenter"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/reconcile"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
+	"github.com/gorilla/mux"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+)
+
+// Routes under /api/reconcile/*
+
+// ApplyReconcileFix applies the fix of a discrepancy. The report is built again first, so only fixes of
+// discrepancies that still exist are applied.
+func ApplyReconcileFix(ctx context.Context, id string) *ErrorBundle {
+	report, err := reconcile.Build(ctx)
+	if err != nil {
+		return SimpleError(err, "Failed to build reconciliation report")
+	}
+	d := report.Find(id)
+	if d == nil {
+		return &ErrorBundle{Err: fmt.Errorf("no discrepancy '%v'", id), UserMsg: "Discrepancy not found, it may have been fixed in the meantime", HttpCode: http.StatusNotFound}
+	}
+	if d.Fix == nil {
+		return &ErrorBundle{Err: fmt.Errorf("discrepancy '%v' has no fix", id), UserMsg: "This discrepancy has to be fixed by hand", HttpCode: http.StatusBadRequest}
+	}
+
+	switch d.Fix.Action {
+	case reconcile.FIX_DELETE_DNS:
+		ip, err := ipaddr.NewIPAddressString(d.Fix.IP).ToAddress()
+		if err != nil {
+			return SimpleError(err, "Invalid IP in fix")
+		}
+		if err := netcenter.DeleteDNSEntryByIP(ctx, ip); err != nil {
+			return netcenterError(err)
+		}
+
+	case reconcile.FIX_CREATE_DNS:
+		if eb := CreateDNSRecord(ctx, d.Fix.IP, d.Fix.FQDN); eb != nil {
+			return eb
+		}
+
+	case reconcile.FIX_DECOMMISSION_REQUEST:
+		request, err := storage.DB.GetVMRequestByID(ctx, d.Fix.RequestID)
+		if err != nil {
+			return SimpleError(err, "Failed to get VM request")
+		}
+		if eb := transitionVMRequest(ctx, request, storage.REQUEST_STATUS_DECOMMISSIONED, "", "Reconciliation: "+d.Detail); eb != nil {
+			return eb
+		}
+
+	case reconcile.FIX_LINK_VM:
+		_, problems, eb := LinkVMRequestVMs(ctx, d.Fix.RequestID)
+		if eb != nil {
+			return eb
+		}
+		if len(problems) > 0 {
+			return &ErrorBundle{Err: fmt.Errorf("%v", problems[0]), UserMsg: problems[0], HttpCode: http.StatusConflict}
+		}
+
+	default:
+		return SimpleError(fmt.Errorf("unknown fix action '%v'", d.Fix.Action), "Unknown fix")
+	}
+
+	log.Printf("Applied reconciliation fix %v: %v", d.ID, d.Fix.Description)
+	return nil
+}
+
+func addReconcileRoutes(r *mux.Router) {
+
+	// Queries every VM of the cluster, so it is not open to viewers
+	r.Methods("GET").Path("/api/reconcile").Subrouter().NewRoute().Handler(auth.RequireRole(auth.ROLE_OPERATOR, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, err := reconcile.Build(r.Context())
+		if err != nil {
+			log.Printf("Failed to build reconciliation report: %v", err)
+			http.Error(w, "Failed to build reconciliation report", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	})))
+
+	r.Methods("POST").Path("/api/reconcile/apply").Subrouter().NewRoute().Handler(audit.Middleware("reconcile.apply", auth.RequireRole(auth.ROLE_SUPERADMIN, confirmation.ConfirmMiddleware(reconcileFixTarget, approval.Middleware("reconcile.apply", auth.ROLE_SUPERADMIN, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type bodyS struct {
+			ID string `json:"id"`
+		}
+
+		var body bodyS
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			log.Printf("Error decoding JSON: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		eb := ApplyReconcileFix(r.Context(), body.ID)
+		if eb != nil {
+			http.Error(w, eb.UserMsg, eb.HttpCode)
+			return
+		}
+	}))))))
+}
@@ -22,6 +22,16 @@ func SimpleError(err error, msg string) *ErrorBundle {
 	}
 }
 
+// Error for requests that conflict with the current state of a resource, e.g. an invalid status transition
+func ConflictError(err error) *ErrorBundle {
+	log.Printf("Conflict: %v\n", err)
+	return &ErrorBundle{
+		Err:      err,
+		UserMsg:  err.Error(),
+		HttpCode: http.StatusConflict,
+	}
+}
+
 func Router() *mux.Router {
 	r := mux.NewRouter()
 
@@ -49,5 +59,17 @@ func Router() *mux.Router {
 
 	addLogRoutes(r)
 
+	addMailRoutes(r)
+
+	addMeRoutes(r)
+
+	addAuditRoutes(r)
+
+	addOperationRoutes(r)
+
+	addQuotaRoutes(r)
+
+	addReconcileRoutes(r)
+
 	return r
 }
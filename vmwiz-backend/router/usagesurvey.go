@@ -8,8 +8,11 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/approval"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/audit"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/auth"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/confirmation"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/logger"
@@ -123,7 +126,7 @@ func addAllPollRoutes(r *mux.Router) {
 		w.Write(respJSON)
 	})))
 
-	r.Methods("POST").Path("/api/usagesurvey/create").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(confirmation.ConfirmMiddleware("create survey", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.Methods("POST").Path("/api/usagesurvey/create").Subrouter().NewRoute().Handler(audit.Middleware("usagesurvey.create", auth.RequireRole(auth.ROLE_OPERATOR, confirmation.ConfirmMiddleware(confirmation.Fixed("create survey", "Create a new usage survey and email the owners of all VMs"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx, lg, finish := logger.Nest(context.Background(), "Create VM usage survey")
 		w.Header().Set("X-Log-Scope-Id", lg.ScopeID())
 		w.WriteHeader(http.StatusAccepted)
@@ -133,9 +136,9 @@ func addAllPollRoutes(r *mux.Router) {
 			defer finish(err)
 			_, err = survey.CreateVMUsageSurvey(ctx, []string{"vsos"})
 		}()
-	}))))
+	})))))
 
-	r.Methods("POST").Path("/api/usagesurvey/set").Subrouter().NewRoute().Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.Methods("POST").Path("/api/usagesurvey/set").Subrouter().NewRoute().Handler(audit.Middleware("usagesurvey.respond", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		type bodyS struct {
 			ID   string `json:"id"`
 			Keep bool   `json:"keep"`
@@ -172,7 +175,7 @@ func addAllPollRoutes(r *mux.Router) {
 		}
 
 		w.WriteHeader(http.StatusOK)
-	}))
+	})))
 
 	r.Methods("GET").Path("/api/usagesurvey/responses/positive").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// get id from query
@@ -278,7 +281,13 @@ func addAllPollRoutes(r *mux.Router) {
 		w.Write(resp)
 	})))
 
-	r.Methods("POST").Path("/api/usagesurvey/resend/unsent").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(confirmation.ConfirmMiddleware("retry emails", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.Methods("POST").Path("/api/usagesurvey/resend/unsent").Subrouter().NewRoute().Handler(audit.Middleware("usagesurvey.resend_unsent", auth.RequireRole(auth.ROLE_OPERATOR, confirmation.ConfirmMiddleware(surveyTarget("retry emails", func(r *http.Request, sv storage.Survey) (string, error) {
+		n, err := storage.DB.CountUnsentSurveyEmails(r.Context(), sv.ID)
+		if err != nil {
+			return "", fmt.Errorf("Failed to count unsent emails")
+		}
+		return fmt.Sprintf("Retry sending %d unsent email(s) of the survey from %s", n, sv.Date.Format(time.DateOnly)), nil
+	}), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		type bodyS struct {
 			ID int64 `json:"id"`
 		}
@@ -296,9 +305,15 @@ func addAllPollRoutes(r *mux.Router) {
 		w.WriteHeader(http.StatusAccepted)
 
 		go func() { finish(survey.RetryUnsentEmails(ctx, body.ID)) }()
-	}))))
+	})))))
 
-	r.Methods("POST").Path("/api/usagesurvey/resend/unanswered").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(confirmation.ConfirmMiddleware("send reminders", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.Methods("POST").Path("/api/usagesurvey/resend/unanswered").Subrouter().NewRoute().Handler(audit.Middleware("usagesurvey.resend_unanswered", auth.RequireRole(auth.ROLE_OPERATOR, confirmation.ConfirmMiddleware(surveyTarget("send reminders", func(r *http.Request, sv storage.Survey) (string, error) {
+		emails, err := storage.DB.ListSentUnansweredSurveyEmails(r.Context(), sv.ID)
+		if err != nil {
+			return "", fmt.Errorf("Failed to list unanswered emails")
+		}
+		return fmt.Sprintf("Send a reminder to %d owner(s) who did not answer the survey from %s", len(emails), sv.Date.Format(time.DateOnly)), nil
+	}), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		type bodyS struct {
 			ID int64 `json:"id"`
 		}
@@ -315,5 +330,30 @@ func addAllPollRoutes(r *mux.Router) {
 		w.WriteHeader(http.StatusAccepted)
 
 		go func() { finish(survey.SendSurveyReminder(ctx, body.ID)) }()
-	}))))
+	})))))
+
+	r.Methods("POST").Path("/api/usagesurvey/shutdown/unanswered").Subrouter().NewRoute().Handler(audit.Middleware("usagesurvey.shutdown_unanswered", auth.RequireRole(auth.ROLE_SUPERADMIN, confirmation.ConfirmMiddleware(surveyTarget("shutdown unanswered", func(r *http.Request, sv storage.Survey) (string, error) {
+		hostnames, err := storage.DB.ListUnansweredSurveyHostnames(r.Context(), sv.ID)
+		if err != nil {
+			return "", fmt.Errorf("Failed to list unanswered hostnames")
+		}
+		return fmt.Sprintf("Shut down %d VM(s) whose owners did not answer the survey from %s: %s", len(hostnames), sv.Date.Format(time.DateOnly), strings.Join(hostnames, ", ")), nil
+	}), approval.Middleware("usagesurvey.shutdown_unanswered", auth.ROLE_SUPERADMIN, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type bodyS struct {
+			ID int64 `json:"id"`
+		}
+		var body bodyS
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			log.Printf("Error decoding JSON: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		ctx, lg, finish := logger.Nest(context.Background(), fmt.Sprintf("Shut down unanswered VMs of survey %d", body.ID))
+		w.Header().Set("X-Log-Scope-Id", lg.ScopeID())
+		w.WriteHeader(http.StatusAccepted)
+
+		go func() { finish(survey.ShutdownUnansweredVMs(ctx, body.ID)) }()
+	}))))))
 }
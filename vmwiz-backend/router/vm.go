@@ -2,25 +2,46 @@ package router
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
 
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/approval"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/audit"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/auth"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/confirmation"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/logger"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/netcenter"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/proxmox"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
 	"github.com/gorilla/mux"
 )
 
 // Routes under /api/vm/*
 
+// Marks the requests linked to a deleted VM as decommissioned
+func decommissionVMRequests(ctx context.Context, vmid int) error {
+	requests, err := storage.DB.GetVMRequestsByVMID(ctx, sql.NullInt32{Int32: int32(vmid), Valid: true})
+	if err != nil {
+		return fmt.Errorf("Failed to get the VM requests of VM %v: %v", vmid, err)
+	}
+	for _, request := range requests {
+		if request.Requeststatus == storage.REQUEST_STATUS_DECOMMISSIONED {
+			continue
+		}
+		if eb := transitionVMRequest(ctx, request, storage.REQUEST_STATUS_DECOMMISSIONED, "The VM was deleted", fmt.Sprintf("VM %v was deleted", vmid)); eb != nil {
+			return fmt.Errorf("Failed to decommission VM request %v: %v", request.Requestid, eb.Err)
+		}
+	}
+	return nil
+}
+
 func addAllVMRoutes(r *mux.Router) {
 
-	r.Methods("POST").Path("/api/vm/deleteByName").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(confirmation.ConfirmMiddleware("delete vm", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.Methods("POST").Path("/api/vm/deleteByName").Subrouter().NewRoute().Handler(audit.Middleware("vm.delete", auth.RequireRole(auth.ROLE_SUPERADMIN, confirmation.ConfirmMiddleware(deleteVMTarget, approval.Middleware("vm.delete", auth.ROLE_SUPERADMIN, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		type bodyS struct {
 			Name      string `json:"vmName"`
 			DeleteDNS bool   `json:"deleteDNS"`
@@ -48,7 +69,7 @@ func addAllVMRoutes(r *mux.Router) {
 		}
 
 		// Create a new logging sub-scope
-		ctx, lg, finish := logger.Nest(context.Background(), fmt.Sprintf("Delete VM %s", body.Name))
+		ctx, lg, finish := logger.Nest(auth.WithActor(context.Background(), auth.Actor(r.Context())), fmt.Sprintf("Delete VM %s", body.Name))
 		w.Header().Set("X-Log-Scope-Id", lg.ScopeID())
 		w.WriteHeader(http.StatusAccepted)
 
@@ -76,6 +97,11 @@ func addAllVMRoutes(r *mux.Router) {
 					continue
 				}
 
+				if err := decommissionVMRequests(ctx, vm.Vmid); err != nil {
+					lg.Errorf("%v %v", errprefix, err)
+					errors = append(errors, fmt.Sprintf("%v Failed to decommission the VM request of VM %v", errprefix, vm.Id))
+				}
+
 				if body.DeleteDNS {
 					lg.Infof("%v Deleting DNS entry for VM %v", errprefix, vm.Id)
 					if err := netcenter.DeleteDNSEntryByHostname(ctx, vm.Name); err != nil {
@@ -92,20 +118,46 @@ func addAllVMRoutes(r *mux.Router) {
 				lg.Info("VM deletion completed successfully")
 			}
 		}()
-	}))))
+	}))))))
 
 	r.Methods("GET").Path("/api/vm/ipv4free").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		free, err := netcenter.GetFreeIPv4sInSubnet(netcenter.VM_SUBNET.V4net)
-		if err != nil {
-			log.Printf("Failed getting free IPs: %v", err)
-			http.Error(w, "Failed to get free IPs", http.StatusInternalServerError)
-			return
+		type SubnetResp struct {
+			Name     string `json:"name"`
+			Net      string `json:"net"`
+			IPv4     string `json:"ipv4"`
+			IPv6     string `json:"ipv6"`
+			FreeIPv4 int    `json:"freeIPv4"`
+			FreeIPv6 int    `json:"freeIPv6"`
 		}
-
 		type Resp struct {
-			Count int `json:"count"`
+			// Free IPv4 addresses over all subnets
+			Count   int          `json:"count"`
+			Subnets []SubnetResp `json:"subnets"`
+		}
+		res := Resp{Subnets: []SubnetResp{}}
+		counted := map[string]bool{}
+		for _, subnet := range netcenter.Subnets() {
+			freeIPv4s, freeIPv6s, err := netcenter.FreeIPs(subnet)
+			if err != nil {
+				log.Printf("Failed getting free IPs of subnet '%v': %v", subnet.Name, err)
+				http.Error(w, "Failed to get free IPs", http.StatusInternalServerError)
+				return
+			}
+			if !counted[subnet.V4net.String()] {
+				counted[subnet.V4net.String()] = true
+				res.Count += len(freeIPv4s)
+			}
+			res.Subnets = append(res.Subnets, SubnetResp{
+				Name:     subnet.Name,
+				Net:      subnet.Net,
+				IPv4:     subnet.V4net.String(),
+				IPv6:     subnet.V6net.String(),
+				FreeIPv4: len(freeIPv4s),
+				FreeIPv6: len(freeIPv6s),
+			})
 		}
-		resp, _ := json.Marshal(Resp{Count: len(*free)})
+
+		resp, _ := json.Marshal(res)
 		w.Header().Set("Content-Type", "application/json")
 		w.Write(resp)
 	})))
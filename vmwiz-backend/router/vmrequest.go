@@ -2,29 +2,150 @@ package router
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/audit"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/auth"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/confirmation"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/form"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/hooks"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/images"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/logger"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/notifier"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/proxmox"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/quota"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/ratelimit"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
 	"github.com/gorilla/mux"
 )
 
 // Routes under /api/vmrequest/*
 
-// AcceptVMRequest marks a VM request as accepted, creates the VM,
-// sends notifications, and emails the requester.
-// Returns an ErrorBundle if any step fails.
+// transitionVMRequest changes the status of a VM request according to the transition table in storage,
+// and records the change in the request history. Invalid or concurrent transitions are reported as conflicts.
+func transitionVMRequest(ctx context.Context, request storage.Request, to storage.RequestStatus, reason string, adminNote string) *ErrorBundle {
+	err := storage.DB.TransitionVMRequest(ctx, request.Requestid, request.Requeststatus, to)
+	if errors.Is(err, storage.ErrInvalidRequestTransition) {
+		return ConflictError(err)
+	}
+	if err != nil {
+		return SimpleError(err, "Failed to update VM request status")
+	}
+
+	err = storage.DB.RecordRequestEvent(ctx, request.Requestid, auth.Actor(ctx), request.Requeststatus, to, reason, adminNote)
+	if err != nil {
+		return SimpleError(err, "Failed to record VM request event")
+	}
+	return nil
+}
+
+// Emails a verification link to the university address of the request, and to the personal one
+// if VMREQUEST_VERIFY_PERSONAL_EMAIL is set. Only the hashes of the tokens are stored.
+func sendVerificationEmails(ctx context.Context, req storage.Request) error {
+	addresses := []string{req.Email}
+	if config.AppConfig.VMREQUEST_VERIFY_PERSONAL_EMAIL && req.Personalemail != "" && !strings.EqualFold(req.Personalemail, req.Email) {
+		addresses = append(addresses, req.Personalemail)
+	}
+
+	for _, address := range addresses {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return fmt.Errorf("Failed to generate verification token: %v", err)
+		}
+		token := base64.RawURLEncoding.EncodeToString(secret)
+
+		err := storage.DB.CreateEmailVerification(ctx, storage.CreateEmailVerificationParams{
+			TokenHash: hashVerificationToken(token),
+			RequestID: req.Requestid,
+			Email:     address,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to store verification token for %v: %v", address, err)
+		}
+
+		link := config.AppConfig.URL("/verify?token=" + token)
+		if err := notifier.EmailVMRequestVerification(ctx, req, address, link); err != nil {
+			return fmt.Errorf("Failed to email verification link to %v: %v", address, err)
+		}
+	}
+	return nil
+}
+
+func hashVerificationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyVMRequestEmail marks the address the token was sent to as verified. Once all addresses of the request are,
+// the request becomes pending: the requester gets the usual receipt and the admins are notified.
+// Verifying an address twice is not an error. Returns the updated request.
+func VerifyVMRequestEmail(ctx context.Context, token string) (storage.Request, *ErrorBundle) {
+	verification, err := storage.DB.GetEmailVerification(ctx, hashVerificationToken(token))
+	if err == sql.ErrNoRows {
+		return storage.Request{}, &ErrorBundle{Err: err, UserMsg: "This link is invalid or has expired", HttpCode: http.StatusNotFound}
+	} else if err != nil {
+		return storage.Request{}, SimpleError(err, "Failed to fetch email verification")
+	}
+
+	if err := storage.DB.SetEmailVerified(ctx, verification.TokenHash); err != nil {
+		return storage.Request{}, SimpleError(err, "Failed to verify email")
+	}
+
+	request, err := storage.DB.GetVMRequestByID(ctx, verification.RequestID)
+	if err != nil {
+		return storage.Request{}, SimpleError(err, "Failed to fetch VM request")
+	}
+	if request.Requeststatus != storage.REQUEST_STATUS_UNVERIFIED {
+		return request, nil
+	}
+
+	remaining, err := storage.DB.CountUnverifiedEmails(ctx, request.Requestid)
+	if err != nil {
+		return storage.Request{}, SimpleError(err, "Failed to count unverified emails")
+	}
+	if remaining > 0 {
+		return request, nil
+	}
+
+	ctx = auth.WithActor(ctx, verification.Email)
+	eb := transitionVMRequest(ctx, request, storage.REQUEST_STATUS_PENDING, "", "")
+	if eb != nil && errors.Is(eb.Err, storage.ErrInvalidRequestTransition) {
+		// Verified concurrently through the other address
+		request, err = storage.DB.GetVMRequestByID(ctx, request.Requestid)
+		if err != nil {
+			return storage.Request{}, SimpleError(err, "Failed to fetch VM request")
+		}
+		return request, nil
+	} else if eb != nil {
+		return storage.Request{}, eb
+	}
+	request.Requeststatus = storage.REQUEST_STATUS_PENDING
+
+	if err := notifier.EmailVMRequestReceived(ctx, request); err != nil {
+		log.Printf("Failed to email requester: %v", err)
+	}
+	if err := notifier.NotifyVMRequest(ctx, request); err != nil {
+		log.Printf("Failed to send notification: %v", err)
+	}
+	return request, nil
+}
+
+// AcceptVMRequest moves a pending (or failed) VM request to provisioning and creates the VM.
+// The request ends up provisioned, with the VM details stored on it, or failed, in which case it can be accepted again.
+// Sends notifications and emails the requester. Returns an ErrorBundle if any step fails.
 func AcceptVMRequest(ctx context.Context, id int64) *ErrorBundle {
 	request, err := storage.DB.GetVMRequestByID(ctx, id)
 
@@ -32,37 +153,104 @@ func AcceptVMRequest(ctx context.Context, id int64) *ErrorBundle {
 		return SimpleError(err, "Error fetching VM request")
 	}
 
-	request.Requeststatus = storage.REQUEST_STATUS_ACCEPTED
+	image, err := images.Get(ctx, request.Image)
+	if err != nil {
+		return SimpleError(err, "Failed to look up the image of the VM request")
+	}
+
+	orgName := ""
+	if request.Isorganization {
+		orgName = request.Orgname.String
+	}
+	vmHooks, err := hooks.For(orgName)
+	if err != nil {
+		return SimpleError(err, "Failed to read the post-install hooks")
+	}
+
+	// Requests made before profiles existed get the default of their kind, recorded now
+	if request.Provisioningprofile == "" {
+		request.Provisioningprofile = proxmox.DefaultProfile(request.Isorganization)
+		err = storage.DB.SetVMRequestProvisioningProfile(ctx, storage.SetVMRequestProvisioningProfileParams{Requestid: id, Provisioningprofile: request.Provisioningprofile})
+		if err != nil {
+			return SimpleError(err, "Failed to record the provisioning profile")
+		}
+	}
+	profile, err := proxmox.GetProfile(request.Provisioningprofile)
+	if err != nil {
+		return SimpleError(err, "Failed to look up the provisioning profile of the VM request")
+	}
+
+	// The quotas or the other requests of the requester may have changed since the request was submitted
+	over, err := quota.CheckRequest(ctx, request)
+	if err != nil {
+		return SimpleError(err, "Failed to check the quota of the VM request")
+	}
+	if over != "" {
+		return &ErrorBundle{Err: fmt.Errorf("request %d exceeds the quota: %v", id, over), UserMsg: over, HttpCode: http.StatusConflict}
+	}
+
+	if eb := transitionVMRequest(ctx, request, storage.REQUEST_STATUS_PROVISIONING, "", ""); eb != nil {
+		return eb
+	}
+	request.Requeststatus = storage.REQUEST_STATUS_PROVISIONING
+
+	// From here on the request must not stay in provisioning, every error moves it to failed so it can be accepted again
+	fail := func(err error, adminNote string) {
+		if eb := transitionVMRequest(ctx, request, storage.REQUEST_STATUS_FAILED, "", adminNote); eb != nil {
+			logger.From(ctx).Errorf("Failed to mark request %d as failed: %v", id, eb.Err)
+		}
+		if err2 := notifier.EmailVMCreationFailed(ctx, request); err2 != nil {
+			logger.From(ctx).Errorf("Failed to email requester about the failed VM creation: %v", err2)
+		}
+		err2 := notifier.NotifyVMCreationUpdate(ctx, fmt.Sprintf("Request %d: Error creating VM:\n%v", id, "```\n"+err.Error()+"\n```"))
+		if err2 != nil {
+			logger.From(ctx).Errorf("Failed to notify VM creation update: %v", err2)
+		}
+	}
+
 	err = notifier.NotifyVMRequestStatusChanged(ctx, request, "Creating VM now, it'll take a while ...")
 	if err != nil {
-		return SimpleError(err, "Failed to notify VM request status change")
+		logger.From(ctx).Errorf("Failed to notify VM request status change: %v", err)
 	}
 
 	opts := request.ToVMOptions()
+	opts.Image = image.ToProxmox()
+	opts.Hooks = vmHooks
+	opts.Profile = profile
 	if request.Isorganization {
 		opts.ResourcePool = config.AppConfig.VM_ORGANIZATION_POOL
 	} else {
 		opts.ResourcePool = config.AppConfig.VM_PERSONAL_POOL
 	}
 
-	err = storage.DB.UpdateVMRequestStatus(ctx, storage.UpdateVMRequestStatusParams{Requestid: id, Requeststatus: storage.REQUEST_STATUS_ACCEPTED})
+	_, summary, err := proxmox.CreateVM(ctx, *opts)
 	if err != nil {
-		return SimpleError(err, "Failed to update VM request status")
+		fail(err, err.Error())
+		return SimpleError(err, "Failed to create VM")
 	}
 
-	_, summary, err := proxmox.CreateVM(ctx, *opts)
+	err = storage.DB.SetVMRequestVM(ctx, storage.SetVMRequestVMParams{
+		Requestid: id,
+		Vmid:      sql.NullInt32{Int32: int32(summary.VMID()), Valid: true},
+		Node:      sql.NullString{String: summary.Node(), Valid: true},
+		Ipv4:      sql.NullString{String: summary.IPv4(), Valid: summary.IPv4() != ""},
+		Ipv6:      sql.NullString{String: summary.IPv6(), Valid: summary.IPv6() != ""},
+	})
 	if err != nil {
-		err2 := notifier.NotifyVMCreationUpdate(ctx, fmt.Sprintf("Request %d: Error creating VM:\n%v", id, "```\n"+err.Error()+"\n```"))
-		if err2 != nil {
-			return SimpleError(err2, "Failed to notify VM creation update")
-		}
-		return SimpleError(err, "Failed to create VM")
+		// The VM exists, the admins have to attach it to the request (or delete it) before accepting again
+		fail(err, fmt.Sprintf("VM %d was created on %s but could not be stored on the request: %v", summary.VMID(), summary.Node(), err))
+		return SimpleError(err, "Failed to store the created VM on the request")
+	}
+
+	if eb := transitionVMRequest(ctx, request, storage.REQUEST_STATUS_PROVISIONED, "", ""); eb != nil {
+		fail(eb.Err, fmt.Sprintf("VM %d was created on %s but the request could not be marked as provisioned: %v", summary.VMID(), summary.Node(), eb.Err))
+		return eb
 	}
 
 	//send mail to the user
-	err = notifier.SendEmail("VSOS VM Creation", []byte(summary.String()), []string{request.Email, config.AppConfig.SMTP_REPLYTO})
+	err = notifier.EmailVMCreated(ctx, request, summary.String())
 	if err != nil {
-		return SimpleError(err, "Failed to send email")
+		return SimpleError(err, "Failed to queue email")
 	}
 
 	successMsg := fmt.Sprintf("Request %v: VM %s created successfully:\n%s", request.Requestid, opts.FQDN, "```\n"+summary.String()+"\n```")
@@ -76,22 +264,73 @@ func AcceptVMRequest(ctx context.Context, id int64) *ErrorBundle {
 	return nil
 }
 
-// RejectVMRequest marks a VM request as rejected.
+// LinkVMRequestVMs stores the VM on provisioned requests that have none, looked up in Proxmox by the FQDN of the
+// request. Requests provisioned before the VMs were recorded on them (see migration 14) have none.
+// Only request id is linked, all of them if id is 0. Returns the requests linked and those whose VM could not be
+// found unambiguously.
+func LinkVMRequestVMs(ctx context.Context, id int64) ([]string, []string, *ErrorBundle) {
+	requests, err := storage.DB.ListVMRequests(ctx)
+	if err != nil {
+		return nil, nil, SimpleError(err, "Failed to list VM requests")
+	}
+	vms, err := proxmox.GetAllClusterVMs()
+	if err != nil {
+		return nil, nil, SimpleError(err, "Failed to list Proxmox VMs")
+	}
+	vmsByName := map[string][]proxmox.PVEClusterVM{}
+	for _, vm := range *vms {
+		if vm.Template == 0 {
+			vmsByName[strings.ToLower(vm.Name)] = append(vmsByName[strings.ToLower(vm.Name)], vm)
+		}
+	}
+
+	linked, problems := []string{}, []string{}
+	for _, req := range requests {
+		if (id != 0 && req.Requestid != id) || req.Requeststatus != storage.REQUEST_STATUS_PROVISIONED || req.Vmid.Valid {
+			continue
+		}
+		subject := fmt.Sprintf("Request %d (%v)", req.Requestid, req.Hostname)
+
+		matches := vmsByName[strings.ToLower(req.Hostname)]
+		if len(matches) != 1 {
+			problems = append(problems, fmt.Sprintf("%v: %d VMs have this name", subject, len(matches)))
+			continue
+		}
+		vm := matches[0]
+		cfg, err := proxmox.GetNodeVMConfig(vm.Node, vm.Vmid)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%v: %v", subject, err))
+			continue
+		}
+		ipv4, ipv6 := cfg.IPConfig()
+
+		err = storage.DB.SetVMRequestVM(ctx, storage.SetVMRequestVMParams{
+			Requestid: req.Requestid,
+			Vmid:      sql.NullInt32{Int32: int32(vm.Vmid), Valid: true},
+			Node:      sql.NullString{String: vm.Node, Valid: true},
+			Ipv4:      sql.NullString{String: ipv4, Valid: ipv4 != ""},
+			Ipv6:      sql.NullString{String: ipv6, Valid: ipv6 != ""},
+		})
+		if err != nil {
+			return linked, problems, SimpleError(err, "Failed to store the VM on the request")
+		}
+		linked = append(linked, fmt.Sprintf("%v: VM %d on %v", subject, vm.Vmid, vm.Node))
+	}
+	return linked, problems, nil
+}
+
+// RejectVMRequest marks a VM request as rejected and emails the requester the (optional) reason.
+// The reason and the internal adminNote are stored in the request history.
 // Returns an ErrorBundle if the request was already accepted
 // or if any database/notification step fails.
-func RejectVMRequest(ctx context.Context, id int64) *ErrorBundle {
+func RejectVMRequest(ctx context.Context, id int64, reason string, adminNote string) *ErrorBundle {
 	request, err := storage.DB.GetVMRequestByID(ctx, id)
 	if err != nil {
 		return SimpleError(err, "Failed to fetch VM request")
 	}
 
-	if request.Requeststatus == storage.REQUEST_STATUS_ACCEPTED {
-		return SimpleError(nil, "Cannot reject an accepted request")
-	}
-
-	err = storage.DB.UpdateVMRequestStatus(ctx, storage.UpdateVMRequestStatusParams{Requestid: id, Requeststatus: storage.REQUEST_STATUS_REJECTED})
-	if err != nil {
-		return SimpleError(err, "Failed to update VM request status")
+	if eb := transitionVMRequest(ctx, request, storage.REQUEST_STATUS_REJECTED, reason, adminNote); eb != nil {
+		return eb
 	}
 
 	request, err = storage.DB.GetVMRequestByID(ctx, id)
@@ -99,31 +338,32 @@ func RejectVMRequest(ctx context.Context, id int64) *ErrorBundle {
 		return SimpleError(err, "Failed to fetch VM request")
 	}
 
-	err = notifier.NotifyVMRequestStatusChanged(ctx, request, "")
+	err = notifier.NotifyVMRequestStatusChanged(ctx, request, reason)
 	if err != nil {
 		return SimpleError(err, "Failed to notify VM request status change")
 	}
 
+	err = notifier.EmailVMRequestRejected(ctx, request, reason)
+	if err != nil {
+		return SimpleError(err, "Failed to email requester")
+	}
+
 	fmt.Printf("Rejected VM request %d (%s).\n", id, request.ToVMOptions().FQDN)
 
 	return nil
 }
 
 // HoldVMRequest changes a VM request from PENDING to HELD,
-// sends a status update notification, and returns any errors.
-func HoldVMRequest(ctx context.Context, id int64) *ErrorBundle {
+// sends a status update notification, emails the requester the (optional) reason, and returns any errors.
+// The reason and the internal adminNote are stored in the request history.
+func HoldVMRequest(ctx context.Context, id int64, reason string, adminNote string) *ErrorBundle {
 	request, err := storage.DB.GetVMRequestByID(ctx, id)
 	if err != nil {
 		return SimpleError(err, "Failed to fetch VM request")
 	}
 
-	if request.Requeststatus != storage.REQUEST_STATUS_PENDING {
-		return SimpleError(nil, "You can only put pending requests on hold")
-	}
-
-	err = storage.DB.UpdateVMRequestStatus(ctx, storage.UpdateVMRequestStatusParams{Requestid: id, Requeststatus: storage.REQUEST_STATUS_HELD})
-	if err != nil {
-		return SimpleError(err, "Failed to update VM request status")
+	if eb := transitionVMRequest(ctx, request, storage.REQUEST_STATUS_HELD, reason, adminNote); eb != nil {
+		return eb
 	}
 
 	request, err = storage.DB.GetVMRequestByID(ctx, id)
@@ -131,11 +371,16 @@ func HoldVMRequest(ctx context.Context, id int64) *ErrorBundle {
 		return SimpleError(err, "Failed to fetch VM request")
 	}
 
-	err = notifier.NotifyVMRequestStatusChanged(ctx, request, "")
+	err = notifier.NotifyVMRequestStatusChanged(ctx, request, reason)
 	if err != nil {
 		return SimpleError(err, "Failed to notify VM request status change")
 	}
 
+	err = notifier.EmailVMRequestHeld(ctx, request, reason)
+	if err != nil {
+		return SimpleError(err, "Failed to email requester")
+	}
+
 	fmt.Printf("Held VM request %d (%s).\n", id, request.ToVMOptions().FQDN)
 
 	return nil
@@ -149,13 +394,13 @@ func UnholdVMRequest(ctx context.Context, id int64) *ErrorBundle {
 		return SimpleError(err, "Failed to fetch VM request")
 	}
 
+	// pending -> held -> pending is allowed by the transition table, unholding a pending request is not
 	if request.Requeststatus != storage.REQUEST_STATUS_HELD {
-		return SimpleError(nil, "Unhold invalid: request is not on hold")
+		return ConflictError(fmt.Errorf("%w: request %d is not on hold", storage.ErrInvalidRequestTransition, id))
 	}
 
-	err = storage.DB.UpdateVMRequestStatus(ctx, storage.UpdateVMRequestStatusParams{Requestid: id, Requeststatus: storage.REQUEST_STATUS_PENDING})
-	if err != nil {
-		return SimpleError(err, "Failed to update VM request status")
+	if eb := transitionVMRequest(ctx, request, storage.REQUEST_STATUS_PENDING, "", ""); eb != nil {
+		return eb
 	}
 
 	request, err = storage.DB.GetVMRequestByID(ctx, id)
@@ -175,8 +420,12 @@ func UnholdVMRequest(ctx context.Context, id int64) *ErrorBundle {
 
 func addVMRequestRoutes(r *mux.Router) {
 
-	// TODO: Rate limit requests
-	r.Methods("POST").Path("/api/vmrequest").HandlerFunc(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	// The form is public and validating it queries Proxmox and Netcenter, so submissions are limited per client IP
+	// before validation. The email address is not verified yet, so the per-email limits only apply to valid submissions.
+	ipLimiter := ratelimit.New(config.AppConfig.VMREQUEST_LIMIT_IP_INTERVAL, config.AppConfig.VMREQUEST_LIMIT_IP_BURST)
+	emailLimiter := ratelimit.New(config.AppConfig.VMREQUEST_LIMIT_EMAIL_INTERVAL, config.AppConfig.VMREQUEST_LIMIT_EMAIL_BURST)
+
+	r.Methods("POST").Path("/api/vmrequest").Handler(ratelimit.Middleware(ipLimiter, config.AppConfig.TRUST_PROXY_HEADERS, audit.Middleware("vmrequest.submit", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var f form.Form
 		err := json.NewDecoder(r.Body).Decode(&f)
 		if err != nil {
@@ -195,21 +444,45 @@ func addVMRequestRoutes(r *mux.Router) {
 			return
 		}
 
+		email := strings.ToLower(strings.TrimSpace(f.Email))
+		if ok, wait := emailLimiter.Allow(email); !ok {
+			log.Printf("Rate limited VM requests for %s", email)
+			ratelimit.ReplyTooManyRequests(w, wait, "Too many requests for this email address")
+			return
+		}
+		if limit := config.AppConfig.VMREQUEST_MAX_OPEN_PER_EMAIL; limit > 0 && email != "" {
+			open, err := storage.DB.CountOpenVMRequestsByEmail(r.Context(), email)
+			if err != nil {
+				log.Printf("Failed to count open VM requests of %s: %v", email, err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if open >= int64(limit) {
+				// Only an admin answering a request frees a slot, there is no exact time to retry at
+				w.Header().Set("Retry-After", strconv.Itoa(int(time.Hour.Seconds())))
+				http.Error(w, fmt.Sprintf("You already have %d unanswered VM requests, please wait until they are processed", open), http.StatusTooManyRequests)
+				return
+			}
+		}
+
 		w.WriteHeader(http.StatusOK)
 
 		id, err := storage.DB.CreateVMRequest(context.Background(), storage.CreateVMRequestParams{
-			Email:           f.Email,
-			Personalemail:   f.PersonalEmail,
-			Isorganization:  f.IsOrganization,
-			Orgname:         sql.NullString{String: f.OrgName, Valid: true},
-			Hostname:        fmt.Sprintf("%v.vsos.ethz.ch", f.Hostname),
-			Image:           f.Image,
-			Cores:           int32(f.Cores),
-			Ramgb:           int32(f.RamGB),
-			Diskgb:          int32(f.DiskGB),
-			Secondarydiskgb: int32(f.SecondaryDiskGB),
-			Sshpubkeys:      f.SshPubkeys,
-			Comments:        sql.NullString{String: f.Comments, Valid: true},
+			Email:               f.Email,
+			Personalemail:       f.PersonalEmail,
+			Isorganization:      f.IsOrganization,
+			Orgname:             sql.NullString{String: f.OrgName, Valid: true},
+			Hostname:            form.FQDN(f.Hostname),
+			Image:               f.Image,
+			Cores:               int32(f.Cores),
+			Ramgb:               int32(f.RamGB),
+			Diskgb:              int32(f.DiskGB),
+			Secondarydiskgb:     int32(f.SecondaryDiskGB),
+			Sshpubkeys:          f.SshPubkeys,
+			Userdata:            f.UserData,
+			Provisioningprofile: proxmox.DefaultProfile(f.IsOrganization),
+			Comments:            sql.NullString{String: f.Comments, Valid: true},
+			Requeststatus:       storage.REQUEST_STATUS_UNVERIFIED,
 		})
 		if err != nil {
 			log.Printf("Failed to store VM request: %v", err)
@@ -224,20 +497,162 @@ func addVMRequestRoutes(r *mux.Router) {
 			return
 		}
 
-		err = notifier.NotifyVMRequest(context.Background(), req)
+		// The admins are only notified once the requester verified their address(es)
+		err = sendVerificationEmails(context.Background(), req)
 		if err != nil {
-			log.Printf("Failed to send notification: %v", err)
+			log.Printf("Failed to send verification emails: %v", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
 
-	}))
+	}))))
+
+	r.Methods("POST").Path("/api/vmrequest/verify").Handler(audit.Middleware("vmrequest.verify", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type bodyS struct {
+			Token string `json:"token"`
+		}
+		var body bodyS
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil || body.Token == "" {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		req, eb := VerifyVMRequestEmail(r.Context(), body.Token)
+		if eb != nil {
+			http.Error(w, eb.UserMsg, eb.HttpCode)
+			return
+		}
+
+		type response struct {
+			ID       int64  `json:"id"`
+			Hostname string `json:"hostname"`
+			// Whether all addresses of the request are verified, i.e. the request is waiting for review
+			Verified bool `json:"verified"`
+		}
+		resp, _ := json.Marshal(response{
+			ID:       req.Requestid,
+			Hostname: req.Hostname,
+			Verified: req.Requeststatus != storage.REQUEST_STATUS_UNVERIFIED,
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+	})))
 
 	r.Methods("GET").Path("/api/vmrequest/options").HandlerFunc(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		resp, _ := json.Marshal(form.ALLOWED_VALUES)
+		opts, err := form.GetOptions(r.Context())
+		if err != nil {
+			log.Printf("Failed to get form options: %v", err)
+			http.Error(w, "Failed to get form options", http.StatusInternalServerError)
+			return
+		}
+		resp, _ := json.Marshal(opts)
 		w.Write(resp)
 	}))
 
+	// The form settings with who changed them last
+	r.Methods("GET").Path("/api/vmrequest/settings").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type response struct {
+			Settings  form.Settings `json:"settings"`
+			UpdatedBy string        `json:"updatedBy"`
+			UpdatedAt *time.Time    `json:"updatedAt"`
+		}
+		resp := response{Settings: form.Current()}
+
+		row, err := storage.DB.GetFormSettings(r.Context())
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Failed to get form settings: %v", err)
+			http.Error(w, "Failed to get form settings", http.StatusInternalServerError)
+			return
+		}
+		if err == nil {
+			resp.UpdatedBy = row.UpdatedBy
+			resp.UpdatedAt = &row.UpdatedAt
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})))
+
+	// Replaces the form settings, they are validated and in effect immediately
+	r.Methods("POST").Path("/api/vmrequest/settings").Subrouter().NewRoute().Handler(audit.Middleware("vmrequest.settings", auth.RequireRole(auth.ROLE_SUPERADMIN, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var settings form.Settings
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&settings)
+		if err != nil {
+			log.Printf("Error decoding JSON: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		if err := settings.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid settings: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		err = form.Save(r.Context(), settings, auth.Actor(r.Context()))
+		if err != nil {
+			log.Printf("Failed to save form settings: %v", err)
+			http.Error(w, "Failed to save form settings", http.StatusInternalServerError)
+			return
+		}
+	}))))
+
+	// The provisioning profiles a request can be created with, and the defaults of new requests
+	r.Methods("GET").Path("/api/vmrequest/profiles").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type response struct {
+			Profiles     []proxmox.ProvisioningProfile `json:"profiles"`
+			Personal     string                        `json:"personal"`
+			Organization string                        `json:"organization"`
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response{
+			Profiles:     proxmox.Profiles(),
+			Personal:     proxmox.DefaultProfile(false),
+			Organization: proxmox.DefaultProfile(true),
+		})
+	})))
+
+	// The image catalog, including the disabled images
+	r.Methods("GET").Path("/api/vmrequest/images").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		catalog, err := images.List(r.Context())
+		if err != nil {
+			log.Printf("Failed to list images: %v", err)
+			http.Error(w, "Failed to list images", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(catalog)
+	})))
+
+	// Adds an image to the catalog or replaces the one with the same name. Images are disabled rather than deleted.
+	r.Methods("POST").Path("/api/vmrequest/images").Subrouter().NewRoute().Handler(audit.Middleware("vmrequest.image", auth.RequireRole(auth.ROLE_SUPERADMIN, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var img images.Image
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&img)
+		if err != nil {
+			log.Printf("Error decoding JSON: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		if err := img.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid image: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		err = images.Save(r.Context(), img, auth.Actor(r.Context()))
+		if err != nil {
+			log.Printf("Failed to save image: %v", err)
+			http.Error(w, "Failed to save image", http.StatusInternalServerError)
+			return
+		}
+	}))))
+
 	r.Methods("GET").Path("/api/vmrequest").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		vmRequests, err := storage.DB.ListVMRequests(r.Context())
 		if err != nil {
@@ -246,6 +661,33 @@ func addVMRequestRoutes(r *mux.Router) {
 			return
 		}
 
+		requestEvents, err := storage.DB.ListAllRequestEvents(r.Context())
+		if err != nil {
+			log.Printf("Failed to get VM request events: %v", err)
+			http.Error(w, "Failed to get VM request events", http.StatusInternalServerError)
+			return
+		}
+
+		type requestEventResp struct {
+			CreatedAt  time.Time `json:"CreatedAt"`
+			Actor      string    `json:"Actor"`
+			FromStatus string    `json:"FromStatus"`
+			ToStatus   string    `json:"ToStatus"`
+			Reason     string    `json:"Reason"`
+			AdminNote  string    `json:"AdminNote"`
+		}
+		eventsByRequest := map[int64][]requestEventResp{}
+		for _, ev := range requestEvents {
+			eventsByRequest[ev.RequestID] = append(eventsByRequest[ev.RequestID], requestEventResp{
+				CreatedAt:  ev.CreatedAt,
+				Actor:      ev.Actor,
+				FromStatus: string(ev.FromStatus),
+				ToStatus:   string(ev.ToStatus),
+				Reason:     ev.Reason.String,
+				AdminNote:  ev.AdminNote.String,
+			})
+		}
+
 		// API response shape, decoupled from the DB row: flatten the nullable
 		// columns and keep the historical JSON field names the frontend expects.
 		type vmRequestResp struct {
@@ -263,26 +705,63 @@ func addVMRequestRoutes(r *mux.Router) {
 			DiskGB           int32     `json:"DiskGB"`
 			SecondaryDiskGB  int32     `json:"SecondaryDiskGB"`
 			SshPubkeys       []string  `json:"SshPubkeys"`
-			Comments         string    `json:"Comments"`
+			UserData         string    `json:"UserData"`
+			// Empty for requests made before profiles existed, they get the default of their kind
+			ProvisioningProfile string `json:"ProvisioningProfile"`
+			Comments            string `json:"Comments"`
+			// Set once the VM has been created
+			VMID int32  `json:"VMID"`
+			Node string `json:"Node"`
+			IPv4 string `json:"IPv4"`
+			IPv6 string `json:"IPv6"`
+			// Status changes and edits, oldest first
+			Events []requestEventResp `json:"Events"`
+			// Current usage and quota of the requester and organization, null if they could not be computed
+			UserQuota *quota.Status `json:"UserQuota"`
+			OrgQuota  *quota.Status `json:"OrgQuota"`
 		}
+
+		report, err := quota.Load(r.Context())
+		if err != nil {
+			log.Printf("Failed to compute quota usage: %v", err)
+		}
+
 		out := make([]vmRequestResp, 0, len(vmRequests))
 		for _, req := range vmRequests {
+			var userQuota, orgQuota *quota.Status
+			if err == nil {
+				status := report.Status(quota.KIND_USER, req.Email)
+				userQuota = &status
+				if req.Isorganization {
+					status := report.Status(quota.KIND_ORG, req.Orgname.String)
+					orgQuota = &status
+				}
+			}
 			out = append(out, vmRequestResp{
-				ID:               req.Requestid,
-				RequestCreatedAt: req.Requestcreatedat,
-				RequestStatus:    string(req.Requeststatus),
-				Email:            req.Email,
-				PersonalEmail:    req.Personalemail,
-				IsOrganization:   req.Isorganization,
-				OrgName:          req.Orgname.String,
-				Hostname:         req.Hostname,
-				Image:            req.Image,
-				Cores:            req.Cores,
-				RamGB:            req.Ramgb,
-				DiskGB:           req.Diskgb,
-				SecondaryDiskGB:  req.Secondarydiskgb,
-				SshPubkeys:       req.Sshpubkeys,
-				Comments:         req.Comments.String,
+				ID:                  req.Requestid,
+				RequestCreatedAt:    req.Requestcreatedat,
+				RequestStatus:       string(req.Requeststatus),
+				Email:               req.Email,
+				PersonalEmail:       req.Personalemail,
+				IsOrganization:      req.Isorganization,
+				OrgName:             req.Orgname.String,
+				Hostname:            req.Hostname,
+				Image:               req.Image,
+				Cores:               req.Cores,
+				RamGB:               req.Ramgb,
+				DiskGB:              req.Diskgb,
+				SecondaryDiskGB:     req.Secondarydiskgb,
+				SshPubkeys:          req.Sshpubkeys,
+				UserData:            req.Userdata,
+				ProvisioningProfile: req.Provisioningprofile,
+				Comments:            req.Comments.String,
+				VMID:                req.Vmid.Int32,
+				Node:                req.Node.String,
+				IPv4:                req.Ipv4.String,
+				IPv6:                req.Ipv6.String,
+				Events:              append([]requestEventResp{}, eventsByRequest[req.Requestid]...),
+				UserQuota:           userQuota,
+				OrgQuota:            orgQuota,
 			})
 		}
 		resp, err := json.Marshal(out)
@@ -294,7 +773,9 @@ func addVMRequestRoutes(r *mux.Router) {
 		w.Write(resp)
 	})))
 
-	r.Methods("POST").Path("/api/vmrequest/accept").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(confirmation.ConfirmMiddleware("accept", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.Methods("POST").Path("/api/vmrequest/accept").Subrouter().NewRoute().Handler(audit.Middleware("vmrequest.accept", auth.RequireRole(auth.ROLE_APPROVER, confirmation.ConfirmMiddleware(vmRequestTarget("accept", func(req storage.Request) string {
+		return fmt.Sprintf("Create the VM %s and email %s", describeVMRequest(req), req.Email)
+	}), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		type bodyS struct {
 			ID int `json:"id"`
 		}
@@ -306,7 +787,20 @@ func addVMRequestRoutes(r *mux.Router) {
 			return
 		}
 
-		ctx, lg, finish := logger.Nest(context.Background(), fmt.Sprintf("Accept VM request %d", body.ID))
+		request, err := storage.DB.GetVMRequestByID(r.Context(), int64(body.ID))
+		if err != nil {
+			log.Printf("Error getting VM request: %v", err)
+			http.Error(w, "Failed to fetch VM request", http.StatusInternalServerError)
+			return
+		}
+		// Checked again when the status is actually changed, this only gives early feedback
+		if !storage.CanTransitionVMRequest(request.Requeststatus, storage.REQUEST_STATUS_PROVISIONING) {
+			http.Error(w, fmt.Sprintf("Cannot accept a request that is '%v'", request.Requeststatus), http.StatusConflict)
+			return
+		}
+
+		// The VM is created after the request has been answered, so the actor is carried over explicitly
+		ctx, lg, finish := logger.Nest(auth.WithActor(context.Background(), auth.Actor(r.Context())), fmt.Sprintf("Accept VM request %d", body.ID))
 		// Set the Log Scope header such that the frontend can stream the live logs immediately.
 		w.Header().Set("X-Log-Scope-Id", lg.ScopeID())
 		w.WriteHeader(http.StatusAccepted)
@@ -320,11 +814,15 @@ func addVMRequestRoutes(r *mux.Router) {
 			}
 		}()
 
-	}))))
+	})))))
 
-	r.Methods("POST").Path("/api/vmrequest/reject").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(confirmation.ConfirmMiddleware("reject", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.Methods("POST").Path("/api/vmrequest/reject").Subrouter().NewRoute().Handler(audit.Middleware("vmrequest.reject", auth.RequireRole(auth.ROLE_APPROVER, confirmation.ConfirmMiddleware(vmRequestTarget("reject", func(req storage.Request) string {
+		return fmt.Sprintf("Reject the request for %s and email %s", req.Hostname, req.Email)
+	}), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		type bodyS struct {
-			ID int `json:"id"`
+			ID        int    `json:"id"`
+			Reason    string `json:"reason"`
+			AdminNote string `json:"admin_note"`
 		}
 
 		var body bodyS
@@ -335,17 +833,19 @@ func addVMRequestRoutes(r *mux.Router) {
 			return
 		}
 
-		eb := RejectVMRequest(r.Context(), int64(body.ID))
+		eb := RejectVMRequest(r.Context(), int64(body.ID), body.Reason, body.AdminNote)
 
 		if eb != nil {
 			http.Error(w, eb.UserMsg, eb.HttpCode)
 			return
 		}
-	}))))
+	})))))
 
-	r.Methods("POST").Path("/api/vmrequest/hold").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.Methods("POST").Path("/api/vmrequest/hold").Subrouter().NewRoute().Handler(audit.Middleware("vmrequest.hold", auth.RequireRole(auth.ROLE_APPROVER, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		type bodyS struct {
-			ID int `json:"id"`
+			ID        int    `json:"id"`
+			Reason    string `json:"reason"`
+			AdminNote string `json:"admin_note"`
 		}
 
 		var body bodyS
@@ -356,15 +856,15 @@ func addVMRequestRoutes(r *mux.Router) {
 			return
 		}
 
-		eb := HoldVMRequest(r.Context(), int64(body.ID))
+		eb := HoldVMRequest(r.Context(), int64(body.ID), body.Reason, body.AdminNote)
 
 		if eb != nil {
 			http.Error(w, eb.UserMsg, eb.HttpCode)
 			return
 		}
-	})))
+	}))))
 
-	r.Methods("POST").Path("/api/vmrequest/unhold").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.Methods("POST").Path("/api/vmrequest/unhold").Subrouter().NewRoute().Handler(audit.Middleware("vmrequest.unhold", auth.RequireRole(auth.ROLE_APPROVER, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		type bodyS struct {
 			ID int `json:"id"`
 		}
@@ -383,9 +883,11 @@ func addVMRequestRoutes(r *mux.Router) {
 			http.Error(w, eb.UserMsg, eb.HttpCode)
 			return
 		}
-	})))
+	}))))
 
-	r.Methods("POST").Path("/api/vmrequest/edit").Subrouter().NewRoute().Handler(auth.CheckAuthenticated(confirmation.ConfirmMiddleware("edit", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.Methods("POST").Path("/api/vmrequest/edit").Subrouter().NewRoute().Handler(audit.Middleware("vmrequest.edit", auth.RequireRole(auth.ROLE_APPROVER, confirmation.ConfirmMiddleware(vmRequestTarget("edit", func(req storage.Request) string {
+		return fmt.Sprintf("Edit the request for %s and email %s the changes", describeVMRequest(req), req.Email)
+	}), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		type bodyS struct {
 			Hostname             string `json:"hostname"`
 			ID                   int    `json:"id"`
@@ -393,6 +895,8 @@ func addVMRequestRoutes(r *mux.Router) {
 			Ram_gb               int    `json:"ram_gb"`
 			Storage_gb           int    `json:"storage_gb"`
 			Secondary_storage_gb int    `json:"secondary_storage_gb"`
+			// Provisioning profile, not shown to the requester
+			Profile string `json:"profile"`
 		}
 
 		var body bodyS
@@ -410,49 +914,107 @@ func addVMRequestRoutes(r *mux.Router) {
 			return
 		}
 
-		if request.Requeststatus != storage.REQUEST_STATUS_PENDING {
-			http.Error(w, "Cannot edit a request that is not pending", http.StatusBadRequest)
+		if !storage.VMRequestEditable(request.Requeststatus) {
+			http.Error(w, fmt.Sprintf("Cannot edit a request that is '%v'", request.Requeststatus), http.StatusConflict)
 			return
 		}
 
-		if body.Cores_cpu != 0 {
+		// Human readable list of the changes, for the requester
+		changes := []string{}
+		if body.Cores_cpu != 0 && int32(body.Cores_cpu) != request.Cores {
+			changes = append(changes, fmt.Sprintf("CPU cores: %v -> %v", request.Cores, body.Cores_cpu))
 			request.Cores = int32(body.Cores_cpu)
 		}
-		if body.Ram_gb != 0 {
+		if body.Ram_gb != 0 && int32(body.Ram_gb) != request.Ramgb {
+			changes = append(changes, fmt.Sprintf("RAM: %v GB -> %v GB", request.Ramgb, body.Ram_gb))
 			request.Ramgb = int32(body.Ram_gb)
 		}
-		if body.Storage_gb != 0 {
+		if body.Storage_gb != 0 && int32(body.Storage_gb) != request.Diskgb {
+			changes = append(changes, fmt.Sprintf("Disk: %v GB -> %v GB", request.Diskgb, body.Storage_gb))
 			request.Diskgb = int32(body.Storage_gb)
 		}
-		if body.Secondary_storage_gb != 0 {
+		if body.Secondary_storage_gb != 0 && int32(body.Secondary_storage_gb) != request.Secondarydiskgb {
+			changes = append(changes, fmt.Sprintf("Secondary disk: %v GB -> %v GB", request.Secondarydiskgb, body.Secondary_storage_gb))
 			request.Secondarydiskgb = int32(body.Secondary_storage_gb)
 		}
-		if body.Hostname != "" {
+		if body.Hostname != "" && body.Hostname != request.Hostname {
+			changes = append(changes, fmt.Sprintf("Hostname: %v -> %v", request.Hostname, body.Hostname))
 			request.Hostname = body.Hostname
 		}
+		if body.Cores_cpu != 0 || body.Ram_gb != 0 || body.Storage_gb != 0 || body.Secondary_storage_gb != 0 {
+			over, err := quota.CheckRequest(r.Context(), request)
+			if err != nil {
+				log.Printf("Error checking quota: %v", err)
+				http.Error(w, "Quota cannot be validated", http.StatusInternalServerError)
+				return
+			}
+			if over != "" {
+				http.Error(w, over, http.StatusConflict)
+				return
+			}
+		}
+
+		internalChanges := []string{}
+		if body.Profile != "" && body.Profile != request.Provisioningprofile {
+			if _, err := proxmox.GetProfile(body.Profile); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			internalChanges = append(internalChanges, fmt.Sprintf("Provisioning profile: %v -> %v", request.Provisioningprofile, body.Profile))
+			request.Provisioningprofile = body.Profile
+		}
 
-		err = storage.DB.UpdateVMRequest(r.Context(), storage.UpdateVMRequestParams{
-			Requestid:        request.Requestid,
-			Requestcreatedat: request.Requestcreatedat,
-			Requeststatus:    request.Requeststatus,
-			Email:            request.Email,
-			Personalemail:    request.Personalemail,
-			Isorganization:   request.Isorganization,
-			Orgname:          request.Orgname,
-			Hostname:         request.Hostname,
-			Image:            request.Image,
-			Cores:            request.Cores,
-			Ramgb:            request.Ramgb,
-			Diskgb:           request.Diskgb,
-			Secondarydiskgb:  request.Secondarydiskgb,
-			Sshpubkeys:       request.Sshpubkeys,
-			Comments:         request.Comments,
+		n, err := storage.DB.UpdateVMRequest(r.Context(), storage.UpdateVMRequestParams{
+			Requestid:           request.Requestid,
+			Requestcreatedat:    request.Requestcreatedat,
+			Requeststatus:       request.Requeststatus,
+			Email:               request.Email,
+			Personalemail:       request.Personalemail,
+			Isorganization:      request.Isorganization,
+			Orgname:             request.Orgname,
+			Hostname:            request.Hostname,
+			Image:               request.Image,
+			Cores:               request.Cores,
+			Ramgb:               request.Ramgb,
+			Diskgb:              request.Diskgb,
+			Secondarydiskgb:     request.Secondarydiskgb,
+			Sshpubkeys:          request.Sshpubkeys,
+			Comments:            request.Comments,
+			Provisioningprofile: request.Provisioningprofile,
 		})
 		if err != nil {
 			log.Printf("Error updating VM request: %v", err)
 			http.Error(w, "Failed to update VM request", http.StatusInternalServerError)
 			return
 		}
+		if n == 0 {
+			http.Error(w, "The request changed status in the meantime, please reload", http.StatusConflict)
+			return
+		}
 
-	}))))
+		if len(changes)+len(internalChanges) > 0 {
+			reason, adminNote := "", ""
+			if len(changes) > 0 {
+				reason = "Edited: " + strings.Join(changes, ", ")
+			}
+			if len(internalChanges) > 0 {
+				adminNote = "Edited: " + strings.Join(internalChanges, ", ")
+			}
+			err = storage.DB.RecordRequestEvent(r.Context(), request.Requestid, auth.Actor(r.Context()), request.Requeststatus, request.Requeststatus, reason, adminNote)
+			if err != nil {
+				log.Printf("Error recording VM request event: %v", err)
+				http.Error(w, "Failed to record VM request event", http.StatusInternalServerError)
+				return
+			}
+		}
+		if len(changes) > 0 {
+			err = notifier.EmailVMRequestEdited(r.Context(), request, changes)
+			if err != nil {
+				log.Printf("Error emailing requester: %v", err)
+				http.Error(w, "Updated VM request, but failed to email the requester", http.StatusInternalServerError)
+				return
+			}
+		}
+
+	})))))
 }
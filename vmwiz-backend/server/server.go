@@ -12,8 +12,10 @@ import (
 
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/logger"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/notifier"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/router"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/startupcheck"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
 	"github.com/rs/cors"
 )
 
@@ -57,6 +59,22 @@ func StartServer() error {
 		}
 	}()
 
+	// Deliver queued emails in the background.
+	go notifier.RunMailWorker()
+
+	// Delete the VM requests whose email address was never verified.
+	go func() {
+		for {
+			n, err := storage.DB.DeleteUnverifiedVMRequests(context.Background(), time.Now().Add(-config.AppConfig.VMREQUEST_UNVERIFIED_TTL))
+			if err != nil {
+				log.Printf("Failed to delete unverified VM requests: %v", err)
+			} else if n > 0 {
+				log.Printf("Deleted %d unverified VM request(s)", n)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+
 	// nodes, err := proxmox.GetAllNodeVMsByName("comp-epyc-lee-3", "vmwiz-test.vsos.ethz.ch")
 	// if err != nil {
 	// 	log.Println(err)
@@ -1,15 +1,21 @@
 package startupcheck
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/url"
+	"os"
 	"os/exec"
 	"slices"
+	"strings"
 
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/hooks"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/images"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/netcenter"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/proxmox"
+	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/ratelimit"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/storage"
 	"github.com/fatih/color"
 )
@@ -65,7 +71,7 @@ func DoAllStartupChecks() bool {
 	fatal := false
 
 	var startupChecks []*StartupCheck
-	startupChecks = slices.Concat(DoDatabaseStartupChecks(), DoNetcenterStartupChecks(), DoProxmoxStartupChecks())
+	startupChecks = slices.Concat(DoDatabaseStartupChecks(), DoNetcenterStartupChecks(), DoProxmoxStartupChecks(), DoProfileStartupChecks(), DoImageStartupChecks(), DoHookStartupChecks(), DoRateLimitStartupChecks())
 	for _, check := range startupChecks {
 		log.Println((*check).String())
 		if len((*check).Errors) > 0 {
@@ -76,6 +82,134 @@ func DoAllStartupChecks() bool {
 	return fatal
 }
 
+func DoRateLimitStartupChecks() []*StartupCheck {
+	limits_check := StartupCheck{
+		Name: "VM request form rate limits",
+	}
+
+	limiters := []struct {
+		name  string
+		limit *ratelimit.Limiter
+	}{
+		{"Per client IP", ratelimit.New(config.AppConfig.VMREQUEST_LIMIT_IP_INTERVAL, config.AppConfig.VMREQUEST_LIMIT_IP_BURST)},
+		{"Per email address", ratelimit.New(config.AppConfig.VMREQUEST_LIMIT_EMAIL_INTERVAL, config.AppConfig.VMREQUEST_LIMIT_EMAIL_BURST)},
+	}
+	for _, l := range limiters {
+		if l.limit.Enabled() {
+			limits_check.AddSuccess(fmt.Sprintf("%v: %v", l.name, l.limit))
+		} else {
+			limits_check.AddWarning(fmt.Sprintf("%v: disabled", l.name))
+		}
+	}
+
+	if config.AppConfig.VMREQUEST_MAX_OPEN_PER_EMAIL > 0 {
+		limits_check.AddSuccess(fmt.Sprintf("At most %d unanswered requests per email address", config.AppConfig.VMREQUEST_MAX_OPEN_PER_EMAIL))
+	} else {
+		limits_check.AddWarning("No limit on unanswered requests per email address")
+	}
+
+	if config.AppConfig.TRUST_PROXY_HEADERS {
+		limits_check.AddSuccess("Client IPs are taken from X-Forwarded-For")
+	} else {
+		limits_check.AddSuccess("Client IPs are taken from the connection (TRUST_PROXY_HEADERS is not set)")
+	}
+
+	return []*StartupCheck{&limits_check}
+}
+
+// Validates the provisioning profiles
+func DoProfileStartupChecks() []*StartupCheck {
+	profiles_check := StartupCheck{
+		Name: "Provisioning profiles",
+	}
+
+	errs := proxmox.ValidateProfiles()
+	for _, err := range errs {
+		profiles_check.AddError(err)
+	}
+	if len(errs) == 0 {
+		for _, p := range proxmox.Profiles() {
+			subnets := []string{}
+			for _, subnet := range netcenter.SubnetsOf(p.NetcenterNet) {
+				subnets = append(subnets, subnet.Name)
+			}
+			profiles_check.AddSuccess(fmt.Sprintf("%v: disks on %v, net %v (subnets %v)", p.Name, p.DiskStorage, p.NetcenterNet, strings.Join(subnets, ", ")))
+		}
+		profiles_check.AddSuccess(fmt.Sprintf("New requests get '%v' (personal) and '%v' (organization)", proxmox.DefaultProfile(false), proxmox.DefaultProfile(true)))
+	}
+
+	return []*StartupCheck{&profiles_check}
+}
+
+// Checks that the file of every enabled image of the catalog exists in the template storage of every profile
+func DoImageStartupChecks() []*StartupCheck {
+	images_check := StartupCheck{
+		Name: "VM image catalog",
+	}
+
+	enabled, err := images.Enabled(context.Background())
+	if err != nil {
+		images_check.AddError(err)
+		return []*StartupCheck{&images_check}
+	}
+	if len(enabled) == 0 {
+		images_check.AddError(fmt.Errorf("No image is enabled, VMs cannot be requested"))
+	}
+	checked := []string{}
+	for _, profile := range proxmox.Profiles() {
+		if slices.Contains(checked, profile.TemplateStorage) {
+			continue
+		}
+		checked = append(checked, profile.TemplateStorage)
+
+		for _, img := range enabled {
+			if err := proxmox.ImageExists(img.ToProxmox(), profile); err != nil {
+				images_check.AddError(fmt.Errorf("%v: %v", img.Name, err))
+			} else {
+				images_check.AddSuccess(fmt.Sprintf("%v: %v exists in %v", img.Name, img.Path, profile.TemplateStorage))
+			}
+		}
+	}
+
+	return []*StartupCheck{&images_check}
+}
+
+// Lists the post-install hooks, and checks that they can be read
+func DoHookStartupChecks() []*StartupCheck {
+	hooks_check := StartupCheck{
+		Name: "VM post-install hooks",
+	}
+
+	if config.AppConfig.VM_HOOKS_DIR == "" {
+		hooks_check.AddSuccess("No hooks (VM_HOOKS_DIR is not set)")
+		return []*StartupCheck{&hooks_check}
+	}
+	if _, err := os.Stat(config.AppConfig.VM_HOOKS_DIR); err != nil {
+		hooks_check.AddWarning(fmt.Sprintf("VM_HOOKS_DIR: %v", err))
+		return []*StartupCheck{&hooks_check}
+	}
+
+	orgs, err := hooks.Organizations()
+	if err != nil {
+		hooks_check.AddError(err)
+		return []*StartupCheck{&hooks_check}
+	}
+	for _, org := range append([]string{""}, orgs...) {
+		found, err := hooks.For(org)
+		if err != nil {
+			hooks_check.AddError(err)
+			continue
+		}
+		for _, hook := range found {
+			if org == "" || strings.HasPrefix(hook.Name, "org/") {
+				hooks_check.AddSuccess(hook.Name)
+			}
+		}
+	}
+
+	return []*StartupCheck{&hooks_check}
+}
+
 func DoNetcenterStartupChecks() []*StartupCheck {
 	// Check that env variables are not empty
 	var checks []*StartupCheck
@@ -100,7 +234,7 @@ func DoNetcenterStartupChecks() []*StartupCheck {
 		Name: "Run example Netcenter query",
 	}
 	checks = append(checks, &query_check)
-	_, err := netcenter.GetFreeIPv4sInSubnet(netcenter.VM_SUBNET.V4net)
+	_, err := netcenter.GetFreeIPv4sInSubnet(netcenter.Subnets()[0].V4net)
 	if err != nil {
 		query_check.AddError(fmt.Errorf("Error when performing HTTP request to Netcenter:  %v", err.Error()))
 	} else {
@@ -115,26 +249,25 @@ func DoNetcenterStartupChecks() []*StartupCheck {
 		Name: "Check availability of free IPs",
 	}
 	checks = append(checks, &ip_availability_check)
-	ipv4s, err := netcenter.GetFreeIPv4sInSubnet(netcenter.VM_SUBNET.V4net)
-	if err != nil {
-		ip_availability_check.AddError(fmt.Errorf("Error when checking availability of free IPv4s: %v", err.Error()))
-	} else {
-		msg := fmt.Sprintf("Found %d free IPv4 addresses in subnet '%v'", len(*ipv4s), netcenter.VM_SUBNET.V4net)
-		if len(*ipv4s) < 20 {
+	// A full subnet is fine as long as its net has another one with free IPs
+	free := map[string]int{}
+	for _, subnet := range netcenter.Subnets() {
+		ipv4s, ipv6s, err := netcenter.FreeIPs(subnet)
+		if err != nil {
+			ip_availability_check.AddError(fmt.Errorf("Error when checking availability of free IPs in subnet '%v': %v", subnet.Name, err.Error()))
+			continue
+		}
+		msg := fmt.Sprintf("Found %d free IPv4 addresses in subnet '%v' (%v) and %d usable IPv6 addresses in '%v'", len(ipv4s), subnet.Name, subnet.V4net, len(ipv6s), subnet.V6net)
+		if len(ipv4s) < 20 || len(ipv6s) < 20 {
 			ip_availability_check.AddWarning(msg)
 		} else {
 			ip_availability_check.AddSuccess(msg)
 		}
+		free[subnet.Net] += min(len(ipv4s), len(ipv6s))
 	}
-	ipv6, err := netcenter.GetFreeIPv6sInSubnet(netcenter.VM_SUBNET.V6net)
-	if err != nil {
-		ip_availability_check.AddError(fmt.Errorf("Error when checking availability of free IPv6s: %v", err.Error()))
-	} else {
-		msg := fmt.Sprintf("Found %d free IPv6 addresses in subnet '%v'", len(*ipv6), netcenter.VM_SUBNET.V6net)
-		if len(*ipv6) < 20 {
-			ip_availability_check.AddWarning(msg)
-		} else {
-			ip_availability_check.AddSuccess(msg)
+	for net, count := range free {
+		if count == 0 {
+			ip_availability_check.AddWarning(fmt.Sprintf("All subnets of net '%v' are full, VMs of its profiles cannot be created", net))
 		}
 	}
 
@@ -0,0 +1,20 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (t ApiToken) ToString() string {
+	line := fmt.Sprintf("Token ID: %v '%v' [%v] scopes: %v\nCreated: %v by %v", t.ID, t.Name, t.Role, strings.Join(t.Scopes, ", "), t.CreatedAt, t.Owner)
+	if t.ExpiresAt.Valid {
+		line += fmt.Sprintf("\nExpires: %v", t.ExpiresAt.Time)
+	}
+	if t.LastUsedAt.Valid {
+		line += fmt.Sprintf("\nLast used: %v", t.LastUsedAt.Time)
+	}
+	if t.RevokedAt.Valid {
+		line += fmt.Sprintf("\nRevoked: %v", t.RevokedAt.Time)
+	}
+	return line
+}
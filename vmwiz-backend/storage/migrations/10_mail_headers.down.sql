@@ -0,0 +1,2 @@
+ALTER TABLE mail_queue DROP COLUMN in_reply_to;
+ALTER TABLE mail_queue DROP COLUMN message_id;
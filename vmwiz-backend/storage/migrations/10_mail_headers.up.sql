@@ -0,0 +1,4 @@
+-- Message-ID is generated when the email is queued, so that every retry carries the same one.
+ALTER TABLE mail_queue ADD COLUMN message_id TEXT NOT NULL DEFAULT '';
+-- Message-ID of the thread root this email belongs to (e.g. all emails about one VM request).
+ALTER TABLE mail_queue ADD COLUMN in_reply_to TEXT;
@@ -0,0 +1,13 @@
+-- History of status changes and edits of VM requests, and who made them.
+CREATE TABLE request_event (
+  id          BIGSERIAL PRIMARY KEY,
+  request_id  BIGINT NOT NULL REFERENCES request(requestID) ON DELETE CASCADE,
+  created_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  actor       TEXT NOT NULL, -- email of the admin, or e.g. 'cli' / 'system'
+  from_status request_status NOT NULL,
+  to_status   request_status NOT NULL,
+  reason      TEXT, -- shown to the requester
+  admin_note  TEXT  -- internal, only shown to admins
+);
+
+CREATE INDEX idx_request_event_request ON request_event(request_id, created_at);
@@ -0,0 +1,23 @@
+UPDATE request SET requestStatus = 'rejected' WHERE requestStatus = 'withdrawn';
+UPDATE request SET requestStatus = 'accepted' WHERE requestStatus = 'decommissioned';
+DELETE FROM request_event WHERE from_status IN ('withdrawn', 'decommissioned') OR to_status IN ('withdrawn', 'decommissioned');
+
+ALTER TABLE request ALTER COLUMN requestStatus DROP DEFAULT;
+
+ALTER TYPE request_status RENAME TO status_old;
+CREATE TYPE request_status AS ENUM ('accepted', 'rejected', 'pending', 'hold');
+
+ALTER TABLE request
+  ALTER COLUMN requestStatus TYPE request_status
+  USING requestStatus::text::request_status;
+ALTER TABLE request_event
+  ALTER COLUMN from_status TYPE request_status
+  USING from_status::text::request_status;
+ALTER TABLE request_event
+  ALTER COLUMN to_status TYPE request_status
+  USING to_status::text::request_status;
+
+ALTER TABLE request
+  ALTER COLUMN requestStatus SET DEFAULT 'pending'::request_status;
+
+DROP TYPE status_old;
@@ -0,0 +1,3 @@
+-- The allowed transitions between the states are defined in storage/requeststatus.go
+ALTER TYPE request_status ADD VALUE 'withdrawn';
+ALTER TYPE request_status ADD VALUE 'decommissioned';
@@ -0,0 +1,22 @@
+UPDATE request SET requestStatus = 'accepted' WHERE requestStatus IN ('provisioning', 'provisioned', 'failed');
+DELETE FROM request_event WHERE from_status IN ('provisioning', 'provisioned', 'failed') OR to_status IN ('provisioning', 'provisioned', 'failed');
+
+ALTER TABLE request ALTER COLUMN requestStatus DROP DEFAULT;
+
+ALTER TYPE request_status RENAME TO status_old;
+CREATE TYPE request_status AS ENUM ('accepted', 'rejected', 'pending', 'hold', 'withdrawn', 'decommissioned');
+
+ALTER TABLE request
+  ALTER COLUMN requestStatus TYPE request_status
+  USING requestStatus::text::request_status;
+ALTER TABLE request_event
+  ALTER COLUMN from_status TYPE request_status
+  USING from_status::text::request_status;
+ALTER TABLE request_event
+  ALTER COLUMN to_status TYPE request_status
+  USING to_status::text::request_status;
+
+ALTER TABLE request
+  ALTER COLUMN requestStatus SET DEFAULT 'pending'::request_status;
+
+DROP TYPE status_old;
@@ -0,0 +1,4 @@
+-- New enum values can't be used in the same transaction that adds them, see 14_provisioning_details
+ALTER TYPE request_status ADD VALUE 'provisioning';
+ALTER TYPE request_status ADD VALUE 'provisioned';
+ALTER TYPE request_status ADD VALUE 'failed';
@@ -0,0 +1,6 @@
+UPDATE request SET requestStatus = 'accepted' WHERE requestStatus = 'provisioned';
+
+ALTER TABLE request DROP COLUMN ipv6;
+ALTER TABLE request DROP COLUMN ipv4;
+ALTER TABLE request DROP COLUMN node;
+ALTER TABLE request DROP COLUMN vmID;
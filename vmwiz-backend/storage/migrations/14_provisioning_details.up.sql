@@ -0,0 +1,9 @@
+-- The VM created for a request. Set once the request is provisioned.
+ALTER TABLE request ADD COLUMN vmID INT;
+ALTER TABLE request ADD COLUMN node TEXT;
+ALTER TABLE request ADD COLUMN ipv4 TEXT;
+ALTER TABLE request ADD COLUMN ipv6 TEXT;
+
+-- Before the provisioning states existed, 'accepted' meant that the VM was created
+-- Their VM is not known here, 'request link-vms' looks it up in Proxmox by hostname
+UPDATE request SET requestStatus = 'provisioned' WHERE requestStatus = 'accepted';
@@ -0,0 +1 @@
+ALTER TABLE request DROP COLUMN deletionRequestedAt;
@@ -0,0 +1,2 @@
+-- Set when the requester asks for their VM to be deleted from the self-service portal
+ALTER TABLE request ADD COLUMN deletionRequestedAt TIMESTAMP;
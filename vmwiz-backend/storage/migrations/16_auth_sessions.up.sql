@@ -0,0 +1,10 @@
+-- Login sessions, see auth.SessionStore. The ID is the hash of the secret in the session cookie.
+CREATE TABLE auth_session (
+  id TEXT PRIMARY KEY,
+  email TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  last_seen_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMP NOT NULL,
+  -- AES-GCM encrypted with SESSION_ENCRYPTION_KEY, nonce first
+  refresh_token BYTEA NOT NULL
+);
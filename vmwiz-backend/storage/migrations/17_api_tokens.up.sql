@@ -0,0 +1,15 @@
+-- Admin-issued tokens for scripts, see auth.APITokenStore. Only the SHA-256 hash of the token is stored.
+CREATE TABLE api_token (
+  id BIGSERIAL PRIMARY KEY,
+  name TEXT NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,
+  -- Who created the token
+  owner TEXT NOT NULL,
+  role TEXT NOT NULL,
+  -- Route groups the token may use, e.g. vmrequest or dns
+  scopes TEXT[] NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMP,
+  last_used_at TIMESTAMP,
+  revoked_at TIMESTAMP
+);
@@ -0,0 +1,29 @@
+-- Who did what, through the API or the CLI. Rows are never changed or deleted.
+CREATE TABLE audit_log (
+  id BIGSERIAL PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  actor TEXT NOT NULL,
+  -- e.g. vmrequest.accept
+  action TEXT NOT NULL,
+  -- Request ID, hostname, survey ID, ... the action was done on
+  target TEXT NOT NULL DEFAULT '',
+  -- Summary of the request body or CLI flags
+  payload TEXT NOT NULL DEFAULT '',
+  -- success or failure
+  result TEXT NOT NULL,
+  -- HTTP status and error message, or the error of a CLI command
+  detail TEXT NOT NULL DEFAULT '',
+  log_scope_id TEXT
+);
+
+CREATE INDEX audit_log_actor ON audit_log (actor);
+CREATE INDEX audit_log_target ON audit_log (target);
+
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
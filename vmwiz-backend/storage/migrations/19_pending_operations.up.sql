@@ -0,0 +1,20 @@
+-- Actions that need a second admin's approval before they are run (four-eyes rule), see the approval package
+CREATE TABLE pending_operation (
+  id BIGSERIAL PRIMARY KEY,
+  -- e.g. vm.delete, same names as in the audit log
+  action TEXT NOT NULL,
+  -- Hostname, survey ID, ... the action will be done on
+  target TEXT NOT NULL DEFAULT '',
+  -- Route and JSON body the action is run with once approved
+  path TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  requested_by TEXT NOT NULL,
+  requested_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMP NOT NULL,
+  -- pending, approved or rejected
+  status TEXT NOT NULL DEFAULT 'pending',
+  decided_by TEXT,
+  decided_at TIMESTAMP
+);
+
+CREATE INDEX pending_operation_status ON pending_operation (status);
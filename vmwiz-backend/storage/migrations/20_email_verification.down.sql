@@ -0,0 +1,24 @@
+DROP TABLE email_verification;
+
+DELETE FROM request WHERE requestStatus = 'unverified';
+DELETE FROM request_event WHERE from_status = 'unverified' OR to_status = 'unverified';
+
+ALTER TABLE request ALTER COLUMN requestStatus DROP DEFAULT;
+
+ALTER TYPE request_status RENAME TO status_old;
+CREATE TYPE request_status AS ENUM ('accepted', 'rejected', 'pending', 'hold', 'withdrawn', 'decommissioned', 'provisioning', 'provisioned', 'failed');
+
+ALTER TABLE request
+  ALTER COLUMN requestStatus TYPE request_status
+  USING requestStatus::text::request_status;
+ALTER TABLE request_event
+  ALTER COLUMN from_status TYPE request_status
+  USING from_status::text::request_status;
+ALTER TABLE request_event
+  ALTER COLUMN to_status TYPE request_status
+  USING to_status::text::request_status;
+
+ALTER TABLE request
+  ALTER COLUMN requestStatus SET DEFAULT 'pending'::request_status;
+
+DROP TYPE status_old;
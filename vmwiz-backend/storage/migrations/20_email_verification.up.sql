@@ -0,0 +1,13 @@
+-- Submitted requests stay unverified until the requester opened the link sent to their address(es)
+ALTER TYPE request_status ADD VALUE 'unverified';
+
+-- One verification link per address of a request. Only the SHA-256 hash of the token is stored.
+CREATE TABLE email_verification (
+  token_hash TEXT PRIMARY KEY,
+  request_id BIGINT NOT NULL REFERENCES request(requestID) ON DELETE CASCADE,
+  email TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  verified_at TIMESTAMP
+);
+
+CREATE INDEX email_verification_request ON email_verification (request_id);
@@ -0,0 +1,16 @@
+-- Per-user and per-organization exceptions to the default quotas (QUOTA_* settings), see the quota package
+CREATE TABLE quota_override (
+  -- 'user' (university email) or 'org' (organization name)
+  kind TEXT NOT NULL,
+  -- Lower case email or organization name
+  subject TEXT NOT NULL,
+  -- NULL keeps the default limit, 0 means unlimited
+  max_vms INT,
+  max_cores INT,
+  max_ramgb INT,
+  max_diskgb INT,
+  comment TEXT NOT NULL DEFAULT '',
+  updated_by TEXT NOT NULL,
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (kind, subject)
+);
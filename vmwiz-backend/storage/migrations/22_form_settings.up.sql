@@ -0,0 +1,7 @@
+-- Limits and images of the VM request form, editable by superadmins. Only one row, see form.Settings
+CREATE TABLE form_settings (
+  id INT PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+  settings JSONB NOT NULL,
+  updated_by TEXT NOT NULL,
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
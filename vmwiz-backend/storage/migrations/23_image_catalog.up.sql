@@ -0,0 +1,40 @@
+-- Images offered in the VM request form and installed by CreateVM, see the images package
+CREATE TABLE vm_image (
+  -- Shown in the form and stored on the requests
+  name TEXT PRIMARY KEY,
+  -- qcow2 file, relative to the template storage
+  path TEXT NOT NULL,
+  -- User the SSH keys are installed for
+  default_user TEXT NOT NULL,
+  -- Regex matching the console line printed once the first boot finished
+  first_boot_marker TEXT NOT NULL,
+  -- Package sources written to sources_path by the post-install script, nothing is written if empty
+  sources_path TEXT NOT NULL DEFAULT '',
+  sources TEXT NOT NULL DEFAULT '',
+  min_disk_gb INT NOT NULL DEFAULT 0,
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  -- Order in the form
+  position INT NOT NULL DEFAULT 0,
+  updated_by TEXT NOT NULL DEFAULT '',
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO vm_image (name, path, default_user, first_boot_marker, sources_path, sources, position) VALUES
+('Ubuntu 24.04 - Noble Numbat', 'cloudinit/current-noble-amd64.qcow2', 'ubuntu', 'Cloud-init .* finished', '/etc/apt/sources.list',
+'deb http://ch.archive.ubuntu.com/ubuntu noble main universe multiverse
+#deb-src http://ch.archive.ubuntu.com/ubuntu noble main universe multiverse
+
+deb http://ch.archive.ubuntu.com/ubuntu noble-updates main universe multiverse
+#deb-src http://ch.archive.ubuntu.com/ubuntu noble-updates main universe multiverse
+
+deb http://security.ubuntu.com/ubuntu noble-security main universe multiverse
+#deb-src http://security.ubuntu.com/ubuntu noble-security main universe multiverse', 0),
+('Debian 13 - Trixie', 'cloudinit/current-trixie-amd64.qcow2', 'debian', 'Cloud-init .* finished', '/etc/apt/sources.list',
+'deb http://ftp.ch.debian.org/debian trixie main
+#deb-src http://ftp.ch.debian.org/debian trixie main
+
+deb http://ftp.ch.debian.org/debian trixie-updates main
+#deb-src http://ftp.ch.debian.org/debian trixie-updates main
+
+deb http://security.debian.org/ trixie-security main
+#deb-src http://security.debian.org/ trixie-security main', 1);
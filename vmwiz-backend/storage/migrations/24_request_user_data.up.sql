@@ -0,0 +1,2 @@
+-- Optional #cloud-config snippet provided by the requester, merged into the cloud-init user-data of the VM
+ALTER TABLE request ADD COLUMN userData TEXT NOT NULL DEFAULT '';
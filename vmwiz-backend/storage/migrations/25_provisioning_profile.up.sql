@@ -0,0 +1,3 @@
+-- Provisioning profile the VM of the request is created with, see PROVISIONING_PROFILES.
+-- Existing requests get the profile of their kind when they are accepted
+ALTER TABLE request ADD COLUMN provisioningProfile TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE pending_operation DROP COLUMN requested_by_owner;
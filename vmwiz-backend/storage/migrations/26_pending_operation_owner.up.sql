@@ -0,0 +1,3 @@
+-- Person behind requested_by, e.g. the owner of the API token or the email of the CLI user (see auth.Owner).
+-- Neither of them may approve the operation
+ALTER TABLE pending_operation ADD COLUMN requested_by_owner TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE vm_image DROP COLUMN package_manager;
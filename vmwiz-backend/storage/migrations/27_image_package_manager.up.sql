@@ -0,0 +1,2 @@
+-- Package manager of the image, the post-install script installs packages and configures the system with it
+ALTER TABLE vm_image ADD COLUMN package_manager TEXT NOT NULL DEFAULT 'apt';
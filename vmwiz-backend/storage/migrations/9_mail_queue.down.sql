@@ -0,0 +1,5 @@
+ALTER TABLE survey_email DROP COLUMN IF EXISTS mail_id;
+
+DROP TABLE IF EXISTS mail_queue;
+
+DROP TYPE IF EXISTS mail_status;
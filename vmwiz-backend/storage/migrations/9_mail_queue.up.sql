@@ -0,0 +1,21 @@
+CREATE TYPE mail_status AS ENUM ('queued', 'sent', 'failed', 'cancelled');
+
+CREATE TABLE mail_queue (
+  id              BIGSERIAL PRIMARY KEY,
+  created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  status          mail_status NOT NULL DEFAULT 'queued',
+  recipients      TEXT[] NOT NULL,
+  subject         TEXT NOT NULL,
+  template        TEXT NOT NULL, -- name of the embedded template rendered at delivery time
+  template_data   JSONB NOT NULL DEFAULT '{}',
+  attempts        INT NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_attempt_at TIMESTAMP WITH TIME ZONE,
+  last_error      TEXT,
+  sent_at         TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_mail_queue_due ON mail_queue(status, next_attempt_at);
+
+-- Survey emails point at the outbox message that delivers them. email_sent is set by the mail worker on delivery.
+ALTER TABLE survey_email ADD COLUMN mail_id BIGINT REFERENCES mail_queue(id) ON DELETE SET NULL;
@@ -7,17 +7,68 @@ package storage
 import (
 	"database/sql"
 	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"time"
 )
 
+type MailStatus string
+
+const (
+	MailStatusQueued    MailStatus = "queued"
+	MailStatusSent      MailStatus = "sent"
+	MailStatusFailed    MailStatus = "failed"
+	MailStatusCancelled MailStatus = "cancelled"
+)
+
+func (e *MailStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = MailStatus(s)
+	case string:
+		*e = MailStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for MailStatus: %T", src)
+	}
+	return nil
+}
+
+type NullMailStatus struct {
+	MailStatus MailStatus
+	Valid      bool // Valid is true if MailStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullMailStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.MailStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.MailStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullMailStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.MailStatus), nil
+}
+
 type RequestStatus string
 
 const (
-	RequestStatusAccepted RequestStatus = "accepted"
-	RequestStatusRejected RequestStatus = "rejected"
-	RequestStatusPending  RequestStatus = "pending"
-	RequestStatusHold     RequestStatus = "hold"
+	RequestStatusAccepted       RequestStatus = "accepted"
+	RequestStatusRejected       RequestStatus = "rejected"
+	RequestStatusPending        RequestStatus = "pending"
+	RequestStatusHold           RequestStatus = "hold"
+	RequestStatusWithdrawn      RequestStatus = "withdrawn"
+	RequestStatusDecommissioned RequestStatus = "decommissioned"
+	RequestStatusProvisioning   RequestStatus = "provisioning"
+	RequestStatusProvisioned    RequestStatus = "provisioned"
+	RequestStatusFailed         RequestStatus = "failed"
+	RequestStatusUnverified     RequestStatus = "unverified"
 )
 
 func (e *RequestStatus) Scan(src interface{}) error {
@@ -55,6 +106,55 @@ func (ns NullRequestStatus) Value() (driver.Value, error) {
 	return string(ns.RequestStatus), nil
 }
 
+type ApiToken struct {
+	ID         int64
+	Name       string
+	TokenHash  string
+	Owner      string
+	Role       string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
+type AuditLog struct {
+	ID         int64
+	CreatedAt  time.Time
+	Actor      string
+	Action     string
+	Target     string
+	Payload    string
+	Result     string
+	Detail     string
+	LogScopeID sql.NullString
+}
+
+type AuthSession struct {
+	ID           string
+	Email        string
+	CreatedAt    time.Time
+	LastSeenAt   time.Time
+	ExpiresAt    time.Time
+	RefreshToken []byte
+}
+
+type EmailVerification struct {
+	TokenHash  string
+	RequestID  int64
+	Email      string
+	CreatedAt  time.Time
+	VerifiedAt sql.NullTime
+}
+
+type FormSetting struct {
+	ID        int32
+	Settings  json.RawMessage
+	UpdatedBy string
+	UpdatedAt time.Time
+}
+
 type LogScope struct {
 	ID        string
 	ParentID  sql.NullString
@@ -65,22 +165,84 @@ type LogScope struct {
 	Failed    bool
 }
 
+type MailQueue struct {
+	ID            int64
+	CreatedAt     time.Time
+	Status        MailStatus
+	Recipients    []string
+	Subject       string
+	Template      string
+	TemplateData  json.RawMessage
+	Attempts      int32
+	NextAttemptAt time.Time
+	LastAttemptAt sql.NullTime
+	LastError     sql.NullString
+	SentAt        sql.NullTime
+	MessageID     string
+	InReplyTo     sql.NullString
+}
+
+type PendingOperation struct {
+	ID               int64
+	Action           string
+	Target           string
+	Path             string
+	Payload          string
+	RequestedBy      string
+	RequestedAt      time.Time
+	ExpiresAt        time.Time
+	Status           string
+	DecidedBy        sql.NullString
+	DecidedAt        sql.NullTime
+	RequestedByOwner string
+}
+
+type QuotaOverride struct {
+	Kind      string
+	Subject   string
+	MaxVms    sql.NullInt32
+	MaxCores  sql.NullInt32
+	MaxRamgb  sql.NullInt32
+	MaxDiskgb sql.NullInt32
+	Comment   string
+	UpdatedBy string
+	UpdatedAt time.Time
+}
+
 type Request struct {
-	Requestid        int64
-	Requestcreatedat time.Time
-	Requeststatus    RequestStatus
-	Email            string
-	Personalemail    string
-	Isorganization   bool
-	Orgname          sql.NullString
-	Hostname         string
-	Image            string
-	Cores            int32
-	Ramgb            int32
-	Diskgb           int32
-	Sshpubkeys       []string
-	Comments         sql.NullString
-	Secondarydiskgb  int32
+	Requestid           int64
+	Requestcreatedat    time.Time
+	Requeststatus       RequestStatus
+	Email               string
+	Personalemail       string
+	Isorganization      bool
+	Orgname             sql.NullString
+	Hostname            string
+	Image               string
+	Cores               int32
+	Ramgb               int32
+	Diskgb              int32
+	Sshpubkeys          []string
+	Comments            sql.NullString
+	Secondarydiskgb     int32
+	Vmid                sql.NullInt32
+	Node                sql.NullString
+	Ipv4                sql.NullString
+	Ipv6                sql.NullString
+	Deletionrequestedat sql.NullTime
+	Userdata            string
+	Provisioningprofile string
+}
+
+type RequestEvent struct {
+	ID         int64
+	RequestID  int64
+	CreatedAt  time.Time
+	Actor      string
+	FromStatus RequestStatus
+	ToStatus   RequestStatus
+	Reason     sql.NullString
+	AdminNote  sql.NullString
 }
 
 type Survey struct {
@@ -97,4 +259,20 @@ type SurveyEmail struct {
 	Uuid      string
 	EmailSent bool
 	StillUsed sql.NullBool
+	MailID    sql.NullInt64
+}
+
+type VmImage struct {
+	Name            string
+	Path            string
+	DefaultUser     string
+	FirstBootMarker string
+	SourcesPath     string
+	Sources         string
+	MinDiskGb       int32
+	Enabled         bool
+	Position        int32
+	UpdatedBy       string
+	UpdatedAt       time.Time
+	PackageManager  string
 }
@@ -8,11 +8,107 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/lib/pq"
 )
 
+const approvePendingOperation = `-- name: ApprovePendingOperation :execrows
+UPDATE pending_operation SET status = 'approved', decided_by = $1, decided_at = NOW()
+WHERE id = $2 AND status = 'pending' AND expires_at > NOW()
+  AND requested_by <> $1 AND requested_by_owner <> $1
+`
+
+type ApprovePendingOperationParams struct {
+	Approver sql.NullString
+	ID       int64
+}
+
+// The requester can never approve their own operation
+func (q *Queries) ApprovePendingOperation(ctx context.Context, arg ApprovePendingOperationParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, approvePendingOperation, arg.Approver, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const cancelMail = `-- name: CancelMail :execrows
+UPDATE mail_queue SET status = 'cancelled', last_error = $2
+WHERE id = $1 AND status IN ('queued', 'failed')
+`
+
+type CancelMailParams struct {
+	ID        int64
+	LastError sql.NullString
+}
+
+func (q *Queries) CancelMail(ctx context.Context, arg CancelMailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cancelMail, arg.ID, arg.LastError)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const claimDueMails = `-- name: ClaimDueMails :many
+UPDATE mail_queue SET next_attempt_at = $1
+WHERE id IN (
+  SELECT id FROM mail_queue
+  WHERE status = 'queued' AND next_attempt_at <= CURRENT_TIMESTAMP
+  ORDER BY id
+  LIMIT $2
+  FOR UPDATE SKIP LOCKED
+)
+RETURNING id, created_at, status, recipients, subject, template, template_data, attempts, next_attempt_at, last_attempt_at, last_error, sent_at, message_id, in_reply_to
+`
+
+type ClaimDueMailsParams struct {
+	LeaseUntil time.Time
+	MaxResults int32
+}
+
+// Due messages, up to max_results. Their next attempt is moved to lease_until so that no other worker delivers them
+// in the meantime, the outcome of the delivery overwrites it. Messages being claimed by another worker are skipped.
+func (q *Queries) ClaimDueMails(ctx context.Context, arg ClaimDueMailsParams) ([]MailQueue, error) {
+	rows, err := q.db.QueryContext(ctx, claimDueMails, arg.LeaseUntil, arg.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []MailQueue{}
+	for rows.Next() {
+		var i MailQueue
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.Status,
+			pq.Array(&i.Recipients),
+			&i.Subject,
+			&i.Template,
+			&i.TemplateData,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.LastAttemptAt,
+			&i.LastError,
+			&i.SentAt,
+			&i.MessageID,
+			&i.InReplyTo,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const countNegativeSurveyEmails = `-- name: CountNegativeSurveyEmails :one
 SELECT COUNT(*) FROM survey_email
 WHERE surveyId = $1 AND (email_sent = TRUE AND still_used = FALSE)
@@ -25,6 +121,20 @@ func (q *Queries) CountNegativeSurveyEmails(ctx context.Context, surveyid int64)
 	return count, err
 }
 
+const countOpenVMRequestsByEmail = `-- name: CountOpenVMRequestsByEmail :one
+SELECT COUNT(*) FROM request
+WHERE lower(email) = lower($1) AND requestStatus IN ('pending', 'hold')
+`
+
+// Verified requests submitted with the given email address that were not answered yet (pending or on hold), case insensitive.
+// Unverified ones do not count, anyone can submit them for any address.
+func (q *Queries) CountOpenVMRequestsByEmail(ctx context.Context, email string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countOpenVMRequestsByEmail, email)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const countPositiveSurveyEmails = `-- name: CountPositiveSurveyEmails :one
 SELECT COUNT(*) FROM survey_email
 WHERE surveyId = $1 AND (email_sent = TRUE AND still_used = TRUE)
@@ -61,6 +171,111 @@ func (q *Queries) CountUnsentSurveyEmails(ctx context.Context, surveyid int64) (
 	return count, err
 }
 
+const countUnverifiedEmails = `-- name: CountUnverifiedEmails :one
+SELECT COUNT(*) FROM email_verification WHERE request_id = $1 AND verified_at IS NULL
+`
+
+// Addresses of the request whose link was not opened yet
+func (q *Queries) CountUnverifiedEmails(ctx context.Context, requestID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUnverifiedEmails, requestID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createAPIToken = `-- name: CreateAPIToken :one
+INSERT INTO api_token (name, token_hash, owner, role, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id
+`
+
+type CreateAPITokenParams struct {
+	Name      string
+	TokenHash string
+	Owner     string
+	Role      string
+	Scopes    []string
+	ExpiresAt sql.NullTime
+}
+
+func (q *Queries) CreateAPIToken(ctx context.Context, arg CreateAPITokenParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, createAPIToken,
+		arg.Name,
+		arg.TokenHash,
+		arg.Owner,
+		arg.Role,
+		pq.Array(arg.Scopes),
+		arg.ExpiresAt,
+	)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createAuditEntry = `-- name: CreateAuditEntry :exec
+INSERT INTO audit_log (actor, action, target, payload, result, detail, log_scope_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+`
+
+type CreateAuditEntryParams struct {
+	Actor      string
+	Action     string
+	Target     string
+	Payload    string
+	Result     string
+	Detail     string
+	LogScopeID sql.NullString
+}
+
+func (q *Queries) CreateAuditEntry(ctx context.Context, arg CreateAuditEntryParams) error {
+	_, err := q.db.ExecContext(ctx, createAuditEntry,
+		arg.Actor,
+		arg.Action,
+		arg.Target,
+		arg.Payload,
+		arg.Result,
+		arg.Detail,
+		arg.LogScopeID,
+	)
+	return err
+}
+
+const createAuthSession = `-- name: CreateAuthSession :exec
+INSERT INTO auth_session (id, email, expires_at, refresh_token) VALUES ($1, $2, $3, $4)
+`
+
+type CreateAuthSessionParams struct {
+	ID           string
+	Email        string
+	ExpiresAt    time.Time
+	RefreshToken []byte
+}
+
+func (q *Queries) CreateAuthSession(ctx context.Context, arg CreateAuthSessionParams) error {
+	_, err := q.db.ExecContext(ctx, createAuthSession,
+		arg.ID,
+		arg.Email,
+		arg.ExpiresAt,
+		arg.RefreshToken,
+	)
+	return err
+}
+
+const createEmailVerification = `-- name: CreateEmailVerification :exec
+INSERT INTO email_verification (token_hash, request_id, email) VALUES ($1, $2, $3)
+`
+
+type CreateEmailVerificationParams struct {
+	TokenHash string
+	RequestID int64
+	Email     string
+}
+
+func (q *Queries) CreateEmailVerification(ctx context.Context, arg CreateEmailVerificationParams) error {
+	_, err := q.db.ExecContext(ctx, createEmailVerification, arg.TokenHash, arg.RequestID, arg.Email)
+	return err
+}
+
 const createLogScope = `-- name: CreateLogScope :exec
 INSERT INTO log_scope (id, parent_id, root_id, label) VALUES ($1, $2, $3, $4)
 `
@@ -82,6 +297,63 @@ func (q *Queries) CreateLogScope(ctx context.Context, arg CreateLogScopeParams)
 	return err
 }
 
+const createPendingOperation = `-- name: CreatePendingOperation :one
+INSERT INTO pending_operation (action, target, path, payload, requested_by, requested_by_owner, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id
+`
+
+type CreatePendingOperationParams struct {
+	Action           string
+	Target           string
+	Path             string
+	Payload          string
+	RequestedBy      string
+	RequestedByOwner string
+	ExpiresAt        time.Time
+}
+
+func (q *Queries) CreatePendingOperation(ctx context.Context, arg CreatePendingOperationParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, createPendingOperation,
+		arg.Action,
+		arg.Target,
+		arg.Path,
+		arg.Payload,
+		arg.RequestedBy,
+		arg.RequestedByOwner,
+		arg.ExpiresAt,
+	)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createRequestEvent = `-- name: CreateRequestEvent :exec
+INSERT INTO request_event (request_id, actor, from_status, to_status, reason, admin_note)
+VALUES ($1, $2, $3, $4, $5, $6)
+`
+
+type CreateRequestEventParams struct {
+	RequestID  int64
+	Actor      string
+	FromStatus RequestStatus
+	ToStatus   RequestStatus
+	Reason     sql.NullString
+	AdminNote  sql.NullString
+}
+
+func (q *Queries) CreateRequestEvent(ctx context.Context, arg CreateRequestEventParams) error {
+	_, err := q.db.ExecContext(ctx, createRequestEvent,
+		arg.RequestID,
+		arg.Actor,
+		arg.FromStatus,
+		arg.ToStatus,
+		arg.Reason,
+		arg.AdminNote,
+	)
+	return err
+}
+
 const createSurvey = `-- name: CreateSurvey :one
 INSERT INTO survey DEFAULT VALUES RETURNING id
 `
@@ -130,26 +402,29 @@ func (q *Queries) CreateSurveyEmail(ctx context.Context, arg CreateSurveyEmailPa
 const createVMRequest = `-- name: CreateVMRequest :one
 INSERT INTO request (
   email, personalEmail, isOrganization, orgName, hostname, image,
-  cores, ramGB, diskGB, secondaryDiskGB, sshPubkeys, comments
+  cores, ramGB, diskGB, secondaryDiskGB, sshPubkeys, comments, requestStatus, userData, provisioningProfile
 ) VALUES (
-  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15
 )
 RETURNING requestID
 `
 
 type CreateVMRequestParams struct {
-	Email           string
-	Personalemail   string
-	Isorganization  bool
-	Orgname         sql.NullString
-	Hostname        string
-	Image           string
-	Cores           int32
-	Ramgb           int32
-	Diskgb          int32
-	Secondarydiskgb int32
-	Sshpubkeys      []string
-	Comments        sql.NullString
+	Email               string
+	Personalemail       string
+	Isorganization      bool
+	Orgname             sql.NullString
+	Hostname            string
+	Image               string
+	Cores               int32
+	Ramgb               int32
+	Diskgb              int32
+	Secondarydiskgb     int32
+	Sshpubkeys          []string
+	Comments            sql.NullString
+	Requeststatus       RequestStatus
+	Userdata            string
+	Provisioningprofile string
 }
 
 func (q *Queries) CreateVMRequest(ctx context.Context, arg CreateVMRequestParams) (int64, error) {
@@ -166,12 +441,94 @@ func (q *Queries) CreateVMRequest(ctx context.Context, arg CreateVMRequestParams
 		arg.Secondarydiskgb,
 		pq.Array(arg.Sshpubkeys),
 		arg.Comments,
+		arg.Requeststatus,
+		arg.Userdata,
+		arg.Provisioningprofile,
 	)
 	var requestid int64
 	err := row.Scan(&requestid)
 	return requestid, err
 }
 
+const deleteAuthSession = `-- name: DeleteAuthSession :execrows
+DELETE FROM auth_session WHERE id = $1
+`
+
+func (q *Queries) DeleteAuthSession(ctx context.Context, id string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteAuthSession, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteExpiredAuthSessions = `-- name: DeleteExpiredAuthSessions :exec
+DELETE FROM auth_session WHERE expires_at < CURRENT_TIMESTAMP
+`
+
+func (q *Queries) DeleteExpiredAuthSessions(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredAuthSessions)
+	return err
+}
+
+const deleteQuotaOverride = `-- name: DeleteQuotaOverride :execrows
+DELETE FROM quota_override WHERE kind = $1 AND subject = lower($2)
+`
+
+type DeleteQuotaOverrideParams struct {
+	Kind    string
+	Subject string
+}
+
+func (q *Queries) DeleteQuotaOverride(ctx context.Context, arg DeleteQuotaOverrideParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteQuotaOverride, arg.Kind, arg.Subject)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUnverifiedVMRequests = `-- name: DeleteUnverifiedVMRequests :execrows
+DELETE FROM request WHERE requestStatus = 'unverified' AND requestCreatedAt < $1
+`
+
+// Requests that were never verified, submitted before the given time
+func (q *Queries) DeleteUnverifiedVMRequests(ctx context.Context, requestcreatedat time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUnverifiedVMRequests, requestcreatedat)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const enqueueMail = `-- name: EnqueueMail :one
+INSERT INTO mail_queue (recipients, subject, template, template_data, message_id, in_reply_to) VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id
+`
+
+type EnqueueMailParams struct {
+	Recipients   []string
+	Subject      string
+	Template     string
+	TemplateData json.RawMessage
+	MessageID    string
+	InReplyTo    sql.NullString
+}
+
+func (q *Queries) EnqueueMail(ctx context.Context, arg EnqueueMailParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, enqueueMail,
+		pq.Array(arg.Recipients),
+		arg.Subject,
+		arg.Template,
+		arg.TemplateData,
+		arg.MessageID,
+		arg.InReplyTo,
+	)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
 const finishLogScope = `-- name: FinishLogScope :exec
 UPDATE log_scope SET ended_at = CURRENT_TIMESTAMP, failed = $2 WHERE id = $1
 `
@@ -186,6 +543,79 @@ func (q *Queries) FinishLogScope(ctx context.Context, arg FinishLogScopeParams)
 	return err
 }
 
+const getAPITokenByHash = `-- name: GetAPITokenByHash :one
+SELECT id, name, token_hash, owner, role, scopes, created_at, expires_at, last_used_at, revoked_at FROM api_token WHERE token_hash = $1
+`
+
+func (q *Queries) GetAPITokenByHash(ctx context.Context, tokenHash string) (ApiToken, error) {
+	row := q.db.QueryRowContext(ctx, getAPITokenByHash, tokenHash)
+	var i ApiToken
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.TokenHash,
+		&i.Owner,
+		&i.Role,
+		pq.Array(&i.Scopes),
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const getAuthSession = `-- name: GetAuthSession :one
+SELECT id, email, created_at, last_seen_at, expires_at, refresh_token FROM auth_session WHERE id = $1
+`
+
+func (q *Queries) GetAuthSession(ctx context.Context, id string) (AuthSession, error) {
+	row := q.db.QueryRowContext(ctx, getAuthSession, id)
+	var i AuthSession
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.CreatedAt,
+		&i.LastSeenAt,
+		&i.ExpiresAt,
+		&i.RefreshToken,
+	)
+	return i, err
+}
+
+const getEmailVerification = `-- name: GetEmailVerification :one
+SELECT token_hash, request_id, email, created_at, verified_at FROM email_verification WHERE token_hash = $1
+`
+
+func (q *Queries) GetEmailVerification(ctx context.Context, tokenHash string) (EmailVerification, error) {
+	row := q.db.QueryRowContext(ctx, getEmailVerification, tokenHash)
+	var i EmailVerification
+	err := row.Scan(
+		&i.TokenHash,
+		&i.RequestID,
+		&i.Email,
+		&i.CreatedAt,
+		&i.VerifiedAt,
+	)
+	return i, err
+}
+
+const getFormSettings = `-- name: GetFormSettings :one
+SELECT id, settings, updated_by, updated_at FROM form_settings WHERE id = 1
+`
+
+func (q *Queries) GetFormSettings(ctx context.Context) (FormSetting, error) {
+	row := q.db.QueryRowContext(ctx, getFormSettings)
+	var i FormSetting
+	err := row.Scan(
+		&i.ID,
+		&i.Settings,
+		&i.UpdatedBy,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const getLatestSurveyID = `-- name: GetLatestSurveyID :one
 SELECT id FROM survey ORDER BY date DESC LIMIT 1
 `
@@ -224,6 +654,82 @@ func (q *Queries) GetLogScopeStatus(ctx context.Context, id string) (GetLogScope
 	return i, err
 }
 
+const getMailByID = `-- name: GetMailByID :one
+SELECT id, created_at, status, recipients, subject, template, template_data, attempts, next_attempt_at, last_attempt_at, last_error, sent_at, message_id, in_reply_to FROM mail_queue WHERE id = $1
+`
+
+func (q *Queries) GetMailByID(ctx context.Context, id int64) (MailQueue, error) {
+	row := q.db.QueryRowContext(ctx, getMailByID, id)
+	var i MailQueue
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.Status,
+		pq.Array(&i.Recipients),
+		&i.Subject,
+		&i.Template,
+		&i.TemplateData,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.LastAttemptAt,
+		&i.LastError,
+		&i.SentAt,
+		&i.MessageID,
+		&i.InReplyTo,
+	)
+	return i, err
+}
+
+const getPendingOperation = `-- name: GetPendingOperation :one
+SELECT id, action, target, path, payload, requested_by, requested_at, expires_at, status, decided_by, decided_at, requested_by_owner FROM pending_operation WHERE id = $1
+`
+
+func (q *Queries) GetPendingOperation(ctx context.Context, id int64) (PendingOperation, error) {
+	row := q.db.QueryRowContext(ctx, getPendingOperation, id)
+	var i PendingOperation
+	err := row.Scan(
+		&i.ID,
+		&i.Action,
+		&i.Target,
+		&i.Path,
+		&i.Payload,
+		&i.RequestedBy,
+		&i.RequestedAt,
+		&i.ExpiresAt,
+		&i.Status,
+		&i.DecidedBy,
+		&i.DecidedAt,
+		&i.RequestedByOwner,
+	)
+	return i, err
+}
+
+const getQuotaOverride = `-- name: GetQuotaOverride :one
+SELECT kind, subject, max_vms, max_cores, max_ramgb, max_diskgb, comment, updated_by, updated_at FROM quota_override WHERE kind = $1 AND subject = lower($2)
+`
+
+type GetQuotaOverrideParams struct {
+	Kind    string
+	Subject string
+}
+
+func (q *Queries) GetQuotaOverride(ctx context.Context, arg GetQuotaOverrideParams) (QuotaOverride, error) {
+	row := q.db.QueryRowContext(ctx, getQuotaOverride, arg.Kind, arg.Subject)
+	var i QuotaOverride
+	err := row.Scan(
+		&i.Kind,
+		&i.Subject,
+		&i.MaxVms,
+		&i.MaxCores,
+		&i.MaxRamgb,
+		&i.MaxDiskgb,
+		&i.Comment,
+		&i.UpdatedBy,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const getSurveyByID = `-- name: GetSurveyByID :one
 SELECT id, date FROM survey WHERE id = $1
 `
@@ -235,8 +741,32 @@ func (q *Queries) GetSurveyByID(ctx context.Context, id int64) (Survey, error) {
 	return i, err
 }
 
+const getVMImage = `-- name: GetVMImage :one
+SELECT name, path, default_user, first_boot_marker, sources_path, sources, min_disk_gb, enabled, position, updated_by, updated_at, package_manager FROM vm_image WHERE name = $1
+`
+
+func (q *Queries) GetVMImage(ctx context.Context, name string) (VmImage, error) {
+	row := q.db.QueryRowContext(ctx, getVMImage, name)
+	var i VmImage
+	err := row.Scan(
+		&i.Name,
+		&i.Path,
+		&i.DefaultUser,
+		&i.FirstBootMarker,
+		&i.SourcesPath,
+		&i.Sources,
+		&i.MinDiskGb,
+		&i.Enabled,
+		&i.Position,
+		&i.UpdatedBy,
+		&i.UpdatedAt,
+		&i.PackageManager,
+	)
+	return i, err
+}
+
 const getVMRequestByID = `-- name: GetVMRequestByID :one
-SELECT requestid, requestcreatedat, requeststatus, email, personalemail, isorganization, orgname, hostname, image, cores, ramgb, diskgb, sshpubkeys, comments, secondarydiskgb FROM request WHERE requestID = $1
+SELECT requestid, requestcreatedat, requeststatus, email, personalemail, isorganization, orgname, hostname, image, cores, ramgb, diskgb, sshpubkeys, comments, secondarydiskgb, vmid, node, ipv4, ipv6, deletionrequestedat, userdata, provisioningprofile FROM request WHERE requestID = $1
 `
 
 func (q *Queries) GetVMRequestByID(ctx context.Context, requestid int64) (Request, error) {
@@ -258,12 +788,19 @@ func (q *Queries) GetVMRequestByID(ctx context.Context, requestid int64) (Reques
 		pq.Array(&i.Sshpubkeys),
 		&i.Comments,
 		&i.Secondarydiskgb,
+		&i.Vmid,
+		&i.Node,
+		&i.Ipv4,
+		&i.Ipv6,
+		&i.Deletionrequestedat,
+		&i.Userdata,
+		&i.Provisioningprofile,
 	)
 	return i, err
 }
 
 const getVMRequestsByHostname = `-- name: GetVMRequestsByHostname :many
-SELECT requestid, requestcreatedat, requeststatus, email, personalemail, isorganization, orgname, hostname, image, cores, ramgb, diskgb, sshpubkeys, comments, secondarydiskgb FROM request WHERE hostname = $1
+SELECT requestid, requestcreatedat, requeststatus, email, personalemail, isorganization, orgname, hostname, image, cores, ramgb, diskgb, sshpubkeys, comments, secondarydiskgb, vmid, node, ipv4, ipv6, deletionrequestedat, userdata, provisioningprofile FROM request WHERE hostname = $1
 `
 
 func (q *Queries) GetVMRequestsByHostname(ctx context.Context, hostname string) ([]Request, error) {
@@ -291,6 +828,232 @@ func (q *Queries) GetVMRequestsByHostname(ctx context.Context, hostname string)
 			pq.Array(&i.Sshpubkeys),
 			&i.Comments,
 			&i.Secondarydiskgb,
+			&i.Vmid,
+			&i.Node,
+			&i.Ipv4,
+			&i.Ipv6,
+			&i.Deletionrequestedat,
+			&i.Userdata,
+			&i.Provisioningprofile,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getVMRequestsByVMID = `-- name: GetVMRequestsByVMID :many
+SELECT requestid, requestcreatedat, requeststatus, email, personalemail, isorganization, orgname, hostname, image, cores, ramgb, diskgb, sshpubkeys, comments, secondarydiskgb, vmid, node, ipv4, ipv6, deletionrequestedat, userdata, provisioningprofile FROM request WHERE vmID = $1
+`
+
+func (q *Queries) GetVMRequestsByVMID(ctx context.Context, vmid sql.NullInt32) ([]Request, error) {
+	rows, err := q.db.QueryContext(ctx, getVMRequestsByVMID, vmid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Request{}
+	for rows.Next() {
+		var i Request
+		if err := rows.Scan(
+			&i.Requestid,
+			&i.Requestcreatedat,
+			&i.Requeststatus,
+			&i.Email,
+			&i.Personalemail,
+			&i.Isorganization,
+			&i.Orgname,
+			&i.Hostname,
+			&i.Image,
+			&i.Cores,
+			&i.Ramgb,
+			&i.Diskgb,
+			pq.Array(&i.Sshpubkeys),
+			&i.Comments,
+			&i.Secondarydiskgb,
+			&i.Vmid,
+			&i.Node,
+			&i.Ipv4,
+			&i.Ipv6,
+			&i.Deletionrequestedat,
+			&i.Userdata,
+			&i.Provisioningprofile,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAPITokens = `-- name: ListAPITokens :many
+SELECT id, name, token_hash, owner, role, scopes, created_at, expires_at, last_used_at, revoked_at FROM api_token ORDER BY id
+`
+
+func (q *Queries) ListAPITokens(ctx context.Context) ([]ApiToken, error) {
+	rows, err := q.db.QueryContext(ctx, listAPITokens)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ApiToken{}
+	for rows.Next() {
+		var i ApiToken
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.TokenHash,
+			&i.Owner,
+			&i.Role,
+			pq.Array(&i.Scopes),
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAllRequestEvents = `-- name: ListAllRequestEvents :many
+SELECT id, request_id, created_at, actor, from_status, to_status, reason, admin_note FROM request_event ORDER BY request_id, created_at, id
+`
+
+func (q *Queries) ListAllRequestEvents(ctx context.Context) ([]RequestEvent, error) {
+	rows, err := q.db.QueryContext(ctx, listAllRequestEvents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []RequestEvent{}
+	for rows.Next() {
+		var i RequestEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.RequestID,
+			&i.CreatedAt,
+			&i.Actor,
+			&i.FromStatus,
+			&i.ToStatus,
+			&i.Reason,
+			&i.AdminNote,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditEntries = `-- name: ListAuditEntries :many
+SELECT id, created_at, actor, action, target, payload, result, detail, log_scope_id FROM audit_log
+WHERE ($1::text IS NULL OR actor = $1)
+  AND ($2::text IS NULL OR action = $2)
+  AND ($3::text IS NULL OR target = $3)
+  AND ($4::timestamp IS NULL OR created_at >= $4)
+  AND ($5::timestamp IS NULL OR created_at < $5)
+ORDER BY id DESC
+LIMIT $6
+`
+
+type ListAuditEntriesParams struct {
+	Actor      sql.NullString
+	Action     sql.NullString
+	Target     sql.NullString
+	Since      sql.NullTime
+	Until      sql.NullTime
+	MaxEntries int32
+}
+
+// Newest first. Filters that are NULL are ignored.
+func (q *Queries) ListAuditEntries(ctx context.Context, arg ListAuditEntriesParams) ([]AuditLog, error) {
+	rows, err := q.db.QueryContext(ctx, listAuditEntries,
+		arg.Actor,
+		arg.Action,
+		arg.Target,
+		arg.Since,
+		arg.Until,
+		arg.MaxEntries,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []AuditLog{}
+	for rows.Next() {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.Actor,
+			&i.Action,
+			&i.Target,
+			&i.Payload,
+			&i.Result,
+			&i.Detail,
+			&i.LogScopeID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuthSessions = `-- name: ListAuthSessions :many
+SELECT id, email, created_at, last_seen_at, expires_at, refresh_token FROM auth_session ORDER BY created_at
+`
+
+func (q *Queries) ListAuthSessions(ctx context.Context) ([]AuthSession, error) {
+	rows, err := q.db.QueryContext(ctx, listAuthSessions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []AuthSession{}
+	for rows.Next() {
+		var i AuthSession
+		if err := rows.Scan(
+			&i.ID,
+			&i.Email,
+			&i.CreatedAt,
+			&i.LastSeenAt,
+			&i.ExpiresAt,
+			&i.RefreshToken,
 		); err != nil {
 			return nil, err
 		}
@@ -362,7 +1125,254 @@ func (q *Queries) ListLogScopeSubtreeIDs(ctx context.Context, id string) ([]stri
 		if err := rows.Scan(&id); err != nil {
 			return nil, err
 		}
-		items = append(items, id)
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMails = `-- name: ListMails :many
+SELECT id, created_at, status, recipients, subject, template, template_data, attempts, next_attempt_at, last_attempt_at, last_error, sent_at, message_id, in_reply_to FROM mail_queue
+WHERE $1::text = '' OR status::text = $1::text
+ORDER BY id DESC
+LIMIT $2
+`
+
+type ListMailsParams struct {
+	Status     string
+	MaxResults int32
+}
+
+// The most recent messages, only those with the given status unless it is ”
+func (q *Queries) ListMails(ctx context.Context, arg ListMailsParams) ([]MailQueue, error) {
+	rows, err := q.db.QueryContext(ctx, listMails, arg.Status, arg.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []MailQueue{}
+	for rows.Next() {
+		var i MailQueue
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.Status,
+			pq.Array(&i.Recipients),
+			&i.Subject,
+			&i.Template,
+			&i.TemplateData,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.LastAttemptAt,
+			&i.LastError,
+			&i.SentAt,
+			&i.MessageID,
+			&i.InReplyTo,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listNegativeSurveyHostnames = `-- name: ListNegativeSurveyHostnames :many
+SELECT hostname FROM survey_email
+WHERE surveyId = $1 AND (email_sent = TRUE AND still_used = FALSE)
+`
+
+func (q *Queries) ListNegativeSurveyHostnames(ctx context.Context, surveyid int64) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listNegativeSurveyHostnames, surveyid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []string{}
+	for rows.Next() {
+		var hostname string
+		if err := rows.Scan(&hostname); err != nil {
+			return nil, err
+		}
+		items = append(items, hostname)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPendingOperations = `-- name: ListPendingOperations :many
+SELECT id, action, target, path, payload, requested_by, requested_at, expires_at, status, decided_by, decided_at, requested_by_owner FROM pending_operation
+WHERE $1::bool OR (status = 'pending' AND expires_at > NOW())
+ORDER BY id DESC
+LIMIT $2
+`
+
+type ListPendingOperationsParams struct {
+	IncludeDecided bool
+	MaxEntries     int32
+}
+
+// Newest first. Unless include_decided, only the ones still waiting for a decision are returned.
+func (q *Queries) ListPendingOperations(ctx context.Context, arg ListPendingOperationsParams) ([]PendingOperation, error) {
+	rows, err := q.db.QueryContext(ctx, listPendingOperations, arg.IncludeDecided, arg.MaxEntries)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []PendingOperation{}
+	for rows.Next() {
+		var i PendingOperation
+		if err := rows.Scan(
+			&i.ID,
+			&i.Action,
+			&i.Target,
+			&i.Path,
+			&i.Payload,
+			&i.RequestedBy,
+			&i.RequestedAt,
+			&i.ExpiresAt,
+			&i.Status,
+			&i.DecidedBy,
+			&i.DecidedAt,
+			&i.RequestedByOwner,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPositiveSurveyHostnames = `-- name: ListPositiveSurveyHostnames :many
+SELECT hostname FROM survey_email
+WHERE surveyId = $1 AND (email_sent = TRUE AND still_used = TRUE)
+`
+
+func (q *Queries) ListPositiveSurveyHostnames(ctx context.Context, surveyid int64) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listPositiveSurveyHostnames, surveyid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []string{}
+	for rows.Next() {
+		var hostname string
+		if err := rows.Scan(&hostname); err != nil {
+			return nil, err
+		}
+		items = append(items, hostname)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listQuotaOverrides = `-- name: ListQuotaOverrides :many
+SELECT kind, subject, max_vms, max_cores, max_ramgb, max_diskgb, comment, updated_by, updated_at FROM quota_override ORDER BY kind, subject
+`
+
+func (q *Queries) ListQuotaOverrides(ctx context.Context) ([]QuotaOverride, error) {
+	rows, err := q.db.QueryContext(ctx, listQuotaOverrides)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []QuotaOverride{}
+	for rows.Next() {
+		var i QuotaOverride
+		if err := rows.Scan(
+			&i.Kind,
+			&i.Subject,
+			&i.MaxVms,
+			&i.MaxCores,
+			&i.MaxRamgb,
+			&i.MaxDiskgb,
+			&i.Comment,
+			&i.UpdatedBy,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listQuotaVMRequests = `-- name: ListQuotaVMRequests :many
+SELECT requestid, requestcreatedat, requeststatus, email, personalemail, isorganization, orgname, hostname, image, cores, ramgb, diskgb, sshpubkeys, comments, secondarydiskgb, vmid, node, ipv4, ipv6, deletionrequestedat, userdata, provisioningprofile FROM request
+WHERE requestStatus IN ('pending', 'hold', 'accepted', 'provisioning', 'provisioned')
+ORDER BY requestID
+`
+
+// Requests that count towards the quotas: verified and not answered yet, accepted or provisioned.
+// Unverified ones do not, anyone can submit them for any address or organization.
+func (q *Queries) ListQuotaVMRequests(ctx context.Context) ([]Request, error) {
+	rows, err := q.db.QueryContext(ctx, listQuotaVMRequests)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Request{}
+	for rows.Next() {
+		var i Request
+		if err := rows.Scan(
+			&i.Requestid,
+			&i.Requestcreatedat,
+			&i.Requeststatus,
+			&i.Email,
+			&i.Personalemail,
+			&i.Isorganization,
+			&i.Orgname,
+			&i.Hostname,
+			&i.Image,
+			&i.Cores,
+			&i.Ramgb,
+			&i.Diskgb,
+			pq.Array(&i.Sshpubkeys),
+			&i.Comments,
+			&i.Secondarydiskgb,
+			&i.Vmid,
+			&i.Node,
+			&i.Ipv4,
+			&i.Ipv6,
+			&i.Deletionrequestedat,
+			&i.Userdata,
+			&i.Provisioningprofile,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
 	}
 	if err := rows.Close(); err != nil {
 		return nil, err
@@ -373,24 +1383,32 @@ func (q *Queries) ListLogScopeSubtreeIDs(ctx context.Context, id string) ([]stri
 	return items, nil
 }
 
-const listNegativeSurveyHostnames = `-- name: ListNegativeSurveyHostnames :many
-SELECT hostname FROM survey_email
-WHERE surveyId = $1 AND (email_sent = TRUE AND still_used = FALSE)
+const listRequestEvents = `-- name: ListRequestEvents :many
+SELECT id, request_id, created_at, actor, from_status, to_status, reason, admin_note FROM request_event WHERE request_id = $1 ORDER BY created_at, id
 `
 
-func (q *Queries) ListNegativeSurveyHostnames(ctx context.Context, surveyid int64) ([]string, error) {
-	rows, err := q.db.QueryContext(ctx, listNegativeSurveyHostnames, surveyid)
+func (q *Queries) ListRequestEvents(ctx context.Context, requestID int64) ([]RequestEvent, error) {
+	rows, err := q.db.QueryContext(ctx, listRequestEvents, requestID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []string{}
+	items := []RequestEvent{}
 	for rows.Next() {
-		var hostname string
-		if err := rows.Scan(&hostname); err != nil {
+		var i RequestEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.RequestID,
+			&i.CreatedAt,
+			&i.Actor,
+			&i.FromStatus,
+			&i.ToStatus,
+			&i.Reason,
+			&i.AdminNote,
+		); err != nil {
 			return nil, err
 		}
-		items = append(items, hostname)
+		items = append(items, i)
 	}
 	if err := rows.Close(); err != nil {
 		return nil, err
@@ -401,24 +1419,38 @@ func (q *Queries) ListNegativeSurveyHostnames(ctx context.Context, surveyid int6
 	return items, nil
 }
 
-const listPositiveSurveyHostnames = `-- name: ListPositiveSurveyHostnames :many
-SELECT hostname FROM survey_email
-WHERE surveyId = $1 AND (email_sent = TRUE AND still_used = TRUE)
+const listRetryableSurveyEmails = `-- name: ListRetryableSurveyEmails :many
+SELECT survey_email.id, survey_email.recipient, survey_email.surveyid, survey_email.vmid, survey_email.hostname, survey_email.uuid, survey_email.email_sent, survey_email.still_used, survey_email.mail_id FROM survey_email
+LEFT JOIN mail_queue ON mail_queue.id = survey_email.mail_id
+WHERE survey_email.surveyId = $1
+  AND survey_email.email_sent = FALSE
+  AND (mail_queue.id IS NULL OR mail_queue.status IN ('failed', 'cancelled'))
 `
 
-func (q *Queries) ListPositiveSurveyHostnames(ctx context.Context, surveyid int64) ([]string, error) {
-	rows, err := q.db.QueryContext(ctx, listPositiveSurveyHostnames, surveyid)
+// Unsent survey emails that are not already waiting in the mail queue.
+func (q *Queries) ListRetryableSurveyEmails(ctx context.Context, surveyid int64) ([]SurveyEmail, error) {
+	rows, err := q.db.QueryContext(ctx, listRetryableSurveyEmails, surveyid)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []string{}
+	items := []SurveyEmail{}
 	for rows.Next() {
-		var hostname string
-		if err := rows.Scan(&hostname); err != nil {
+		var i SurveyEmail
+		if err := rows.Scan(
+			&i.ID,
+			&i.Recipient,
+			&i.Surveyid,
+			&i.Vmid,
+			&i.Hostname,
+			&i.Uuid,
+			&i.EmailSent,
+			&i.StillUsed,
+			&i.MailID,
+		); err != nil {
 			return nil, err
 		}
-		items = append(items, hostname)
+		items = append(items, i)
 	}
 	if err := rows.Close(); err != nil {
 		return nil, err
@@ -477,7 +1509,7 @@ func (q *Queries) ListRootLogScopes(ctx context.Context, arg ListRootLogScopesPa
 }
 
 const listSentUnansweredSurveyEmails = `-- name: ListSentUnansweredSurveyEmails :many
-SELECT id, recipient, surveyid, vmid, hostname, uuid, email_sent, still_used FROM survey_email
+SELECT id, recipient, surveyid, vmid, hostname, uuid, email_sent, still_used, mail_id FROM survey_email
 WHERE surveyId = $1 AND (still_used IS NULL AND email_sent = TRUE)
 `
 
@@ -499,6 +1531,7 @@ func (q *Queries) ListSentUnansweredSurveyEmails(ctx context.Context, surveyid i
 			&i.Uuid,
 			&i.EmailSent,
 			&i.StillUsed,
+			&i.MailID,
 		); err != nil {
 			return nil, err
 		}
@@ -568,7 +1601,7 @@ func (q *Queries) ListSurveys(ctx context.Context) ([]Survey, error) {
 }
 
 const listUnansweredOrUnsentSurveyEmails = `-- name: ListUnansweredOrUnsentSurveyEmails :many
-SELECT id, recipient, surveyid, vmid, hostname, uuid, email_sent, still_used FROM survey_email
+SELECT id, recipient, surveyid, vmid, hostname, uuid, email_sent, still_used, mail_id FROM survey_email
 WHERE surveyId = $1 AND (still_used IS NULL OR email_sent = FALSE)
 `
 
@@ -590,6 +1623,7 @@ func (q *Queries) ListUnansweredOrUnsentSurveyEmails(ctx context.Context, survey
 			&i.Uuid,
 			&i.EmailSent,
 			&i.StillUsed,
+			&i.MailID,
 		); err != nil {
 			return nil, err
 		}
@@ -632,33 +1666,24 @@ func (q *Queries) ListUnansweredSurveyHostnames(ctx context.Context, surveyid in
 	return items, nil
 }
 
-const listUnsentSurveyEmails = `-- name: ListUnsentSurveyEmails :many
-SELECT id, recipient, surveyid, vmid, hostname, uuid, email_sent, still_used FROM survey_email
-WHERE surveyId = $1 AND (email_sent = FALSE)
+const listUnsentSurveyHostnames = `-- name: ListUnsentSurveyHostnames :many
+SELECT hostname FROM survey_email
+WHERE surveyId = $1 AND email_sent = FALSE
 `
 
-func (q *Queries) ListUnsentSurveyEmails(ctx context.Context, surveyid int64) ([]SurveyEmail, error) {
-	rows, err := q.db.QueryContext(ctx, listUnsentSurveyEmails, surveyid)
+func (q *Queries) ListUnsentSurveyHostnames(ctx context.Context, surveyid int64) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listUnsentSurveyHostnames, surveyid)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []SurveyEmail{}
+	items := []string{}
 	for rows.Next() {
-		var i SurveyEmail
-		if err := rows.Scan(
-			&i.ID,
-			&i.Recipient,
-			&i.Surveyid,
-			&i.Vmid,
-			&i.Hostname,
-			&i.Uuid,
-			&i.EmailSent,
-			&i.StillUsed,
-		); err != nil {
+		var hostname string
+		if err := rows.Scan(&hostname); err != nil {
 			return nil, err
 		}
-		items = append(items, i)
+		items = append(items, hostname)
 	}
 	if err := rows.Close(); err != nil {
 		return nil, err
@@ -669,24 +1694,36 @@ func (q *Queries) ListUnsentSurveyEmails(ctx context.Context, surveyid int64) ([
 	return items, nil
 }
 
-const listUnsentSurveyHostnames = `-- name: ListUnsentSurveyHostnames :many
-SELECT hostname FROM survey_email
-WHERE surveyId = $1 AND email_sent = FALSE
+const listVMImages = `-- name: ListVMImages :many
+SELECT name, path, default_user, first_boot_marker, sources_path, sources, min_disk_gb, enabled, position, updated_by, updated_at, package_manager FROM vm_image ORDER BY position, name
 `
 
-func (q *Queries) ListUnsentSurveyHostnames(ctx context.Context, surveyid int64) ([]string, error) {
-	rows, err := q.db.QueryContext(ctx, listUnsentSurveyHostnames, surveyid)
+func (q *Queries) ListVMImages(ctx context.Context) ([]VmImage, error) {
+	rows, err := q.db.QueryContext(ctx, listVMImages)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []string{}
+	items := []VmImage{}
 	for rows.Next() {
-		var hostname string
-		if err := rows.Scan(&hostname); err != nil {
+		var i VmImage
+		if err := rows.Scan(
+			&i.Name,
+			&i.Path,
+			&i.DefaultUser,
+			&i.FirstBootMarker,
+			&i.SourcesPath,
+			&i.Sources,
+			&i.MinDiskGb,
+			&i.Enabled,
+			&i.Position,
+			&i.UpdatedBy,
+			&i.UpdatedAt,
+			&i.PackageManager,
+		); err != nil {
 			return nil, err
 		}
-		items = append(items, hostname)
+		items = append(items, i)
 	}
 	if err := rows.Close(); err != nil {
 		return nil, err
@@ -698,7 +1735,7 @@ func (q *Queries) ListUnsentSurveyHostnames(ctx context.Context, surveyid int64)
 }
 
 const listVMRequests = `-- name: ListVMRequests :many
-SELECT requestid, requestcreatedat, requeststatus, email, personalemail, isorganization, orgname, hostname, image, cores, ramgb, diskgb, sshpubkeys, comments, secondarydiskgb FROM request ORDER BY requestID
+SELECT requestid, requestcreatedat, requeststatus, email, personalemail, isorganization, orgname, hostname, image, cores, ramgb, diskgb, sshpubkeys, comments, secondarydiskgb, vmid, node, ipv4, ipv6, deletionrequestedat, userdata, provisioningprofile FROM request ORDER BY requestID
 `
 
 func (q *Queries) ListVMRequests(ctx context.Context) ([]Request, error) {
@@ -726,6 +1763,64 @@ func (q *Queries) ListVMRequests(ctx context.Context) ([]Request, error) {
 			pq.Array(&i.Sshpubkeys),
 			&i.Comments,
 			&i.Secondarydiskgb,
+			&i.Vmid,
+			&i.Node,
+			&i.Ipv4,
+			&i.Ipv6,
+			&i.Deletionrequestedat,
+			&i.Userdata,
+			&i.Provisioningprofile,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listVMRequestsByEmail = `-- name: ListVMRequestsByEmail :many
+SELECT requestid, requestcreatedat, requeststatus, email, personalemail, isorganization, orgname, hostname, image, cores, ramgb, diskgb, sshpubkeys, comments, secondarydiskgb, vmid, node, ipv4, ipv6, deletionrequestedat, userdata, provisioningprofile FROM request WHERE lower(email) = lower($1) ORDER BY requestID
+`
+
+// Requests submitted with the given (institutional) email address, case insensitive.
+func (q *Queries) ListVMRequestsByEmail(ctx context.Context, email string) ([]Request, error) {
+	rows, err := q.db.QueryContext(ctx, listVMRequestsByEmail, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Request{}
+	for rows.Next() {
+		var i Request
+		if err := rows.Scan(
+			&i.Requestid,
+			&i.Requestcreatedat,
+			&i.Requeststatus,
+			&i.Email,
+			&i.Personalemail,
+			&i.Isorganization,
+			&i.Orgname,
+			&i.Hostname,
+			&i.Image,
+			&i.Cores,
+			&i.Ramgb,
+			&i.Diskgb,
+			pq.Array(&i.Sshpubkeys),
+			&i.Comments,
+			&i.Secondarydiskgb,
+			&i.Vmid,
+			&i.Node,
+			&i.Ipv4,
+			&i.Ipv6,
+			&i.Deletionrequestedat,
+			&i.Userdata,
+			&i.Provisioningprofile,
 		); err != nil {
 			return nil, err
 		}
@@ -740,12 +1835,275 @@ func (q *Queries) ListVMRequests(ctx context.Context) ([]Request, error) {
 	return items, nil
 }
 
-const markSurveyEmailSent = `-- name: MarkSurveyEmailSent :exec
-UPDATE survey_email SET email_sent = TRUE WHERE uuid = $1
+const markMailAttemptFailed = `-- name: MarkMailAttemptFailed :exec
+UPDATE mail_queue SET
+  status = $2,
+  attempts = attempts + 1,
+  last_attempt_at = CURRENT_TIMESTAMP,
+  last_error = $3,
+  next_attempt_at = $4
+WHERE id = $1
+`
+
+type MarkMailAttemptFailedParams struct {
+	ID            int64
+	Status        MailStatus
+	LastError     sql.NullString
+	NextAttemptAt time.Time
+}
+
+func (q *Queries) MarkMailAttemptFailed(ctx context.Context, arg MarkMailAttemptFailedParams) error {
+	_, err := q.db.ExecContext(ctx, markMailAttemptFailed,
+		arg.ID,
+		arg.Status,
+		arg.LastError,
+		arg.NextAttemptAt,
+	)
+	return err
+}
+
+const markMailSent = `-- name: MarkMailSent :exec
+UPDATE mail_queue SET
+  status = 'sent',
+  attempts = attempts + 1,
+  last_attempt_at = CURRENT_TIMESTAMP,
+  sent_at = CURRENT_TIMESTAMP,
+  last_error = NULL
+WHERE id = $1
+`
+
+func (q *Queries) MarkMailSent(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, markMailSent, id)
+	return err
+}
+
+const markSurveyEmailSentByMailID = `-- name: MarkSurveyEmailSentByMailID :exec
+UPDATE survey_email SET email_sent = TRUE WHERE mail_id = $1
+`
+
+func (q *Queries) MarkSurveyEmailSentByMailID(ctx context.Context, mailID sql.NullInt64) error {
+	_, err := q.db.ExecContext(ctx, markSurveyEmailSentByMailID, mailID)
+	return err
+}
+
+const rejectPendingOperation = `-- name: RejectPendingOperation :execrows
+UPDATE pending_operation SET status = 'rejected', decided_by = $2, decided_at = NOW()
+WHERE id = $1 AND status = 'pending'
+`
+
+type RejectPendingOperationParams struct {
+	ID        int64
+	DecidedBy sql.NullString
+}
+
+func (q *Queries) RejectPendingOperation(ctx context.Context, arg RejectPendingOperationParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rejectPendingOperation, arg.ID, arg.DecidedBy)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const requestVMDeletion = `-- name: RequestVMDeletion :execrows
+UPDATE request SET deletionRequestedAt = NOW()
+WHERE requestID = $1 AND requestStatus = 'provisioned' AND deletionRequestedAt IS NULL
+`
+
+// Only provisioned requests can ask for deletion, and only once.
+func (q *Queries) RequestVMDeletion(ctx context.Context, requestid int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, requestVMDeletion, requestid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const retryMail = `-- name: RetryMail :execrows
+UPDATE mail_queue SET status = 'queued', next_attempt_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND status <> 'sent'
+`
+
+func (q *Queries) RetryMail(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, retryMail, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeAPIToken = `-- name: RevokeAPIToken :execrows
+UPDATE api_token SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAPIToken(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeAPIToken, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const saveFormSettings = `-- name: SaveFormSettings :exec
+INSERT INTO form_settings (id, settings, updated_by) VALUES (1, $1, $2)
+ON CONFLICT (id) DO UPDATE SET settings = EXCLUDED.settings, updated_by = EXCLUDED.updated_by, updated_at = NOW()
+`
+
+type SaveFormSettingsParams struct {
+	Settings  json.RawMessage
+	UpdatedBy string
+}
+
+func (q *Queries) SaveFormSettings(ctx context.Context, arg SaveFormSettingsParams) error {
+	_, err := q.db.ExecContext(ctx, saveFormSettings, arg.Settings, arg.UpdatedBy)
+	return err
+}
+
+const saveVMImage = `-- name: SaveVMImage :exec
+INSERT INTO vm_image (name, path, default_user, first_boot_marker, sources_path, sources, min_disk_gb, enabled, position, updated_by, package_manager)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+ON CONFLICT (name) DO UPDATE SET
+  path = EXCLUDED.path,
+  default_user = EXCLUDED.default_user,
+  first_boot_marker = EXCLUDED.first_boot_marker,
+  sources_path = EXCLUDED.sources_path,
+  sources = EXCLUDED.sources,
+  min_disk_gb = EXCLUDED.min_disk_gb,
+  enabled = EXCLUDED.enabled,
+  position = EXCLUDED.position,
+  updated_by = EXCLUDED.updated_by,
+  package_manager = EXCLUDED.package_manager,
+  updated_at = NOW()
+`
+
+type SaveVMImageParams struct {
+	Name            string
+	Path            string
+	DefaultUser     string
+	FirstBootMarker string
+	SourcesPath     string
+	Sources         string
+	MinDiskGb       int32
+	Enabled         bool
+	Position        int32
+	UpdatedBy       string
+	PackageManager  string
+}
+
+func (q *Queries) SaveVMImage(ctx context.Context, arg SaveVMImageParams) error {
+	_, err := q.db.ExecContext(ctx, saveVMImage,
+		arg.Name,
+		arg.Path,
+		arg.DefaultUser,
+		arg.FirstBootMarker,
+		arg.SourcesPath,
+		arg.Sources,
+		arg.MinDiskGb,
+		arg.Enabled,
+		arg.Position,
+		arg.UpdatedBy,
+		arg.PackageManager,
+	)
+	return err
+}
+
+const setEmailVerified = `-- name: SetEmailVerified :exec
+UPDATE email_verification SET verified_at = NOW() WHERE token_hash = $1 AND verified_at IS NULL
+`
+
+func (q *Queries) SetEmailVerified(ctx context.Context, tokenHash string) error {
+	_, err := q.db.ExecContext(ctx, setEmailVerified, tokenHash)
+	return err
+}
+
+const setQuotaOverride = `-- name: SetQuotaOverride :exec
+INSERT INTO quota_override (kind, subject, max_vms, max_cores, max_ramgb, max_diskgb, comment, updated_by)
+VALUES (
+  $1, lower($2), $3, $4, $5, $6,
+  $7, $8
+)
+ON CONFLICT (kind, subject) DO UPDATE SET
+  max_vms = EXCLUDED.max_vms,
+  max_cores = EXCLUDED.max_cores,
+  max_ramgb = EXCLUDED.max_ramgb,
+  max_diskgb = EXCLUDED.max_diskgb,
+  comment = EXCLUDED.comment,
+  updated_by = EXCLUDED.updated_by,
+  updated_at = NOW()
+`
+
+type SetQuotaOverrideParams struct {
+	Kind      string
+	Subject   string
+	MaxVms    sql.NullInt32
+	MaxCores  sql.NullInt32
+	MaxRamgb  sql.NullInt32
+	MaxDiskgb sql.NullInt32
+	Comment   string
+	UpdatedBy string
+}
+
+func (q *Queries) SetQuotaOverride(ctx context.Context, arg SetQuotaOverrideParams) error {
+	_, err := q.db.ExecContext(ctx, setQuotaOverride,
+		arg.Kind,
+		arg.Subject,
+		arg.MaxVms,
+		arg.MaxCores,
+		arg.MaxRamgb,
+		arg.MaxDiskgb,
+		arg.Comment,
+		arg.UpdatedBy,
+	)
+	return err
+}
+
+const setSurveyEmailMailID = `-- name: SetSurveyEmailMailID :exec
+UPDATE survey_email SET mail_id = $2 WHERE uuid = $1
+`
+
+type SetSurveyEmailMailIDParams struct {
+	Uuid   string
+	MailID sql.NullInt64
+}
+
+func (q *Queries) SetSurveyEmailMailID(ctx context.Context, arg SetSurveyEmailMailIDParams) error {
+	_, err := q.db.ExecContext(ctx, setSurveyEmailMailID, arg.Uuid, arg.MailID)
+	return err
+}
+
+const setVMRequestProvisioningProfile = `-- name: SetVMRequestProvisioningProfile :exec
+UPDATE request SET provisioningProfile = $2 WHERE requestID = $1
+`
+
+type SetVMRequestProvisioningProfileParams struct {
+	Requestid           int64
+	Provisioningprofile string
+}
+
+func (q *Queries) SetVMRequestProvisioningProfile(ctx context.Context, arg SetVMRequestProvisioningProfileParams) error {
+	_, err := q.db.ExecContext(ctx, setVMRequestProvisioningProfile, arg.Requestid, arg.Provisioningprofile)
+	return err
+}
+
+const setVMRequestVM = `-- name: SetVMRequestVM :exec
+UPDATE request SET vmID = $2, node = $3, ipv4 = $4, ipv6 = $5 WHERE requestID = $1
 `
 
-func (q *Queries) MarkSurveyEmailSent(ctx context.Context, uuid string) error {
-	_, err := q.db.ExecContext(ctx, markSurveyEmailSent, uuid)
+type SetVMRequestVMParams struct {
+	Requestid int64
+	Vmid      sql.NullInt32
+	Node      sql.NullString
+	Ipv4      sql.NullString
+	Ipv6      sql.NullString
+}
+
+func (q *Queries) SetVMRequestVM(ctx context.Context, arg SetVMRequestVMParams) error {
+	_, err := q.db.ExecContext(ctx, setVMRequestVM,
+		arg.Requestid,
+		arg.Vmid,
+		arg.Node,
+		arg.Ipv4,
+		arg.Ipv6,
+	)
 	return err
 }
 
@@ -760,6 +2118,53 @@ func (q *Queries) SurveyEmailExistsByUUID(ctx context.Context, uuid string) (boo
 	return exists, err
 }
 
+const touchAPIToken = `-- name: TouchAPIToken :exec
+UPDATE api_token SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1
+`
+
+func (q *Queries) TouchAPIToken(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, touchAPIToken, id)
+	return err
+}
+
+const transitionVMRequestStatus = `-- name: TransitionVMRequestStatus :execrows
+UPDATE request SET requestStatus = $1
+WHERE requestID = $2 AND requestStatus = $3
+`
+
+type TransitionVMRequestStatusParams struct {
+	ToStatus   RequestStatus
+	Requestid  int64
+	FromStatus RequestStatus
+}
+
+// Only updates the status if it is still from_status, so that concurrent transitions can't both succeed.
+func (q *Queries) TransitionVMRequestStatus(ctx context.Context, arg TransitionVMRequestStatusParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, transitionVMRequestStatus, arg.ToStatus, arg.Requestid, arg.FromStatus)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateAuthSession = `-- name: UpdateAuthSession :execrows
+UPDATE auth_session SET refresh_token = $2, expires_at = $3, last_seen_at = CURRENT_TIMESTAMP WHERE id = $1
+`
+
+type UpdateAuthSessionParams struct {
+	ID           string
+	RefreshToken []byte
+	ExpiresAt    time.Time
+}
+
+func (q *Queries) UpdateAuthSession(ctx context.Context, arg UpdateAuthSessionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateAuthSession, arg.ID, arg.RefreshToken, arg.ExpiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const updateSurveyEmailResponse = `-- name: UpdateSurveyEmailResponse :exec
 UPDATE survey_email SET still_used = $2 WHERE uuid = $1
 `
@@ -774,10 +2179,9 @@ func (q *Queries) UpdateSurveyEmailResponse(ctx context.Context, arg UpdateSurve
 	return err
 }
 
-const updateVMRequest = `-- name: UpdateVMRequest :exec
+const updateVMRequest = `-- name: UpdateVMRequest :execrows
 UPDATE request SET
   requestCreatedAt = $2,
-  requestStatus = $3,
   email = $4,
   personalEmail = $5,
   isOrganization = $6,
@@ -789,30 +2193,33 @@ UPDATE request SET
   diskGB = $12,
   secondaryDiskGB = $13,
   sshPubkeys = $14,
-  comments = $15
-WHERE requestID = $1
+  comments = $15,
+  provisioningProfile = $16
+WHERE requestID = $1 AND requestStatus = $3
 `
 
 type UpdateVMRequestParams struct {
-	Requestid        int64
-	Requestcreatedat time.Time
-	Requeststatus    RequestStatus
-	Email            string
-	Personalemail    string
-	Isorganization   bool
-	Orgname          sql.NullString
-	Hostname         string
-	Image            string
-	Cores            int32
-	Ramgb            int32
-	Diskgb           int32
-	Secondarydiskgb  int32
-	Sshpubkeys       []string
-	Comments         sql.NullString
-}
-
-func (q *Queries) UpdateVMRequest(ctx context.Context, arg UpdateVMRequestParams) error {
-	_, err := q.db.ExecContext(ctx, updateVMRequest,
+	Requestid           int64
+	Requestcreatedat    time.Time
+	Requeststatus       RequestStatus
+	Email               string
+	Personalemail       string
+	Isorganization      bool
+	Orgname             sql.NullString
+	Hostname            string
+	Image               string
+	Cores               int32
+	Ramgb               int32
+	Diskgb              int32
+	Secondarydiskgb     int32
+	Sshpubkeys          []string
+	Comments            sql.NullString
+	Provisioningprofile string
+}
+
+// The status is not changed here (see TransitionVMRequest), it only guards against concurrent status changes.
+func (q *Queries) UpdateVMRequest(ctx context.Context, arg UpdateVMRequestParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateVMRequest,
 		arg.Requestid,
 		arg.Requestcreatedat,
 		arg.Requeststatus,
@@ -828,20 +2235,10 @@ func (q *Queries) UpdateVMRequest(ctx context.Context, arg UpdateVMRequestParams
 		arg.Secondarydiskgb,
 		pq.Array(arg.Sshpubkeys),
 		arg.Comments,
+		arg.Provisioningprofile,
 	)
-	return err
-}
-
-const updateVMRequestStatus = `-- name: UpdateVMRequestStatus :exec
-UPDATE request SET requestStatus = $2 WHERE requestID = $1
-`
-
-type UpdateVMRequestStatusParams struct {
-	Requestid     int64
-	Requeststatus RequestStatus
-}
-
-func (q *Queries) UpdateVMRequestStatus(ctx context.Context, arg UpdateVMRequestStatusParams) error {
-	_, err := q.db.ExecContext(ctx, updateVMRequestStatus, arg.Requestid, arg.Requeststatus)
-	return err
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
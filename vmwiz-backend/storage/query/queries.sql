@@ -1,9 +1,9 @@
 -- name: CreateVMRequest :one
 INSERT INTO request (
   email, personalEmail, isOrganization, orgName, hostname, image,
-  cores, ramGB, diskGB, secondaryDiskGB, sshPubkeys, comments
+  cores, ramGB, diskGB, secondaryDiskGB, sshPubkeys, comments, requestStatus, userData, provisioningProfile
 ) VALUES (
-  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15
 )
 RETURNING requestID;
 
@@ -13,13 +13,26 @@ SELECT * FROM request WHERE requestID = $1;
 -- name: GetVMRequestsByHostname :many
 SELECT * FROM request WHERE hostname = $1;
 
+-- name: GetVMRequestsByVMID :many
+SELECT * FROM request WHERE vmID = $1;
+
 -- name: ListVMRequests :many
 SELECT * FROM request ORDER BY requestID;
 
--- name: UpdateVMRequest :exec
+-- name: ListVMRequestsByEmail :many
+-- Requests submitted with the given (institutional) email address, case insensitive.
+SELECT * FROM request WHERE lower(email) = lower(sqlc.arg(email)) ORDER BY requestID;
+
+-- name: CountOpenVMRequestsByEmail :one
+-- Verified requests submitted with the given email address that were not answered yet (pending or on hold), case insensitive.
+-- Unverified ones do not count, anyone can submit them for any address.
+SELECT COUNT(*) FROM request
+WHERE lower(email) = lower(sqlc.arg(email)) AND requestStatus IN ('pending', 'hold');
+
+-- name: UpdateVMRequest :execrows
+-- The status is not changed here (see TransitionVMRequest), it only guards against concurrent status changes.
 UPDATE request SET
   requestCreatedAt = $2,
-  requestStatus = $3,
   email = $4,
   personalEmail = $5,
   isOrganization = $6,
@@ -31,11 +44,35 @@ UPDATE request SET
   diskGB = $12,
   secondaryDiskGB = $13,
   sshPubkeys = $14,
-  comments = $15
-WHERE requestID = $1;
+  comments = $15,
+  provisioningProfile = $16
+WHERE requestID = $1 AND requestStatus = $3;
+
+-- name: TransitionVMRequestStatus :execrows
+-- Only updates the status if it is still from_status, so that concurrent transitions can't both succeed.
+UPDATE request SET requestStatus = sqlc.arg(to_status)
+WHERE requestID = sqlc.arg(requestid) AND requestStatus = sqlc.arg(from_status);
+
+-- name: SetVMRequestVM :exec
+UPDATE request SET vmID = $2, node = $3, ipv4 = $4, ipv6 = $5 WHERE requestID = $1;
+
+-- name: SetVMRequestProvisioningProfile :exec
+UPDATE request SET provisioningProfile = $2 WHERE requestID = $1;
+
+-- name: RequestVMDeletion :execrows
+-- Only provisioned requests can ask for deletion, and only once.
+UPDATE request SET deletionRequestedAt = NOW()
+WHERE requestID = $1 AND requestStatus = 'provisioned' AND deletionRequestedAt IS NULL;
 
--- name: UpdateVMRequestStatus :exec
-UPDATE request SET requestStatus = $2 WHERE requestID = $1;
+-- name: CreateRequestEvent :exec
+INSERT INTO request_event (request_id, actor, from_status, to_status, reason, admin_note)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: ListRequestEvents :many
+SELECT * FROM request_event WHERE request_id = $1 ORDER BY created_at, id;
+
+-- name: ListAllRequestEvents :many
+SELECT * FROM request_event ORDER BY request_id, created_at, id;
 
 
 
@@ -114,8 +151,11 @@ RETURNING id;
 -- name: UpdateSurveyEmailResponse :exec
 UPDATE survey_email SET still_used = $2 WHERE uuid = $1;
 
--- name: MarkSurveyEmailSent :exec
-UPDATE survey_email SET email_sent = TRUE WHERE uuid = $1;
+-- name: SetSurveyEmailMailID :exec
+UPDATE survey_email SET mail_id = $2 WHERE uuid = $1;
+
+-- name: MarkSurveyEmailSentByMailID :exec
+UPDATE survey_email SET email_sent = TRUE WHERE mail_id = $1;
 
 -- name: ListUnansweredOrUnsentSurveyEmails :many
 SELECT * FROM survey_email
@@ -125,9 +165,13 @@ WHERE surveyId = $1 AND (still_used IS NULL OR email_sent = FALSE);
 SELECT * FROM survey_email
 WHERE surveyId = $1 AND (still_used IS NULL AND email_sent = TRUE);
 
--- name: ListUnsentSurveyEmails :many
-SELECT * FROM survey_email
-WHERE surveyId = $1 AND (email_sent = FALSE);
+-- name: ListRetryableSurveyEmails :many
+-- Unsent survey emails that are not already waiting in the mail queue.
+SELECT survey_email.* FROM survey_email
+LEFT JOIN mail_queue ON mail_queue.id = survey_email.mail_id
+WHERE survey_email.surveyId = $1
+  AND survey_email.email_sent = FALSE
+  AND (mail_queue.id IS NULL OR mail_queue.status IN ('failed', 'cancelled'));
 
 -- name: CountUnsentSurveyEmails :one
 SELECT COUNT(*) FROM survey_email
@@ -163,3 +207,213 @@ WHERE surveyId = $1 AND email_sent = FALSE;
 
 -- name: SurveyEmailExistsByUUID :one
 SELECT EXISTS(SELECT 1 FROM survey_email WHERE uuid = $1);
+
+
+
+
+
+
+-- name: EnqueueMail :one
+INSERT INTO mail_queue (recipients, subject, template, template_data, message_id, in_reply_to) VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id;
+
+-- name: GetMailByID :one
+SELECT * FROM mail_queue WHERE id = $1;
+
+-- name: ListMails :many
+-- The most recent messages, only those with the given status unless it is ''
+SELECT * FROM mail_queue
+WHERE sqlc.arg(status)::text = '' OR status::text = sqlc.arg(status)::text
+ORDER BY id DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: ClaimDueMails :many
+-- Due messages, up to max_results. Their next attempt is moved to lease_until so that no other worker delivers them
+-- in the meantime, the outcome of the delivery overwrites it. Messages being claimed by another worker are skipped.
+UPDATE mail_queue SET next_attempt_at = sqlc.arg(lease_until)
+WHERE id IN (
+  SELECT id FROM mail_queue
+  WHERE status = 'queued' AND next_attempt_at <= CURRENT_TIMESTAMP
+  ORDER BY id
+  LIMIT sqlc.arg(max_results)
+  FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: MarkMailSent :exec
+UPDATE mail_queue SET
+  status = 'sent',
+  attempts = attempts + 1,
+  last_attempt_at = CURRENT_TIMESTAMP,
+  sent_at = CURRENT_TIMESTAMP,
+  last_error = NULL
+WHERE id = $1;
+
+-- name: MarkMailAttemptFailed :exec
+UPDATE mail_queue SET
+  status = $2,
+  attempts = attempts + 1,
+  last_attempt_at = CURRENT_TIMESTAMP,
+  last_error = $3,
+  next_attempt_at = $4
+WHERE id = $1;
+
+-- name: RetryMail :execrows
+UPDATE mail_queue SET status = 'queued', next_attempt_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND status <> 'sent';
+
+-- name: CancelMail :execrows
+UPDATE mail_queue SET status = 'cancelled', last_error = $2
+WHERE id = $1 AND status IN ('queued', 'failed');
+
+-- name: CreateAuthSession :exec
+INSERT INTO auth_session (id, email, expires_at, refresh_token) VALUES ($1, $2, $3, $4);
+
+-- name: GetAuthSession :one
+SELECT * FROM auth_session WHERE id = $1;
+
+-- name: UpdateAuthSession :execrows
+UPDATE auth_session SET refresh_token = $2, expires_at = $3, last_seen_at = CURRENT_TIMESTAMP WHERE id = $1;
+
+-- name: DeleteAuthSession :execrows
+DELETE FROM auth_session WHERE id = $1;
+
+-- name: ListAuthSessions :many
+SELECT * FROM auth_session ORDER BY created_at;
+
+-- name: DeleteExpiredAuthSessions :exec
+DELETE FROM auth_session WHERE expires_at < CURRENT_TIMESTAMP;
+
+-- name: CreateAPIToken :one
+INSERT INTO api_token (name, token_hash, owner, role, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id;
+
+-- name: GetAPITokenByHash :one
+SELECT * FROM api_token WHERE token_hash = $1;
+
+-- name: ListAPITokens :many
+SELECT * FROM api_token ORDER BY id;
+
+-- name: TouchAPIToken :exec
+UPDATE api_token SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1;
+
+-- name: RevokeAPIToken :execrows
+UPDATE api_token SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL;
+
+-- name: CreateAuditEntry :exec
+INSERT INTO audit_log (actor, action, target, payload, result, detail, log_scope_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: ListAuditEntries :many
+-- Newest first. Filters that are NULL are ignored.
+SELECT * FROM audit_log
+WHERE (sqlc.narg(actor)::text IS NULL OR actor = sqlc.narg(actor))
+  AND (sqlc.narg(action)::text IS NULL OR action = sqlc.narg(action))
+  AND (sqlc.narg(target)::text IS NULL OR target = sqlc.narg(target))
+  AND (sqlc.narg(since)::timestamp IS NULL OR created_at >= sqlc.narg(since))
+  AND (sqlc.narg(until)::timestamp IS NULL OR created_at < sqlc.narg(until))
+ORDER BY id DESC
+LIMIT sqlc.arg(max_entries);
+
+-- name: CreatePendingOperation :one
+INSERT INTO pending_operation (action, target, path, payload, requested_by, requested_by_owner, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id;
+
+-- name: GetPendingOperation :one
+SELECT * FROM pending_operation WHERE id = $1;
+
+-- name: ListPendingOperations :many
+-- Newest first. Unless include_decided, only the ones still waiting for a decision are returned.
+SELECT * FROM pending_operation
+WHERE sqlc.arg(include_decided)::bool OR (status = 'pending' AND expires_at > NOW())
+ORDER BY id DESC
+LIMIT sqlc.arg(max_entries);
+
+-- name: ApprovePendingOperation :execrows
+-- The requester can never approve their own operation
+UPDATE pending_operation SET status = 'approved', decided_by = sqlc.arg(approver), decided_at = NOW()
+WHERE id = sqlc.arg(id) AND status = 'pending' AND expires_at > NOW()
+  AND requested_by <> sqlc.arg(approver) AND requested_by_owner <> sqlc.arg(approver);
+
+-- name: RejectPendingOperation :execrows
+UPDATE pending_operation SET status = 'rejected', decided_by = $2, decided_at = NOW()
+WHERE id = $1 AND status = 'pending';
+
+-- name: CreateEmailVerification :exec
+INSERT INTO email_verification (token_hash, request_id, email) VALUES ($1, $2, $3);
+
+-- name: GetEmailVerification :one
+SELECT * FROM email_verification WHERE token_hash = $1;
+
+-- name: SetEmailVerified :exec
+UPDATE email_verification SET verified_at = NOW() WHERE token_hash = $1 AND verified_at IS NULL;
+
+-- name: CountUnverifiedEmails :one
+-- Addresses of the request whose link was not opened yet
+SELECT COUNT(*) FROM email_verification WHERE request_id = $1 AND verified_at IS NULL;
+
+-- name: DeleteUnverifiedVMRequests :execrows
+-- Requests that were never verified, submitted before the given time
+DELETE FROM request WHERE requestStatus = 'unverified' AND requestCreatedAt < $1;
+
+-- name: ListQuotaVMRequests :many
+-- Requests that count towards the quotas: verified and not answered yet, accepted or provisioned.
+-- Unverified ones do not, anyone can submit them for any address or organization.
+SELECT * FROM request
+WHERE requestStatus IN ('pending', 'hold', 'accepted', 'provisioning', 'provisioned')
+ORDER BY requestID;
+
+-- name: GetQuotaOverride :one
+SELECT * FROM quota_override WHERE kind = sqlc.arg(kind) AND subject = lower(sqlc.arg(subject));
+
+-- name: ListQuotaOverrides :many
+SELECT * FROM quota_override ORDER BY kind, subject;
+
+-- name: SetQuotaOverride :exec
+INSERT INTO quota_override (kind, subject, max_vms, max_cores, max_ramgb, max_diskgb, comment, updated_by)
+VALUES (
+  sqlc.arg(kind), lower(sqlc.arg(subject)), sqlc.narg(max_vms), sqlc.narg(max_cores), sqlc.narg(max_ramgb), sqlc.narg(max_diskgb),
+  sqlc.arg(comment), sqlc.arg(updated_by)
+)
+ON CONFLICT (kind, subject) DO UPDATE SET
+  max_vms = EXCLUDED.max_vms,
+  max_cores = EXCLUDED.max_cores,
+  max_ramgb = EXCLUDED.max_ramgb,
+  max_diskgb = EXCLUDED.max_diskgb,
+  comment = EXCLUDED.comment,
+  updated_by = EXCLUDED.updated_by,
+  updated_at = NOW();
+
+-- name: DeleteQuotaOverride :execrows
+DELETE FROM quota_override WHERE kind = sqlc.arg(kind) AND subject = lower(sqlc.arg(subject));
+
+-- name: GetFormSettings :one
+SELECT * FROM form_settings WHERE id = 1;
+
+-- name: SaveFormSettings :exec
+INSERT INTO form_settings (id, settings, updated_by) VALUES (1, $1, $2)
+ON CONFLICT (id) DO UPDATE SET settings = EXCLUDED.settings, updated_by = EXCLUDED.updated_by, updated_at = NOW();
+
+-- name: ListVMImages :many
+SELECT * FROM vm_image ORDER BY position, name;
+
+-- name: GetVMImage :one
+SELECT * FROM vm_image WHERE name = $1;
+
+-- name: SaveVMImage :exec
+INSERT INTO vm_image (name, path, default_user, first_boot_marker, sources_path, sources, min_disk_gb, enabled, position, updated_by, package_manager)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+ON CONFLICT (name) DO UPDATE SET
+  path = EXCLUDED.path,
+  default_user = EXCLUDED.default_user,
+  first_boot_marker = EXCLUDED.first_boot_marker,
+  sources_path = EXCLUDED.sources_path,
+  sources = EXCLUDED.sources,
+  min_disk_gb = EXCLUDED.min_disk_gb,
+  enabled = EXCLUDED.enabled,
+  position = EXCLUDED.position,
+  updated_by = EXCLUDED.updated_by,
+  package_manager = EXCLUDED.package_manager,
+  updated_at = NOW();
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Allowed status transitions of a VM request. Every status change must go through TransitionVMRequest.
+var requestTransitions = map[RequestStatus][]RequestStatus{
+	REQUEST_STATUS_UNVERIFIED:     {REQUEST_STATUS_PENDING, REQUEST_STATUS_WITHDRAWN},
+	REQUEST_STATUS_PENDING:        {REQUEST_STATUS_HELD, REQUEST_STATUS_PROVISIONING, REQUEST_STATUS_REJECTED, REQUEST_STATUS_WITHDRAWN},
+	REQUEST_STATUS_HELD:           {REQUEST_STATUS_PENDING, REQUEST_STATUS_REJECTED, REQUEST_STATUS_WITHDRAWN},
+	REQUEST_STATUS_PROVISIONING:   {REQUEST_STATUS_PROVISIONED, REQUEST_STATUS_FAILED},
+	REQUEST_STATUS_PROVISIONED:    {REQUEST_STATUS_DECOMMISSIONED},
+	REQUEST_STATUS_FAILED:         {REQUEST_STATUS_PROVISIONING, REQUEST_STATUS_REJECTED},
+	REQUEST_STATUS_ACCEPTED:       {REQUEST_STATUS_DECOMMISSIONED},
+	REQUEST_STATUS_REJECTED:       {},
+	REQUEST_STATUS_WITHDRAWN:      {},
+	REQUEST_STATUS_DECOMMISSIONED: {},
+}
+
+// Statuses in which admins can still change the requested resources
+var requestEditableStatuses = []RequestStatus{REQUEST_STATUS_PENDING, REQUEST_STATUS_HELD}
+
+// Returned when a transition is not in the transition table, or the request changed status in the meantime
+var ErrInvalidRequestTransition = errors.New("invalid request status transition")
+
+func CanTransitionVMRequest(from RequestStatus, to RequestStatus) bool {
+	return slices.Contains(requestTransitions[from], to)
+}
+
+func VMRequestEditable(status RequestStatus) bool {
+	return slices.Contains(requestEditableStatuses, status)
+}
+
+// TransitionVMRequest moves a VM request from one status to another.
+// The update only succeeds if the request is still in the from status, so two admins can't e.g. both accept the same request.
+// Returns an error wrapping ErrInvalidRequestTransition if the transition is not allowed or the status changed concurrently.
+func (s *postgresstorage) TransitionVMRequest(ctx context.Context, id int64, from RequestStatus, to RequestStatus) error {
+	if !CanTransitionVMRequest(from, to) {
+		return fmt.Errorf("%w: request %d cannot go from '%v' to '%v'", ErrInvalidRequestTransition, id, from, to)
+	}
+
+	n, err := s.Queries.TransitionVMRequestStatus(ctx, TransitionVMRequestStatusParams{
+		ToStatus:   to,
+		Requestid:  id,
+		FromStatus: from,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to update status of request %d: %v", id, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: request %d is no longer '%v'", ErrInvalidRequestTransition, id, from)
+	}
+	return nil
+}
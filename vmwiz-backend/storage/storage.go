@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"embed"
 	"fmt"
+	"strings"
 	"time"
 
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
@@ -19,10 +20,28 @@ import (
 var migrationsFS embed.FS
 
 const (
-	REQUEST_STATUS_PENDING  = "pending"
+	// Submitted, the requester did not open the verification link(s) yet. Purged after VMREQUEST_UNVERIFIED_TTL
+	REQUEST_STATUS_UNVERIFIED = "unverified"
+	REQUEST_STATUS_PENDING    = "pending"
+	// Legacy: accepted before the provisioning states existed. Only used by old requests.
 	REQUEST_STATUS_ACCEPTED = "accepted"
 	REQUEST_STATUS_REJECTED = "rejected"
 	REQUEST_STATUS_HELD     = "hold"
+	// Accepted, the VM is being created
+	REQUEST_STATUS_PROVISIONING = "provisioning"
+	// The VM was created, see Request.Vmid
+	REQUEST_STATUS_PROVISIONED = "provisioned"
+	// Creating the VM failed. Accepting the request again retries it.
+	REQUEST_STATUS_FAILED = "failed"
+	// Withdrawn by the requester before it was processed
+	REQUEST_STATUS_WITHDRAWN = "withdrawn"
+	// The VM of an accepted request has been deleted
+	REQUEST_STATUS_DECOMMISSIONED = "decommissioned"
+
+	MAIL_STATUS_QUEUED    = "queued"
+	MAIL_STATUS_SENT      = "sent"
+	MAIL_STATUS_FAILED    = "failed"
+	MAIL_STATUS_CANCELLED = "cancelled"
 
 	// Reserved catch-all log scope id, owns "0.log".
 	SCOPE_ROOT = "0"
@@ -45,12 +64,34 @@ DiskGB: ` + fmt.Sprintf("%v", r.Diskgb) + `
 SecondaryDiskGB: ` + fmt.Sprintf("%v", r.Secondarydiskgb) + `
 SshPubkeys: ` + fmt.Sprintf("%v", r.Sshpubkeys) + `
 Comments: ` + fmt.Sprintf("%v", r.Comments.String) + `
-`
+ProvisioningProfile: ` + fmt.Sprintf("%v", r.Provisioningprofile) + `
+` + r.userDataString() + r.vmString()
+}
+
+// The custom cloud-init user-data, if there is one
+func (r Request) userDataString() string {
+	if r.Userdata == "" {
+		return ""
+	}
+	return "UserData:\n" + r.Userdata + "\n"
+}
+
+// Details of the created VM, if there is one
+func (r Request) vmString() string {
+	if !r.Vmid.Valid {
+		return ""
+	}
+	vm := fmt.Sprintf("VM: %v on node %v\nIPv4: %v\nIPv6: %v\n", r.Vmid.Int32, r.Node.String, r.Ipv4.String, r.Ipv6.String)
+	if r.Deletionrequestedat.Valid {
+		vm += fmt.Sprintf("Deletion requested at: %v\n", r.Deletionrequestedat.Time.Format(time.RFC3339))
+	}
+	return vm
 }
 
+// ToVMOptions returns the options to create the VM of the request with.
+// The image is not set, it has to be looked up in the catalog (see images.Get).
 func (r Request) ToVMOptions() *proxmox.VMCreationOptions {
 	return &proxmox.VMCreationOptions{
-		Template:         r.Image,
 		FQDN:             r.Hostname,
 		Reinstall:        false,
 		Cores_CPU:        int(r.Cores),
@@ -58,6 +99,7 @@ func (r Request) ToVMOptions() *proxmox.VMCreationOptions {
 		Disk_GB:          int64(r.Diskgb),
 		SecondaryDisk_GB: int64(r.Secondarydiskgb),
 		SSHPubkeys:       r.Sshpubkeys,
+		UserData:         r.Userdata,
 		Notes:            "VM is being set up, please wait...",
 		Tags:             []string{"created-by-vmwiz"},
 		DescriptionKVPairs: map[string]string{
@@ -70,11 +112,35 @@ func (r Request) ToVMOptions() *proxmox.VMCreationOptions {
 	}
 }
 
+// ToString renders a request event for CLI output.
+func (e RequestEvent) ToString() string {
+	line := fmt.Sprintf("%v %v: %v -> %v", e.CreatedAt.Format(time.DateTime), e.Actor, e.FromStatus, e.ToStatus)
+	if e.Reason.Valid {
+		line += fmt.Sprintf("\n\tReason: %v", e.Reason.String)
+	}
+	if e.AdminNote.Valid {
+		line += fmt.Sprintf("\n\tAdmin note: %v", e.AdminNote.String)
+	}
+	return line
+}
+
 // ToString renders a usage survey for CLI output.
 func (s Survey) ToString() string {
 	return fmt.Sprintf("Survey ID: %v\nCreated date: %v", s.ID, s.Date)
 }
 
+// ToString renders a queued email for CLI output.
+func (m MailQueue) ToString() string {
+	line := fmt.Sprintf("Mail ID: %v [%v] attempts: %v\nCreated date: %v\nTo: %v\nSubject: %v", m.ID, m.Status, m.Attempts, m.CreatedAt, strings.Join(m.Recipients, ", "), m.Subject)
+	if m.Status == MAIL_STATUS_QUEUED {
+		line += fmt.Sprintf("\nNext attempt: %v", m.NextAttemptAt)
+	}
+	if m.LastError.Valid {
+		line += fmt.Sprintf("\nLast error: %v", m.LastError.String)
+	}
+	return line
+}
+
 type postgresstorage struct {
 	*Queries
 	db        *sql.DB
@@ -146,6 +212,19 @@ func (s *postgresstorage) Init() error {
 	return nil
 }
 
+// RecordRequestEvent stores a status change of a VM request (or an edit, if from and to are the same).
+// Empty reason and adminNote are stored as NULL.
+func (s *postgresstorage) RecordRequestEvent(ctx context.Context, requestId int64, actor string, from RequestStatus, to RequestStatus, reason string, adminNote string) error {
+	return s.Queries.CreateRequestEvent(ctx, CreateRequestEventParams{
+		RequestID:  requestId,
+		Actor:      actor,
+		FromStatus: from,
+		ToStatus:   to,
+		Reason:     sql.NullString{String: reason, Valid: reason != ""},
+		AdminNote:  sql.NullString{String: adminNote, Valid: adminNote != ""},
+	})
+}
+
 // logger.ScopeStore stuff
 
 func (s *postgresstorage) CreateLogScope(id string, parentID string, rootID string, label string) error {
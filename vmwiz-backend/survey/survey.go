@@ -1,14 +1,10 @@
 package survey
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
-	"embed"
 	"fmt"
-	"strconv"
 	"strings"
-	"text/template"
 
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/config"
 	"git.sos.ethz.ch/vsos/vmwiz.vsos.ethz.ch/vmwiz-backend/logger"
@@ -18,9 +14,6 @@ import (
 	"github.com/google/uuid"
 )
 
-//go:embed *.tmpl
-var templatesFS embed.FS
-
 // Creates a new VM Usage Survey in the database and sends out the emails to the users.
 // This function may return error if any email fails to send: in that case, the surveyId is still returned such that the missed emails can be retried later.
 func CreateVMUsageSurvey(ctx context.Context, restrict_pool []string) (*int64, error) {
@@ -96,7 +89,7 @@ func RetryUnsentEmails(ctx context.Context, surveyId int64) error {
 	}
 
 	// Retrieve all unsent emails
-	surveyEmails, err := storage.DB.ListUnsentSurveyEmails(ctx, surveyId)
+	surveyEmails, err := storage.DB.ListRetryableSurveyEmails(ctx, surveyId)
 	if err != nil {
 		msg := fmt.Sprintf("Failed to retry unsent emails for VM usage survey %v: Failed to get all emails that need to be sent from db: %v", surveyId, err)
 		notifier.NotifyVMUsageSurvey(ctx, surveyId, msg)
@@ -134,86 +127,95 @@ func SendSurveyReminder(ctx context.Context, surveyId int64) error {
 	return nil
 }
 
-func sendVMUsageSurveyReminder(ctx context.Context, surveyId int64, surveyEmails []storage.SurveyEmail) error {
-	// Process the email template for VM Usage Survey
-	vmusage_survey_reminder_template, err := template.ParseFS(templatesFS, "vmusage_survey_reminder.tmpl")
+// Shuts down the VMs whose owners did not answer the survey.
+func ShutdownUnansweredVMs(ctx context.Context, surveyId int64) error {
+	hostnames, err := storage.DB.ListUnansweredSurveyHostnames(ctx, surveyId)
+	if err != nil {
+		return fmt.Errorf("Failed to get unanswered hostnames of VM usage survey %v: %v", surveyId, err)
+	}
+	unanswered := map[string]struct{}{}
+	for _, h := range hostnames {
+		unanswered[h] = struct{}{}
+	}
+
+	vms, err := proxmox.GetAllClusterVMs()
 	if err != nil {
-		return fmt.Errorf("Failed send VM usage survey reminder: Failed to parse email template: %v", err)
+		return fmt.Errorf("Failed to get VMs: %v", err)
 	}
 
-	// Send each email
-	emails_sent := 0
+	errors := []string{}
+	for _, vm := range *vms {
+		if _, ok := unanswered[vm.Name]; !ok {
+			continue
+		}
+
+		logger.From(ctx).Infof("Shutting down %s...", vm.Name)
+		err = proxmox.ShutdownVMWithReason(vm.Node, vm.Vmid, "the owner did not respond to the survey.")
+		if err != nil {
+			logger.From(ctx).Errorf("Failed to shut down VM %s: %v", vm.Name, err)
+			errors = append(errors, fmt.Sprintf("Failed to shut down VM %s: %v", vm.Name, err))
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("Errors occurred during shutdown:\n%s", strings.Join(errors, "\n"))
+	}
+	return nil
+}
+
+func surveyURL(surveyEmail storage.SurveyEmail) string {
+	return config.AppConfig.URL("/survey?id=" + surveyEmail.Uuid + "&hostname=" + surveyEmail.Hostname)
+}
+
+func sendVMUsageSurveyReminder(ctx context.Context, surveyId int64, surveyEmails []storage.SurveyEmail) error {
+	// Queue each email
+	emails_queued := 0
 	for _, surveyEmail := range surveyEmails {
-		if emails_sent%10 == 0 {
-			// TODO: Startup check for checking production ^ SMTP disabled
-			if config.AppConfig.SMTP_ENABLE {
-				logger.From(ctx).Infof("Sending emails ... (%v / %v)", emails_sent, len(surveyEmails))
-			} else {
-				logger.From(ctx).Infof("Dry-run Sending emails ... (%v / %v) (SMTP disabled)", emails_sent, len(surveyEmails))
-			}
+		if emails_queued%10 == 0 {
+			logger.From(ctx).Infof("Queueing emails ... (%v / %v)", emails_queued, len(surveyEmails))
 		}
 
-		mail_content := new(bytes.Buffer)
-		err = vmusage_survey_reminder_template.Execute(mail_content, struct {
+		_, err := notifier.EnqueueEmail(ctx, "VSOS VM Usage Survey: Reminder", "vmusage_survey_reminder.tmpl", struct {
 			HOSTNAME string
 			URL      string
 			REPLYTO  string
 		}{
 			HOSTNAME: surveyEmail.Hostname,
-			URL:      config.AppConfig.VMWIZ_SCHEME + "://" + config.AppConfig.VMWIZ_HOSTNAME + ":" + strconv.Itoa(config.AppConfig.VMWIZ_PORT) + "/survey?id=" + surveyEmail.Uuid + "&hostname=" + surveyEmail.Hostname,
+			URL:      surveyURL(surveyEmail),
 			REPLYTO:  config.AppConfig.SMTP_REPLYTO,
-		})
+		}, []string{surveyEmail.Recipient})
 		if err != nil {
-			return fmt.Errorf("Failed send VM usage survey reminder: Failed to execute email template: %v", err)
-		}
-
-		// TODO: Add startup check for checking wether we can send emails
-		err = notifier.SendEmail("VSOS VM Usage Survey: Reminder", mail_content.Bytes(), []string{surveyEmail.Recipient})
-		if err != nil {
-			logger.From(ctx).Errorf("Failed send VM usage survey reminder: Failed to send email: %v", err)
+			logger.From(ctx).Errorf("Failed send VM usage survey reminder: %v", err)
 			continue
 		}
 
-		emails_sent++
+		emails_queued++
 	}
-	var msg string
-	if config.AppConfig.SMTP_ENABLE {
-		msg = fmt.Sprintf("Sent %d reminder emails for VM usage survey %v", emails_sent, surveyId)
-	} else {
-		msg = fmt.Sprintf("Dry-run Sent %d reminder emails for VM usage survey %v (SMTP disabled)", emails_sent, surveyId)
+	msg := fmt.Sprintf("Queued %d reminder emails for VM usage survey %v", emails_queued, surveyId)
+	if !config.AppConfig.SMTP_ENABLE {
+		msg += " (SMTP disabled, they will not be delivered)"
 	}
 
 	logger.From(ctx).Info("[+] " + msg)
 
 	// Notify about the survey getting sent
-	err = notifier.NotifyVMUsageSurvey(ctx, surveyId, msg)
+	err := notifier.NotifyVMUsageSurvey(ctx, surveyId, msg)
 	if err != nil {
 		return fmt.Errorf("Failed to send VM usage survey notification: %v", err)
 	}
 	return nil
 }
 
+// Queues the survey emails. The email_sent flag of each survey email is set by the mail worker once the message is delivered.
 func sendVMUsageSurvey(ctx context.Context, surveyId int64, surveyEmails []storage.SurveyEmail) error {
-	// Process the email template for VM Usage Survey
-	vmusage_survey_template, err := template.ParseFS(templatesFS, "vmusage_survey.tmpl")
-	if err != nil {
-		return fmt.Errorf("Failed send VM usage survey: Failed to parse email template: %v", err)
-	}
-
-	// Send each email
-	emails_sent := 0
+	// Queue each email
+	emails_queued := 0
 	for _, surveyEmail := range surveyEmails {
-		if emails_sent%10 == 0 {
-			// TODO: Startup check for checking production ^ SMTP disabled
-			if config.AppConfig.SMTP_ENABLE {
-				logger.From(ctx).Infof("Sending emails ... (%v / %v)", emails_sent, len(surveyEmails))
-			} else {
-				logger.From(ctx).Infof("Dry-run Sending emails ... (%v / %v) (SMTP disabled)", emails_sent, len(surveyEmails))
-			}
+		if emails_queued%10 == 0 {
+			logger.From(ctx).Infof("Queueing emails ... (%v / %v)", emails_queued, len(surveyEmails))
 		}
 
-		mail_content := new(bytes.Buffer)
-		err = vmusage_survey_template.Execute(mail_content, struct {
+		mailId, err := notifier.EnqueueEmail(ctx, "VSOS: Do you still need your VM?", "vmusage_survey.tmpl", struct {
 			HOSTNAME       string
 			HOSTNAME_SHORT string
 			URL            string
@@ -221,41 +223,34 @@ func sendVMUsageSurvey(ctx context.Context, surveyId int64, surveyEmails []stora
 		}{
 			HOSTNAME:       surveyEmail.Hostname,
 			HOSTNAME_SHORT: strings.Split(surveyEmail.Hostname, ".")[0],
-			URL:            config.AppConfig.VMWIZ_SCHEME + "://" + config.AppConfig.VMWIZ_HOSTNAME + ":" + strconv.Itoa(config.AppConfig.VMWIZ_PORT) + "/survey?id=" + surveyEmail.Uuid + "&hostname=" + surveyEmail.Hostname,
+			URL:            surveyURL(surveyEmail),
 			REPLYTO:        config.AppConfig.SMTP_REPLYTO,
-		})
+		}, []string{surveyEmail.Recipient})
 		if err != nil {
-			return fmt.Errorf("Failed send VM usage survey: Failed to execute email template: %v", err)
+			logger.From(ctx).Errorf("Failed send VM usage survey: %v", err)
+			continue
 		}
 
-		// TODO: Add startup check for checking wether we can send emails
-		err = notifier.SendEmail("VSOS: Do you still need your VM?", mail_content.Bytes(), []string{surveyEmail.Recipient})
+		err = storage.DB.SetSurveyEmailMailID(ctx, storage.SetSurveyEmailMailIDParams{
+			Uuid:   surveyEmail.Uuid,
+			MailID: sql.NullInt64{Int64: mailId, Valid: true},
+		})
 		if err != nil {
-			logger.From(ctx).Errorf("Failed send VM usage survey: Failed to send email: %v", err)
+			logger.From(ctx).Errorf("Failed send VM usage survey: Failed to link survey email to queued email %v: %v", mailId, err)
 			continue
 		}
 
-		if config.AppConfig.SMTP_ENABLE {
-			err = storage.DB.MarkSurveyEmailSent(ctx, surveyEmail.Uuid)
-			if err != nil {
-				logger.From(ctx).Errorf("Failed send VM usage survey: Failed to set EmailMarkAsSent %v", err)
-				continue
-			}
-		}
-
-		emails_sent++
+		emails_queued++
 	}
-	var msg string
-	if config.AppConfig.SMTP_ENABLE {
-		msg = fmt.Sprintf("Sent %d emails for VM usage survey %v", emails_sent, surveyId)
-	} else {
-		msg = fmt.Sprintf("Dry-run Sent %d emails for VM usage survey %v (SMTP disabled)", emails_sent, surveyId)
+	msg := fmt.Sprintf("Queued %d emails for VM usage survey %v", emails_queued, surveyId)
+	if !config.AppConfig.SMTP_ENABLE {
+		msg += " (SMTP disabled, they will not be delivered)"
 	}
 
 	logger.From(ctx).Info("[+] " + msg)
 
 	// Notify about the survey getting sent
-	err = notifier.NotifyVMUsageSurvey(ctx, surveyId, msg)
+	err := notifier.NotifyVMUsageSurvey(ctx, surveyId, msg)
 	if err != nil {
 		return fmt.Errorf("Failed to send VM usage survey notification: %v", err)
 	}
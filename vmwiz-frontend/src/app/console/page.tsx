@@ -7,12 +7,21 @@ import { DnsDelete } from "@/components/admin/dns-delete";
 import { LogScopesMenu } from "@/components/admin/log-scopes-menu";
 import { SurveyAdmin } from "@/components/admin/survey-admin";
 import { VMRequestAdmin } from "@/components/admin/vm-request-admin";
-import { ClipboardList, BarChart3, Trash2, User, Server } from "lucide-react";
+import { PendingOperations } from "@/components/admin/pending-operations";
+import {
+    ClipboardList,
+    BarChart3,
+    Trash2,
+    User,
+    Server,
+    LogOut,
+    ShieldCheck,
+} from "lucide-react";
 import { fetchFreeIPv4Count } from "@/lib/api";
 import { useEffect, useState } from "react";
 
 export default function ConsolePage() {
-    const { user, loading } = useAuth();
+    const { user, loading, logout } = useAuth();
     const [freeIPs, setFreeIPs] = useState<number | null>(null);
 
     useEffect(() => {
@@ -39,7 +48,17 @@ export default function ConsolePage() {
                         {loading ? (
                             <span className="animate-pulse">…</span>
                         ) : user ? (
-                            <span>{user.email}</span>
+                            <>
+                                <span>{user.email}</span>
+                                <button
+                                    type="button"
+                                    onClick={logout}
+                                    className="inline-flex items-center gap-1 hover:text-foreground"
+                                    title="Log out"
+                                >
+                                    <LogOut className="h-4 w-4" />
+                                </button>
+                            </>
                         ) : (
                             <span>Not logged in</span>
                         )}
@@ -47,6 +66,14 @@ export default function ConsolePage() {
                 </div>
             </div>
 
+            <section className="space-y-4">
+                <h2 className="flex items-center gap-2 text-lg font-semibold">
+                    <ShieldCheck className="h-5 w-5" />
+                    Pending Approvals
+                </h2>
+                <PendingOperations />
+            </section>
+
             <section className="space-y-4">
                 <h2 className="flex items-center gap-2 text-lg font-semibold">
                     <Trash2 className="h-5 w-5" />
@@ -0,0 +1,11 @@
+import { MyRequests } from "@/components/me/my-requests";
+import type { Metadata } from "next";
+
+export const metadata: Metadata = {
+    title: "My VMs - VMWiz",
+    description: "Your VSOS VM requests and VMs",
+};
+
+export default function MePage() {
+    return <MyRequests />;
+}
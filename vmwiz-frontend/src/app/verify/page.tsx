@@ -0,0 +1,25 @@
+import { Suspense } from "react";
+import { VerifyEmail } from "@/components/verify/verify-email";
+import { Loader2 } from "lucide-react";
+import type { Metadata } from "next";
+
+export const metadata: Metadata = {
+    title: "Confirm Email - VMWiz",
+    description: "Confirm the email address of a VM request",
+};
+
+function VerifyLoading() {
+    return (
+        <div className="flex min-h-[70vh] items-center justify-center">
+            <Loader2 className="h-8 w-8 animate-spin text-muted-foreground" />
+        </div>
+    );
+}
+
+export default function VerifyPage() {
+    return (
+        <Suspense fallback={<VerifyLoading />}>
+            <VerifyEmail />
+        </Suspense>
+    );
+}
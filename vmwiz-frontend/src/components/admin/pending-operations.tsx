@@ -0,0 +1,123 @@
+"use client";
+
+import { useCallback, useEffect, useState } from "react";
+import { formatDate } from "@/lib/utils";
+import {
+    fetchPendingOperations,
+    prepareApproveOperation,
+    prepareRejectOperation,
+} from "@/lib/api";
+import type { PendingOperation } from "@/lib/types/api";
+import { FetchDialog } from "@/components/fetch-dialog";
+import { Badge } from "@/components/ui/badge";
+import { Button } from "@/components/ui/button";
+import { Check, X } from "lucide-react";
+
+/** Operations waiting for the approval of a second admin (four-eyes rule) */
+export function PendingOperations() {
+    const [operations, setOperations] = useState<PendingOperation[]>([]);
+    const [dialog, setDialog] = useState<{
+        open: boolean;
+        type: "approve" | "reject";
+        operation: PendingOperation | null;
+    }>({ open: false, type: "approve", operation: null });
+
+    const load = useCallback(() => {
+        fetchPendingOperations()
+            .then(setOperations)
+            .catch(() => setOperations([]));
+    }, []);
+
+    useEffect(load, [load]);
+
+    if (operations.length === 0) {
+        return (
+            <p className="text-sm text-muted-foreground">
+                No operations are waiting for approval.
+            </p>
+        );
+    }
+
+    const op = dialog.operation;
+
+    return (
+        <div className="space-y-2">
+            {operations.map((o) => (
+                <div
+                    key={o.id}
+                    className="flex items-center justify-between gap-3 rounded-md border border-border p-3 text-sm"
+                >
+                    <div className="space-y-1">
+                        <div className="flex items-center gap-2">
+                            <Badge variant="outline">{o.action}</Badge>
+                            <span className="font-mono">{o.target}</span>
+                        </div>
+                        <div className="text-muted-foreground">
+                            #{o.id} requested by {o.requestedBy} on{" "}
+                            {formatDate(o.requestedAt)}, expires{" "}
+                            {formatDate(o.expiresAt)}
+                        </div>
+                    </div>
+                    <div className="flex gap-2">
+                        <Button
+                            size="sm"
+                            variant="outline"
+                            onClick={() =>
+                                setDialog({
+                                    open: true,
+                                    type: "reject",
+                                    operation: o,
+                                })
+                            }
+                        >
+                            <X className="h-4 w-4" />
+                            Reject
+                        </Button>
+                        <Button
+                            size="sm"
+                            variant="destructive"
+                            onClick={() =>
+                                setDialog({
+                                    open: true,
+                                    type: "approve",
+                                    operation: o,
+                                })
+                            }
+                        >
+                            <Check className="h-4 w-4" />
+                            Approve
+                        </Button>
+                    </div>
+                </div>
+            ))}
+
+            {op && (
+                <FetchDialog
+                    open={dialog.open}
+                    onOpenChange={(open) =>
+                        setDialog((prev) => ({ ...prev, open }))
+                    }
+                    request={
+                        dialog.type === "approve"
+                            ? prepareApproveOperation(op.id)
+                            : prepareRejectOperation(op.id)
+                    }
+                    title={
+                        dialog.type === "approve"
+                            ? "Approve operation"
+                            : "Reject operation"
+                    }
+                    description={
+                        dialog.type === "approve"
+                            ? `Run ${op.action} on "${op.target}", requested by ${op.requestedBy}? This cannot be undone.`
+                            : `Reject ${op.action} on "${op.target}", requested by ${op.requestedBy}?`
+                    }
+                    proceedLabel={
+                        dialog.type === "approve" ? "Approve" : "Reject"
+                    }
+                    onSuccess={load}
+                />
+            )}
+        </div>
+    );
+}
@@ -1,7 +1,7 @@
 "use client";
 
 import { useState, useEffect, useCallback, useMemo } from "react";
-import { formatDate } from "@/lib/utils";
+import { formatDate, formatQuota, isOverQuota } from "@/lib/utils";
 import { fetchVMRequests } from "@/lib/api";
 import type {
     VMRequest,
@@ -64,8 +64,10 @@ function FilterBar({
     const options: { value: StatusFilter; label: string }[] = [
         { value: "all", label: "All" },
         { value: "pending", label: "Pending" },
-        { value: "accepted", label: "Accepted" },
+        { value: "provisioned", label: "Provisioned" },
+        { value: "failed", label: "Failed" },
         { value: "rejected", label: "Rejected" },
+        { value: "unverified", label: "Unverified" },
     ];
 
     return (
@@ -132,10 +134,16 @@ export function VMRequestAdmin() {
     const counts = useMemo(() => {
         const c: Record<StatusFilter, number> = {
             all: requests.length,
+            unverified: 0,
             pending: 0,
             accepted: 0,
+            provisioning: 0,
+            provisioned: 0,
+            failed: 0,
             rejected: 0,
             hold: 0,
+            withdrawn: 0,
+            decommissioned: 0,
         };
         for (const r of requests) {
             c[r.RequestStatus]++;
@@ -206,6 +214,7 @@ export function VMRequestAdmin() {
                                     <TableHead>Cores</TableHead>
                                     <TableHead>RAM</TableHead>
                                     <TableHead>Disk</TableHead>
+                                    <TableHead>Quota</TableHead>
                                     <TableHead>Created</TableHead>
                                 </TableRow>
                             </TableHeader>
@@ -242,6 +251,30 @@ export function VMRequestAdmin() {
                                                     `${req.DiskGB} GB`
                                                 )}
                                             </TableCell>
+                                            <TableCell>
+                                                {req.UserQuota ? (
+                                                    <span
+                                                        title={formatQuota(
+                                                            req.UserQuota,
+                                                        )}
+                                                        className={
+                                                            isOverQuota(
+                                                                req.UserQuota,
+                                                            )
+                                                                ? "text-destructive"
+                                                                : ""
+                                                        }
+                                                    >
+                                                        {req.UserQuota.used.vms}
+                                                        {req.UserQuota.limit
+                                                            .vms > 0 &&
+                                                            `/${req.UserQuota.limit.vms}`}{" "}
+                                                        VMs
+                                                    </span>
+                                                ) : (
+                                                    "—"
+                                                )}
+                                            </TableCell>
                                             <TableCell className="text-muted-foreground">
                                                 {formatDate(
                                                     req.RequestCreatedAt,
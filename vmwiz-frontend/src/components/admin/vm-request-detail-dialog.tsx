@@ -1,7 +1,7 @@
 "use client";
 
 import { useState } from "react";
-import { formatDate } from "@/lib/utils";
+import { formatDate, formatQuota, isOverQuota } from "@/lib/utils";
 import {
     prepareAcceptVMRequest,
     prepareRejectVMRequest,
@@ -13,6 +13,7 @@ import type {
     VMRequest,
     VMRequestStatus,
     VMRequestEditFields,
+    QuotaStatus,
 } from "@/lib/types/api";
 import { FetchDialog } from "@/components/fetch-dialog";
 import { Badge } from "@/components/ui/badge";
@@ -32,15 +33,27 @@ import {
     Check,
     X,
     Clock,
+    Loader2,
+    AlertTriangle,
+    RotateCw,
     Pencil,
     Server,
     Key,
     MessageSquare,
+    Mail,
     User,
+    FileCode,
 } from "lucide-react";
 
 export function StatusBadge({ status }: { status: VMRequestStatus }) {
     switch (status) {
+        case "unverified":
+            return (
+                <Badge variant="outline">
+                    <Mail className="mr-1 size-3" />
+                    Unverified
+                </Badge>
+            );
         case "pending":
             return (
                 <Badge className="bg-amber-100 text-amber-800 dark:bg-amber-900/30 dark:text-amber-400">
@@ -55,6 +68,27 @@ export function StatusBadge({ status }: { status: VMRequestStatus }) {
                     Accepted
                 </Badge>
             );
+        case "provisioning":
+            return (
+                <Badge className="bg-sky-100 text-sky-800 dark:bg-sky-900/30 dark:text-sky-400">
+                    <Loader2 className="mr-1 size-3 animate-spin" />
+                    Provisioning
+                </Badge>
+            );
+        case "provisioned":
+            return (
+                <Badge className="bg-teal-100 text-teal-800 dark:bg-teal-900/30 dark:text-teal-400">
+                    <Check className="mr-1 size-3" />
+                    Provisioned
+                </Badge>
+            );
+        case "failed":
+            return (
+                <Badge variant="destructive">
+                    <AlertTriangle className="mr-1 size-3" />
+                    Failed
+                </Badge>
+            );
         case "rejected":
             return (
                 <Badge variant="destructive">
@@ -69,6 +103,20 @@ export function StatusBadge({ status }: { status: VMRequestStatus }) {
                     Hold
                 </Badge>
             );
+        case "withdrawn":
+            return (
+                <Badge variant="secondary">
+                    <X className="mr-1 size-3" />
+                    Withdrawn
+                </Badge>
+            );
+        case "decommissioned":
+            return (
+                <Badge variant="secondary">
+                    <Server className="mr-1 size-3" />
+                    Decommissioned
+                </Badge>
+            );
     }
 }
 
@@ -81,7 +129,8 @@ function isEditDirty(req: VMRequest, fields: EditState): boolean {
         fields.Cores !== req.Cores ||
         fields.RamGB !== req.RamGB ||
         fields.DiskGB !== req.DiskGB ||
-        fields.SecondaryDiskGB !== req.SecondaryDiskGB
+        fields.SecondaryDiskGB !== req.SecondaryDiskGB ||
+        fields.Profile !== req.ProvisioningProfile
     );
 }
 
@@ -97,6 +146,8 @@ function buildEditPayload(
     if (fields.DiskGB !== req.DiskGB) payload.DiskGB = fields.DiskGB;
     if (fields.SecondaryDiskGB !== req.SecondaryDiskGB)
         payload.SecondaryDiskGB = fields.SecondaryDiskGB;
+    if (fields.Profile !== req.ProvisioningProfile)
+        payload.Profile = fields.Profile;
     return payload;
 }
 
@@ -116,6 +167,18 @@ function DetailField({
     );
 }
 
+/** Usage and limits of a quota, highlighted if it is exceeded */
+function QuotaValue({ quota }: { quota: QuotaStatus }) {
+    return (
+        <span className={isOverQuota(quota) ? "text-destructive" : ""}>
+            {formatQuota(quota)}
+            {quota.overridden && (
+                <span className="text-muted-foreground"> (overridden)</span>
+            )}
+        </span>
+    );
+}
+
 /** Edit dialog for VM requests. If the request is not pending, it simply shows the details without allowing edits or actions. */
 export function RequestDetailDialog({
     request,
@@ -134,6 +197,7 @@ export function RequestDetailDialog({
         RamGB: request?.RamGB ?? 0,
         DiskGB: request?.DiskGB ?? 0,
         SecondaryDiskGB: request?.SecondaryDiskGB ?? 0,
+        Profile: request?.ProvisioningProfile ?? "",
     });
     const [acceptDialogOpen, setAcceptDialogOpen] = useState(false);
     const [rejectDialogOpen, setRejectDialogOpen] = useState(false);
@@ -146,6 +210,7 @@ export function RequestDetailDialog({
 
     const isPending = request.RequestStatus === "pending";
     const isHold = request.RequestStatus === "hold";
+    const isFailed = request.RequestStatus === "failed";
     const dirty = isPending && isEditDirty(request, editFields);
     const editPayload = buildEditPayload(request, editFields);
 
@@ -182,6 +247,32 @@ export function RequestDetailDialog({
                                             {request.OrgName}
                                         </DetailField>
                                     )}
+                                    {request.UserQuota && (
+                                        <DetailField label="Quota of the requester">
+                                            <QuotaValue
+                                                quota={request.UserQuota}
+                                            />
+                                        </DetailField>
+                                    )}
+                                    {request.OrgQuota && (
+                                        <DetailField label="Quota of the organization">
+                                            <QuotaValue
+                                                quota={request.OrgQuota}
+                                            />
+                                        </DetailField>
+                                    )}
+                                    {request.VMID > 0 && (
+                                        <>
+                                            <DetailField label="VM">
+                                                {request.VMID} on {request.Node}
+                                            </DetailField>
+                                            <DetailField label="IP Addresses">
+                                                {[request.IPv4, request.IPv6]
+                                                    .filter(Boolean)
+                                                    .join(", ") || "—"}
+                                            </DetailField>
+                                        </>
+                                    )}
                                 </div>
                             </div>
 
@@ -210,6 +301,23 @@ export function RequestDetailDialog({
                                         {request.Image}
                                     </DetailField>
 
+                                    <DetailField label="Provisioning Profile">
+                                        <Input
+                                            disabled={!isPending}
+                                            placeholder="(default)"
+                                            value={editFields.Profile}
+                                            onChange={(e) => {
+                                                const target =
+                                                    e.target as HTMLInputElement | null;
+                                                setEditFields((f) => ({
+                                                    ...f,
+                                                    Profile:
+                                                        target?.value ?? "",
+                                                }));
+                                            }}
+                                        />
+                                    </DetailField>
+
                                     <DetailField label="Hostname">
                                         <Input
                                             disabled={!isPending}
@@ -339,6 +447,18 @@ export function RequestDetailDialog({
                                     </p>
                                 )}
                             </div>
+
+                            {request.UserData && (
+                                <div className="space-y-3">
+                                    <div className="flex items-center gap-2 text-sm font-medium">
+                                        <FileCode className="size-4 text-muted-foreground" />
+                                        Cloud-init User-data
+                                    </div>
+                                    <pre className="max-h-64 overflow-auto rounded-md bg-muted/50 px-3 py-2 font-mono text-xs whitespace-pre-wrap">
+                                        {request.UserData}
+                                    </pre>
+                                </div>
+                            )}
                         </div>
                     </div>
 
@@ -385,6 +505,22 @@ export function RequestDetailDialog({
                             </Button>
                         </DialogFooter>
                     )}
+
+                    {isFailed && (
+                        <DialogFooter>
+                            <Button
+                                variant="destructive"
+                                onClick={() => setRejectDialogOpen(true)}
+                            >
+                                <X className="size-3.5" />
+                                Reject
+                            </Button>
+                            <Button onClick={() => setAcceptDialogOpen(true)}>
+                                <RotateCw className="size-3.5" />
+                                Retry
+                            </Button>
+                        </DialogFooter>
+                    )}
                 </DialogContent>
             </Dialog>
 
@@ -147,6 +147,7 @@ export function FetchDialog({
     const [errorMessage, setErrorMessage] = useState("");
     const [confirmInput, setConfirmInput] = useState("");
     const [expectedToken, setExpectedToken] = useState("");
+    const [confirmSummary, setConfirmSummary] = useState("");
     const [successData, setSuccessData] = useState<unknown>(undefined);
     const [logScopeId, setLogScopeId] = useState<string | null>(null);
     const [responseInfo, setResponseInfo] = useState<ResponseInfo | undefined>(
@@ -166,6 +167,7 @@ export function FetchDialog({
                 setErrorMessage("");
                 setConfirmInput("");
                 setExpectedToken("");
+                setConfirmSummary("");
                 setSuccessData(undefined);
                 setLogScopeId(null);
                 setResponseInfo(undefined);
@@ -177,8 +179,9 @@ export function FetchDialog({
 
     /** Callback when the request requires user confirmation */
     const onConfirmRequired: OnConfirmCallback = useCallback(
-        (token: string) => {
+        (token: string, summary: string) => {
             setExpectedToken(token);
+            setConfirmSummary(summary);
             setConfirmInput("");
             setPhase("confirming");
             return new Promise<string>((resolve, reject) => {
@@ -351,6 +354,11 @@ export function FetchDialog({
 
                 {phase === "confirming" && (
                     <div className="space-y-2 py-2">
+                        {confirmSummary && (
+                            <p className="text-sm text-muted-foreground">
+                                {confirmSummary}
+                            </p>
+                        )}
                         <Label htmlFor="fetch-dialog-confirm">
                             Type{" "}
                             <span className="font-mono font-semibold">
@@ -0,0 +1,195 @@
+"use client";
+
+import { useCallback, useEffect, useState } from "react";
+import { formatDate } from "@/lib/utils";
+import {
+    fetchMyVMRequests,
+    fetchMyVMs,
+    prepareRequestMyVMDeletion,
+    prepareWithdrawMyVMRequest,
+} from "@/lib/api";
+import type { MyVM, MyVMRequest } from "@/lib/types/api";
+import { FetchDialog } from "@/components/fetch-dialog";
+import { StatusBadge } from "@/components/admin/vm-request-detail-dialog";
+import { Button } from "@/components/ui/button";
+import {
+    Card,
+    CardContent,
+    CardDescription,
+    CardHeader,
+    CardTitle,
+} from "@/components/ui/card";
+import { ClipboardList, RefreshCw, Server, Trash2, Undo2 } from "lucide-react";
+
+/** Self-service page listing the requests and VMs of the logged in requester */
+export function MyRequests() {
+    const [requests, setRequests] = useState<MyVMRequest[]>([]);
+    const [vms, setVMs] = useState<MyVM[]>([]);
+    const [loading, setLoading] = useState(true);
+    const [withdrawID, setWithdrawID] = useState<number | null>(null);
+    const [deleteVM, setDeleteVM] = useState<MyVM | null>(null);
+
+    const load = useCallback(async () => {
+        setLoading(true);
+        try {
+            const [reqs, myVMs] = await Promise.all([
+                fetchMyVMRequests(),
+                fetchMyVMs(),
+            ]);
+            setRequests(reqs);
+            setVMs(myVMs);
+        } finally {
+            setLoading(false);
+        }
+    }, []);
+
+    useEffect(() => {
+        load();
+    }, [load]);
+
+    return (
+        <div className="mx-auto w-full max-w-4xl space-y-10 p-6 pb-16">
+            <div className="flex items-center justify-between">
+                <h1 className="text-2xl font-bold">My VMs</h1>
+                <Button
+                    variant="outline"
+                    size="sm"
+                    onClick={load}
+                    disabled={loading}
+                >
+                    <RefreshCw
+                        className={`size-3.5 ${loading ? "animate-spin" : ""}`}
+                    />
+                    Refresh
+                </Button>
+            </div>
+
+            <section className="space-y-4">
+                <h2 className="flex items-center gap-2 text-lg font-semibold">
+                    <Server className="size-5" />
+                    Virtual Machines
+                </h2>
+                {vms.length === 0 && !loading && (
+                    <p className="text-sm text-muted-foreground">
+                        You have no VMs yet.
+                    </p>
+                )}
+                {vms.map((vm) => (
+                    <Card key={vm.RequestID}>
+                        <CardHeader>
+                            <CardTitle className="font-mono text-base">
+                                {vm.Hostname}
+                            </CardTitle>
+                            <CardDescription>
+                                {vm.Status} · {vm.Cores} cores · {vm.RamGB} GB
+                                RAM · {vm.DiskGB} GB disk
+                                {vm.SecondaryDiskGB > 0 &&
+                                    ` + ${vm.SecondaryDiskGB} GB`}
+                            </CardDescription>
+                        </CardHeader>
+                        <CardContent className="flex items-center justify-between gap-4">
+                            <span className="font-mono text-xs text-muted-foreground">
+                                {[vm.IPv4, vm.IPv6].filter(Boolean).join(", ")}
+                            </span>
+                            {vm.DeletionRequestedAt ? (
+                                <span className="text-sm text-muted-foreground">
+                                    Deletion requested on{" "}
+                                    {formatDate(vm.DeletionRequestedAt)}
+                                </span>
+                            ) : (
+                                <Button
+                                    variant="destructive"
+                                    size="sm"
+                                    onClick={() => setDeleteVM(vm)}
+                                >
+                                    <Trash2 className="size-3.5" />
+                                    Request deletion
+                                </Button>
+                            )}
+                        </CardContent>
+                    </Card>
+                ))}
+            </section>
+
+            <section className="space-y-4">
+                <h2 className="flex items-center gap-2 text-lg font-semibold">
+                    <ClipboardList className="size-5" />
+                    Requests
+                </h2>
+                {requests.length === 0 && !loading && (
+                    <p className="text-sm text-muted-foreground">
+                        You have not submitted any VM requests.
+                    </p>
+                )}
+                {requests.map((req) => (
+                    <Card key={req.ID}>
+                        <CardHeader>
+                            <div className="flex items-center gap-3">
+                                <StatusBadge status={req.RequestStatus} />
+                                <CardTitle className="font-mono text-base">
+                                    {req.Hostname}
+                                </CardTitle>
+                            </div>
+                            <CardDescription>
+                                Request #{req.ID}, submitted on{" "}
+                                {formatDate(req.RequestCreatedAt)}
+                            </CardDescription>
+                        </CardHeader>
+                        <CardContent className="space-y-3">
+                            {req.Events.filter((ev) => ev.Reason).map(
+                                (ev, i) => (
+                                    <p key={i} className="text-sm">
+                                        <span className="text-muted-foreground">
+                                            {formatDate(ev.CreatedAt)}:
+                                        </span>{" "}
+                                        {ev.Reason}
+                                    </p>
+                                ),
+                            )}
+                            {["pending", "hold"].includes(
+                                req.RequestStatus,
+                            ) && (
+                                <Button
+                                    variant="outline"
+                                    size="sm"
+                                    onClick={() => setWithdrawID(req.ID)}
+                                >
+                                    <Undo2 className="size-3.5" />
+                                    Withdraw
+                                </Button>
+                            )}
+                        </CardContent>
+                    </Card>
+                ))}
+            </section>
+
+            {withdrawID !== null && (
+                <FetchDialog
+                    open={withdrawID !== null}
+                    onOpenChange={(open) => !open && setWithdrawID(null)}
+                    request={prepareWithdrawMyVMRequest(withdrawID)}
+                    title="Withdraw VM Request"
+                    description={`You are about to withdraw request #${withdrawID}. This cannot be undone.`}
+                    proceedLabel="Withdraw"
+                    proceedVariant="destructive"
+                    successDescription="Your request has been withdrawn."
+                    onSuccess={load}
+                />
+            )}
+
+            {deleteVM !== null && (
+                <FetchDialog
+                    open={deleteVM !== null}
+                    onOpenChange={(open) => !open && setDeleteVM(null)}
+                    request={prepareRequestMyVMDeletion(deleteVM.RequestID)}
+                    title="Request VM Deletion"
+                    description={`You are about to ask the admins to delete ${deleteVM.Hostname}. All data on it will be lost.`}
+                    proceedLabel="Request deletion"
+                    proceedVariant="destructive"
+                    successDescription="The admins have been notified and will delete your VM."
+                    onSuccess={load}
+                />
+            )}
+        </div>
+    );
+}
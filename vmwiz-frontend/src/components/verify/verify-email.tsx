@@ -0,0 +1,97 @@
+"use client";
+
+import { useState } from "react";
+import { useSearchParams } from "next/navigation";
+import {
+    Card,
+    CardContent,
+    CardDescription,
+    CardHeader,
+    CardTitle,
+} from "@/components/ui/card";
+import { Button } from "@/components/ui/button";
+import { FetchDialog } from "@/components/fetch-dialog";
+import { prepareVerifyVMRequestEmail } from "@/lib/api";
+import type { VMRequestVerifyResponse } from "@/lib/types/api";
+import { AlertTriangle, MailCheck } from "lucide-react";
+
+function InvalidLinkCard() {
+    return (
+        <div className="flex min-h-[70vh] items-center justify-center px-4">
+            <Card className="w-full max-w-md text-center animate-in fade-in-0 zoom-in-95 duration-300">
+                <CardHeader>
+                    <div className="mx-auto mb-2 flex h-14 w-14 items-center justify-center rounded-full bg-destructive/10">
+                        <AlertTriangle className="h-7 w-7 text-destructive" />
+                    </div>
+                    <CardTitle className="text-xl">
+                        Invalid Confirmation Link
+                    </CardTitle>
+                    <CardDescription className="text-balance">
+                        This link appears to be missing required parameters.
+                        Please use the link from the email you received.
+                    </CardDescription>
+                </CardHeader>
+            </Card>
+        </div>
+    );
+}
+
+/** Confirms the ownership of an email address of a VM request, with the token of the link we emailed */
+export function VerifyEmail() {
+    const searchParams = useSearchParams();
+    const token = searchParams.get("token") ?? "";
+
+    const [dialog, setDialog] = useState(false);
+    const [result, setResult] = useState<VMRequestVerifyResponse | null>(
+        null,
+    );
+
+    if (!token) {
+        return <InvalidLinkCard />;
+    }
+
+    return (
+        <>
+            <FetchDialog
+                open={dialog}
+                onOpenChange={setDialog}
+                request={prepareVerifyVMRequestEmail(token)}
+                immediate
+                title="Confirm Email"
+                successDescription="Your email address has been confirmed."
+                onSuccess={(data) =>
+                    setResult(data as VMRequestVerifyResponse)
+                }
+            />
+
+            <div className="flex min-h-[70vh] items-center justify-center px-4">
+                <Card className="w-full max-w-md py-8 text-center">
+                    <CardHeader className="gap-2 px-8">
+                        <div className="mx-auto mb-2 flex h-14 w-14 items-center justify-center rounded-full bg-teal-50">
+                            <MailCheck className="h-7 w-7 text-teal-600" />
+                        </div>
+                        <CardTitle className="text-xl">
+                            Confirm your email address
+                        </CardTitle>
+                        <CardDescription className="text-balance">
+                            {result === null
+                                ? "Your VM request is only sent to the admins once you confirm that this address belongs to you."
+                                : result.verified
+                                  ? `Thank you! Your request for ${result.hostname} is now waiting for review.`
+                                  : `Thank you! Your request for ${result.hostname} is reviewed once the other address has been confirmed too.`}
+                        </CardDescription>
+                    </CardHeader>
+
+                    <CardContent className="px-8 pt-2">
+                        <Button
+                            disabled={result !== null}
+                            onClick={() => setDialog(true)}
+                        >
+                            Confirm email address
+                        </Button>
+                    </CardContent>
+                </Card>
+            </div>
+        </>
+    );
+}
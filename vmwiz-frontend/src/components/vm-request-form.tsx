@@ -63,7 +63,7 @@ export function VMRequestForm() {
                 )}
                 immediate
                 title="Submitting Request"
-                successDescription="We received your request! You can close this window."
+                successDescription="We received your request! Please confirm your email address with the link we just sent you, the request is reviewed once it is confirmed."
                 onError={handleError}
             />
 
@@ -101,6 +101,8 @@ export function VMRequestForm() {
 
                 <SshKeysSection />
 
+                <UserDataSection />
+
                 <Separator className="opacity-30" />
 
                 <CommentsAndTermsSection />
@@ -187,6 +189,8 @@ function GeneralInfoSection() {
 
 function VMSpecSection() {
     const { values, errors, allowed, setField, syncToUrl } = useVMRequestForm();
+    const minDiskGB =
+        allowed.imageMinDiskGB?.[values.image] ?? allowed.diskGB.min;
 
     return (
         <section className="space-y-6">
@@ -206,7 +210,7 @@ function VMSpecSection() {
                         className="rounded-r-none"
                     />
                     <span className="flex h-8 items-center rounded-r-lg border border-l-0 border-input bg-muted px-3 text-sm text-muted-foreground">
-                        .vsos.ethz.ch
+                        .{allowed.domain}
                     </span>
                 </div>
                 <FieldError message={errors.hostname} />
@@ -305,7 +309,7 @@ function VMSpecSection() {
                     <Input
                         type="number"
                         className="w-20"
-                        min={allowed.diskGB.min}
+                        min={minDiskGB}
                         max={allowed.diskGB.max}
                         value={values.diskGB}
                         onChange={(e) =>
@@ -316,7 +320,7 @@ function VMSpecSection() {
                     />
                     <Slider
                         className="flex-1 [&_[data-slot=slider-range]]:bg-green-500 [&_[data-slot=slider-thumb]]:border-green-500 [&_[data-slot=slider-thumb]]:ring-green-500/50"
-                        min={allowed.diskGB.min}
+                        min={minDiskGB}
                         max={allowed.diskGB.max}
                         step={1}
                         value={[values.diskGB]}
@@ -439,6 +443,39 @@ function SshKeysSection() {
     );
 }
 
+function UserDataSection() {
+    const { values, errors, allowed, setField } = useVMRequestForm();
+
+    if (allowed.userDataMaxBytes === 0) return null;
+    const size = new TextEncoder().encode(values.userData).length;
+
+    return (
+        <section className="space-y-2">
+            <Label htmlFor="userData">
+                Cloud-init User-data{" "}
+                <span className="font-normal text-muted-foreground">
+                    (optional)
+                </span>
+            </Label>
+            <Textarea
+                id="userData"
+                className="min-h-32 font-mono text-xs"
+                placeholder={"#cloud-config\npackages:\n  - htop\nruncmd:\n  - echo hello"}
+                value={values.userData}
+                onChange={(e) => setField("userData", e.target.value)}
+                aria-invalid={!!errors.userData}
+            />
+            <p className="text-xs text-muted-foreground">
+                A <code>#cloud-config</code> document run on the first boot,
+                e.g. to install packages or add users. Hostname, default user
+                and SSH keys are set by VMWiz. {size}/{allowed.userDataMaxBytes}{" "}
+                bytes
+            </p>
+            <FieldError message={errors.userData} />
+        </section>
+    );
+}
+
 function CommentsAndTermsSection() {
     const { values, errors, setField, syncToUrl } = useVMRequestForm();
 
@@ -454,6 +491,7 @@ function CommentsAndTermsSection() {
                     onBlur={syncToUrl}
                 />
                 <FieldError message={errors.explanation} />
+                <FieldError message={errors.quota} />
             </div>
 
             <div className="flex gap-2">
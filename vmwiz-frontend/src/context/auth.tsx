@@ -11,13 +11,18 @@ import React, {
 /** User info returned by /api/auth/whoami */
 export interface WhoAmI {
     email: string;
+    email_verified: boolean;
     groups: string[];
+    /** Admin console role, each role includes the ones before it */
+    role: "none" | "viewer" | "approver" | "operator" | "superadmin";
 }
 
 interface AuthContextValue {
     /** user info, null until fetched */
     user: WhoAmI | null;
     loading: boolean;
+    /** Ends the session on the backend */
+    logout: () => Promise<void>;
 }
 
 const AuthContext = createContext<AuthContextValue | null>(null);
@@ -64,8 +69,16 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
         fetchWhoAmI();
     }, [fetchWhoAmI]);
 
+    const logout = useCallback(async () => {
+        await fetch("/api/auth/logout", {
+            method: "POST",
+            credentials: "include",
+        });
+        setUser(null);
+    }, []);
+
     return (
-        <AuthContext.Provider value={{ user, loading }}>
+        <AuthContext.Provider value={{ user, loading, logout }}>
             {children}
         </AuthContext.Provider>
     );
@@ -88,6 +88,8 @@ function toSearchParams(
     const params = new URLSearchParams();
 
     for (const _key of Object.keys(current) as (keyof VMRequestFormData)[]) {
+        // Too long for a URL
+        if (_key === "userData") continue;
         const cur = current[_key];
         const init = initial[_key];
 
@@ -1,4 +1,5 @@
 import {
+    ConfirmationPreviewResponse,
     LogScope,
     VMRequestAllowedValues,
     VMRequestListResponse,
@@ -7,15 +8,22 @@ import {
     SurveyInfo,
     SurveyResponseCategory,
     SurveyHostnameListResponse,
+    MyVMRequest,
+    MyVM,
+    PendingOperation,
 } from "@/lib/types/api";
 import { HTTP_METHOD } from "next/dist/server/web/http";
 import { getReasonPhrase } from "http-status-codes";
 
 /**
  * Callback invoked when a request needs user confirmation.
- * Receives the token the user must type, returns a promise that should resolve with the token they entered.
+ * Receives the token the user must type and a summary of what will happen,
+ * returns a promise that should resolve with the token they entered.
  */
-export type OnConfirmCallback = (confirmationToken: string) => Promise<string>;
+export type OnConfirmCallback = (
+    confirmationToken: string,
+    summary: string,
+) => Promise<string>;
 
 /**
  * Generic backend HTTP request error
@@ -105,18 +113,18 @@ export async function fetchBackend<T = void>(
         // Handle the backend asking for a confirmation token (e.g. for destructive actions)
         if (response.status === 409 && onConfirmRequired && body) {
             // Retry with ?preview=true to obtain a confirmation token
-            const { data: preview } = await fetchBackend<{
-                confirmationToken: string;
-            }>({
-                path: `${path}?preview=true`,
-                method: "POST",
-                headers,
-                body,
-            });
+            const { data: preview } =
+                await fetchBackend<ConfirmationPreviewResponse>({
+                    path: `${path}?preview=true`,
+                    method: "POST",
+                    headers,
+                    body,
+                });
 
             // Ask the user to confirm (may throw/reject to cancel) by calling the provided callback
             const confirmedToken = await onConfirmRequired(
                 preview.confirmationToken,
+                preview.summary,
             );
 
             // Retry the original request with the confirmed token merged in
@@ -188,6 +196,15 @@ export function prepareSubmitVMRequest(
     };
 }
 
+export function prepareVerifyVMRequestEmail(token: string): BackendRequest {
+    return {
+        path: "/api/vmrequest/verify",
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ token }),
+    };
+}
+
 export function prepareSubmitSurveyResponse(
     id: string,
     keep: boolean,
@@ -323,21 +340,29 @@ export function prepareAcceptVMRequest(id: number): BackendRequest {
     };
 }
 
-export function prepareRejectVMRequest(id: number): BackendRequest {
+export function prepareRejectVMRequest(
+    id: number,
+    reason?: string,
+    adminNote?: string,
+): BackendRequest {
     return {
         path: "/api/vmrequest/reject",
         method: "POST",
         headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ id }),
+        body: JSON.stringify({ id, reason, admin_note: adminNote }),
     };
 }
 
-export function prepareHoldVMRequest(id: number): BackendRequest {
+export function prepareHoldVMRequest(
+    id: number,
+    reason?: string,
+    adminNote?: string,
+): BackendRequest {
     return {
         path: "/api/vmrequest/hold",
         method: "POST",
         headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ id }),
+        body: JSON.stringify({ id, reason, admin_note: adminNote }),
     };
 }
 
@@ -361,6 +386,7 @@ export function prepareEditVMRequest(
     if (options?.DiskGB !== undefined) body.storage_gb = options.DiskGB;
     if (options?.SecondaryDiskGB !== undefined)
         body.secondary_storage_gb = options.SecondaryDiskGB;
+    if (options?.Profile !== undefined) body.profile = options.Profile;
     return {
         path: "/api/vmrequest/edit",
         method: "POST",
@@ -369,6 +395,87 @@ export function prepareEditVMRequest(
     };
 }
 
+/**
+ * Fetches the VM requests of the logged in requester.
+ */
+export async function fetchMyVMRequests(): Promise<MyVMRequest[]> {
+    const { data } = await fetchBackend<MyVMRequest[]>({
+        path: "/api/me/requests",
+        method: "GET",
+        headers: { "Content-Type": "application/json" },
+    });
+    return data;
+}
+
+/**
+ * Fetches the VMs of the logged in requester.
+ */
+export async function fetchMyVMs(): Promise<MyVM[]> {
+    const { data } = await fetchBackend<MyVM[]>({
+        path: "/api/me/vms",
+        method: "GET",
+        headers: { "Content-Type": "application/json" },
+    });
+    return data;
+}
+
+export function prepareWithdrawMyVMRequest(
+    id: number,
+    reason?: string,
+): BackendRequest {
+    return {
+        path: "/api/me/requests/withdraw",
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id, reason }),
+    };
+}
+
+export function prepareRequestMyVMDeletion(
+    id: number,
+    reason?: string,
+): BackendRequest {
+    return {
+        path: "/api/me/vms/delete",
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id, reason }),
+    };
+}
+
+/**
+ * Fetches the operations waiting for the approval of a second admin.
+ * @param all Also include the approved, rejected and expired ones
+ */
+export async function fetchPendingOperations(
+    all = false,
+): Promise<PendingOperation[]> {
+    const { data } = await fetchBackend<PendingOperation[]>({
+        path: `/api/operations${all ? "?all=true" : ""}`,
+        method: "GET",
+        headers: { "Content-Type": "application/json" },
+    });
+    return data;
+}
+
+export function prepareApproveOperation(id: number): BackendRequest {
+    return {
+        path: "/api/operations/approve",
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id }),
+    };
+}
+
+export function prepareRejectOperation(id: number): BackendRequest {
+    return {
+        path: "/api/operations/reject",
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id }),
+    };
+}
+
 /**
  * Fetches the number of free IPv4 addresses.
  */
@@ -1,5 +1,8 @@
 export interface ConfirmationPreviewResponse {
+    /** Derived from the target resource, e.g. "accept request 123" */
     confirmationToken: string;
+    /** Human-readable description of what will happen */
+    summary: string;
 }
 
 export interface UnauthorizedResponse {
@@ -31,6 +34,8 @@ export interface VMRequestFormData {
     diskGB: number;
     secondaryDiskGB: number;
     sshPubkey: string[];
+    /** Optional #cloud-config merged into the cloud-init user-data of the VM */
+    userData: string;
     comments: string;
     accept_terms: boolean;
 }
@@ -46,7 +51,9 @@ export interface VMRequestValidationErrors {
     diskGB: string;
     secondaryDiskGB: string;
     explanation: string;
+    quota: string;
     sshPubkey: string[];
+    userData: string;
     accept_terms: string;
 }
 
@@ -55,17 +62,49 @@ export interface MinMax {
     max: number;
 }
 
+/** GET /api/vmrequest/options, the form settings and the enabled images */
 export interface VMRequestAllowedValues {
+    /** The VMs are created as <hostname>.<domain> */
+    domain: string;
     image: string[];
+    /** Minimum disk size of the images that need more than diskGB.min */
+    imageMinDiskGB: Record<string, number>;
+    /** Size limit of the custom cloud-init user-data, 0 if it is disabled */
+    userDataMaxBytes: number;
     cores: MinMax;
     ramGB: MinMax;
     diskGB: MinMax;
     secondaryDiskGB: MinMax;
+    /** Requests exceeding these values need an explanation */
+    needsExplanation: {
+        cores: number;
+        ramGB: number;
+        diskGB: number;
+        secondaryDiskGB: number;
+    };
 }
 
 /** GET /api/vmrequest */
 
-export type VMRequestStatus = "pending" | "accepted" | "rejected" | "hold";
+export type VMRequestStatus =
+    | "unverified"
+    | "pending"
+    | "accepted"
+    | "provisioning"
+    | "provisioned"
+    | "failed"
+    | "rejected"
+    | "hold"
+    | "withdrawn"
+    | "decommissioned";
+
+/** Response of POST /api/vmrequest/verify */
+export interface VMRequestVerifyResponse {
+    id: number;
+    hostname: string;
+    /** Whether all addresses of the request are verified, i.e. it now waits for review */
+    verified: boolean;
+}
 
 export interface VMRequest {
     ID: number;
@@ -82,11 +121,121 @@ export interface VMRequest {
     DiskGB: number;
     SecondaryDiskGB: number;
     SshPubkeys: string[];
+    /** Custom #cloud-config of the requester, empty if none */
+    UserData: string;
+    /** Provisioning profile the VM is created with, empty for the default */
+    ProvisioningProfile: string;
     Comments: string;
+    /** The created VM, only set once the request is provisioned */
+    VMID: number;
+    Node: string;
+    IPv4: string;
+    IPv6: string;
+    /** Status changes and edits, oldest first */
+    Events: VMRequestEvent[];
+    /** Usage and quota of the requester and organization, null if they could not be computed */
+    UserQuota: QuotaStatus | null;
+    OrgQuota: QuotaStatus | null;
+}
+
+/** Resources of one or more VMs. As a limit, 0 means unlimited */
+export interface QuotaResources {
+    vms: number;
+    cores: number;
+    ramGB: number;
+    diskGB: number;
+}
+
+export interface QuotaStatus {
+    used: QuotaResources;
+    limit: QuotaResources;
+    /** Whether the limit was changed by an admin */
+    overridden: boolean;
+}
+
+export interface VMRequestEvent {
+    CreatedAt: string;
+    Actor: string;
+    FromStatus: string;
+    ToStatus: string;
+    Reason: string;
+    AdminNote: string;
 }
 
 export type VMRequestListResponse = VMRequest[];
 
+/** GET /api/auth/sessions */
+export interface AuthSession {
+    ID: string;
+    Email: string;
+    CreatedAt: string;
+    LastSeenAt: string;
+    ExpiresAt: string;
+    /** The session this request was made with */
+    Current: boolean;
+}
+
+/** GET /api/operations, actions waiting for the approval of a second admin */
+export type PendingOperationStatus =
+    | "pending"
+    | "approved"
+    | "rejected"
+    | "expired";
+
+export interface PendingOperation {
+    id: number;
+    /** e.g. "vm.delete", as in the audit log */
+    action: string;
+    target: string;
+    /** JSON body the action is run with once approved */
+    payload: string;
+    requestedBy: string;
+    requestedAt: string;
+    expiresAt: string;
+    status: PendingOperationStatus;
+    decidedBy: string;
+    decidedAt: string | null;
+}
+
+/** Response of actions that need approval (202) */
+export interface PendingOperationCreatedResponse {
+    pendingOperationId: number;
+    expiresAt: string;
+    message: string;
+}
+
+/** GET /api/me/requests */
+export interface MyVMRequest {
+    ID: number;
+    RequestCreatedAt: string;
+    RequestStatus: VMRequestStatus;
+    Hostname: string;
+    Image: string;
+    Cores: number;
+    RamGB: number;
+    DiskGB: number;
+    SecondaryDiskGB: number;
+    /** Status changes, oldest first. Admin notes are not included */
+    Events: Omit<VMRequestEvent, "Actor" | "AdminNote">[];
+}
+
+/** GET /api/me/vms */
+export interface MyVM {
+    /** ID of the request the VM was created for */
+    RequestID: number;
+    Hostname: string;
+    VMID: number;
+    IPv4: string;
+    IPv6: string;
+    Cores: number;
+    RamGB: number;
+    DiskGB: number;
+    SecondaryDiskGB: number;
+    /** Proxmox status, e.g. "running" or "stopped"; "unknown" if it could not be fetched */
+    Status: string;
+    DeletionRequestedAt: string | null;
+}
+
 /** POST /api/vmrequest/accept (confirmable) */
 export interface VMRequestAcceptBody {
     id: number;
@@ -96,11 +245,19 @@ export interface VMRequestAcceptBody {
 /** POST /api/vmrequest/reject */
 export interface VMRequestRejectBody {
     id: number;
+    /** Included in the email to the requester */
+    reason?: string;
+    /** Internal, only visible to admins */
+    admin_note?: string;
 }
 
 /** POST /api/vmrequest/hold */
 export interface VMRequestHoldBody {
     id: number;
+    /** Included in the email to the requester */
+    reason?: string;
+    /** Internal, only visible to admins */
+    admin_note?: string;
 }
 
 /** POST /api/vmrequest/unhold */
@@ -115,6 +272,7 @@ export interface VMRequestEditFields {
     RamGB?: number;
     DiskGB?: number;
     SecondaryDiskGB?: number;
+    Profile?: string;
 }
 
 export interface VMRequestEditBody {
@@ -123,6 +281,7 @@ export interface VMRequestEditBody {
     cores_cpu?: number;
     ram_gb?: number;
     storage_gb?: number;
+    profile?: string;
 }
 
 /** POST /api/vm/deleteByName (confirmable) */
@@ -197,16 +356,21 @@ export const DEFAULT_FORM_VALUES: VMRequestFormData = {
     diskGB: 15,
     secondaryDiskGB: 0,
     sshPubkey: [""],
+    userData: "",
     comments: "",
     accept_terms: false,
 };
 
 export const DEFAULT_ALLOWED_VALUES: VMRequestAllowedValues = {
+    domain: "vsos.ethz.ch",
     image: ["Ubuntu 24.04 - Noble Numbat", "Debian 13 - Trixie"],
+    imageMinDiskGB: {},
+    userDataMaxBytes: 16384,
     cores: { min: 1, max: 8 },
     ramGB: { min: 2, max: 16 },
     diskGB: { min: 15, max: 100 },
     secondaryDiskGB: { min: 0, max: 500 },
+    needsExplanation: { cores: 5, ramGB: 4, diskGB: 30, secondaryDiskGB: 0 },
 };
 
 export const EMPTY_VALIDATION_ERRORS: VMRequestValidationErrors = {
@@ -220,6 +384,8 @@ export const EMPTY_VALIDATION_ERRORS: VMRequestValidationErrors = {
     diskGB: "",
     secondaryDiskGB: "",
     explanation: "",
+    quota: "",
     sshPubkey: [],
+    userData: "",
     accept_terms: "",
 };
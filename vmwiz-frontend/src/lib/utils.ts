@@ -1,5 +1,6 @@
 import { clsx, type ClassValue } from "clsx";
 import { twMerge } from "tailwind-merge";
+import type { QuotaResources, QuotaStatus } from "@/lib/types/api";
 
 export function cn(...inputs: ClassValue[]) {
     return twMerge(clsx(inputs));
@@ -18,3 +19,26 @@ export function formatDate(dateStr: string): string {
     };
     return date.toLocaleDateString("en-US", options);
 }
+
+const QUOTA_UNITS: [keyof QuotaResources, string][] = [
+    ["vms", "VMs"],
+    ["cores", "cores"],
+    ["ramGB", "GB RAM"],
+    ["diskGB", "GB disk"],
+];
+
+/** Format a quota usage (e.g. "2/3 VMs, 4/12 cores, 8 GB RAM, ..."). Limits of 0 are unlimited. */
+export function formatQuota(quota: QuotaStatus): string {
+    return QUOTA_UNITS.map(([key, unit]) =>
+        quota.limit[key] > 0
+            ? `${quota.used[key]}/${quota.limit[key]} ${unit}`
+            : `${quota.used[key]} ${unit}`,
+    ).join(", ");
+}
+
+/** Whether any of the resources is over its limit */
+export function isOverQuota(quota: QuotaStatus): boolean {
+    return QUOTA_UNITS.some(
+        ([key]) => quota.limit[key] > 0 && quota.used[key] > quota.limit[key],
+    );
+}